@@ -84,10 +84,28 @@ type InstanceInfo struct {
 	FwdMode     string      `json:"fwd-mode"`
 	ArpMode     string      `json:"arp-mode"`
 	DbURL       string      `json:"db-url"`
+	DbSecurity  DbSecurity  `json:"db-security"`
 	PluginMode  string      `json:"plugin-mode"`
 	HostPvtNW   int         `json:"host-pvt-nw"`
 }
 
+// DbSecurity has the client certificate, CA bundle and credentials used to
+// connect to the state store. The connection uses TLS if a certificate or CA
+// bundle is set. The password can be read from PasswordFile instead.
+type DbSecurity struct {
+	CertFile     string `json:"cert-file"`
+	KeyFile      string `json:"key-file"`
+	CAFile       string `json:"ca-file"`
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	PasswordFile string `json:"password-file"`
+}
+
+// UseTLS returns true if the state store connection uses TLS
+func (s DbSecurity) UseTLS() bool {
+	return s.CertFile != "" || s.CAFile != ""
+}
+
 // PortSpec defines protocol/port info required to host the service
 type PortSpec struct {
 	Protocol string
@@ -466,16 +466,24 @@ func (d *OvsDriver) CreateEndpoint(id string) error {
 		VtepIP:      cfgEp.VtepIP}
 	operEp.StateDriver = d.oper.StateDriver
 	operEp.ID = id
-	err = operEp.Write()
+	err = operEp.Create()
 	if err != nil {
+		// another writer created the endpoint meanwhile, don't leak our port
+		log.Errorf("Error saving oper state of endpoint %s, removing port %s. Err: %v", id, intfName, err)
+		if delErr := sw.DeletePort(operEp, skipVethPair); delErr != nil {
+			log.Errorf("Error deleting port %s. Err: %v", intfName, delErr)
+		}
+
+		d.oper.localEpInfoMutex.Lock()
+		if epInfo := d.oper.LocalEpInfo[id]; epInfo != nil && epInfo.Ovsportname == ovsPortName {
+			delete(d.oper.LocalEpInfo, id)
+		}
+		d.oper.localEpInfoMutex.Unlock()
+		d.oper.Write()
+
 		return err
 	}
 
-	defer func() {
-		if err != nil {
-			operEp.Clear()
-		}
-	}()
 	return nil
 }
 
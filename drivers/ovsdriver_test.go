@@ -468,6 +468,53 @@ func TestOvsDriverCreateEndpointStatefulStateMismatch(t *testing.T) {
 	}
 }
 
+// racingStateDriver creates a key right before the versioned write creating
+// it, as another writer would
+type racingStateDriver struct {
+	*state.FakeStateDriver
+	racedKey string
+}
+
+func (d *racingStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	if key == d.racedKey {
+		d.FakeStateDriver.Write(key, value)
+	}
+	return d.FakeStateDriver.WriteVersion(key, value, version)
+}
+
+func TestOvsDriverCreateEndpointConflict(t *testing.T) {
+	driver := initOvsDriver(t, bridgeMode, defPvtNW)
+	defer func() { driver.Deinit() }()
+	id := createEpID
+
+	// create network
+	err := driver.CreateNetwork(testOvsNwID)
+	if err != nil {
+		t.Fatalf("network creation failed. Error: %s", err)
+	}
+	defer func() { driver.DeleteNetwork(testOvsNwID, "", "", testPktTag, testExtPktTag, testGateway, testTenant) }()
+
+	driver.oper.StateDriver = &racingStateDriver{
+		FakeStateDriver: driver.oper.StateDriver.(*state.FakeStateDriver),
+		racedKey:        fmt.Sprintf(endpointOperPath, id),
+	}
+
+	// create endpoint, losing the oper state to another writer
+	err = driver.CreateEndpoint(id)
+	if err != state.ErrStateConflict {
+		t.Fatalf("expected a state conflict, got: %v", err)
+	}
+
+	portName := fmt.Sprintf("port%d", driver.oper.CurrPortNum)
+	output, err := exec.Command("ovs-vsctl", "list", "Port").CombinedOutput()
+	if err != nil || strings.Contains(string(output), portName) {
+		t.Fatalf("port %s was not removed. Error: %v Output: %s", portName, err, output)
+	}
+	if _, ok := driver.oper.LocalEpInfo[id]; ok {
+		t.Fatalf("local endpoint info was not removed")
+	}
+}
+
 func TestOvsDriverDeleteEndpoint(t *testing.T) {
 	driver := initOvsDriver(t, bridgeMode, defPvtNW)
 	defer func() { driver.Deinit() }()
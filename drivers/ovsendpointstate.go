@@ -21,6 +21,7 @@ import (
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 )
 
 // OvsOperEndpointState is the necessary data used to perform operations on endpoints.
@@ -55,6 +56,14 @@ func (s *OvsOperEndpointState) Write() error {
 	return s.StateDriver.WriteState(key, s, json.Marshal)
 }
 
+// Create writes the state if it does not exist, so that an endpoint oper
+// state written meanwhile by another writer is not overwritten. It returns
+// state.ErrStateConflict otherwise.
+func (s *OvsOperEndpointState) Create() error {
+	key := fmt.Sprintf(endpointOperPath, s.ID)
+	return state.WriteStateVersion(s.StateDriver, key, s, json.Marshal, 0)
+}
+
 // Read the state for a given identifier.
 func (s *OvsOperEndpointState) Read(id string) error {
 	key := fmt.Sprintf(endpointOperPath, id)
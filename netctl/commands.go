@@ -404,6 +404,91 @@ var Commands = []cli.Command{
 			},
 		},
 	},
+	{
+		Name:  "state",
+		Usage: "State store backup and restore",
+		Subcommands: []cli.Command{
+			{
+				Name:      "backup",
+				Usage:     "Back up the contiv state, to stdout if no file is given",
+				ArgsUsage: "[file]",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "secrets",
+						Usage: "Keep the sensitive fields, redacted backups can not be restored. Netmaster must run with -state-backup-secrets",
+					},
+				},
+				Action: backupState,
+			},
+			{
+				Name:      "restore",
+				Usage:     "Restore a backup of the contiv state",
+				ArgsUsage: "[file]",
+				Flags: []cli.Flag{
+					cli.BoolFlag{
+						Name:  "clear",
+						Usage: "Clear the existing contiv state first",
+					},
+				},
+				Action: restoreState,
+			},
+		},
+	},
+	{
+		Name:      "chk",
+		Usage:     "Check the consistency of the network, endpoint, IPAM, OVS and docknet state",
+		ArgsUsage: " ",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "repair, r",
+				Usage: "Repair the inconsistencies that can be repaired",
+			},
+			cli.StringFlag{
+				Name:  "grace, g",
+				Usage: "Only repair the inconsistencies found by checks at least this long ago, eg. 0s, netmaster default if empty",
+			},
+			jsonFlag,
+		},
+		Action: checkState,
+	},
+	{
+		Name:      "audit",
+		Usage:     "Show the audit log of the config state changes, oldest first",
+		ArgsUsage: " ",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "key, k",
+				Usage: "Key prefix of the changed states, eg. /contiv.io/state/nets/",
+			},
+			cli.StringFlag{
+				Name:  "since, s",
+				Usage: "Show the changes since a duration ago, eg. 1h, or an RFC 3339 time",
+			},
+			cli.StringFlag{
+				Name:  "before, b",
+				Usage: "Show the changes before an RFC 3339 time, eg. the time of the oldest change in the json output, to page back",
+			},
+			cli.IntFlag{
+				Name:  "limit, l",
+				Value: 50,
+				Usage: "Number of most recent changes to show, 0 for all",
+			},
+			jsonFlag,
+		},
+		Action: showAudit,
+	},
+	{
+		Name:  "debug",
+		Usage: "Debugging information",
+		Subcommands: []cli.Command{
+			{
+				Name:      "state",
+				Usage:     "Show the contiv state under a path, eg. oper/docknet, with the secrets redacted",
+				ArgsUsage: "[path]",
+				Action:    inspectState,
+			},
+		},
+	},
 	{
 		Name:  "global",
 		Usage: "Global information",
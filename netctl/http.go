@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
 	"github.com/codegangsta/cli"
 )
@@ -26,6 +28,26 @@ func versionURL(ctx *cli.Context) string {
 	return fmt.Sprintf("%s/version", baseURL(ctx))
 }
 
+func stateBackupURL(ctx *cli.Context, query url.Values) string {
+	return fmt.Sprintf("%s/state/backup?%s", baseURL(ctx), query.Encode())
+}
+
+func stateRestoreURL(ctx *cli.Context, query url.Values) string {
+	return fmt.Sprintf("%s/state/restore?%s", baseURL(ctx), query.Encode())
+}
+
+func stateInspectURL(ctx *cli.Context, path string) string {
+	return fmt.Sprintf("%s/debug/state/%s", baseURL(ctx), strings.TrimPrefix(path, "/"))
+}
+
+func stateChkURL(ctx *cli.Context, query url.Values) string {
+	return fmt.Sprintf("%s/state/chk?%s", baseURL(ctx), query.Encode())
+}
+
+func auditURL(ctx *cli.Context, query url.Values) string {
+	return fmt.Sprintf("%s/audit?%s", baseURL(ctx), query.Encode())
+}
+
 func writeBody(resp *http.Response, ctx *cli.Context) {
 	content, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
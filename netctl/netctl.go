@@ -4,15 +4,22 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"github.com/codegangsta/cli"
 	contivClient "github.com/contiv/contivmodel/client"
+	"github.com/contiv/netplugin/netmaster/master"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/version"
 )
 
@@ -1016,6 +1023,195 @@ func showVersion(ctx *cli.Context) {
 	}
 }
 
+func backupState(ctx *cli.Context) {
+	if len(ctx.Args()) > 1 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	query := url.Values{}
+	if ctx.Bool("secrets") {
+		query.Set("secrets", "true")
+	}
+	resp, err := client.Get(stateBackupURL(ctx, query))
+	handleBasicError(ctx, err)
+	defer resp.Body.Close()
+	respCheck(resp, ctx)
+
+	out := os.Stdout
+	if len(ctx.Args()) == 1 {
+		out, err = os.Create(ctx.Args()[0])
+		if err != nil {
+			errExit(ctx, exitIO, err.Error(), false)
+		}
+		defer out.Close()
+	}
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		errExit(ctx, exitIO, err.Error(), false)
+	}
+}
+
+func restoreState(ctx *cli.Context) {
+	if len(ctx.Args()) != 1 {
+		errExit(ctx, exitHelp, "Backup file required", true)
+	}
+
+	in, err := os.Open(ctx.Args()[0])
+	if err != nil {
+		errExit(ctx, exitIO, err.Error(), false)
+	}
+	defer in.Close()
+
+	query := url.Values{}
+	if ctx.Bool("clear") {
+		query.Set("clear", "true")
+	}
+	resp, err := client.Post(stateRestoreURL(ctx, query), "application/json", in)
+	handleBasicError(ctx, err)
+	defer resp.Body.Close()
+	respCheck(resp, ctx)
+
+	restored := map[string]int{}
+	errCheck(ctx, json.NewDecoder(resp.Body).Decode(&restored))
+	fmt.Printf("Restored %d keys, restart netmaster and netplugin to use them\n", restored["restored"])
+}
+
+func inspectState(ctx *cli.Context) {
+	if len(ctx.Args()) > 1 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	resp, err := client.Get(stateInspectURL(ctx, ctx.Args().First()))
+	handleBasicError(ctx, err)
+	defer resp.Body.Close()
+	respCheck(resp, ctx)
+
+	content, err := ioutil.ReadAll(resp.Body)
+	handleBasicError(ctx, err)
+
+	// indented as is, decoding would round large numbers
+	out := &bytes.Buffer{}
+	errCheck(ctx, json.Indent(out, content, "", "  "))
+	out.WriteTo(os.Stdout)
+}
+
+func checkState(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	report := master.ChkReport{}
+	if ctx.Bool("repair") {
+		query := url.Values{}
+		if grace := ctx.String("grace"); grace != "" {
+			if _, err := time.ParseDuration(grace); err != nil {
+				errExit(ctx, exitHelp, fmt.Sprintf("Invalid grace %q", grace), true)
+			}
+			query.Set("grace", grace)
+		}
+		resp, err := client.Post(stateChkURL(ctx, query), "application/json", strings.NewReader("{}"))
+		handleBasicError(ctx, err)
+		defer resp.Body.Close()
+		respCheck(resp, ctx)
+		errCheck(ctx, json.NewDecoder(resp.Body).Decode(&report))
+	} else {
+		getObject(ctx, stateChkURL(ctx, url.Values{}), &report)
+	}
+
+	if ctx.Bool("json") {
+		dumpJSONList(ctx, report)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte("Kind\tKey\tDetail\tStatus\n"))
+	writer.Write([]byte("----\t---\t------\t------\n"))
+
+	for _, issue := range report.Issues {
+		status := "-"
+		switch {
+		case issue.Repaired:
+			status = "repaired"
+		case issue.Error != "":
+			status = "failed: " + issue.Error
+		case issue.Repairable && report.Repair:
+			status = fmt.Sprintf("pending since %s", issue.Since.Format(time.RFC3339))
+		case issue.Repairable:
+			status = "repairable"
+		}
+		writer.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\n", issue.Kind, issue.Key, issue.Detail, status)))
+	}
+}
+
+func showAudit(ctx *cli.Context) {
+	if len(ctx.Args()) != 0 {
+		errExit(ctx, exitHelp, "More arguments than required", true)
+	}
+
+	query := url.Values{}
+	if key := ctx.String("key"); key != "" {
+		query.Set("key", key)
+	}
+	if since := ctx.String("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			since = time.Now().Add(-d).Format(time.RFC3339)
+		} else if _, err := time.Parse(time.RFC3339, since); err != nil {
+			errExit(ctx, exitHelp, fmt.Sprintf("Invalid since %q", since), true)
+		}
+		query.Set("since", since)
+	}
+	if before := ctx.String("before"); before != "" {
+		if _, err := time.Parse(time.RFC3339Nano, before); err != nil {
+			errExit(ctx, exitHelp, fmt.Sprintf("Invalid before %q", before), true)
+		}
+		query.Set("before", before)
+	}
+	query.Set("limit", strconv.Itoa(ctx.Int("limit")))
+
+	records := []state.AuditRecord{}
+	getObject(ctx, auditURL(ctx, query), &records)
+
+	if ctx.Bool("json") {
+		dumpJSONList(ctx, records)
+		return
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+	defer writer.Flush()
+	writer.Write([]byte("Time\tActor\tOp\tKey\tField\tOld\tNew\n"))
+	writer.Write([]byte("----\t-----\t--\t---\t-----\t---\t---\n"))
+
+	for _, r := range records {
+		prefix := fmt.Sprintf("%s\t%s\t%s\t%s", r.Time.Format(time.RFC3339), r.Actor, r.Op, r.Key)
+		if len(r.Change) == 0 {
+			writer.Write([]byte(prefix + "\t\t\t\n"))
+		}
+		for _, change := range r.Change {
+			writer.Write([]byte(fmt.Sprintf("%s\t%s\t%s\t%s\n", prefix, change.Field,
+				auditValue(change.Old), auditValue(change.New))))
+			prefix = "\t\t\t"
+		}
+	}
+}
+
+// auditValue formats a changed value of the audit log
+func auditValue(value interface{}) string {
+	if value == nil {
+		return "-"
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	content, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(content)
+}
+
 func createAppProfile(ctx *cli.Context) {
 	if len(ctx.Args()) != 1 {
 		errExit(ctx, exitHelp, "Profile name required", true)
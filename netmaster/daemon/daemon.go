@@ -25,10 +25,12 @@ import (
 	"time"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/docknet"
 	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
 	"github.com/contiv/netplugin/netmaster/objApi"
 	"github.com/contiv/netplugin/netmaster/resources"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/objdb"
 	"github.com/contiv/ofnet"
@@ -44,12 +46,46 @@ type MasterDaemon struct {
 	// Public state
 	ListenURL    string // URL where netmaster needs to listen
 	ClusterStore string // state store URL
-	ClusterMode  string // cluster scheduler used docker/kubernetes/mesos etc
+	// client certificate, CA bundle and credentials of the state store
+	ClusterStoreSecurity core.DbSecurity
+	ClusterMode          string // cluster scheduler used docker/kubernetes/mesos etc
+
+	// read cache of the state store, see state.CachedStateDriver
+	ClusterStoreCache       bool
+	ClusterStoreCacheMaxAge time.Duration
+
+	// docknet reconcile loop run by the leader, disabled if the interval is 0
+	DocknetReconcileInterval time.Duration
+	DocknetReconcileMode     docknet.ReconcileMode
+
+	// docker event watcher run by the leader, disabled if the policy is 0
+	DocknetRemovedPolicy docknet.RemovedPolicy
+
+	// orphan docker network GC run by the leader, disabled if the interval
+	// is 0
+	DocknetGCInterval time.Duration
+	DocknetGCGrace    time.Duration
+	DocknetGCDryRun   bool
+
+	// state consistency check run by the leader, disabled if the interval
+	// is 0, see master.CheckState
+	StateChkInterval time.Duration
+	StateChkRepair   bool
+	StateChkGrace    time.Duration
+
+	// serve state store backups with the sensitive fields, which are
+	// redacted otherwise
+	StateBackupSecrets bool
+
+	// records of the audit log kept by the leader
+	AuditRetention state.AuditRetention
 
 	// Private state
 	currState        string                          // Current state of the daemon
 	apiController    *objApi.APIController           // API controller for contiv model
 	stateDriver      core.StateDriver                // KV store
+	storeMetrics     *state.MeteredStateDriver       // KV store metrics
+	auditDriver      *state.AuditedStateDriver       // KV store audit log
 	resmgr           *resources.StateResourceManager // state resource manager
 	objdbClient      objdb.API                       // Objdb client
 	ofnetMaster      *ofnet.OfnetMaster              // Ofnet master instance
@@ -69,10 +105,28 @@ func (d *MasterDaemon) Init() {
 	}
 
 	// initialize state driver
-	d.stateDriver, err = initStateDriver(d.ClusterStore)
+	d.stateDriver, err = initStateDriver(d.ClusterStore, d.ClusterStoreSecurity)
 	if err != nil {
 		log.Fatalf("Failed to init state-store. Error: %s", err)
 	}
+	// the cache is in front of the metrics, which only count the reads
+	// reaching the state store
+	d.storeMetrics, err = utils.MeterStateDriver()
+	if err != nil {
+		log.Fatalf("Failed to meter state-store. Error: %s", err)
+	}
+	// config state changes are recorded in the audit log
+	d.auditDriver, err = utils.AuditStateDriver(auditActor(), mastercfg.StateConfigPath)
+	if err != nil {
+		log.Fatalf("Failed to audit state-store. Error: %s", err)
+	}
+	d.stateDriver = d.auditDriver
+	if d.ClusterStoreCache {
+		d.stateDriver, err = utils.CacheStateDriver(d.ClusterStoreCacheMaxAge)
+		if err != nil {
+			log.Fatalf("Failed to cache state-store. Error: %s", err)
+		}
+	}
 
 	// Initialize resource manager
 	d.resmgr, err = resources.NewStateResourceManager(d.stateDriver)
@@ -81,7 +135,7 @@ func (d *MasterDaemon) Init() {
 	}
 
 	// Create an objdb client
-	d.objdbClient, err = objdb.NewClient(d.ClusterStore)
+	d.objdbClient, err = utils.NewObjdbClient(d.ClusterStore, d.ClusterStoreSecurity)
 	if err != nil {
 		log.Fatalf("Error connecting to state store: %v. Err: %v", d.ClusterStore, err)
 	}
@@ -175,6 +229,8 @@ func (d *MasterDaemon) registerRoutes(router *mux.Router) {
 	s.HandleFunc("/plugin/createEndpoint", makeHTTPHandler(master.CreateEndpointHandler))
 	s.HandleFunc("/plugin/deleteEndpoint", makeHTTPHandler(master.DeleteEndpointHandler))
 	s.HandleFunc("/plugin/updateEndpoint", makeHTTPHandler(master.UpdateEndpointHandler))
+	s.HandleFunc(fmt.Sprintf("/%s", master.StateRestoreRESTEndpoint), d.restoreState)
+	s.HandleFunc(fmt.Sprintf("/%s", master.StateChkRESTEndpoint), d.repairState)
 
 	s = router.Methods("Get").Subrouter()
 
@@ -207,6 +263,27 @@ func (d *MasterDaemon) registerRoutes(router *mux.Router) {
 	s.HandleFunc(fmt.Sprintf("/%s", master.GetServicesRESTEndpoint),
 		get(true, d.services))
 
+	// docknet REST endpoints, to debug the docker network name mapping
+	s.HandleFunc(fmt.Sprintf("/%s/%s", master.GetDocknetRESTEndpoint, "{id}"), getDocknet)
+	s.HandleFunc(fmt.Sprintf("/%s", master.GetDocknetsRESTEndpoint), getDocknets)
+
+	// state store backup, restored with a POST
+	s.HandleFunc(fmt.Sprintf("/%s", master.StateBackupRESTEndpoint), d.backupState)
+
+	// state store metrics, in the prometheus text format
+	s.HandleFunc(fmt.Sprintf("/%s", master.GetMetricsRESTEndpoint), d.getMetrics)
+
+	// state consistency check, without repairs
+	s.HandleFunc(fmt.Sprintf("/%s", master.StateChkRESTEndpoint), d.checkState)
+
+	// audit log of the config state changes, see getAuditLog for the filters
+	s.HandleFunc(fmt.Sprintf("/%s", master.AuditRESTEndpoint), d.getAuditLog)
+
+	// Debug REST endpoint for inspecting the state store, the path is
+	// relative to the contiv base key
+	s.HandleFunc(fmt.Sprintf("/%s", master.StateInspectRESTEndpoint), d.inspectState)
+	s.HandleFunc(fmt.Sprintf("/%s/{path:.*}", master.StateInspectRESTEndpoint), d.inspectState)
+
 	// Debug REST endpoint for inspecting ofnet state
 	s.HandleFunc("/debug/ofnet", func(w http.ResponseWriter, r *http.Request) {
 		ofnetMasterState, err := d.ofnetMaster.InspectState()
@@ -228,8 +305,27 @@ func (d *MasterDaemon) runLeader() {
 	d.listenerMutex.Lock()
 	defer d.listenerMutex.Unlock()
 
+	// finish the state batches of the previous leader
+	if err := state.ReplayJournal(d.stateDriver); err != nil {
+		log.Errorf("Error replaying the state journal. Err: %v", err)
+	}
+
+	// upgrade the states written by older versions
+	if count, err := state.MigrateAllState(d.stateDriver); err != nil {
+		log.Errorf("Error migrating the state. Err: %v", err)
+	} else if count > 0 {
+		log.Infof("Migrated %d states written by older versions", count)
+	}
+
+	// copy the docknet oper states of older releases into their tenants
+	if count, err := docknet.CopyLegacyOperStates(); err != nil {
+		log.Errorf("Error copying docknet oper states. Err: %v", err)
+	} else if count > 0 {
+		log.Infof("Copied %d docknet oper states to their tenants", count)
+	}
+
 	// Create a new api controller
-	d.apiController = objApi.NewAPIController(router, d.objdbClient, d.ClusterStore)
+	d.apiController = objApi.NewAPIController(router, d.objdbClient, d.ClusterStore, d.ClusterStoreSecurity)
 
 	//Restore state from clusterStore
 	d.restoreCache()
@@ -244,7 +340,7 @@ func (d *MasterDaemon) runLeader() {
 	d.registerRoutes(router)
 
 	// Create HTTP server and listener
-	server := &http.Server{Handler: router}
+	server := &http.Server{Handler: d.auditCallers(router)}
 	server.SetKeepAlivesEnabled(false)
 	listener, err := net.Listen("tcp", d.ListenURL)
 	if nil != err {
@@ -258,10 +354,54 @@ func (d *MasterDaemon) runLeader() {
 	// start server
 	go server.Serve(listener)
 
+	// keep the docker networks in sync with the docknet state
+	stopReconcile := func() {}
+	if d.DocknetReconcileInterval > 0 {
+		log.Infof("Reconciling docknets every %v, mode %s", d.DocknetReconcileInterval, d.DocknetReconcileMode)
+		stopReconcile = docknet.StartReconcileLoop(d.DocknetReconcileInterval, d.DocknetReconcileMode)
+	}
+	stopWatcher := func() {}
+	if d.DocknetRemovedPolicy != 0 {
+		log.Infof("Watching docker network events, removed networks policy %s", d.DocknetRemovedPolicy)
+		// the watcher reconnects with a backoff, only a bad policy fails it
+		if stop, err := docknet.StartEventWatcher(d.DocknetRemovedPolicy); err != nil {
+			log.Errorf("Error watching docker events, removed networks are not handled. Err: %v", err)
+		} else {
+			stopWatcher = stop
+		}
+	}
+
+	stopGC := func() {}
+	if d.DocknetGCInterval > 0 {
+		log.Infof("Collecting orphan docker networks every %v, grace period %v, dry run %v",
+			d.DocknetGCInterval, d.DocknetGCGrace, d.DocknetGCDryRun)
+		stopGC = docknet.StartGCLoop(d.DocknetGCInterval, d.DocknetGCGrace, d.DocknetGCDryRun)
+	}
+
+	stopChk := func() {}
+	if d.StateChkInterval > 0 {
+		log.Infof("Checking the state every %v, repair %v, grace period %v",
+			d.StateChkInterval, d.StateChkRepair, d.StateChkGrace)
+		stopChk = master.StartChkLoop(d.StateChkInterval,
+			master.ChkOptions{Repair: d.StateChkRepair, Grace: d.StateChkGrace})
+	}
+
+	stopTrim := func() {}
+	if d.AuditRetention.MaxRecords > 0 || d.AuditRetention.MaxAge > 0 {
+		log.Infof("Keeping %d audit log records, max age %v",
+			d.AuditRetention.MaxRecords, d.AuditRetention.MaxAge)
+		stopTrim = d.startAuditTrimLoop()
+	}
+
 	// Wait till we are asked to stop
 	<-d.stopLeaderChan
 
 	// Close the listener and exit
+	stopTrim()
+	stopChk()
+	stopGC()
+	stopWatcher()
+	stopReconcile()
 	listener.Close()
 	log.Infof("Exiting Leader mode")
 }
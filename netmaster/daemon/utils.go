@@ -18,13 +18,20 @@ package daemon
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/docknet"
+	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/netplugin/version"
@@ -51,7 +58,7 @@ func getVersion(w http.ResponseWriter, r *http.Request) {
 }
 
 // initStateDriver creates a state driver based on the cluster store URL
-func initStateDriver(clusterStore string) (core.StateDriver, error) {
+func initStateDriver(clusterStore string, security core.DbSecurity) (core.StateDriver, error) {
 	// parse the state store URL
 	parts := strings.Split(clusterStore, "://")
 	if len(parts) < 2 {
@@ -69,7 +76,8 @@ func initStateDriver(clusterStore string) (core.StateDriver, error) {
 
 	// Setup instance info
 	instInfo := core.InstanceInfo{
-		DbURL: clusterStore,
+		DbURL:      clusterStore,
+		DbSecurity: security,
 	}
 
 	return utils.NewStateDriver(stateStore, &instInfo)
@@ -215,3 +223,270 @@ func (d *MasterDaemon) services(id string) ([]core.State, error) {
 
 	return nil, err
 }
+
+// getDocknets returns the docknet oper states
+func getDocknets(w http.ResponseWriter, r *http.Request) {
+	infos, err := docknet.ListDocknetInfo()
+	if err != nil {
+		log.Errorf("Error listing docknets. Err: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, infos); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// getDocknet returns the docknet oper state of an oper state ID
+func getDocknet(w http.ResponseWriter, r *http.Request) {
+	info, err := docknet.InspectDockNet(mux.Vars(r)["id"])
+	if err == docknet.ErrDocknetNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Errorf("Error inspecting docknet. Err: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, info); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// backupState returns a backup of the contiv state in the state store, with
+// the sensitive fields redacted unless the secrets query parameter is true.
+// Backups with the secrets must be enabled with StateBackupSecrets.
+func (d *MasterDaemon) backupState(w http.ResponseWriter, r *http.Request) {
+	secrets := r.URL.Query().Get("secrets") == "true"
+	if secrets && !d.StateBackupSecrets {
+		http.Error(w, "backups with secrets are disabled, see -state-backup-secrets", http.StatusForbidden)
+		return
+	}
+
+	backup, err := state.NewBackup(d.stateDriver)
+	if err != nil {
+		log.Errorf("Error backing up the state store. Err: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !secrets {
+		backup.Redact()
+	}
+
+	if err := writeJSON(w, http.StatusOK, backup); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// inspectState returns the state under a path, with the secrets redacted
+func (d *MasterDaemon) inspectState(w http.ResponseWriter, r *http.Request) {
+	entries, err := state.InspectState(d.stateDriver, mux.Vars(r)["path"])
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Errorf("Error inspecting the state store. Err: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, entries); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// checkState checks the consistency of the state, see master.CheckState
+func (d *MasterDaemon) checkState(w http.ResponseWriter, r *http.Request) {
+	d.writeChkReport(w, master.ChkOptions{})
+}
+
+// repairState checks the consistency of the state and repairs the issues
+// older than the grace query parameter, a duration, or the grace period of
+// the state check loop
+func (d *MasterDaemon) repairState(w http.ResponseWriter, r *http.Request) {
+	opts := master.ChkOptions{Repair: true, Grace: d.StateChkGrace}
+	if grace := r.URL.Query().Get("grace"); grace != "" {
+		g, err := time.ParseDuration(grace)
+		if err != nil || g < 0 {
+			http.Error(w, fmt.Sprintf("Invalid grace %q", grace), http.StatusBadRequest)
+			return
+		}
+		opts.Grace = g
+	}
+
+	d.writeChkReport(w, opts)
+}
+
+// writeChkReport checks the state and returns the report
+func (d *MasterDaemon) writeChkReport(w http.ResponseWriter, opts master.ChkOptions) {
+	report, err := master.CheckState(d.stateDriver, opts)
+	if err != nil {
+		log.Errorf("Error checking the state. Err: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, report); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// auditActor returns the actor of the changes recorded in the audit log
+func auditActor() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Warnf("Error getting the hostname for the audit log. Err: %v", err)
+		return "netmaster"
+	}
+
+	return "netmaster@" + hostname
+}
+
+// auditTrimInterval is the interval of the audit log trims of the leader
+const auditTrimInterval = 10 * time.Minute
+
+// startAuditTrimLoop trims the audit log to the retention limits now and
+// every auditTrimInterval, until the returned function is called
+func (d *MasterDaemon) startAuditTrimLoop() func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ticker := time.NewTicker(auditTrimInterval)
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		for {
+			if count, err := state.TrimAuditLog(d.stateDriver, d.AuditRetention); err != nil {
+				log.Errorf("Error trimming the audit log. Err: %v", err)
+			} else if count > 0 {
+				log.Infof("Trimmed %d audit log records", count)
+			}
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// auditCallers records the state changes made by the requests other than
+// GETs as made by their clients in the audit log. The requests are
+// serialized, see state.AuditedStateDriver.RunAs.
+func (d *MasterDaemon) auditCallers(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.auditDriver == nil || r.Method == "GET" || r.Method == "HEAD" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		d.auditDriver.RunAs(restCaller(r), func() {
+			handler.ServeHTTP(w, r)
+		})
+	})
+}
+
+// restCaller returns the actor of a REST request, the address of its client.
+// Requests proxied by followers are from the client they forwarded.
+func restCaller(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return "rest@" + strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "rest@" + host
+}
+
+// getAuditLog returns the audit log records, oldest first. They are filtered
+// by the key, since, before and limit query parameters: the key prefix of
+// the changed states, the RFC 3339 time of the oldest record, the RFC 3339
+// time the records are older than, to page back in the log, and the number
+// of most recent records.
+func (d *MasterDaemon) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := state.AuditFilter{Key: query.Get("key")}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since %q. Err: %v", since, err), http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if before := query.Get("before"); before != "" {
+		t, err := time.Parse(time.RFC3339Nano, before)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid before %q. Err: %v", before, err), http.StatusBadRequest)
+			return
+		}
+		filter.Before = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Invalid limit %q", limit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	records, err := state.ReadAuditLog(d.stateDriver, filter)
+	if err != nil {
+		log.Errorf("Error reading the audit log. Err: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, records); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
+
+// getMetrics returns the state store metrics
+func (d *MasterDaemon) getMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := d.storeMetrics.WriteMetrics(w); err != nil {
+		log.Errorf("Error writing metrics. Err: %v", err)
+	}
+}
+
+// restoreState restores a backup of the state store. The contiv state must be
+// empty, or cleared with the clear query parameter set to true.
+func (d *MasterDaemon) restoreState(w http.ResponseWriter, r *http.Request) {
+	backup := &state.Backup{}
+	if err := json.NewDecoder(r.Body).Decode(backup); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid backup. Err: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := backup.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := state.RestoreBackup(d.stateDriver, backup, r.URL.Query().Get("clear") == "true")
+	if err == state.ErrStateNotEmpty {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, map[string]int{"restored": len(backup.Entries)}); err != nil {
+		log.Errorf("Error generating json. Err: %v", err)
+	}
+}
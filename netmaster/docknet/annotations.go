@@ -0,0 +1,60 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+const (
+	// annotationsOption is the driver option carrying the annotations of a
+	// network, as a JSON object
+	annotationsOption = "annotations"
+
+	// maxAnnotationsSize is the maximum size of the encoded annotations
+	maxAnnotationsSize = 4096
+)
+
+var (
+	// ErrInvalidAnnotations is returned when the annotations are not a JSON
+	// object
+	ErrInvalidAnnotations = errors.New("annotations must be a JSON object")
+
+	// ErrAnnotationsTooLarge is returned when the encoded annotations are
+	// larger than maxAnnotationsSize
+	ErrAnnotationsTooLarge = errors.New("annotations are too large")
+)
+
+// encodeAnnotations checks the annotations are a JSON object of at most
+// maxAnnotationsSize bytes, and returns them compacted
+func encodeAnnotations(annotations json.RawMessage) (string, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(annotations, &obj); err != nil || obj == nil {
+		return "", ErrInvalidAnnotations
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, annotations); err != nil {
+		return "", ErrInvalidAnnotations
+	}
+	if buf.Len() > maxAnnotationsSize {
+		return "", ErrAnnotationsTooLarge
+	}
+
+	return buf.String(), nil
+}
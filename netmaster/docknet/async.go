@@ -0,0 +1,132 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// defaultAsyncConcurrency is the default number of async creates running at
+// a time
+const defaultAsyncConcurrency = 8
+
+// CreateResult is the outcome of a create. NetworkID is the docker network
+// ID, and DocknetName and PktTag are the docker network name and packet tag
+// chosen under the conflict policy. Err is only set for async creates.
+type CreateResult struct {
+	NetworkID   string
+	DocknetName string
+	PktTag      int
+	Err         error
+}
+
+// CreateHandle tracks an async create
+type CreateHandle struct {
+	done   chan CreateResult
+	result CreateResult
+}
+
+// Done returns a channel receiving the result when the create completes.
+// Either Done or Wait may be used, not both.
+func (h *CreateHandle) Done() <-chan CreateResult {
+	return h.done
+}
+
+// Wait blocks until the create completes and returns the docker network ID
+func (h *CreateHandle) Wait() (string, error) {
+	if result, ok := <-h.done; ok {
+		h.result = result
+	}
+
+	return h.result.NetworkID, h.result.Err
+}
+
+// SetAsyncConcurrency sets how many async creates run at a time. Creates
+// already running are not affected.
+func SetAsyncConcurrency(n int) error {
+	if n < 1 {
+		return errors.New("async concurrency must be at least 1")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.asyncSlots = make(chan struct{}, n)
+
+	return nil
+}
+
+// CreateDockNetAsync creates a network in the background, at most
+// SetAsyncConcurrency creates running at a time. The returned handle gives
+// the outcome.
+func CreateDockNetAsync(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) *CreateHandle {
+	slots := getConfig().asyncSlots
+	handle := &CreateHandle{done: make(chan CreateResult, 1)}
+
+	go func() {
+		slots <- struct{}{}
+		defer func() { <-slots }()
+
+		result, err := createDockNet(tenantName, networkName, serviceName, nwCfg, opts)
+		result.Err = err
+		handle.done <- result
+		close(handle.done)
+	}()
+
+	return handle
+}
+
+// CreateDockNets creates the networks of a tenant concurrently, at most
+// SetAsyncConcurrency creates running at a time. Specs without a tenant are
+// created in tenantName. The results are in the order of the specs, and the
+// error names each docknet that failed, the others are still created.
+func CreateDockNets(tenantName string, specs []DockNetSpec) ([]CreateResult, error) {
+	results := make([]CreateResult, len(specs))
+	handles := make([]*CreateHandle, len(specs))
+	for i, spec := range specs {
+		if spec.TenantName == "" {
+			spec.TenantName = tenantName
+		}
+		if spec.TenantName != tenantName {
+			results[i].Err = fmt.Errorf("docknet is in tenant %s, not %s", spec.TenantName, tenantName)
+			continue
+		}
+		handles[i] = CreateDockNetAsync(spec.TenantName, spec.NetworkName, spec.ServiceName, spec.NwCfg, spec.Options)
+	}
+
+	errs := []string{}
+	for i, handle := range handles {
+		if handle != nil {
+			handle.Wait()
+			results[i] = handle.result
+		}
+		if results[i].Err != nil {
+			operID := docknetOperID(tenantName, specs[i].NetworkName, specs[i].ServiceName)
+			errs = append(errs, fmt.Sprintf("%s: %v", operID, results[i].Err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return results, fmt.Errorf("error creating %d of %d docker networks: %s", len(errs), len(specs),
+			strings.Join(errs, "; "))
+	}
+
+	return results, nil
+}
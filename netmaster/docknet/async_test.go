@@ -0,0 +1,154 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+// slowDockerClient delays network creates and tracks how many run at a time
+type slowDockerClient struct {
+	*fakeDockerClient
+	mutex   sync.Mutex
+	running int
+	maxSeen int
+}
+
+func (d *slowDockerClient) CreateNetwork(config *dockerclient.NetworkCreate) (*dockerclient.NetworkCreateResponse, error) {
+	d.mutex.Lock()
+	d.running++
+	if d.running > d.maxSeen {
+		d.maxSeen = d.running
+	}
+	d.mutex.Unlock()
+
+	time.Sleep(5 * time.Millisecond)
+	defer func() {
+		d.mutex.Lock()
+		d.running--
+		d.mutex.Unlock()
+	}()
+
+	return d.fakeDockerClient.CreateNetwork(config)
+}
+
+func TestCreateDockNetAsync(t *testing.T) {
+	fake, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetAsyncConcurrency(defaultAsyncConcurrency)
+
+	docker := &slowDockerClient{fakeDockerClient: fake}
+	newDockerClient = func() (dockerclient.Client, error) {
+		return docker, nil
+	}
+	if err := SetAsyncConcurrency(0); err == nil {
+		t.Fatalf("Zero async concurrency was accepted")
+	}
+	SetAsyncConcurrency(3)
+
+	handles := []*CreateHandle{}
+	for i := 0; i < 20; i++ {
+		netName := fmt.Sprintf("net%d", i)
+		handles = append(handles, CreateDockNetAsync("unit-test", netName, "",
+			fakeNwCfg("unit-test", netName), DockNetOptions{}))
+	}
+
+	// a failing create reports its error
+	nwCfg := fakeNwCfg("unit-test", "bad")
+	nwCfg.Gateway = "10.9.9.9"
+	badHandle := CreateDockNetAsync("unit-test", "bad", "", nwCfg, DockNetOptions{})
+
+	for i, handle := range handles {
+		nwID, err := handle.Wait()
+		if err != nil {
+			t.Fatalf("Error creating network %d. Err: %v", i, err)
+		}
+		dnet := getDocknetState("unit-test", fmt.Sprintf("net%d", i), "")
+		if dnet == nil || dnet.DocknetUUID != nwID {
+			t.Fatalf("Unexpected oper state %+v for network ID %s", dnet, nwID)
+		}
+	}
+	result := <-badHandle.Done()
+	if result.Err != ErrGatewayOutsideSubnet || result.NetworkID != "" {
+		t.Fatalf("Unexpected result %+v", result)
+	}
+
+	if docker.maxSeen < 2 || docker.maxSeen > 3 {
+		t.Fatalf("%d creates ran at a time, expected up to 3", docker.maxSeen)
+	}
+}
+
+func TestCreateDockNets(t *testing.T) {
+	fake, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetAsyncConcurrency(defaultAsyncConcurrency)
+
+	docker := &slowDockerClient{fakeDockerClient: fake}
+	newDockerClient = func() (dockerclient.Client, error) {
+		return docker, nil
+	}
+	SetAsyncConcurrency(4)
+
+	// a network with a dozen endpoint groups
+	specs := []DockNetSpec{{NetworkName: "net1", NwCfg: fakeNwCfg("unit-test", "net1")}}
+	for i := 0; i < 12; i++ {
+		specs = append(specs, DockNetSpec{
+			NetworkName: "net1",
+			ServiceName: fmt.Sprintf("epg%d", i),
+			NwCfg:       fakeNwCfg("unit-test", "net1"),
+		})
+	}
+	results, err := CreateDockNets("unit-test", specs)
+	if err != nil {
+		t.Fatalf("Error creating networks. Err: %v", err)
+	}
+	for i, spec := range specs {
+		dnet := getDocknetState("unit-test", spec.NetworkName, spec.ServiceName)
+		if dnet == nil || dnet.DocknetUUID != results[i].NetworkID ||
+			results[i].DocknetName != GetDocknetName("unit-test", spec.NetworkName, spec.ServiceName) {
+			t.Fatalf("Unexpected result %+v for docknet %+v", results[i], dnet)
+		}
+	}
+	if docker.maxSeen < 2 || docker.maxSeen > 4 {
+		t.Fatalf("%d creates ran at a time, expected up to 4", docker.maxSeen)
+	}
+
+	// failures are reported together, the other networks are created
+	badCfg := fakeNwCfg("unit-test", "bad")
+	badCfg.Gateway = "10.9.9.9"
+	specs = []DockNetSpec{
+		{NetworkName: "bad", NwCfg: badCfg},
+		{NetworkName: "net2", NwCfg: fakeNwCfg("unit-test", "net2")},
+		{TenantName: "other", NetworkName: "net3", NwCfg: fakeNwCfg("other", "net3")},
+	}
+	results, err = CreateDockNets("unit-test", specs)
+	exp := "error creating 2 of 3 docker networks: unit-test.bad.: " + ErrGatewayOutsideSubnet.Error() +
+		"; unit-test.net3.: docknet is in tenant other, not unit-test"
+	if err == nil || err.Error() != exp {
+		t.Fatalf("Expected error %q, got: %v", exp, err)
+	}
+	if results[0].Err != ErrGatewayOutsideSubnet || results[1].Err != nil || results[2].Err == nil {
+		t.Fatalf("Unexpected results %+v", results)
+	}
+	if getDocknetState("unit-test", "net2", "") == nil || getDocknetState("other", "net3", "") != nil {
+		t.Fatalf("Unexpected docknets after partial failure")
+	}
+}
@@ -0,0 +1,117 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// StartAutoDeleteLoop checks the docknets created with AutoDeleteWhenEmpty
+// every interval, and deletes those whose docker network has had no
+// endpoints for the grace period. The returned function stops the loop.
+func StartAutoDeleteLoop(interval, grace time.Duration) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		// when each docknet was first seen empty
+		emptySince := make(map[string]time.Time)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if err := autoDeleteEmpty(emptySince, grace, time.Now()); err != nil {
+				log.Errorf("Error deleting empty docknets. Err: %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// autoDeleteEmpty deletes the auto delete docknets that have been empty
+// since before the grace period. emptySince is updated with the docknets
+// seen empty.
+func autoDeleteEmpty(emptySince map[string]time.Time, grace time.Duration, now time.Time) error {
+	dnets, err := ListDockNets()
+	if err != nil {
+		return err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	seen := make(map[string]bool)
+	for _, dnet := range dnets {
+		if !dnet.AutoDeleteWhenEmpty {
+			continue
+		}
+
+		nw, err := docker.InspectNetwork(dnet.DocknetUUID)
+		if isNotFound(err) {
+			continue
+		} else if err != nil {
+			log.Errorf("Error inspecting network %s. Err: %v", dnet.DocknetName(), err)
+			continue
+		}
+		if len(nw.Containers) > 0 {
+			continue
+		}
+
+		seen[dnet.ID] = true
+		since, ok := emptySince[dnet.ID]
+		if !ok {
+			emptySince[dnet.ID] = now
+			continue
+		}
+		if now.Sub(since) < grace {
+			continue
+		}
+
+		logInfof("Deleting docknet %s, empty since %v", dnet.ID, since)
+		if err := DeleteDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName); err != nil {
+			log.Errorf("Error deleting empty docknet %s. Err: %v", dnet.ID, err)
+			continue
+		}
+		delete(seen, dnet.ID)
+	}
+
+	// forget docknets that are gone or have endpoints again
+	for id := range emptySince {
+		if !seen[id] {
+			delete(emptySince, id)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoDeleteEmpty(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	err := CreateDockNetWithOptions("unit-test", "job", "", fakeNwCfg("unit-test", "job"),
+		DockNetOptions{AutoDeleteWhenEmpty: true})
+	if err != nil {
+		t.Fatalf("Error creating auto delete network. Err: %v", err)
+	}
+	if err := CreateDockNet("unit-test", "keep", "", fakeNwCfg("unit-test", "keep")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if dnet := getDocknetState("unit-test", "job", ""); dnet == nil || !dnet.AutoDeleteWhenEmpty {
+		t.Fatalf("Auto delete flag not saved in oper state: %+v", dnet)
+	}
+
+	jobName := GetDocknetName("unit-test", "job", "")
+	docker.addContainer("c1", jobName)
+
+	grace := time.Minute
+	start := time.Now()
+	emptySince := make(map[string]time.Time)
+
+	// not deleted while a container is attached
+	if err := autoDeleteEmpty(emptySince, grace, start); err != nil {
+		t.Fatalf("Error deleting empty docknets. Err: %v", err)
+	}
+	if err := autoDeleteEmpty(emptySince, grace, start.Add(2*grace)); err != nil {
+		t.Fatalf("Error deleting empty docknets. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "job", "") == nil {
+		t.Fatalf("Docknet with a container was deleted")
+	}
+
+	// not deleted within the grace period
+	if err := docker.DisconnectNetwork(jobName, "c1", false); err != nil {
+		t.Fatalf("Error disconnecting container. Err: %v", err)
+	}
+	now := start.Add(3 * grace)
+	if err := autoDeleteEmpty(emptySince, grace, now); err != nil {
+		t.Fatalf("Error deleting empty docknets. Err: %v", err)
+	}
+	if err := autoDeleteEmpty(emptySince, grace, now.Add(grace/2)); err != nil {
+		t.Fatalf("Error deleting empty docknets. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "job", "") == nil {
+		t.Fatalf("Docknet was deleted within the grace period")
+	}
+
+	// deleted after the grace period, networks without the flag are kept
+	if err := autoDeleteEmpty(emptySince, grace, now.Add(grace)); err != nil {
+		t.Fatalf("Error deleting empty docknets. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "job", "") != nil {
+		t.Fatalf("Empty docknet was not deleted")
+	}
+	if _, err := docker.InspectNetwork(jobName); err == nil {
+		t.Fatalf("Docker network of the empty docknet was not deleted")
+	}
+	if getDocknetState("unit-test", "keep", "") == nil {
+		t.Fatalf("Docknet without auto delete was deleted")
+	}
+	if len(emptySince) != 0 {
+		t.Fatalf("Deleted docknet is still tracked: %v", emptySince)
+	}
+}
+
+func TestStartAutoDeleteLoop(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	err := CreateDockNetWithOptions("unit-test", "job", "", fakeNwCfg("unit-test", "job"),
+		DockNetOptions{AutoDeleteWhenEmpty: true})
+	if err != nil {
+		t.Fatalf("Error creating auto delete network. Err: %v", err)
+	}
+
+	stop := StartAutoDeleteLoop(time.Millisecond, 0)
+	defer stop()
+
+	for i := 0; i < 1000; i++ {
+		if getDocknetState("unit-test", "job", "") == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Empty docknet was not deleted by the loop")
+}
@@ -0,0 +1,295 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// config has the package configuration. Operations take a snapshot of it when
+// they start, so that a setter running concurrently does not change the
+// configuration halfway through an operation.
+type config struct {
+	netDriverName  string
+	ipamDriverName string
+	maxIPAMPools   int
+	createPolicy   CreatePolicy
+	retryAttempts  int
+	retryBackoff   Backoff
+	createHooks    []CreateHook
+	fabricCheck    func(encap string) error
+	logLevel       log.Level
+
+	// readStateDriver is used for reads when set
+	readStateDriver core.StateDriver
+
+	// ownedDriverNames are legacy network driver names whose networks are
+	// still managed by docknet
+	ownedDriverNames []string
+
+	// asyncSlots bounds the async creates running at a time
+	asyncSlots chan struct{}
+
+	// prefixProvider supplies delegated IPv6 prefixes
+	prefixProvider IPv6PrefixProvider
+
+	// maxNameLength limits docker network names when not zero, longer
+	// names are truncated if truncateNames is set
+	maxNameLength int
+	truncateNames bool
+
+	// nameScheme names new docker networks, nil for the legacy naming
+	nameScheme NameScheme
+
+	// tagConflictCheck rejects packet tags used on the same segment
+	tagConflictCheck bool
+
+	// maxDocknets limits the number of docknets when not zero
+	maxDocknets int
+
+	// sensitiveOptions are the options whose values are redacted
+	sensitiveOptions map[string]bool
+
+	// optionSchema validates the user-provided options, unknown keys are
+	// rejected with StrictOptions
+	optionSchema     map[string]func(string) error
+	optionValidation OptionValidation
+
+	// tenantSupernets are the supernets tenant subnets must be within
+	tenantSupernets map[string][]*net.IPNet
+}
+
+var (
+	// configMutex protects pkgConfig
+	configMutex sync.RWMutex
+	pkgConfig   = config{
+		netDriverName:  "netplugin",
+		ipamDriverName: "netplugin",
+		maxIPAMPools:   defaultMaxIPAMPools,
+		createPolicy:   AutoCreate,
+		retryAttempts:  defaultRetryAttempts,
+		retryBackoff:   defaultRetryBackoff,
+		logLevel:       log.DebugLevel,
+		asyncSlots:     make(chan struct{}, defaultAsyncConcurrency),
+	}
+)
+
+// getConfig returns a snapshot of the package configuration
+func getConfig() config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return pkgConfig
+}
+
+// SetDriverNames sets the network and IPAM drivers of docker networks
+func SetDriverNames(netDriver, ipamDriver string) error {
+	if netDriver == "" || ipamDriver == "" {
+		return errors.New("driver names can not be empty")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.netDriverName = netDriver
+	pkgConfig.ipamDriverName = ipamDriver
+
+	return nil
+}
+
+// AddOwnedDriverName makes docknet keep managing docker networks created with
+// a previous network driver name, eg. after SetDriverNames renamed the driver.
+// New networks are always created with the current driver.
+func AddOwnedDriverName(name string) error {
+	if name == "" {
+		return errors.New("driver name can not be empty")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	for _, owned := range pkgConfig.ownedDriverNames {
+		if owned == name {
+			return nil
+		}
+	}
+	// copy on write, snapshots may be using the old slice
+	names := make([]string, len(pkgConfig.ownedDriverNames), len(pkgConfig.ownedDriverNames)+1)
+	copy(names, pkgConfig.ownedDriverNames)
+	pkgConfig.ownedDriverNames = append(names, name)
+
+	return nil
+}
+
+// ownsDriver returns true if networks of the driver are managed by docknet
+func (c config) ownsDriver(driverName string) bool {
+	if driverName == c.netDriverName {
+		return true
+	}
+	for _, owned := range c.ownedDriverNames {
+		if owned == driverName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetMaxNameLength limits the length of docker network names, for systems
+// with stricter limits than docker. Longer names are rejected, or truncated
+// and made unique with a hash if truncate is set. Networks created with a
+// truncated name keep it as their raw name. A zero limit removes the limit.
+func SetMaxNameLength(limit int, truncate bool) error {
+	if limit < 0 || truncate && limit != 0 && limit <= nameHashLen+1 {
+		return fmt.Errorf("invalid docker network name length limit %d", limit)
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.maxNameLength = limit
+	pkgConfig.truncateNames = truncate
+
+	return nil
+}
+
+// SetCreatePolicy sets whether CreateDockNet may create docker networks
+func SetCreatePolicy(policy CreatePolicy) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.createPolicy = policy
+}
+
+// SetMaxIPAMPools sets the maximum number of subnets a docker network can have
+func SetMaxIPAMPools(max int) error {
+	if max < 1 {
+		return fmt.Errorf("invalid max IPAM pools %d", max)
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.maxIPAMPools = max
+
+	return nil
+}
+
+// SetRetryAttempts sets how many times docker calls are attempted before
+// giving up
+func SetRetryAttempts(attempts int) error {
+	if attempts < 1 {
+		return fmt.Errorf("invalid retry attempts %d", attempts)
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.retryAttempts = attempts
+
+	return nil
+}
+
+// SetRetryBackoff sets the delay between retries of docker calls
+func SetRetryBackoff(backoff Backoff) error {
+	if backoff == nil {
+		return errors.New("retry backoff can not be nil")
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.retryBackoff = backoff
+
+	return nil
+}
+
+// RegisterCreateHook adds a hook called before docker networks are created
+func RegisterCreateHook(hook CreateHook) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	// copy the hooks so that snapshots are not changed
+	hooks := make([]CreateHook, len(pkgConfig.createHooks), len(pkgConfig.createHooks)+1)
+	copy(hooks, pkgConfig.createHooks)
+	pkgConfig.createHooks = append(hooks, hook)
+}
+
+// clearCreateHooks removes all create hooks
+func clearCreateHooks() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.createHooks = nil
+}
+
+// SetReadStateDriver sets a state driver used only for listing and looking up
+// docknets, eg. a read replica of the state store. Writes, and the reads done
+// to update a docknet, always use the primary state driver. Since a replica
+// lags behind the primary, a docknet may not be listed right after it is
+// created, and may still be listed for a while after it is deleted. Setting
+// nil reads from the primary state driver.
+func SetReadStateDriver(stateDriver core.StateDriver) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.readStateDriver = stateDriver
+}
+
+// getWriteStateDriver returns the primary state driver
+func getWriteStateDriver() (core.StateDriver, error) {
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return nil, err
+	}
+
+	return stateDriver, nil
+}
+
+// getReadStateDriver returns the state driver used for lookups
+func getReadStateDriver(cfg config) (core.StateDriver, error) {
+	if cfg.readStateDriver != nil {
+		return cfg.readStateDriver, nil
+	}
+
+	return getWriteStateDriver()
+}
+
+// RegisterFabricCapabilityCheck sets a check that the fabric supports an
+// encap. Networks using an encap the check rejects are not created. Setting
+// nil removes the check.
+func RegisterFabricCapabilityCheck(check func(encap string) error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.fabricCheck = check
+}
+
+// SetLogLevel sets the verbosity of the docknet informational logs, eg.
+// log.WarnLevel keeps bulk operations from logging every network they create
+// or delete. Warnings and errors are always logged. The logrus level still
+// applies on top, and is not changed for other packages.
+func SetLogLevel(level log.Level) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.logLevel = level
+}
+
+// logInfof logs an informational message if the docknet verbosity allows it
+func logInfof(format string, args ...interface{}) {
+	if getConfig().logLevel >= log.InfoLevel {
+		log.Infof(format, args...)
+	}
+}
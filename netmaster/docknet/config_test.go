@@ -0,0 +1,230 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TestConfigConcurrency changes the config while docknets are created. Run
+// with -race to check config access is synchronized.
+func TestConfigConcurrency(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	origCfg := getConfig()
+	defer SetDriverNames(origCfg.netDriverName, origCfg.ipamDriverName)
+	defer SetMaxIPAMPools(origCfg.maxIPAMPools)
+	SetDriverNames("net-a", "ipam-a")
+
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				SetDriverNames("net-a", "ipam-a")
+			} else {
+				SetDriverNames("net-b", "ipam-b")
+			}
+			SetMaxIPAMPools(i%4 + 1)
+			SetCreatePolicy(AutoCreate)
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+
+		// the drivers must come from the same config
+		nw, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+		if err != nil {
+			t.Fatalf("Error inspecting network. Err: %v", err)
+		}
+		if nw.Driver[len("net-"):] != nw.IPAM.Driver[len("ipam-"):] {
+			t.Fatalf("Network created with drivers %s and %s", nw.Driver, nw.IPAM.Driver)
+		}
+
+		if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+			t.Fatalf("Error deleting network. Err: %v", err)
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestReadStateDriver(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetReadStateDriver(nil)
+
+	replica := &state.FakeStateDriver{}
+	replica.Init(&core.InstanceInfo{})
+	SetReadStateDriver(replica)
+
+	// writes hit the primary
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "net1", "") == nil {
+		t.Fatalf("oper state was not written to the primary")
+	}
+	if len(replica.TestState) != 0 {
+		t.Fatalf("oper state was written to the replica: %+v", replica.TestState)
+	}
+
+	// reads hit the replica, which has not caught up yet
+	if dnets, err := ListDockNets(); err != nil || len(dnets) != 0 {
+		t.Fatalf("docknets were not read from the replica: %+v. Err: %v", dnets, err)
+	}
+
+	dnet := getDocknetState("unit-test", "net1", "")
+	dnet.StateDriver = replica
+	if err := dnet.Write(); err != nil {
+		t.Fatalf("Error writing replica state. Err: %v", err)
+	}
+	found, err := FindDocknetByUUID(dnet.DocknetUUID)
+	if err != nil {
+		t.Fatalf("docknet was not read from the replica. Err: %v", err)
+	}
+
+	// updates of docknets read from the replica go to the primary
+	found.Default = true
+	if err := found.Write(); err != nil {
+		t.Fatalf("Error writing state. Err: %v", err)
+	}
+	if dnet := getDocknetState("unit-test", "net1", ""); dnet == nil || !dnet.Default {
+		t.Fatalf("update was not written to the primary: %+v", dnet)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetLogLevel(log.DebugLevel)
+
+	origLevel := log.GetLevel()
+	defer log.SetLevel(origLevel)
+	log.SetLevel(log.InfoLevel)
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	// info logs are emitted by default
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Creating docker network") {
+		t.Fatalf("Create was not logged: %s", buf.String())
+	}
+
+	// and suppressed at warning verbosity, without changing the logrus level
+	SetLogLevel(log.WarnLevel)
+	buf.Reset()
+	if err := CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if err := DeleteDockNet("unit-test", "net2", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if strings.Contains(buf.String(), "level=info") {
+		t.Fatalf("Info logs were not suppressed: %s", buf.String())
+	}
+	if log.GetLevel() != log.InfoLevel {
+		t.Fatalf("logrus level was changed to %v", log.GetLevel())
+	}
+
+	// errors are still logged
+	nwCfg := fakeNwCfg("unit-test", "net3")
+	nwCfg.Gateway = "10.9.9.9"
+	if err := CreateDockNet("unit-test", "net3", "", nwCfg); err != ErrGatewayOutsideSubnet {
+		t.Fatalf("Expected ErrGatewayOutsideSubnet, got: %v", err)
+	}
+	if !strings.Contains(buf.String(), "level=error") {
+		t.Fatalf("Error was not logged: %s", buf.String())
+	}
+}
+
+func TestAddOwnedDriverName(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	origCfg := getConfig()
+	defer func() {
+		configMutex.Lock()
+		defer configMutex.Unlock()
+		pkgConfig.netDriverName = origCfg.netDriverName
+		pkgConfig.ownedDriverNames = origCfg.ownedDriverNames
+	}()
+
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "stray", Driver: origCfg.netDriverName})
+
+	// networks of the old driver are not ours after a rename
+	SetDriverNames("newdriver", origCfg.ipamDriverName)
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err == nil {
+		t.Fatalf("Network of the old driver was recognized")
+	}
+
+	// unless the old driver is still owned
+	if err := AddOwnedDriverName(origCfg.netDriverName); err != nil {
+		t.Fatalf("Error adding owned driver name. Err: %v", err)
+	}
+	if err := AddOwnedDriverName(""); err == nil {
+		t.Fatalf("Empty driver name was accepted")
+	}
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Network of the legacy driver was not recognized. Err: %v", err)
+	}
+	orphans, err := ListOrphanDockerNetworks()
+	if err != nil || len(orphans) != 1 || orphans[0].Name != "stray" {
+		t.Fatalf("Unexpected orphans %+v. Err: %v", orphans, err)
+	}
+
+	// new networks use the current driver
+	if err := CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net2", ""))
+	if nw.Driver != "newdriver" {
+		t.Fatalf("Network was created with driver %s", nw.Driver)
+	}
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network of the legacy driver. Err: %v", err)
+	}
+}
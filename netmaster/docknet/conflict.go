@@ -0,0 +1,106 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// ConflictPolicy selects how a create resolves a conflict with an existing
+// network
+type ConflictPolicy int
+
+const (
+	// ConflictFail fails the create
+	ConflictFail ConflictPolicy = iota
+	// ConflictAutoSuffix appends -1, -2, ... to a docker network name used
+	// by another network, and saves the name as the docknet's raw name
+	ConflictAutoSuffix
+	// ConflictAutoTag uses the next packet tag free on the segment
+	ConflictAutoTag
+)
+
+// maxConflictRetries is the number of names or tags tried before giving up
+const maxConflictRetries = 100
+
+// ErrNameConflict is returned when the docker network name is used by a
+// network of another driver or another docknet
+var ErrNameConflict = errors.New("docker network name is used by another network")
+
+// createDockNet creates a docker network, resolving conflicts with existing
+// networks as set by the conflict policy of opts
+func createDockNet(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (CreateResult, error) {
+	baseName := opts.RawName
+	if baseName == "" {
+		baseName = GetDocknetName(tenantName, networkName, serviceName)
+	}
+
+	result, err := createDockNetOnce(tenantName, networkName, serviceName, nwCfg, opts)
+	for i := 1; err != nil && i <= maxConflictRetries; i++ {
+		if _, ok := err.(*TagConflictError); ok && opts.ConflictPolicy == ConflictAutoTag {
+			nwCfg = nextPktTag(nwCfg)
+			logInfof("Packet tag of network %s is in use, trying %s %d", baseName,
+				nwCfg.PktTagType, getPktTag(nwCfg))
+		} else if err == ErrNameConflict && opts.ConflictPolicy == ConflictAutoSuffix {
+			opts.RawName = fmt.Sprintf("%s-%d", baseName, i)
+			logInfof("Network name %s is in use, trying %s", baseName, opts.RawName)
+		} else {
+			return result, err
+		}
+
+		result, err = createDockNetOnce(tenantName, networkName, serviceName, nwCfg, opts)
+	}
+
+	return result, err
+}
+
+// nextPktTag returns a copy of the network config using the next packet tag
+func nextPktTag(nwCfg *mastercfg.CfgNetworkState) *mastercfg.CfgNetworkState {
+	next := *nwCfg
+	if next.PktTagType == "vxlan" {
+		next.ExtPktTag++
+	} else {
+		next.PktTag++
+	}
+
+	return &next
+}
+
+// nameConflict returns true if a docker network is used by a docknet other
+// than operID, or the shared docknets of its tenant
+func nameConflict(operID, tenantName, nwID string) (bool, error) {
+	dnets, err := ListDockNets()
+	if err != nil {
+		return false, err
+	}
+
+	for _, dnet := range dnets {
+		if dnet.DocknetUUID != nwID || dnet.ID == operID {
+			continue
+		}
+		if dnet.isSharedSecondary() && dnet.PrimaryTenant == tenantName {
+			continue
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
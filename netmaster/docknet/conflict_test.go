@@ -0,0 +1,126 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestConflictFail(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetTagConflictCheck(false)
+
+	// name used by another driver
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "net1/unit-test", Driver: "macvlan"})
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != ErrNameConflict {
+		t.Fatalf("Expected ErrNameConflict, got: %v", err)
+	}
+
+	// name used by another docknet, the epg web of net2 is named like net web
+	if err := CreateDockNet(defaultTenantName, "web", "", fakeNwCfg(defaultTenantName, "web")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if err := CreateDockNet(defaultTenantName, "net2", "web", fakeNwCfg(defaultTenantName, "net2")); err != ErrNameConflict {
+		t.Fatalf("Expected ErrNameConflict, got: %v", err)
+	}
+	if getDocknetState(defaultTenantName, "net2", "web") != nil {
+		t.Fatalf("Oper state written for a conflicting network")
+	}
+
+	// tag used on the segment
+	SetTagConflictCheck(true)
+	err := CreateDockNet("other", "net3", "", fakeNwCfg("other", "net3"))
+	if tagErr, ok := err.(*TagConflictError); !ok || tagErr.Tag != 10 {
+		t.Fatalf("Expected a TagConflictError, got: %v", err)
+	}
+}
+
+func TestConflictAutoSuffix(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	opts := DockNetOptions{ConflictPolicy: ConflictAutoSuffix}
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "net1/unit-test", Driver: "macvlan"})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "net1/unit-test-1", Driver: "macvlan"})
+	result, err := CreateDockNetWithResult("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if result.DocknetName != "net1/unit-test-2" {
+		t.Fatalf("Expected name net1/unit-test-2, got %q", result.DocknetName)
+	}
+	nw, err := docker.InspectNetwork(result.NetworkID)
+	if err != nil || nw.Name != result.DocknetName || nw.Driver != getConfig().netDriverName {
+		t.Fatalf("Docker network not created with the chosen name: %+v. Err: %v", nw, err)
+	}
+	dnet := getDocknetState("unit-test", "net1", "")
+	if dnet == nil || dnet.DocknetName() != result.DocknetName {
+		t.Fatalf("Chosen name not saved in oper state: %+v", dnet)
+	}
+
+	// creating again finds the network under the chosen name
+	again, err := CreateDockNetWithResult("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil || again.NetworkID != result.NetworkID {
+		t.Fatalf("Network was not found again: %+v. Err: %v", again, err)
+	}
+
+	// name used by another docknet
+	if err := CreateDockNet(defaultTenantName, "web", "", fakeNwCfg(defaultTenantName, "web")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	result, err = CreateDockNetWithResult(defaultTenantName, "net2", "web", fakeNwCfg(defaultTenantName, "net2"), opts)
+	if err != nil || result.DocknetName != "web-1" {
+		t.Fatalf("Expected name web-1, got %q. Err: %v", result.DocknetName, err)
+	}
+}
+
+func TestConflictAutoTag(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nwCfg := fakeNwCfg("unit-test", "net2")
+	nwCfg.PktTag = 11
+	if err := CreateDockNet("unit-test", "net2", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// tags 10 and 11 are used, the tag check is not enabled
+	opts := DockNetOptions{ConflictPolicy: ConflictAutoTag}
+	result, err := CreateDockNetWithResult("other", "net3", "", fakeNwCfg("other", "net3"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if result.PktTag != 12 {
+		t.Fatalf("Expected tag 12, got %d", result.PktTag)
+	}
+	if dnet := getDocknetState("other", "net3", ""); dnet == nil || dnet.PktTag != 12 {
+		t.Fatalf("Chosen tag not saved in oper state: %+v", dnet)
+	}
+
+	// name conflicts are not resolved
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "net4", Driver: "macvlan"})
+	nwCfg = fakeNwCfg(defaultTenantName, "net4")
+	nwCfg.PktTag = 20
+	if _, err := CreateDockNetWithResult(defaultTenantName, "net4", "", nwCfg, opts); err != ErrNameConflict {
+		t.Fatalf("Expected ErrNameConflict, got: %v", err)
+	}
+}
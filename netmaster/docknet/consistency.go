@@ -0,0 +1,329 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConsistencyResult compares docknet oper state with docker networks
+type ConsistencyResult struct {
+	OperStateCount int  // number of docknet oper states
+	DockerCount    int  // number of non-ephemeral docker networks using our driver
+	OnlyInState    int  // docker network UUIDs of oper states missing in docker
+	OnlyInDocker   int  // docker networks without an oper state
+	Match          bool // oper state and docker agree
+}
+
+// ConsistencyCheck compares the docknet oper states with the docker networks
+// using our driver. It only matches network UUIDs and is cheaper than a full
+// reconcile. Ephemeral networks have no oper state and are left out, and a
+// docker network shared by several tenants is counted once.
+func ConsistencyCheck() (ConsistencyResult, error) {
+	result := ConsistencyResult{}
+
+	dnets, err := ListDockNets()
+	if err != nil {
+		return result, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return result, errors.New("Unable to connect to docker")
+	}
+
+	nws, err := listDriverNetworks(docker, getConfig())
+	if err != nil {
+		log.Errorf("Error listing docker networks. Err: %v", err)
+		return result, err
+	}
+
+	dockerIDs := make(map[string]bool)
+	for _, nw := range nws {
+		if !isEphemeral(nw) {
+			dockerIDs[nw.ID] = true
+		}
+	}
+	// the oper states of a shared network all carry its UUID
+	stateIDs := make(map[string]bool)
+	for _, dnet := range dnets {
+		stateIDs[dnet.DocknetUUID] = true
+	}
+	for id := range stateIDs {
+		if !dockerIDs[id] {
+			result.OnlyInState++
+		}
+	}
+	for id := range dockerIDs {
+		if !stateIDs[id] {
+			result.OnlyInDocker++
+		}
+	}
+
+	result.OperStateCount = len(dnets)
+	result.DockerCount = len(dockerIDs)
+	result.Match = result.OnlyInState == 0 && result.OnlyInDocker == 0
+
+	return result, nil
+}
+
+// DockerNetInfo identifies a docker network
+type DockerNetInfo struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Driver string `json:"driver"`
+	OperID string `json:"operID,omitempty"` // oper state ID derived from the name
+}
+
+// ListOrphanDockerNetworks returns the docker networks using our driver that
+// have no oper state, eg. networks left behind when the oper state was lost.
+// Ephemeral networks never have an oper state and are not reported. Networks
+// whose name can not be mapped to an oper state ID are included without an
+// OperID.
+func ListOrphanDockerNetworks() ([]DockerNetInfo, error) {
+	dnets, err := ListDockNets()
+	if err != nil {
+		return nil, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return nil, errors.New("Unable to connect to docker")
+	}
+
+	nws, err := listDriverNetworks(docker, getConfig())
+	if err != nil {
+		log.Errorf("Error listing docker networks. Err: %v", err)
+		return nil, err
+	}
+
+	return orphanNetworks(dnets, nws), nil
+}
+
+// orphanNetworks returns the docker networks that have no oper state
+func orphanNetworks(dnets []*DnetOperState, nws []*dockerclient.NetworkResource) []DockerNetInfo {
+	operIDs := make(map[string]bool)
+	for _, dnet := range dnets {
+		operIDs[dnet.ID] = true
+	}
+
+	orphans := []DockerNetInfo{}
+	for _, nw := range nws {
+		if isEphemeral(nw) {
+			continue
+		}
+		operID, err := DocknetNameToOperID(nw.Name)
+		if err != nil {
+			log.Warnf("Unable to map docker network %s to an oper state. Err: %v", nw.Name, err)
+			operID = ""
+		} else if operIDs[operID] {
+			continue
+		}
+
+		orphans = append(orphans, DockerNetInfo{
+			Name:   nw.Name,
+			ID:     nw.ID,
+			Driver: nw.Driver,
+			OperID: operID,
+		})
+	}
+
+	return orphans
+}
+
+// IDAuthority selects which side of a mismatched oper state is trusted when
+// repairing it
+type IDAuthority int
+
+const (
+	// TrustOperID keeps the oper state ID, and points its UUID at the docker
+	// network named after it
+	TrustOperID IDAuthority = iota
+	// TrustUUID keeps the docker network the UUID points at, and moves the
+	// oper state to the ID its name decodes to
+	TrustUUID
+)
+
+// IDMismatch is an oper state whose UUID points at a docker network with the
+// name of another docknet
+type IDMismatch struct {
+	OperID        string `json:"operID"`
+	DocknetUUID   string `json:"docknetUUID"`
+	DockerName    string `json:"dockerName"`
+	DecodedOperID string `json:"decodedOperID"`
+}
+
+// IDConsistencyReport has the oper states whose ID and UUID disagree.
+// Missing are the oper states whose UUID has no docker network, they are
+// left to Reconcile.
+type IDConsistencyReport struct {
+	Checked    int               `json:"checked"`
+	Missing    []string          `json:"missing"`
+	Mismatches []IDMismatch      `json:"mismatches"`
+	Repaired   []string          `json:"repaired"`
+	Failed     map[string]string `json:"failed,omitempty"`
+}
+
+// VerifyIDConsistency checks that the docker network each oper state UUID
+// points at has a name that decodes back to the oper state ID
+func VerifyIDConsistency() (IDConsistencyReport, error) {
+	report, _, err := checkIDConsistency()
+	return report, err
+}
+
+// RepairIDConsistency fixes the oper states found by VerifyIDConsistency,
+// trusting either their ID or their UUID
+func RepairIDConsistency(authority IDAuthority) (IDConsistencyReport, error) {
+	report, dnets, err := checkIDConsistency()
+	if err != nil {
+		return report, err
+	}
+
+	failed := func(operID string, err error) {
+		if report.Failed == nil {
+			report.Failed = make(map[string]string)
+		}
+		report.Failed[operID] = err.Error()
+	}
+
+	for _, mismatch := range report.Mismatches {
+		var err error
+		if authority == TrustUUID {
+			err = moveDocknetOper(dnets[mismatch.OperID], mismatch.DecodedOperID)
+		} else {
+			err = repointDocknetUUID(dnets[mismatch.OperID])
+		}
+		if err != nil {
+			log.Errorf("Error repairing docknet %s. Err: %v", mismatch.OperID, err)
+			failed(mismatch.OperID, err)
+			continue
+		}
+		report.Repaired = append(report.Repaired, mismatch.OperID)
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("error repairing %d docknets", len(report.Failed))
+	}
+
+	return report, nil
+}
+
+// checkIDConsistency returns the ID consistency report and the oper states
+// by ID
+func checkIDConsistency() (IDConsistencyReport, map[string]*DnetOperState, error) {
+	report := IDConsistencyReport{
+		Missing:    []string{},
+		Mismatches: []IDMismatch{},
+		Repaired:   []string{},
+	}
+
+	dnetList, err := ListDockNets()
+	if err != nil {
+		return report, nil, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return report, nil, errors.New("Unable to connect to docker")
+	}
+
+	dnets := make(map[string]*DnetOperState)
+	for _, dnet := range dnetList {
+		dnets[dnet.ID] = dnet
+		report.Checked++
+
+		nw, err := docker.InspectNetwork(dnet.DocknetUUID)
+		if isNotFound(err) {
+			report.Missing = append(report.Missing, dnet.ID)
+			continue
+		} else if err != nil {
+			log.Errorf("Error inspecting network %s. Err: %v", dnet.DocknetUUID, err)
+			return report, nil, err
+		}
+
+		name, err := ParseDocknetNameStruct(nw.Name)
+		if err != nil {
+			log.Warnf("Unable to decode docker network name %s. Err: %v", nw.Name, err)
+		}
+		if err != nil || name.OperID() != dnet.ID {
+			report.Mismatches = append(report.Mismatches, IDMismatch{
+				OperID:        dnet.ID,
+				DocknetUUID:   dnet.DocknetUUID,
+				DockerName:    nw.Name,
+				DecodedOperID: name.OperID(),
+			})
+		}
+	}
+
+	return report, dnets, nil
+}
+
+// repointDocknetUUID sets the UUID of an oper state to the docker network
+// named after it
+func repointDocknetUUID(dnet *DnetOperState) error {
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnet.DocknetName())
+	if err != nil {
+		return err
+	}
+
+	logInfof("Pointing docknet %s at docker network %s", dnet.ID, nw.ID)
+	dnet.DocknetUUID = nw.ID
+	return dnet.Write()
+}
+
+// moveDocknetOper moves an oper state to a new ID
+func moveDocknetOper(dnet *DnetOperState, operID string) error {
+	parts := strings.Split(operID, OperIDSeparator)
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid docknet oper state ID %q", operID)
+	}
+
+	existing := DnetOperState{}
+	existing.StateDriver = dnet.StateDriver
+	if err := existing.Read(operID); err == nil {
+		return fmt.Errorf("docknet %s already exists", operID)
+	}
+
+	logInfof("Moving docknet %s to %s", dnet.ID, operID)
+	moved := *dnet
+	moved.ID = operID
+	moved.TenantName, moved.NetworkName, moved.ServiceName = parts[0], parts[1], parts[2]
+	if err := moved.Write(); err != nil {
+		return err
+	}
+
+	return core.ErrIfKeyExists(dnet.Clear())
+}
@@ -0,0 +1,193 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestConsistencyCheck(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	for _, nwName := range []string{"net1", "net2"} {
+		err := CreateDockNet("unit-test", nwName, "", fakeNwCfg("unit-test", nwName))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+	// networks of other drivers and ephemeral networks are ignored
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "foreign", Driver: "overlay"})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   "net9/unit-test",
+		Driver: getConfig().netDriverName,
+		Labels: map[string]string{ephemeralLabel: "true"},
+	})
+
+	res, err := ConsistencyCheck()
+	if err != nil {
+		t.Fatalf("Error checking consistency. Err: %v", err)
+	}
+	if !res.Match || res.OperStateCount != 2 || res.DockerCount != 2 {
+		t.Fatalf("Unexpected consistency result: %+v", res)
+	}
+
+	// a shared network has two oper states for one docker network
+	if err := CreateSharedDockNet("blue", "red", "net3", fakeNwCfg("blue", "net3")); err != nil {
+		t.Fatalf("Error creating shared network. Err: %v", err)
+	}
+	res, err = ConsistencyCheck()
+	if err != nil {
+		t.Fatalf("Error checking consistency. Err: %v", err)
+	}
+	if !res.Match || res.OperStateCount != 4 || res.DockerCount != 3 {
+		t.Fatalf("Unexpected consistency result: %+v", res)
+	}
+	if err := DeleteDockNet("red", "net3", ""); err != nil {
+		t.Fatalf("Error deleting shared network. Err: %v", err)
+	}
+	if err := DeleteDockNet("blue", "net3", ""); err != nil {
+		t.Fatalf("Error deleting shared network. Err: %v", err)
+	}
+
+	// remove one network behind our back and add an unknown one
+	docker.RemoveNetwork(GetDocknetName("unit-test", "net1", ""))
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "stray", Driver: getConfig().netDriverName})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "stray2", Driver: getConfig().netDriverName})
+
+	res, err = ConsistencyCheck()
+	if err != nil {
+		t.Fatalf("Error checking consistency. Err: %v", err)
+	}
+	expRes := ConsistencyResult{
+		OperStateCount: 2,
+		DockerCount:    3,
+		OnlyInState:    1,
+		OnlyInDocker:   2,
+	}
+	if res != expRes {
+		t.Fatalf("Unexpected consistency result: %+v, expected: %+v", res, expRes)
+	}
+}
+
+func TestListOrphanDockerNetworks(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	driver := getConfig().netDriverName
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "web/blue", Driver: driver})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "db/blue", Driver: driver})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "foreign", Driver: "overlay"})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   "tmp/blue",
+		Driver: driver,
+		Labels: map[string]string{ephemeralLabel: "true"},
+	})
+	if err := AdoptDockNet("web/blue"); err != nil {
+		t.Fatalf("Error adopting network. Err: %v", err)
+	}
+
+	orphans, err := ListOrphanDockerNetworks()
+	if err != nil {
+		t.Fatalf("Error listing orphan networks. Err: %v", err)
+	}
+	if len(orphans) != 1 || orphans[0].Name != "db/blue" || orphans[0].OperID != "blue.db." ||
+		orphans[0].ID == "" || orphans[0].Driver != driver {
+		t.Fatalf("Unexpected orphan networks %+v", orphans)
+	}
+}
+
+// swapDocknetUUIDs swaps the UUIDs of two docknets
+func swapDocknetUUIDs(t *testing.T, id1, id2 string) {
+	dnets := make(map[string]*DnetOperState)
+	all, _ := ListDockNets()
+	for _, dnet := range all {
+		dnets[dnet.ID] = dnet
+	}
+	dnet1, dnet2 := dnets[id1], dnets[id2]
+	dnet1.DocknetUUID, dnet2.DocknetUUID = dnet2.DocknetUUID, dnet1.DocknetUUID
+	if err := dnet1.Write(); err != nil {
+		t.Fatalf("Error writing state. Err: %v", err)
+	}
+	if err := dnet2.Write(); err != nil {
+		t.Fatalf("Error writing state. Err: %v", err)
+	}
+}
+
+func TestIDConsistency(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	for _, nwName := range []string{"net1", "net2", "net3"} {
+		err := CreateDockNet("unit-test", nwName, "", fakeNwCfg("unit-test", nwName))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+
+	report, err := VerifyIDConsistency()
+	if err != nil || report.Checked != 3 || len(report.Mismatches) != 0 || len(report.Missing) != 0 {
+		t.Fatalf("Unexpected report %+v. Err: %v", report, err)
+	}
+
+	// trusting the ID points the UUIDs back at the networks named after them
+	swapDocknetUUIDs(t, "unit-test.net1.", "unit-test.net2.")
+	report, err = VerifyIDConsistency()
+	if err != nil || len(report.Mismatches) != 2 || len(report.Repaired) != 0 {
+		t.Fatalf("Unexpected report %+v. Err: %v", report, err)
+	}
+	for _, mismatch := range report.Mismatches {
+		nw, _ := docker.InspectNetwork(mismatch.DocknetUUID)
+		if mismatch.DockerName != nw.Name || mismatch.DecodedOperID == mismatch.OperID {
+			t.Fatalf("Unexpected mismatch %+v", mismatch)
+		}
+	}
+	report, err = RepairIDConsistency(TrustOperID)
+	if err != nil || len(report.Repaired) != 2 {
+		t.Fatalf("Unexpected repair report %+v. Err: %v", report, err)
+	}
+	for _, nwName := range []string{"net1", "net2"} {
+		nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", nwName, ""))
+		if dnet := getDocknetState("unit-test", nwName, ""); dnet.DocknetUUID != nw.ID {
+			t.Fatalf("UUID of %s was not repaired: %+v", nwName, dnet)
+		}
+	}
+
+	// trusting the UUID moves the oper state to the network it points at
+	dnet := getDocknetState("unit-test", "net3", "")
+	docker.RemoveNetwork(dnet.DocknetUUID)
+	resp, _ := docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "net4/unit-test", Driver: getConfig().netDriverName})
+	dnet.DocknetUUID = resp.ID
+	dnet.Write()
+
+	report, err = RepairIDConsistency(TrustUUID)
+	if err != nil || len(report.Repaired) != 1 || report.Repaired[0] != "unit-test.net3." {
+		t.Fatalf("Unexpected repair report %+v. Err: %v", report, err)
+	}
+	if getDocknetState("unit-test", "net3", "") != nil {
+		t.Fatalf("Old oper state was not removed")
+	}
+	if dnet := getDocknetState("unit-test", "net4", ""); dnet == nil || dnet.DocknetUUID != resp.ID ||
+		dnet.NetworkName != "net4" {
+		t.Fatalf("Oper state was not moved: %+v", dnet)
+	}
+	report, err = VerifyIDConsistency()
+	if err != nil || len(report.Mismatches) != 0 {
+		t.Fatalf("Unexpected report %+v. Err: %v", report, err)
+	}
+}
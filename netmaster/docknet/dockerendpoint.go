@@ -0,0 +1,131 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"github.com/samalba/dockerclient"
+)
+
+// defaultDockerHost is the docker daemon endpoint used when none is set
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// DockerEndpoint is the docker daemon docknet talks to. Host is a unix or tcp
+// URL. TLS is used when CertPath is set, with the ca.pem, cert.pem and key.pem
+// files of that directory, like the docker client. The daemon certificate is
+// not verified unless TLSVerify is set.
+type DockerEndpoint struct {
+	Host      string
+	CertPath  string
+	TLSVerify bool
+}
+
+var (
+	// dockerMutex protects the docker endpoint and the shared client
+	dockerMutex    sync.Mutex
+	dockerEndpoint = DockerEndpoint{Host: defaultDockerHost}
+	dockerClient   *dockerclient.DockerClient
+)
+
+// DockerEndpointFromEnv returns the docker endpoint set by the DOCKER_HOST,
+// DOCKER_CERT_PATH and DOCKER_TLS_VERIFY environment variables, defaulting to
+// the local docker socket
+func DockerEndpointFromEnv() DockerEndpoint {
+	endpoint := DockerEndpoint{
+		Host:      os.Getenv("DOCKER_HOST"),
+		CertPath:  os.Getenv("DOCKER_CERT_PATH"),
+		TLSVerify: os.Getenv("DOCKER_TLS_VERIFY") != "",
+	}
+	if endpoint.Host == "" {
+		endpoint.Host = defaultDockerHost
+	}
+
+	return endpoint
+}
+
+// SetDockerEndpoint sets the docker daemon docknet talks to. The shared
+// client of the previous endpoint is dropped.
+func SetDockerEndpoint(endpoint DockerEndpoint) error {
+	u, err := url.Parse(endpoint.Host)
+	if err != nil {
+		return fmt.Errorf("invalid docker host %q. Err: %v", endpoint.Host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Path == "" {
+			return fmt.Errorf("invalid docker host %q, no socket path", endpoint.Host)
+		}
+	case "tcp", "http", "https":
+		if u.Host == "" {
+			return fmt.Errorf("invalid docker host %q, no address", endpoint.Host)
+		}
+	default:
+		return fmt.Errorf("invalid docker host %q, unsupported scheme", endpoint.Host)
+	}
+
+	// fail early on missing certificates
+	if endpoint.CertPath != "" {
+		if _, err := dockerclient.TLSConfigFromCertPath(endpoint.CertPath); err != nil {
+			return fmt.Errorf("invalid docker certificates in %s. Err: %v", endpoint.CertPath, err)
+		}
+	}
+
+	dockerMutex.Lock()
+	defer dockerMutex.Unlock()
+	dockerEndpoint = endpoint
+	dockerClient = nil
+
+	return nil
+}
+
+// sharedDockerClient returns the client of the docker endpoint, connecting
+// on first use
+func sharedDockerClient() (*dockerclient.DockerClient, error) {
+	dockerMutex.Lock()
+	defer dockerMutex.Unlock()
+
+	if dockerClient != nil {
+		return dockerClient, nil
+	}
+
+	docker, err := connectDocker(dockerEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	watchRateLimit(docker)
+	dockerClient = docker
+
+	return docker, nil
+}
+
+// connectDocker returns a client of a docker endpoint
+func connectDocker(endpoint DockerEndpoint) (*dockerclient.DockerClient, error) {
+	if endpoint.CertPath == "" {
+		return dockerclient.NewDockerClient(endpoint.Host, nil)
+	}
+
+	tlsConfig, err := dockerclient.TLSConfigFromCertPath(endpoint.CertPath)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.InsecureSkipVerify = !endpoint.TLSVerify
+
+	return dockerclient.NewDockerClient(endpoint.Host, tlsConfig)
+}
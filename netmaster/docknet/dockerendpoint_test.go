@@ -0,0 +1,141 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCerts writes a self-signed ca.pem, cert.pem and key.pem to dir
+func writeTestCerts(t *testing.T, dir string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key. Err: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "docker"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate. Err: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding key. Err: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	for name, data := range map[string][]byte{"ca.pem": certPEM, "cert.pem": certPEM, "key.pem": keyPEM} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("Error writing %s. Err: %v", name, err)
+		}
+	}
+}
+
+func TestSetDockerEndpoint(t *testing.T) {
+	defer SetDockerEndpoint(DockerEndpoint{Host: defaultDockerHost})
+
+	dir, err := ioutil.TempDir("", "docknet-certs")
+	if err != nil {
+		t.Fatalf("Error creating temp dir. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, host := range []string{"ftp://docker:21", "unix://", "tcp://", "docker.sock"} {
+		if err := SetDockerEndpoint(DockerEndpoint{Host: host}); err == nil {
+			t.Fatalf("Invalid docker host %q was accepted", host)
+		}
+	}
+	if err := SetDockerEndpoint(DockerEndpoint{Host: "tcp://10.0.0.1:2376", CertPath: dir}); err == nil {
+		t.Fatalf("Cert path without certificates was accepted")
+	}
+
+	// the client is shared until the endpoint changes
+	if err := SetDockerEndpoint(DockerEndpoint{Host: "tcp://10.0.0.1:2375"}); err != nil {
+		t.Fatalf("Error setting docker endpoint. Err: %v", err)
+	}
+	docker, err := sharedDockerClient()
+	if err != nil {
+		t.Fatalf("Error connecting to docker. Err: %v", err)
+	}
+	if docker.URL.String() != "http://10.0.0.1:2375" || docker.TLSConfig != nil {
+		t.Fatalf("Unexpected docker client %v", docker.URL)
+	}
+	if again, _ := sharedDockerClient(); again != docker {
+		t.Fatalf("Docker client is not shared")
+	}
+
+	writeTestCerts(t, dir)
+	err = SetDockerEndpoint(DockerEndpoint{Host: "tcp://10.0.0.1:2376", CertPath: dir, TLSVerify: true})
+	if err != nil {
+		t.Fatalf("Error setting TLS docker endpoint. Err: %v", err)
+	}
+	tlsDocker, err := sharedDockerClient()
+	if err != nil {
+		t.Fatalf("Error connecting to docker. Err: %v", err)
+	}
+	if tlsDocker == docker {
+		t.Fatalf("Docker client was not replaced")
+	}
+	if tlsDocker.URL.String() != "https://10.0.0.1:2376" || tlsDocker.TLSConfig == nil ||
+		tlsDocker.TLSConfig.InsecureSkipVerify {
+		t.Fatalf("Unexpected TLS docker client %v", tlsDocker.URL)
+	}
+
+	// alternate socket path
+	if err := SetDockerEndpoint(DockerEndpoint{Host: "unix:///run/docker/alt.sock"}); err != nil {
+		t.Fatalf("Error setting docker socket. Err: %v", err)
+	}
+	if docker, err := sharedDockerClient(); err != nil || docker == tlsDocker {
+		t.Fatalf("Docker client was not replaced. Err: %v", err)
+	}
+}
+
+func TestDockerEndpointFromEnv(t *testing.T) {
+	for _, name := range []string{"DOCKER_HOST", "DOCKER_CERT_PATH", "DOCKER_TLS_VERIFY"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	if endpoint := DockerEndpointFromEnv(); endpoint != (DockerEndpoint{Host: defaultDockerHost}) {
+		t.Fatalf("Unexpected default endpoint %+v", endpoint)
+	}
+
+	os.Setenv("DOCKER_HOST", "tcp://10.0.0.1:2376")
+	os.Setenv("DOCKER_CERT_PATH", "/etc/docker/certs")
+	os.Setenv("DOCKER_TLS_VERIFY", "1")
+	exp := DockerEndpoint{Host: "tcp://10.0.0.1:2376", CertPath: "/etc/docker/certs", TLSVerify: true}
+	if endpoint := DockerEndpointFromEnv(); endpoint != exp {
+		t.Fatalf("Expected endpoint %+v, got %+v", exp, endpoint)
+	}
+}
@@ -19,246 +19,1616 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/version"
+	"github.com/docker/libnetwork/netlabel"
 	"github.com/samalba/dockerclient"
+	"golang.org/x/net/context"
 
 	log "github.com/Sirupsen/logrus"
 )
 
 const (
-	defaultTenantName = "default"
-	docknetOperPrefix = mastercfg.StateOperPath + "docknet/"
-	docknetOperPath   = docknetOperPrefix + "%s"
+	defaultTenantName   = "default"
+	defaultMaxIPAMPools = 8
+	ephemeralLabel      = "contiv.ephemeral"
+	managedLabel        = "contiv.managed"
+	versionLabel        = "contiv.version"
+	adminStateUp        = "up"
+	adminStateDown      = "down"
+	// docknet oper states are kept in the state of their tenant, under
+	// docknetOperPath. They are also written under legacyDocknetOperPrefix,
+	// where netplugins of older releases look them up, until no supported
+	// release reads them there. CopyLegacyOperStates copies the ones written
+	// by older releases into the tenants.
+	docknetOperPath         = "docknet/"
+	legacyDocknetOperPrefix = mastercfg.StateOperPath + "docknet/"
+	// schema versions of DnetOperState:
+	// 1 - tenant, network, service and docker network UUID
+	// 2 - encap, packet tag and admin state
+	// 3 - docker network parameters and schema version
+	// 4 - raw docker network name
+	// 5 - excluded address range
+	// 6 - subnet pool
+	// 7 - annotations
+	// 8 - auto delete when empty
+	// 9 - shared networks
+	// 10 - internal networks
+	dnetOperSchemaVersion = 10
 )
 
-var netDriverName = "netplugin"
-var ipamDriverName = "netplugin"
+// contivVersion is the version set at build time. It is added as a label to
+// the docker networks we create.
+var contivVersion = version.Get().Version
+
+// CreatePolicy controls whether docknet creates docker networks
+type CreatePolicy int
+
+const (
+	// AutoCreate creates the docker network if it does not exist
+	AutoCreate CreatePolicy = iota
+	// RequirePreexisting only records docker networks created by someone else
+	RequirePreexisting
+)
+
+// defaultMutex serializes updates to the tenant default network
+var defaultMutex sync.Mutex
+
+var (
+	// ErrGatewayNotAllocated is returned when the driver could not materialize
+	// the gateway endpoint of a newly created network
+	ErrGatewayNotAllocated = errors.New("gateway endpoint was not allocated")
+
+	// ErrTooManyPools is returned when a network has more subnets than allowed
+	ErrTooManyPools = errors.New("too many IPAM pools for the network")
+
+	// ErrL2OnlyGateway is returned when a gateway is set for an L2 only network
+	ErrL2OnlyGateway = errors.New("gateway can not be set for an L2 only network")
+
+	// ErrDocknetNotFound is returned when there is no docknet oper state
+	ErrDocknetNotFound = errors.New("docknet not found")
+
+	// ErrDockerNetworkMissing is returned when the docker network must be
+	// created by someone else and does not exist
+	ErrDockerNetworkMissing = errors.New("docker network does not exist")
+
+	// ErrUplinkMissing is returned when a network needs external connectivity
+	// and its uplink does not exist on the host
+	ErrUplinkMissing = errors.New("uplink interface does not exist")
+
+	// ErrInvalidMTU is returned for a network MTU out of the valid range
+	ErrInvalidMTU = errors.New("invalid network MTU")
+
+	// ErrReservedDriverOption is returned when the driver options of a
+	// network config set an option computed by docknet
+	ErrReservedDriverOption = errors.New("driver option is computed by docknet")
+
+	// ErrNoSubnet is returned for a network without IPv4 and IPv6 subnet
+	ErrNoSubnet = errors.New("network has no subnet")
+)
+
+// newDockerClient returns the shared client of the docker endpoint set by
+// SetDockerEndpoint. Unit-tests replace it with a fake client.
+var newDockerClient = func() (dockerclient.Client, error) {
+	docker, err := sharedDockerClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return docker, nil
+}
+
+const (
+	// mtuOption is the driver option carrying the network MTU
+	mtuOption = "mtu"
+
+	// minMTU and maxMTU bound the network MTU
+	minMTU = 68
+	maxMTU = 65535
+)
+
+// reservedOptions are the driver options computed by docknet
+var reservedOptions = map[string]bool{
+	"tenant":      true,
+	"encap":       true,
+	"pkt-tag":     true,
+	"gw-endpoint": true,
+	"admin-state": true,
+	"l2-only":     true,
+	"external":    true,
+	"uplink":      true,
+
+	annotationsOption:   true,
+	netlabel.EnableIPv6: true,
+}
+
+// lookupInterface checks a host interface exists. Unit-tests replace it.
+var lookupInterface = func(name string) error {
+	_, err := net.InterfaceByName(name)
+	return err
+}
+
+// DockNetOptions has optional parameters for docker network creation
+type DockNetOptions struct {
+	// PreallocateGateway asks the driver to program the gateway as an
+	// actual endpoint when the network is created
+	PreallocateGateway bool
+
+	// AdditionalPools are subnets added after the network's own subnets
+	AdditionalPools []IPAMPool
+
+	// Default marks the network as the tenant's default network
+	Default bool
+
+	// CreatedBy and Source identify who created the network, for auditing
+	CreatedBy string
+	Source    string
+
+	// IPv6GatewayMode derives the IPv6 gateway when the network has none.
+	// IPv6GatewayMAC is the MAC address used by IPv6GatewayEUI64.
+	IPv6GatewayMode IPv6GatewayMode
+	IPv6GatewayMAC  string
+
+	// L2Only networks have no gateway
+	L2Only bool
+
+	// Disabled networks are created with the dataplane admin state down
+	Disabled bool
+
+	// Ephemeral networks are created in docker only, without an oper state.
+	// They are not listed or reconciled, and are marked with a docker label
+	// so that DeleteDockNet does not look for their oper state.
+	Ephemeral bool
+
+	// RawName is used verbatim as the docker network name instead of the
+	// name built from the tenant, network and service names
+	RawName string
+
+	// ExcludedRange is a range of the network's subnet that the IPAM driver
+	// must not allocate, eg. when it is served by an external DHCP server
+	ExcludedRange *AddrRange
+
+	// ExternalConnectivity networks need north-south connectivity through
+	// the Uplink interface, which must exist on the host
+	ExternalConnectivity bool
+	Uplink               string
+
+	// SubnetPool is the name of a registered subnet pool to take the
+	// network's subnet from, in place of the subnet in the network config.
+	// The pool is shared by networks asking for a SubnetPoolPrefixLen
+	// subnet, and used whole otherwise.
+	SubnetPool          string
+	SubnetPoolPrefixLen int
+
+	// IPv6PrefixDelegation takes the network's IPv6 subnet from the
+	// registered IPv6PrefixProvider, in place of the IPv6 subnet in the
+	// network config. The create waits up to IPv6PrefixTimeout for a prefix
+	// to be delegated.
+	IPv6PrefixDelegation bool
+	IPv6PrefixTimeout    time.Duration
+
+	// ResolveGatewayHostname resolves a gateway given as a hostname to its
+	// address in the subnet. Gateway hostnames are rejected otherwise.
+	ResolveGatewayHostname bool
+
+	// GatewaySource selects whether the gateways are set in the docker IPAM
+	// config or passed to the IPAM driver in an IPAM option
+	GatewaySource GatewaySource
+
+	// AutoDeleteWhenEmpty networks are deleted by StartAutoDeleteLoop once
+	// their last endpoint leaves, eg. networks of short-lived jobs
+	AutoDeleteWhenEmpty bool
+
+	// Annotations is a JSON object passed to the network driver in the
+	// annotations driver option, eg. for policy binding at create time. It
+	// is limited to maxAnnotationsSize bytes.
+	Annotations json.RawMessage
+
+	// ConflictPolicy selects whether a docker network name used by another
+	// network, or a packet tag used on the segment, fails the create or is
+	// replaced with a free name or tag. ConflictAutoTag checks for tag
+	// conflicts even if SetTagConflictCheck is disabled.
+	ConflictPolicy ConflictPolicy
+
+	// OptionsOverride is applied to the driver options last, and replaces
+	// the options computed from the network config. Overriding options like
+	// encap or pkt-tag can leave the docker network out of sync with the
+	// contiv network, so it is only meant for working around problems.
+	// The options are validated against the schema registered with
+	// RegisterOptionSchema.
+	OptionsOverride map[string]string
+}
+
+// IPAMPool is a subnet and its gateway for a docker network
+type IPAMPool struct {
+	Subnet  string `json:"subnet"`
+	Gateway string `json:"gateway,omitempty"`
+}
 
 // DnetOperState has oper state of docker network
 type DnetOperState struct {
 	core.CommonState
+	SchemaVersion int `json:"schemaVersion"`
+
 	TenantName  string `json:"tenantName"`
 	NetworkName string `json:"networkName"`
 	ServiceName string `json:"serviceName"`
 	DocknetUUID string `json:"docknetUUID"`
+	RawName     string `json:"rawName,omitempty"`
+	Encap       string `json:"encap,omitempty"`
+	PktTag      int    `json:"pktTag,omitempty"`
+	AdminState  string `json:"adminState,omitempty"`
+	L2Only      bool   `json:"l2Only,omitempty"`
+
+	// Internal docker networks have no external connectivity
+	Internal bool `json:"internal,omitempty"`
+
+	// AutoDeleteWhenEmpty docknets are deleted by StartAutoDeleteLoop when
+	// their docker network has no endpoints
+	AutoDeleteWhenEmpty bool `json:"autoDeleteWhenEmpty,omitempty"`
+
+	// Shared docknets have oper state under two tenants referencing the
+	// same docker network, which is named after the PrimaryTenant.
+	// SharedWith is the other tenant.
+	Shared        bool   `json:"shared,omitempty"`
+	SharedWith    string `json:"sharedWith,omitempty"`
+	PrimaryTenant string `json:"primaryTenant,omitempty"`
+
+	ExcludedRange *AddrRange `json:"excludedRange,omitempty"`
+	SubnetPool    string     `json:"subnetPool,omitempty"`
+
+	// Annotations are passed to the network driver at create time, eg. the
+	// endpoint group policy is bound to
+	Annotations json.RawMessage `json:"annotations,omitempty"`
+
+	// docker network parameters
+	Subnets     []IPAMPool        `json:"subnets,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+	IPAMOptions map[string]string `json:"ipamOptions,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+
+	Default   bool      `json:"default,omitempty"`
+	CreatedBy string    `json:"createdBy,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Source    string    `json:"source,omitempty"`
 }
 
 // Write the state.
 func (s *DnetOperState) Write() error {
-	key := fmt.Sprintf(docknetOperPath, s.ID)
-	return s.StateDriver.WriteState(key, s, json.Marshal)
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now().UTC()
+	}
+	if s.SchemaVersion < dnetOperSchemaVersion {
+		s.SchemaVersion = dnetOperSchemaVersion
+	}
+	key := docknetOperKey(s.ID)
+	if err := s.StateDriver.WriteState(key, s, json.Marshal); err != nil {
+		return err
+	}
+	if err := s.writeLegacy(); err != nil {
+		return err
+	}
+
+	tracker.added(s.ID)
+	s.indexUUID()
+	return nil
+}
+
+// update rereads the state, applies update and writes it, retrying if
+// another writer changed it meanwhile. update returns state.ErrUnchanged to
+// leave the state as read.
+func (s *DnetOperState) update(update func() error) error {
+	err := state.UpdateState(s.StateDriver, docknetOperKey(s.ID), s, unmarshalDnetOper, json.Marshal,
+		func() error {
+			if err := update(); err != nil {
+				return err
+			}
+			if s.SchemaVersion < dnetOperSchemaVersion {
+				s.SchemaVersion = dnetOperSchemaVersion
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	if err := s.writeLegacy(); err != nil {
+		return err
+	}
+
+	tracker.added(s.ID)
+	s.indexUUID()
+	return nil
+}
+
+// writeLegacy writes the state where older netplugins read it
+func (s *DnetOperState) writeLegacy() error {
+	return s.StateDriver.WriteState(legacyDocknetOperPrefix+s.ID, s, json.Marshal)
 }
 
 // Read the state for a given identifier
 func (s *DnetOperState) Read(id string) error {
-	key := fmt.Sprintf(docknetOperPath, id)
-	return s.StateDriver.ReadState(key, s, json.Unmarshal)
+	key := docknetOperKey(id)
+	return s.StateDriver.ReadState(key, s, unmarshalDnetOper)
 }
 
 // ReadAll state and return the collection.
 func (s *DnetOperState) ReadAll() ([]core.State, error) {
-	return s.StateDriver.ReadAllState(docknetOperPrefix, s, json.Unmarshal)
+	return state.ReadAllTenantsState(s.StateDriver, docknetOperPath, s, unmarshalDnetOper)
 }
 
 // WatchAll state transitions and send them through the channel.
 func (s *DnetOperState) WatchAll(rsps chan core.WatchState) error {
-	return s.StateDriver.WatchAllState(docknetOperPrefix, s, json.Unmarshal,
+	return state.WatchAllTenantsState(s.StateDriver, docknetOperPath, s, unmarshalDnetOper,
 		rsps)
 }
 
+// unmarshalDnetOper decodes a DnetOperState written by any schema version.
+// Fields added by newer versions are ignored, and fields missing in older
+// versions are filled with their defaults.
+func unmarshalDnetOper(data []byte, value interface{}) error {
+	err := json.Unmarshal(data, value)
+	if err != nil {
+		return err
+	}
+
+	// ReadAllState decodes into a pointer to a state pointer
+	var s *DnetOperState
+	switch v := value.(type) {
+	case *DnetOperState:
+		s = v
+	case **DnetOperState:
+		s = *v
+	default:
+		return nil
+	}
+
+	// records written before the schema version was added
+	if s.SchemaVersion == 0 {
+		s.SchemaVersion = 1
+		if s.AdminState != "" {
+			s.SchemaVersion = 2
+		}
+	}
+	if s.SchemaVersion < 2 {
+		s.AdminState = adminStateUp
+	}
+	if s.Encap == "" && s.Options["encap"] != "" {
+		s.Encap = s.Options["encap"]
+		s.PktTag, _ = strconv.Atoi(s.Options["pkt-tag"])
+	}
+
+	return nil
+}
+
 // Clear removes the state.
 func (s *DnetOperState) Clear() error {
-	key := fmt.Sprintf(docknetOperPath, s.ID)
-	return s.StateDriver.ClearState(key)
+	// callers may only set the ID, the UUID is needed to update the index
+	uuid := s.DocknetUUID
+	if uuid == "" {
+		cur := DnetOperState{}
+		cur.StateDriver = s.StateDriver
+		if err := cur.Read(s.ID); err == nil {
+			uuid = cur.DocknetUUID
+		}
+	}
+
+	key := docknetOperKey(s.ID)
+	if err := s.StateDriver.ClearState(key); err != nil {
+		return err
+	}
+	if err := s.StateDriver.ClearState(legacyDocknetOperPrefix + s.ID); core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+
+	tracker.removed(s.ID)
+	s.unindexUUID(uuid)
+	return nil
 }
 
-// GetDocknetName trims default tenant from network name
-func GetDocknetName(tenantName, networkName, epgName string) string {
+// CreateDockNet Creates a network in docker daemon
+func CreateDockNet(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState) error {
+	return CreateDockNetWithOptions(tenantName, networkName, serviceName, nwCfg, DockNetOptions{})
+}
 
-	netName := ""
-	// if epg is specified, always use that, else use nw
-	if epgName == "" {
-		netName = networkName
-	} else {
-		netName = epgName
+// CreateDockNetWithOptions creates a network in docker daemon using the
+// optional parameters in opts
+func CreateDockNetWithOptions(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) error {
+	_, err := createDockNet(tenantName, networkName, serviceName, nwCfg, opts)
+	return err
+}
+
+// CreateDockNetWithResult creates a network like CreateDockNetWithOptions,
+// and returns the docker network ID and the name and packet tag chosen under
+// the conflict policy of opts
+func CreateDockNetWithResult(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (CreateResult, error) {
+	return createDockNet(tenantName, networkName, serviceName, nwCfg, opts)
+}
+
+// ComputeNetworkCreate returns the docker network parameters CreateDockNet
+// would use, after the same validation, without creating anything. It lets
+// an external creator create the docker network for RequirePreexisting. A
+// subnet taken from a pool is not reserved until the docknet is created.
+func ComputeNetworkCreate(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (dockerclient.NetworkCreate, error) {
+	cfg := getConfig()
+
+	if opts.SubnetPool != "" {
+		poolMutex.Lock()
+		defer poolMutex.Unlock()
+	}
+	if getPktTag(nwCfg) == 0 {
+		tagPoolMutex.Lock()
+		defer tagPoolMutex.Unlock()
+	}
+	_, _, nwCreate, err := prepareDockNet(cfg, tenantName, networkName, serviceName, nwCfg, opts)
+	if err != nil {
+		return dockerclient.NetworkCreate{}, err
+	}
+
+	return *nwCreate, nil
+}
+
+// prepareDockNet validates a docknet and builds its docker network
+// parameters. It returns the network config and options updated with the
+// resolved prefix, gateways, pool subnet, pool packet tag and raw name.
+// poolMutex must be held if the docknet takes its subnet from a pool, and
+// tagPoolMutex if it has no packet tag.
+func prepareDockNet(cfg config, tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (*mastercfg.CfgNetworkState, DockNetOptions, *dockerclient.NetworkCreate, error) {
+	// Trim default tenant name
+	docknetName := truncateName(cfg, docknetNameOf(cfg, tenantName, networkName, serviceName))
+	if opts.RawName != "" {
+		if err := validateRawName(opts.RawName); err != nil {
+			log.Errorf("Invalid docker network name %q. Err: %v", opts.RawName, err)
+			return nil, opts, nil, err
+		}
+		docknetName = opts.RawName
+	} else if docknetName != fullDocknetName(tenantName, networkName, serviceName) {
+		// truncated names and names of other schemes can not be parsed, look
+		// them up as raw names
+		if err := validateRawName(docknetName); err != nil {
+			log.Errorf("Invalid docker network name %q. Err: %v", docknetName, err)
+			return nil, opts, nil, err
+		}
+		opts.RawName = docknetName
+	}
+	if err := checkNameLength(cfg, docknetName); err != nil {
+		log.Errorf("Invalid docker network name. Err: %v", err)
+		return nil, opts, nil, err
+	}
+
+	// validate the user options
+	if len(opts.OptionsOverride) > 0 {
+		overrides, err := cfg.validateOptions(docknetName, opts.OptionsOverride)
+		if err != nil {
+			log.Errorf("Invalid options for network %s. Err: %v", docknetName, err)
+			return nil, opts, nil, err
+		}
+		opts.OptionsOverride = overrides
+	}
+
+	// wait for the delegated IPv6 prefix
+	if opts.IPv6PrefixDelegation {
+		var err error
+		nwCfg, err = resolveDelegatedPrefix(cfg, docknetName, nwCfg, opts)
+		if err != nil {
+			return nil, opts, nil, err
+		}
 	}
 
-	// add tenant suffix if not the default tenant
-	if tenantName != defaultTenantName {
-		netName = netName + "/" + tenantName
+	nwCfg, err := resolveGatewayHostnames(docknetName, nwCfg, opts)
+	if err != nil {
+		return nil, opts, nil, err
+	}
+
+	// take the subnet from the pool
+	if opts.SubnetPool != "" {
+		stateDriver, err := utils.GetStateDriver()
+		if err != nil {
+			log.Warnf("Couldn't read global config %v", err)
+			return nil, opts, nil, err
+		}
+
+		nwCfg, err = allocatePoolSubnet(stateDriver, nwCfg, opts)
+		if err != nil {
+			log.Errorf("Error allocating subnet from pool %s for network %s. Err: %v", opts.SubnetPool, docknetName, err)
+			return nil, opts, nil, err
+		}
+	}
+
+	// take the packet tag from the pool
+	if getPktTag(nwCfg) == 0 {
+		stateDriver, err := utils.GetStateDriver()
+		if err != nil {
+			log.Warnf("Couldn't read global config %v", err)
+			return nil, opts, nil, err
+		}
+
+		encap := nwCfg.PktTagType
+		nwCfg, err = allocatePktTag(stateDriver, tenantName, networkName, nwCfg)
+		if err != nil {
+			log.Errorf("Error allocating %s tag for network %s. Err: %v", encap, docknetName, err)
+			return nil, opts, nil, err
+		}
+	}
+
+	// Build network parameters
+	nwCreate, err := buildNetworkCreate(cfg, docknetName, nwCfg, opts)
+	if err != nil {
+		return nil, opts, nil, err
+	}
+	setObjectLabels(nwCreate, tenantName, networkName, serviceName)
+
+	// make sure the subnets are in the address space of the tenant
+	if err := cfg.checkTenantSupernets(tenantName, nwCreate); err != nil {
+		log.Errorf("Invalid subnet for network %s. Err: %v", docknetName, err)
+		return nil, opts, nil, err
+	}
+
+	// make sure the fabric can carry the encap
+	if cfg.fabricCheck != nil {
+		if err := cfg.fabricCheck(nwCreate.Options["encap"]); err != nil {
+			log.Errorf("Fabric does not support encap %s of network %s. Err: %v",
+				nwCreate.Options["encap"], docknetName, err)
+			return nil, opts, nil, err
+		}
+	}
+
+	// make sure the packet tag is not used on the segment
+	if cfg.tagConflictCheck || opts.ConflictPolicy == ConflictAutoTag {
+		if err := checkTagConflict(tenantName, networkName, nwCreate); err != nil {
+			log.Errorf("Packet tag conflict for network %s. Err: %v", docknetName, err)
+			return nil, opts, nil, err
+		}
 	}
 
-	return netName
+	return nwCfg, opts, nwCreate, nil
 }
 
-// CreateDockNet Creates a network in docker daemon
-func CreateDockNet(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState) error {
+// createDockNetOnce creates a docker network, without resolving conflicts
+func createDockNetOnce(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (_ CreateResult, err error) {
 	var nwID string
-	var subnetCIDRv6 = ""
+	cfg := getConfig()
 
-	if nwCfg.IPv6Subnet != "" {
-		subnetCIDRv6 = fmt.Sprintf("%s/%d", nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen)
+	if opts.SubnetPool != "" {
+		poolMutex.Lock()
+		defer poolMutex.Unlock()
+	}
+	if getPktTag(nwCfg) == 0 {
+		tagPoolMutex.Lock()
+		defer tagPoolMutex.Unlock()
 	}
+	nwCfg, opts, nwCreate, err := prepareDockNet(cfg, tenantName, networkName, serviceName, nwCfg, opts)
+	if err != nil {
+		return CreateResult{}, err
+	}
+	docknetName := nwCreate.Name
 
-	// Trim default tenant name
-	docknetName := GetDocknetName(tenantName, networkName, serviceName)
+	// count the docknet against the global limit
+	if cfg.maxDocknets > 0 && !opts.Ephemeral {
+		operID := docknetOperID(tenantName, networkName, serviceName)
+		var reserved bool
+		reserved, err = tracker.reserve(cfg.maxDocknets, operID)
+		if err != nil {
+			log.Errorf("Unable to create network %s. Err: %v", docknetName, err)
+			return CreateResult{}, err
+		}
+		if reserved {
+			defer func() {
+				if err != nil {
+					tracker.removed(operID)
+				}
+			}()
+		}
+	}
 
 	// connect to docker
-	docker, err := dockerclient.NewDockerClient("unix:///var/run/docker.sock", nil)
+	docker, err := newDockerClient()
 	if err != nil {
 		log.Errorf("Unable to connect to docker. Error %v", err)
-		return errors.New("Unable to connect to docker")
+		return CreateResult{}, errors.New("Unable to connect to docker")
 	}
 
 	// Check if the network already exists
-	nw, err := docker.InspectNetwork(docknetName)
-	if err == nil && nw.Driver == netDriverName {
-		log.Infof("docker network: %s already exists", docknetName)
+	var nw *dockerclient.NetworkResource
+	err = retry(context.Background(), cfg, func() error {
+		nw, err = docker.InspectNetwork(docknetName)
+		return err
+	})
+	if err != nil && !isNotFound(err) {
+		log.Errorf("Error inspecting network %s. Err: %v", docknetName, err)
+		return CreateResult{}, err
+	}
+	if err == nil && cfg.ownsDriver(nw.Driver) {
+		var conflict bool
+		conflict, err = nameConflict(docknetOperID(tenantName, networkName, serviceName), tenantName, nw.ID)
+		if err != nil {
+			return CreateResult{}, err
+		} else if conflict {
+			log.Errorf("Network name %s used by another docknet", docknetName)
+			return CreateResult{}, ErrNameConflict
+		}
+		logInfof("docker network: %s already exists", docknetName)
 		nwID = nw.ID
-	} else if err == nil && nw.Driver != netDriverName {
+	} else if err == nil {
 		log.Errorf("Network name %s used by another driver %s", docknetName, nw.Driver)
-		return errors.New("Network name used by another driver")
-	} else if err != nil {
-		// plugin options to be sent to docker
-		netPluginOptions := make(map[string]string)
-		netPluginOptions["tenant"] = nwCfg.Tenant
-		netPluginOptions["encap"] = nwCfg.PktTagType
-		if nwCfg.PktTagType == "vxlan" {
-			netPluginOptions["pkt-tag"] = strconv.Itoa(nwCfg.ExtPktTag)
-		} else {
-			netPluginOptions["pkt-tag"] = strconv.Itoa(nwCfg.PktTag)
-		}
-
-		subnetCIDR := fmt.Sprintf("%s/%d", nwCfg.SubnetIP, nwCfg.SubnetLen)
-
-		var ipams []dockerclient.IPAMConfig
-		var IPAMv4 = dockerclient.IPAMConfig{
-			Subnet:  subnetCIDR,
-			Gateway: nwCfg.Gateway,
-		}
-		ipams = append(ipams, IPAMv4)
-		var IPAMv6 dockerclient.IPAMConfig
-		if subnetCIDRv6 != "" {
-			IPAMv6 = dockerclient.IPAMConfig{
-				Subnet:  subnetCIDRv6,
-				Gateway: nwCfg.IPv6Gateway,
-			}
-			ipams = append(ipams, IPAMv6)
-		}
-		ipamOptions := make(map[string]string)
-		ipamOptions["tenant"] = nwCfg.Tenant
-		ipamOptions["network"] = nwCfg.NetworkName
-
-		// Build network parameters
-		nwCreate := dockerclient.NetworkCreate{
-			Name:           docknetName,
-			CheckDuplicate: true,
-			Driver:         netDriverName,
-			IPAM: dockerclient.IPAM{
-				Driver:  ipamDriverName,
-				Config:  ipams,
-				Options: ipamOptions,
-			},
-			Options: netPluginOptions,
+		return CreateResult{}, ErrNameConflict
+	} else if cfg.createPolicy == RequirePreexisting {
+		log.Errorf("docker network %s does not exist and can not be created", docknetName)
+		return CreateResult{}, ErrDockerNetworkMissing
+	} else {
+		// let external systems veto the network
+		err = runCreateHooks(cfg, newCreateHookRequest(tenantName, networkName, serviceName, nwCreate))
+		if err != nil {
+			log.Errorf("Create hook rejected network %s. Err: %v", docknetName, err)
+			return CreateResult{}, err
 		}
 
-		log.Infof("Creating docker network: %+v", nwCreate)
+		logInfof("Creating docker network: %+v", cfg.redactNetworkCreate(nwCreate))
 
 		// Create network
-		resp, err := docker.CreateNetwork(&nwCreate)
+		nwID, err = createNetworkRetry(cfg, docker, nwCreate)
 		if err != nil {
+			err = cfg.redactCreateError(nwCreate, err)
 			log.Errorf("Error creating network %s. Err: %v", docknetName, err)
-			return err
+			return CreateResult{}, wrapIPAMError(err)
+		}
+
+		// make sure the driver programmed the gateway endpoint
+		if opts.PreallocateGateway {
+			gateway := nwCfg.Gateway
+			if gateway == "" {
+				gateway = nwCfg.IPv6Gateway
+			}
+			err = checkGatewayEndpoint(docker, nwID, gateway)
+			if err != nil {
+				log.Errorf("Error allocating gateway for network %s. Err: %v", docknetName, err)
+				if rmErr := docker.RemoveNetwork(nwID); rmErr != nil {
+					log.Errorf("Error removing network %s. Err: %v", docknetName, rmErr)
+				}
+				return CreateResult{}, err
+			}
 		}
+	}
+
+	result := CreateResult{NetworkID: nwID, DocknetName: docknetName}
+	result.PktTag, _ = strconv.Atoi(nwCreate.Options["pkt-tag"])
 
-		nwID = resp.ID
+	// ephemeral networks have no oper state
+	if opts.Ephemeral {
+		return result, nil
 	}
 
 	// Get the state driver
 	stateDriver, err := utils.GetStateDriver()
 	if err != nil {
 		log.Warnf("Couldn't read global config %v", err)
-		return err
+		return CreateResult{}, err
 	}
 
 	// save docknet oper state
-	dnetOper := DnetOperState{
-		TenantName:  tenantName,
-		NetworkName: networkName,
-		ServiceName: serviceName,
-		DocknetUUID: nwID,
-	}
-	dnetOper.ID = fmt.Sprintf("%s.%s.%s", tenantName, networkName, serviceName)
+	dnetOper := newDnetOper(tenantName, networkName, serviceName, nwCfg, opts, nwCreate)
+	dnetOper.DocknetUUID = nwID
 	dnetOper.StateDriver = stateDriver
 
-	// write the dnet oper state
-	return dnetOper.Write()
-}
+	if err := dnetOper.writeDocknet(); err != nil {
+		return CreateResult{}, err
+	}
 
-// DeleteDockNet deletes a network in docker daemon
-func DeleteDockNet(tenantName, networkName, serviceName string) error {
-	// Trim default tenant name
-	docknetName := GetDocknetName(tenantName, networkName, serviceName)
+	return result, nil
+}
 
-	// connect to docker
-	docker, err := dockerclient.NewDockerClient("unix:///var/run/docker.sock", nil)
-	if err != nil {
-		log.Errorf("Unable to connect to docker. Error %v", err)
-		return errors.New("Unable to connect to docker")
+// newDnetOper builds the oper state of a docknet
+func newDnetOper(tenantName, networkName, serviceName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions, nwCreate *dockerclient.NetworkCreate) *DnetOperState {
+	dnetOper := DnetOperState{
+		TenantName:    tenantName,
+		NetworkName:   networkName,
+		ServiceName:   serviceName,
+		RawName:       opts.RawName,
+		Encap:         nwCreate.Options["encap"],
+		AdminState:    adminStateUp,
+		L2Only:        opts.L2Only,
+		ExcludedRange: opts.ExcludedRange,
+		SubnetPool:    opts.SubnetPool,
+		Default:       opts.Default,
+		CreatedBy:     opts.CreatedBy,
+		Source:        opts.Source,
 	}
+	dnetOper.ID = docknetOperID(tenantName, networkName, serviceName)
+	dnetOper.PktTag, _ = strconv.Atoi(nwCreate.Options["pkt-tag"])
+	dnetOper.AutoDeleteWhenEmpty = opts.AutoDeleteWhenEmpty
+	if annotations := nwCreate.Options[annotationsOption]; annotations != "" {
+		dnetOper.Annotations = json.RawMessage(annotations)
+	}
+	if opts.Disabled {
+		dnetOper.AdminState = adminStateDown
+	}
+	dnetOper.setNetworkSpec(nwCreate)
 
-	log.Infof("Deleting docker network: %+v", docknetName)
+	return &dnetOper
+}
 
-	// Delete network
-	err = docker.RemoveNetwork(docknetName)
-	if err != nil {
-		log.Errorf("Error deleting network %s. Err: %v", docknetName, err)
-		return err
+// writeDocknet writes the oper state of a docknet, taking over the tenant
+// default if the docknet is the default network
+func (s *DnetOperState) writeDocknet() error {
+	if !s.Default {
+		// write the dnet oper state
+		return s.Write()
 	}
 
-	// Get the state driver
-	stateDriver, err := utils.GetStateDriver()
+	// take over the tenant default from any other network
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+	err := s.Write()
 	if err != nil {
-		log.Warnf("Couldn't read global config %v", err)
 		return err
 	}
 
-	// save docknet oper state
-	dnetOper := DnetOperState{}
-	dnetOper.ID = fmt.Sprintf("%s.%s.%s", tenantName, networkName, serviceName)
-	dnetOper.StateDriver = stateDriver
-
-	// write the dnet oper state
-	return dnetOper.Clear()
+	return clearTenantDefault(s.StateDriver, s.TenantName, s.ID)
 }
 
-// FindDocknetByUUID find the docknet by UUID
-func FindDocknetByUUID(dnetID string) (*DnetOperState, error) {
-	// Get the state driver
-	stateDriver, err := utils.GetStateDriver()
-	if err != nil {
-		log.Warnf("Couldn't read global config %v", err)
-		return nil, err
+// buildNetworkCreate validates the network config and builds the docker
+// network create request
+func buildNetworkCreate(cfg config, docknetName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (*dockerclient.NetworkCreate, error) {
+	var subnetCIDRv6 = ""
+	var gatewayv6 = nwCfg.IPv6Gateway
+
+	if opts.L2Only {
+		hasGateway := nwCfg.Gateway != "" || nwCfg.IPv6Gateway != "" ||
+			opts.IPv6GatewayMode != IPv6GatewayNone || opts.PreallocateGateway
+		for _, pool := range opts.AdditionalPools {
+			hasGateway = hasGateway || pool.Gateway != ""
+		}
+		if hasGateway {
+			log.Errorf("Gateway specified for L2 only network %s", docknetName)
+			return nil, ErrL2OnlyGateway
+		}
 	}
 
-	tmpDnet := DnetOperState{}
-	tmpDnet.StateDriver = stateDriver
-	dnetOperList, err := tmpDnet.ReadAll()
-	if err != nil {
-		log.Errorf("Error getting docknet list. Err: %v", err)
-		return nil, err
+	if nwCfg.IPv6Subnet != "" {
+		subnetCIDRv6 = fmt.Sprintf("%s/%d", nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen)
+
+		if gatewayv6 == "" && opts.IPv6GatewayMode != IPv6GatewayNone {
+			var err error
+			gatewayv6, err = deriveIPv6Gateway(subnetCIDRv6, opts.IPv6GatewayMode, opts.IPv6GatewayMAC)
+			if err != nil {
+				log.Errorf("Error deriving IPv6 gateway for network %s. Err: %v", docknetName, err)
+				return nil, err
+			}
+		}
 	}
 
-	// Walk all dnets and find the matching UUID
-	for _, dnet := range dnetOperList {
-		if dnet.(*DnetOperState).DocknetUUID == dnetID {
-			return dnet.(*DnetOperState), nil
+	// validate the subnets, IPv6 only networks have no IPv4 subnet
+	pools := []IPAMPool{}
+	if nwCfg.SubnetIP != "" {
+		pools = append(pools, IPAMPool{Subnet: fmt.Sprintf("%s/%d", nwCfg.SubnetIP, nwCfg.SubnetLen), Gateway: nwCfg.Gateway})
+	} else if nwCfg.Gateway != "" {
+		log.Errorf("Gateway %s without IPv4 subnet for network %s", nwCfg.Gateway, docknetName)
+		return nil, ErrGatewayOutsideSubnet
+	}
+	if subnetCIDRv6 != "" {
+		pools = append(pools, IPAMPool{Subnet: subnetCIDRv6, Gateway: gatewayv6})
+	}
+	pools = append(pools, opts.AdditionalPools...)
+	if len(pools) == 0 {
+		log.Errorf("Network %s has no subnet", docknetName)
+		return nil, ErrNoSubnet
+	}
+	if len(pools) > cfg.maxIPAMPools {
+		log.Errorf("Network %s has %d subnets, max allowed is %d", docknetName, len(pools), cfg.maxIPAMPools)
+		return nil, ErrTooManyPools
+	}
+	for _, pool := range pools {
+		if _, _, err := net.ParseCIDR(pool.Subnet); err != nil {
+			log.Errorf("Invalid subnet %s for network %s", pool.Subnet, docknetName)
+			return nil, err
+		}
+		if pool.Gateway == "" {
+			continue
+		}
+		if net.ParseIP(pool.Gateway) == nil {
+			log.Errorf("Gateway %s for subnet %s of network %s is not an IP address", pool.Gateway, pool.Subnet, docknetName)
+			return nil, ErrGatewayHostname
+		}
+		if err := validateGateway(pool.Subnet, pool.Gateway); err != nil {
+			log.Errorf("Invalid gateway %s for subnet %s of network %s. Err: %v", pool.Gateway, pool.Subnet, docknetName, err)
+			return nil, err
+		}
+	}
+	if opts.ExcludedRange != nil {
+		if err := validateExcludedRange(pools[0].Subnet, *opts.ExcludedRange); err != nil {
+			log.Errorf("Invalid excluded range %s for subnet %s of network %s. Err: %v",
+				opts.ExcludedRange, pools[0].Subnet, docknetName, err)
+			return nil, err
+		}
+	}
+
+	if opts.ExternalConnectivity {
+		if opts.Uplink == "" {
+			log.Errorf("No uplink for network %s needing external connectivity", docknetName)
+			return nil, ErrUplinkMissing
+		}
+		if err := lookupInterface(opts.Uplink); err != nil {
+			log.Errorf("Uplink %s of network %s not found. Err: %v", opts.Uplink, docknetName, err)
+			return nil, ErrUplinkMissing
+		}
+	}
+
+	var annotations string
+	if len(opts.Annotations) > 0 {
+		var err error
+		annotations, err = encodeAnnotations(opts.Annotations)
+		if err != nil {
+			log.Errorf("Invalid annotations for network %s. Err: %v", docknetName, err)
+			return nil, err
 		}
 	}
 
-	return nil, errors.New("docknet UUID not found")
+	// plugin options to be sent to docker
+	netPluginOptions := make(map[string]string)
+	netPluginOptions["tenant"] = nwCfg.Tenant
+	netPluginOptions["encap"] = nwCfg.PktTagType
+	netPluginOptions["pkt-tag"] = strconv.Itoa(getPktTag(nwCfg))
+	if opts.PreallocateGateway {
+		netPluginOptions["gw-endpoint"] = "true"
+	}
+	if opts.Disabled {
+		netPluginOptions["admin-state"] = adminStateDown
+	}
+	if opts.L2Only {
+		netPluginOptions["l2-only"] = "true"
+	}
+	if subnetCIDRv6 != "" {
+		// docker only assigns IPv6 addresses on networks with IPv6 enabled
+		netPluginOptions[netlabel.EnableIPv6] = "true"
+	}
+	if opts.ExternalConnectivity {
+		netPluginOptions["external"] = "true"
+		netPluginOptions["uplink"] = opts.Uplink
+	}
+	if annotations != "" {
+		netPluginOptions[annotationsOption] = annotations
+	}
+
+	// driver options and MTU of the network config
+	driverOptions, err := cfg.validateOptions(docknetName, nwCfg.DriverOptions)
+	if err != nil {
+		log.Errorf("Invalid driver options for network %s. Err: %v", docknetName, err)
+		return nil, err
+	}
+	for key, val := range driverOptions {
+		if reservedOptions[key] {
+			log.Errorf("Driver option %s of network %s is computed by docknet", key, docknetName)
+			return nil, ErrReservedDriverOption
+		}
+		netPluginOptions[key] = val
+	}
+	if nwCfg.MTU != 0 {
+		if nwCfg.MTU < minMTU || nwCfg.MTU > maxMTU {
+			log.Errorf("Invalid MTU %d for network %s", nwCfg.MTU, docknetName)
+			return nil, ErrInvalidMTU
+		}
+		netPluginOptions[mtuOption] = strconv.Itoa(nwCfg.MTU)
+	}
+
+	for key, val := range opts.OptionsOverride {
+		if reservedOptions[key] {
+			log.Warnf("Overriding reserved option %s=%q of network %s with %q", key,
+				cfg.redactValue(key, netPluginOptions[key]), docknetName, cfg.redactValue(key, val))
+		}
+		netPluginOptions[key] = val
+	}
+
+	var ipams []dockerclient.IPAMConfig
+	driverGateways := []string{}
+	for _, pool := range pools {
+		ipam := dockerclient.IPAMConfig{
+			Subnet:  pool.Subnet,
+			Gateway: pool.Gateway,
+		}
+		if opts.GatewaySource == GatewayFromDriver && pool.Gateway != "" {
+			driverGateways = append(driverGateways, pool.Subnet+"="+pool.Gateway)
+			ipam.Gateway = ""
+		}
+		ipams = append(ipams, ipam)
+	}
+	ipamOptions := make(map[string]string)
+	ipamOptions["tenant"] = nwCfg.Tenant
+	ipamOptions["network"] = nwCfg.NetworkName
+	if len(driverGateways) > 0 {
+		ipamOptions[gatewaysOption] = strings.Join(driverGateways, ",")
+	}
+	if opts.ExcludedRange != nil {
+		ipamOptions[excludeRangeOption] = opts.ExcludedRange.String()
+	}
+
+	// nwCfg.Attachable needs no flag, our networks are local scoped and
+	// docker lets containers connect to local scoped networks
+	nwCreate := dockerclient.NetworkCreate{
+		Name:           docknetName,
+		CheckDuplicate: true,
+		Driver:         cfg.netDriverName,
+		IPAM: dockerclient.IPAM{
+			Driver:  cfg.ipamDriverName,
+			Config:  ipams,
+			Options: ipamOptions,
+		},
+		Internal: nwCfg.Internal,
+		Options:  netPluginOptions,
+		Labels:   managedLabels(nil),
+	}
+	if opts.Ephemeral {
+		nwCreate.Labels[ephemeralLabel] = "true"
+	}
+
+	return &nwCreate, nil
+}
+
+// isEphemeral returns true if a docker network was created as ephemeral,
+// without oper state
+func isEphemeral(nw *dockerclient.NetworkResource) bool {
+	return nw.Labels[ephemeralLabel] == "true"
+}
+
+// setNetworkSpec saves the docker network parameters in the oper state
+func (s *DnetOperState) setNetworkSpec(nwCreate *dockerclient.NetworkCreate) {
+	s.Subnets = []IPAMPool{}
+	for _, ipam := range nwCreate.IPAM.Config {
+		s.Subnets = append(s.Subnets, IPAMPool{Subnet: ipam.Subnet, Gateway: ipam.Gateway})
+	}
+	s.Options = nwCreate.Options
+	s.IPAMOptions = nwCreate.IPAM.Options
+	s.Labels = nwCreate.Labels
+	s.Internal = nwCreate.Internal
+}
+
+// networkCreate rebuilds the docker network create request from the oper state
+func (s *DnetOperState) networkCreate(cfg config) *dockerclient.NetworkCreate {
+	nwCreate := dockerclient.NetworkCreate{
+		Name:           s.DocknetName(),
+		CheckDuplicate: true,
+		Driver:         cfg.netDriverName,
+		IPAM: dockerclient.IPAM{
+			Driver:  cfg.ipamDriverName,
+			Options: s.IPAMOptions,
+		},
+		Internal: s.Internal,
+		Options:  s.Options,
+		Labels:   managedLabels(s.Labels),
+	}
+	for _, pool := range s.Subnets {
+		nwCreate.IPAM.Config = append(nwCreate.IPAM.Config, dockerclient.IPAMConfig{
+			Subnet:  pool.Subnet,
+			Gateway: pool.Gateway,
+		})
+	}
+
+	return &nwCreate
+}
+
+// managedLabels returns a copy of labels with the labels marking networks
+// created by us
+func managedLabels(labels map[string]string) map[string]string {
+	newLabels := map[string]string{}
+	for key, val := range labels {
+		newLabels[key] = val
+	}
+	newLabels[managedLabel] = "true"
+	newLabels[versionLabel] = contivVersion
+
+	return newLabels
+}
+
+// getPktTag returns the packet tag used for the network's encap
+func getPktTag(nwCfg *mastercfg.CfgNetworkState) int {
+	if nwCfg.PktTagType == "vxlan" {
+		return nwCfg.ExtPktTag
+	}
+
+	return nwCfg.PktTag
+}
+
+// clearTenantDefault clears the default flag on all networks of the tenant
+// other than exceptID
+func clearTenantDefault(stateDriver core.StateDriver, tenantName, exceptID string) error {
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil {
+		return err
+	}
+
+	for _, dnet := range dnets {
+		if dnet.TenantName != tenantName || dnet.ID == exceptID || !dnet.Default {
+			continue
+		}
+
+		logInfof("Clearing default flag on docknet %s", dnet.ID)
+		dnet.Default = false
+		err = dnet.Write()
+		if err != nil {
+			log.Errorf("Error clearing default flag on docknet %s. Err: %v", dnet.ID, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetDefaultDockNet returns the default network of a tenant
+func GetDefaultDockNet(tenantName string) (*DnetOperState, error) {
+	dnets, err := ListDockNets()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dnet := range dnets {
+		if dnet.TenantName == tenantName && dnet.Default {
+			return dnet, nil
+		}
+	}
+
+	return nil, ErrDocknetNotFound
+}
+
+// listDriverNetworks returns all docker networks using our network driver, or
+// a legacy driver name we still own
+func listDriverNetworks(docker dockerclient.Client, cfg config) ([]*dockerclient.NetworkResource, error) {
+	// NOTE: dockerclient does not build the filters query correctly, so we
+	// filter the driver here
+	nwList, err := docker.ListNetworks("")
+	if err != nil {
+		return nil, err
+	}
+
+	nws := []*dockerclient.NetworkResource{}
+	for _, nw := range nwList {
+		if cfg.ownsDriver(nw.Driver) {
+			nws = append(nws, nw)
+		}
+	}
+
+	return nws, nil
+}
+
+// checkGatewayEndpoint verifies the network has an endpoint holding the
+// gateway address, IPv4 or IPv6. A network without a gateway has nothing to
+// check.
+func checkGatewayEndpoint(docker dockerclient.Client, nwID, gateway string) error {
+	gatewayIP := net.ParseIP(gateway)
+	if gatewayIP == nil {
+		return nil
+	}
+
+	nw, err := docker.InspectNetwork(nwID)
+	if err != nil {
+		return err
+	}
+
+	for _, ep := range nw.Containers {
+		for _, epAddr := range []string{ep.IPv4Address, ep.IPv6Address} {
+			if net.ParseIP(strings.Split(epAddr, "/")[0]).Equal(gatewayIP) {
+				return nil
+			}
+		}
+	}
+
+	return ErrGatewayNotAllocated
+}
+
+// DeleteDockNet deletes a network in docker daemon. Deleting a network that
+// does not exist is not an error.
+func DeleteDockNet(tenantName, networkName, serviceName string) error {
+	// Trim default tenant name, unless the network has a raw name
+	docknetName := GetDocknetName(tenantName, networkName, serviceName)
+	shared := false
+	if dnetOper, err := readDocknetOper(tenantName, networkName, serviceName); err == nil {
+		docknetName = dnetOper.DocknetName()
+		shared = dnetOper.sharedInUse()
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	logInfof("Deleting docker network: %+v", docknetName)
+	defer expectRemoval(docknetName)()
+
+	// ephemeral networks have no oper state to clear
+	ephemeral := false
+	if nw, err := docker.InspectNetwork(docknetName); err == nil {
+		ephemeral = isEphemeral(nw)
+	}
+
+	// Delete network, unless the other tenant of a shared network uses it
+	if shared {
+		logInfof("docker network %s is shared with another tenant, not deleting it", docknetName)
+	} else if err = removeNetworkRetry(getConfig(), docker, docknetName); isNotFound(err) {
+		logInfof("docker network %s does not exist", docknetName)
+	} else if err != nil {
+		log.Errorf("Error deleting network %s. Err: %v", docknetName, err)
+		return err
+	}
+
+	if ephemeral {
+		return nil
+	}
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return err
+	}
+
+	// save docknet oper state
+	dnetOper := DnetOperState{}
+	dnetOper.ID = docknetOperID(tenantName, networkName, serviceName)
+	dnetOper.StateDriver = stateDriver
+
+	// write the dnet oper state
+	return core.ErrIfKeyExists(dnetOper.Clear())
+}
+
+// DeleteDockNetCascade deletes the docker network of a tenant's network along
+// with the docker networks of all its endpoint groups
+func DeleteDockNetCascade(tenantName, networkName string) error {
+	dnets, err := ListDockNets()
+	if err != nil {
+		return err
+	}
+
+	errs := []string{}
+	for _, dnet := range dnets {
+		if dnet.TenantName != tenantName || dnet.NetworkName != networkName || dnet.ServiceName == "" {
+			continue
+		}
+
+		err = DeleteDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", dnet.ID, err))
+		}
+	}
+
+	// delete the base network last
+	err = DeleteDockNet(tenantName, networkName, "")
+	if err != nil {
+		errs = append(errs, fmt.Sprintf("%s: %v", docknetOperID(tenantName, networkName, ""), err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error deleting docker networks: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// readDocknetOper reads the oper state of a docknet
+func readDocknetOper(tenantName, networkName, serviceName string) (*DnetOperState, error) {
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return nil, err
+	}
+
+	dnetOper := DnetOperState{}
+	dnetOper.StateDriver = stateDriver
+	err = dnetOper.Read(docknetOperID(tenantName, networkName, serviceName))
+	if err != nil {
+		log.Errorf("Error reading docknet state. Err: %v", err)
+		return nil, ErrDocknetNotFound
+	}
+
+	return &dnetOper, nil
+}
+
+// DocknetName returns the docker network name of the docknet
+func (s *DnetOperState) DocknetName() string {
+	if s.RawName != "" {
+		return s.RawName
+	}
+	// docknets named by other schemes or truncated have a raw name
+	if s.isSharedSecondary() {
+		return fullDocknetName(s.PrimaryTenant, s.NetworkName, s.ServiceName)
+	}
+
+	return fullDocknetName(s.TenantName, s.NetworkName, s.ServiceName)
+}
+
+// docknetOperID returns the oper state ID for a docknet
+func docknetOperID(tenantName, networkName, serviceName string) string {
+	return strings.Join([]string{tenantName, networkName, serviceName}, OperIDSeparator)
+}
+
+// docknetOperPrefix returns the base key of the docknet oper states of a
+// tenant
+func docknetOperPrefix(tenantName string) string {
+	return state.TenantPath(tenantName) + docknetOperPath
+}
+
+// docknetOperKey returns the key of a docknet oper state, in the state of the
+// tenant in the oper state ID
+func docknetOperKey(operID string) string {
+	tenantName := strings.SplitN(operID, OperIDSeparator, 2)[0]
+	return docknetOperPrefix(tenantName) + operID
+}
+
+// validOperID checks the oper state ID is of the form tenant.network.service
+// and matches the names in the record
+func (s *DnetOperState) validOperID() bool {
+	if s.TenantName == "" || s.NetworkName == "" {
+		return false
+	}
+	if len(strings.Split(s.ID, OperIDSeparator)) != 3 {
+		return false
+	}
+
+	return s.ID == docknetOperID(s.TenantName, s.NetworkName, s.ServiceName)
+}
+
+// readAllDocknets reads all docknet oper states, skipping malformed records
+func readAllDocknets(stateDriver core.StateDriver) ([]*DnetOperState, error) {
+	tmpDnet := DnetOperState{}
+	tmpDnet.StateDriver = stateDriver
+	dnetOperList, err := tmpDnet.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	dnets := []*DnetOperState{}
+	for _, state := range dnetOperList {
+		dnet := state.(*DnetOperState)
+		if !dnet.validOperID() {
+			log.Warnf("Skipping docknet oper state with malformed ID %q: %+v", dnet.ID, dnet)
+			continue
+		}
+		dnets = append(dnets, dnet)
+	}
+
+	return dnets, nil
+}
+
+// ListDockNets returns all docknet oper states. They are read from the read
+// state driver if one is set.
+func ListDockNets() ([]*DnetOperState, error) {
+	// Get the state drivers
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return nil, err
+	}
+	writeDriver, err := getWriteStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil {
+		log.Errorf("Error getting docknet list. Err: %v", err)
+		return nil, err
+	}
+
+	// updates must go to the primary
+	for _, dnet := range dnets {
+		dnet.StateDriver = writeDriver
+	}
+
+	return dnets, nil
+}
+
+// TenantHasDockNets returns true if the tenant has any docknet oper state
+func TenantHasDockNets(tenantName string) (bool, error) {
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return false, err
+	}
+
+	if checker, ok := stateDriver.(state.KeyPrefixChecker); ok {
+		return checker.KeyPrefixExists(docknetOperPrefix(tenantName))
+	}
+
+	values, err := stateDriver.ReadAll(docknetOperPrefix(tenantName))
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return false, nil
+		}
+		log.Errorf("Error reading docknets. Err: %v", err)
+		return false, err
+	}
+
+	return len(values) > 0, nil
+}
+
+// CopyLegacyOperStates copies the docknet oper states written by releases
+// that did not keep them in the state of their tenant, and that are not in
+// their tenant yet. The legacy states are kept for those releases. It
+// returns the number of copied states.
+func CopyLegacyOperStates() (int, error) {
+	stateDriver, err := getWriteStateDriver()
+	if err != nil {
+		return 0, err
+	}
+	reader, ok := stateDriver.(state.KeyReader)
+	if !ok {
+		return 0, core.Errorf("state driver does not read keys")
+	}
+
+	values, err := reader.ReadAllKeys(legacyDocknetOperPrefix)
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return 0, nil
+		}
+		log.Errorf("Error reading legacy docknets. Err: %v", err)
+		return 0, err
+	}
+
+	copied := 0
+	for key, value := range values {
+		operID := strings.TrimPrefix(key, legacyDocknetOperPrefix)
+		if operID == "" || strings.Contains(operID, "/") {
+			continue
+		}
+		if _, err := stateDriver.Read(docknetOperKey(operID)); err == nil {
+			continue
+		}
+
+		// the raw value is copied, schema migrations apply on read
+		if err := stateDriver.Write(docknetOperKey(operID), value); err != nil {
+			log.Errorf("Error copying docknet %s. Err: %v", operID, err)
+			return copied, err
+		}
+		copied++
+	}
+
+	return copied, nil
+}
+
+// FindByPktTag returns the docknets using an encap and packet tag
+func FindByPktTag(encap string, tag int) ([]*DnetOperState, error) {
+	dnetOperList, err := ListDockNets()
+	if err != nil {
+		return nil, err
+	}
+
+	dnets := []*DnetOperState{}
+	for _, dnet := range dnetOperList {
+		if dnet.Encap == encap && dnet.PktTag == tag {
+			dnets = append(dnets, dnet)
+		}
+	}
+
+	return dnets, nil
+}
+
+// DockNetsForContainer returns the docknets a container is attached to
+func DockNetsForContainer(containerID string) ([]*DnetOperState, error) {
+	cfg := getConfig()
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return nil, errors.New("Unable to connect to docker")
+	}
+
+	cinfo, err := docker.InspectContainer(containerID)
+	if err != nil {
+		log.Errorf("Error inspecting container %s. Err: %v", containerID, err)
+		return nil, err
+	}
+
+	dnets := []*DnetOperState{}
+	for nwName, epSettings := range cinfo.NetworkSettings.Networks {
+		nw, err := docker.InspectNetwork(epSettings.NetworkID)
+		if err != nil {
+			log.Errorf("Error inspecting network %s. Err: %v", nwName, err)
+			return nil, err
+		}
+
+		// skip networks that are not ours
+		if !cfg.ownsDriver(nw.Driver) {
+			continue
+		}
+
+		dnet, err := FindDocknetByUUID(nw.ID)
+		if err != nil {
+			log.Errorf("Error finding docknet for network %s. Err: %v", nwName, err)
+			return nil, err
+		}
+		dnets = append(dnets, dnet)
+	}
+
+	return dnets, nil
+}
+
+// AdoptDockNet records the oper state for an existing docker network using
+// our driver. Networks that already have an oper state are left alone.
+func AdoptDockNet(docknetName string) error {
+	cfg := getConfig()
+
+	name, err := ParseDocknetNameStruct(docknetName)
+	if err != nil {
+		log.Errorf("Unable to adopt network %s. Err: %v", docknetName, err)
+		return err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(docknetName)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", docknetName, err)
+		return err
+	}
+	if !cfg.ownsDriver(nw.Driver) {
+		log.Errorf("Network name %s used by another driver %s", docknetName, nw.Driver)
+		return errors.New("Network name used by another driver")
+	}
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return err
+	}
+
+	_, err = adoptNetwork(stateDriver, name, nw)
+	return err
+}
+
+// AdoptReport has the outcome of adopting each docker network
+type AdoptReport struct {
+	Adopted        []string          `json:"adopted"`
+	AlreadyTracked []string          `json:"alreadyTracked"`
+	Unparseable    []string          `json:"unparseable"`
+	Failed         map[string]string `json:"failed,omitempty"`
+}
+
+// AdoptAll adopts all docker networks using our driver that have no oper
+// state. Networks whose names can not be parsed are reported and skipped.
+// Ephemeral networks are never adopted.
+func AdoptAll() (AdoptReport, error) {
+	report := AdoptReport{
+		Adopted:        []string{},
+		AlreadyTracked: []string{},
+		Unparseable:    []string{},
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return report, errors.New("Unable to connect to docker")
+	}
+
+	nws, err := listDriverNetworks(docker, getConfig())
+	if err != nil {
+		log.Errorf("Error listing docker networks. Err: %v", err)
+		return report, err
+	}
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return report, err
+	}
+
+	for _, nw := range nws {
+		if isEphemeral(nw) {
+			continue
+		}
+		name, err := ParseDocknetNameStruct(nw.Name)
+		if err != nil {
+			log.Warnf("Unable to adopt network %s. Err: %v", nw.Name, err)
+			report.Unparseable = append(report.Unparseable, nw.Name)
+			continue
+		}
+
+		adopted, err := adoptNetwork(stateDriver, name, nw)
+		switch {
+		case err != nil:
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[nw.Name] = err.Error()
+		case adopted:
+			report.Adopted = append(report.Adopted, nw.Name)
+		default:
+			report.AlreadyTracked = append(report.AlreadyTracked, nw.Name)
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("error adopting %d docker networks", len(report.Failed))
+	}
+
+	return report, nil
+}
+
+// adoptNetwork writes the oper state of a docker network, unless it already
+// has one. It returns true if the network was adopted.
+func adoptNetwork(stateDriver core.StateDriver, name DocknetName, nw *dockerclient.NetworkResource) (bool, error) {
+	dnetOper := DnetOperState{}
+	dnetOper.StateDriver = stateDriver
+	operID := name.OperID()
+	if err := dnetOper.Read(operID); err == nil {
+		logInfof("docker network %s is already tracked as %s", nw.Name, operID)
+		return false, nil
+	}
+
+	if nw.Labels[managedLabel] == "true" {
+		logInfof("docker network %s was created by netplugin version %s", nw.Name, nw.Labels[versionLabel])
+	}
+
+	dnetOper = DnetOperState{
+		TenantName:  name.Tenant,
+		NetworkName: name.Network,
+		ServiceName: name.Service,
+		DocknetUUID: nw.ID,
+		Labels:      nw.Labels,
+		Source:      "adopt",
+	}
+	dnetOper.ID = operID
+	dnetOper.StateDriver = stateDriver
+
+	logInfof("Adopting docker network %s as %s", nw.Name, operID)
+
+	if err := dnetOper.Write(); err != nil {
+		log.Errorf("Error writing docknet %s. Err: %v", operID, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RecreateDockNet recreates a docker network that went missing, using the
+// parameters saved in its oper state
+func RecreateDockNet(tenantName, networkName, serviceName string) error {
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	cfg := getConfig()
+	nwCreate := dnetOper.networkCreate(cfg)
+
+	logInfof("Recreating docker network: %+v", cfg.redactNetworkCreate(nwCreate))
+
+	resp, err := docker.CreateNetwork(nwCreate)
+	if err != nil {
+		err = cfg.redactCreateError(nwCreate, err)
+		log.Errorf("Error creating network %s. Err: %v", nwCreate.Name, err)
+		return wrapIPAMError(err)
+	}
+
+	dnetOper.DocknetUUID = resp.ID
+
+	return dnetOper.Write()
 }
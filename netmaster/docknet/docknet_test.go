@@ -16,14 +16,22 @@ limitations under the License.
 package docknet
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/samalba/dockerclient"
 
 	log "github.com/Sirupsen/logrus"
 )
@@ -95,7 +103,7 @@ func checkDocknetCreate(t *testing.T, tenantName, networkName, serviceName, subn
 	}
 
 	// verify params are correct
-	if ninfo.Scope != "local" || ninfo.Driver != netDriverName || ninfo.IPAM.Driver != ipamDriverName ||
+	if ninfo.Scope != "local" || ninfo.Driver != getConfig().netDriverName || ninfo.IPAM.Driver != getConfig().ipamDriverName ||
 		ninfo.IPAM.Config[0].Subnet != subnet || ninfo.IPAM.Config[0].Gateway != gw {
 		t.Fatalf("Docker network {%+v} does not match expected values", ninfo)
 	}
@@ -154,7 +162,7 @@ func checkDocknetCreateIPv6(t *testing.T, tenantName, networkName, serviceName,
 	}
 
 	// verify params are correct
-	if ninfo.Scope != "local" || ninfo.Driver != netDriverName || ninfo.IPAM.Driver != ipamDriverName ||
+	if ninfo.Scope != "local" || ninfo.Driver != getConfig().netDriverName || ninfo.IPAM.Driver != getConfig().ipamDriverName ||
 		ninfo.IPAM.Config[0].Subnet != subnet || ninfo.IPAM.Config[0].Gateway != gw {
 		t.Fatalf("Docker network {%+v} does not match expected values", ninfo)
 	}
@@ -203,8 +211,7 @@ func checkDocknetDelete(t *testing.T, tenantName, networkName, serviceName strin
 
 func TestMain(m *testing.M) {
 	// change driver names for unit-testing
-	netDriverName = "bridge"
-	ipamDriverName = "default"
+	SetDriverNames("bridge", "default")
 
 	initStateDriver()
 
@@ -224,3 +231,977 @@ func TestDocknetCreateDelete(t *testing.T) {
 	checkDocknetCreate(t, "unit-test", "net1", "srv1", "10.1.1.1/24", "10.1.1.254")
 	checkDocknetDelete(t, "unit-test", "net1", "srv1")
 }
+
+func TestDocknetPreallocateGateway(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	opts := DockNetOptions{PreallocateGateway: true}
+
+	// driver programs the gateway endpoint
+	docker.allocGateway = true
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network with gateway endpoint. Err: %v", err)
+	}
+	nw, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if err != nil || nw.Options["gw-endpoint"] != "true" {
+		t.Fatalf("gateway endpoint option was not passed to docker. nw: %+v, Err: %v", nw, err)
+	}
+	if getDocknetState("unit-test", "net1", "") == nil {
+		t.Fatalf("docknet state was not created")
+	}
+
+	// driver fails to program the gateway endpoint
+	docker.allocGateway = false
+	err = CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+	if err != ErrGatewayNotAllocated {
+		t.Fatalf("Expected ErrGatewayNotAllocated, got: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); err == nil {
+		t.Fatalf("docker network was not rolled back")
+	}
+	if getDocknetState("unit-test", "net2", "") != nil {
+		t.Fatalf("docknet state was created for rolled back network")
+	}
+
+	// the gateway endpoint of an IPv6 only network has an IPv6 address
+	docker.allocGateway = true
+	nwCfg := fakeNwCfg("unit-test", "net3")
+	nwCfg.SubnetIP, nwCfg.SubnetLen, nwCfg.Gateway = "", 0, ""
+	nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, nwCfg.IPv6Gateway = "2016:430::", 100, "2016:430::254"
+	if err := CreateDockNetWithOptions("unit-test", "net3", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating IPv6 network with gateway endpoint. Err: %v", err)
+	}
+
+	// a network without a gateway has no gateway endpoint to check
+	docker.allocGateway = false
+	nwCfg = fakeNwCfg("unit-test", "net4")
+	nwCfg.Gateway = ""
+	if err := CreateDockNetWithOptions("unit-test", "net4", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating network without gateway. Err: %v", err)
+	}
+}
+
+func TestDocknetMaxIPAMPools(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetMaxIPAMPools(defaultMaxIPAMPools)
+
+	if err := SetMaxIPAMPools(0); err == nil {
+		t.Fatalf("Zero max IPAM pools was accepted")
+	}
+	if err := SetMaxIPAMPools(3); err != nil {
+		t.Fatalf("Error setting max IPAM pools. Err: %v", err)
+	}
+
+	pools := []IPAMPool{
+		{Subnet: "10.2.1.0/24", Gateway: "10.2.1.254"},
+		{Subnet: "10.3.1.0/24", Gateway: "10.3.1.254"},
+		{Subnet: "10.4.1.0/24", Gateway: "10.4.1.254"},
+	}
+
+	// under the limit
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"),
+		DockNetOptions{AdditionalPools: pools[:1]})
+	if err != nil {
+		t.Fatalf("Error creating network with 2 pools. Err: %v", err)
+	}
+
+	// at the limit
+	err = CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"),
+		DockNetOptions{AdditionalPools: pools[:2]})
+	if err != nil {
+		t.Fatalf("Error creating network with 3 pools. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net2", ""))
+	if len(nw.IPAM.Config) != 3 || nw.IPAM.Config[2].Subnet != "10.3.1.0/24" {
+		t.Fatalf("Unexpected IPAM config %+v", nw.IPAM.Config)
+	}
+
+	// over the limit
+	err = CreateDockNetWithOptions("unit-test", "net3", "", fakeNwCfg("unit-test", "net3"),
+		DockNetOptions{AdditionalPools: pools})
+	if err != ErrTooManyPools {
+		t.Fatalf("Expected ErrTooManyPools, got: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net3", "")); err == nil {
+		t.Fatalf("docker network was created with too many pools")
+	}
+}
+
+func TestDockNetsForContainer(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "foreign", Driver: "overlay"})
+
+	docker.addContainer("ctr1", GetDocknetName("unit-test", "net1", ""), "foreign")
+	docker.addContainer("ctr2", "foreign")
+
+	dnets, err := DockNetsForContainer("ctr1")
+	if err != nil {
+		t.Fatalf("Error getting docknets for container. Err: %v", err)
+	}
+	if len(dnets) != 1 || dnets[0].TenantName != "unit-test" || dnets[0].NetworkName != "net1" {
+		t.Fatalf("Unexpected docknets for container: %+v", dnets)
+	}
+
+	// container on no netplugin networks
+	dnets, err = DockNetsForContainer("ctr2")
+	if err != nil || len(dnets) != 0 {
+		t.Fatalf("Expected no docknets for container. Got: %+v, Err: %v", dnets, err)
+	}
+
+	// unknown container
+	if _, err := DockNetsForContainer("ctr3"); err == nil {
+		t.Fatalf("Unknown container did not return an error")
+	}
+}
+
+func TestDocknetSkipMalformedOperState(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// inject a record whose ID does not match tenant.network.service
+	stateDriver, _ := utils.GetStateDriver()
+	badOper := DnetOperState{
+		TenantName:  "unit-test",
+		NetworkName: "net2",
+		DocknetUUID: "bad-uuid",
+	}
+	badOper.ID = "bogus"
+	badOper.StateDriver = stateDriver
+	if err := badOper.Write(); err != nil {
+		t.Fatalf("Error writing malformed oper state. Err: %v", err)
+	}
+
+	dnets, err := ListDockNets()
+	if err != nil {
+		t.Fatalf("Error listing docknets. Err: %v", err)
+	}
+	if len(dnets) != 1 || dnets[0].ID != "unit-test.net1." {
+		t.Fatalf("Malformed record was not skipped: %+v", dnets)
+	}
+
+	if _, err := FindDocknetByUUID("bad-uuid"); err == nil {
+		t.Fatalf("FindDocknetByUUID returned a malformed record")
+	}
+}
+
+func TestDocknetTenantDefault(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if _, err := GetDefaultDockNet("unit-test"); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	opts := DockNetOptions{Default: true}
+	for _, tenant := range []string{"unit-test", "other"} {
+		err := CreateDockNetWithOptions(tenant, "net1", "", fakeNwCfg(tenant, "net1"), opts)
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+	dnet, err := GetDefaultDockNet("unit-test")
+	if err != nil || dnet.NetworkName != "net1" {
+		t.Fatalf("Unexpected default network %+v. Err: %v", dnet, err)
+	}
+
+	// a new default unsets the old one
+	err = CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	dnet, err = GetDefaultDockNet("unit-test")
+	if err != nil || dnet.NetworkName != "net2" {
+		t.Fatalf("Unexpected default network %+v. Err: %v", dnet, err)
+	}
+	if getDocknetState("unit-test", "net1", "").Default {
+		t.Fatalf("Old default network was not unset")
+	}
+
+	// only one default per tenant
+	dnets, _ := ListDockNets()
+	numDefaults := 0
+	for _, dnet := range dnets {
+		if dnet.TenantName == "unit-test" && dnet.Default {
+			numDefaults++
+		}
+	}
+	if numDefaults != 1 {
+		t.Fatalf("Found %d default networks for tenant", numDefaults)
+	}
+
+	// other tenants are not affected
+	dnet, err = GetDefaultDockNet("other")
+	if err != nil || dnet.NetworkName != "net1" {
+		t.Fatalf("Unexpected default network %+v. Err: %v", dnet, err)
+	}
+}
+
+func TestDocknetCreationMetadata(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	start := time.Now().UTC()
+	opts := DockNetOptions{CreatedBy: "admin", Source: "netctl"}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	dnets, err := ListDockNets()
+	if err != nil || len(dnets) != 1 {
+		t.Fatalf("Error listing docknets %+v. Err: %v", dnets, err)
+	}
+	if dnets[0].CreatedBy != "admin" || dnets[0].Source != "netctl" {
+		t.Fatalf("Creation metadata was not persisted: %+v", dnets[0])
+	}
+	if dnets[0].CreatedAt.Before(start.Add(-time.Second)) || dnets[0].CreatedAt.After(time.Now().UTC()) {
+		t.Fatalf("CreatedAt was not populated: %v", dnets[0].CreatedAt)
+	}
+}
+
+func TestDocknetCreatePolicy(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetCreatePolicy(AutoCreate)
+
+	// auto-create makes the docker network
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", "")); err != nil {
+		t.Fatalf("docker network was not created. Err: %v", err)
+	}
+
+	SetCreatePolicy(RequirePreexisting)
+
+	// missing docker network is an error
+	err = CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"))
+	if err != ErrDockerNetworkMissing {
+		t.Fatalf("Expected ErrDockerNetworkMissing, got: %v", err)
+	}
+	if len(docker.networks) != 1 || getDocknetState("unit-test", "net2", "") != nil {
+		t.Fatalf("network was created with RequirePreexisting policy")
+	}
+
+	// pre-created docker network is recorded
+	resp, _ := docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   GetDocknetName("unit-test", "net3", ""),
+		Driver: getConfig().netDriverName,
+	})
+	err = CreateDockNet("unit-test", "net3", "", fakeNwCfg("unit-test", "net3"))
+	if err != nil {
+		t.Fatalf("Error adopting pre-created network. Err: %v", err)
+	}
+	dnetOper := getDocknetState("unit-test", "net3", "")
+	if dnetOper == nil || dnetOper.DocknetUUID != resp.ID {
+		t.Fatalf("Pre-created network was not recorded: %+v", dnetOper)
+	}
+}
+
+func TestDocknetEphemeral(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	opts := DockNetOptions{Ephemeral: true}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil {
+		t.Fatalf("Error creating ephemeral network. Err: %v", err)
+	}
+
+	docknetName := GetDocknetName("unit-test", "net1", "")
+	nw, err := docker.InspectNetwork(docknetName)
+	if err != nil || nw.Labels[ephemeralLabel] != "true" {
+		t.Fatalf("ephemeral docker network was not created: %+v. Err: %v", nw, err)
+	}
+	if getDocknetState("unit-test", "net1", "") != nil {
+		t.Fatalf("oper state was written for ephemeral network")
+	}
+	if dnets, _ := ListDockNets(); len(dnets) != 0 {
+		t.Fatalf("ephemeral network was listed: %+v", dnets)
+	}
+
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting ephemeral network. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(docknetName); err == nil {
+		t.Fatalf("ephemeral docker network was not deleted")
+	}
+}
+
+func TestDeleteDockNetCascade(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	dnets := []struct{ tenant, network, service string }{
+		{"unit-test", "web", ""},
+		{"unit-test", "web", "lb"},
+		{"unit-test", "web", "db"},
+		{"unit-test", "web2", "lb2"},
+		{"other", "web", "lb3"},
+	}
+	for _, dnet := range dnets {
+		err := CreateDockNet(dnet.tenant, dnet.network, dnet.service, fakeNwCfg(dnet.tenant, dnet.network))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+
+	if err := DeleteDockNetCascade("unit-test", "web"); err != nil {
+		t.Fatalf("Error deleting networks. Err: %v", err)
+	}
+
+	for i, dnet := range dnets {
+		_, err := docker.InspectNetwork(GetDocknetName(dnet.tenant, dnet.network, dnet.service))
+		operState := getDocknetState(dnet.tenant, dnet.network, dnet.service)
+		if i < 3 && (err == nil || operState != nil) {
+			t.Fatalf("network %+v was not deleted", dnet)
+		}
+		if i >= 3 && (err != nil || operState == nil) {
+			t.Fatalf("unrelated network %+v was deleted", dnet)
+		}
+	}
+
+	// deleting again is not an error
+	if err := DeleteDockNetCascade("unit-test", "web"); err != nil {
+		t.Fatalf("Error deleting deleted networks. Err: %v", err)
+	}
+}
+
+func TestFindByPktTag(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.PktTag = 100
+	CreateDockNet("unit-test", "net1", "", nwCfg)
+	CreateDockNet("unit-test", "net1", "epg1", nwCfg)
+
+	nwCfg = fakeNwCfg("unit-test", "net2")
+	nwCfg.PktTag = 200
+	CreateDockNet("unit-test", "net2", "", nwCfg)
+
+	// vxlan uses the external tag
+	nwCfg = fakeNwCfg("unit-test", "net3")
+	nwCfg.PktTagType = "vxlan"
+	nwCfg.PktTag = 1
+	nwCfg.ExtPktTag = 200
+	CreateDockNet("unit-test", "net3", "", nwCfg)
+
+	tagTests := []struct {
+		encap string
+		tag   int
+		ids   []string
+	}{
+		{"vlan", 100, []string{"unit-test.net1.", "unit-test.net1.epg1"}},
+		{"vlan", 200, []string{"unit-test.net2."}},
+		{"vxlan", 200, []string{"unit-test.net3."}},
+		{"vlan", 300, []string{}},
+	}
+	for _, tt := range tagTests {
+		dnets, err := FindByPktTag(tt.encap, tt.tag)
+		if err != nil {
+			t.Fatalf("Error finding networks by tag. Err: %v", err)
+		}
+		ids := []string{}
+		for _, dnet := range dnets {
+			ids = append(ids, dnet.ID)
+		}
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Fatalf("%s/%d: got networks %v, expected %v", tt.encap, tt.tag, ids, tt.ids)
+		}
+	}
+}
+
+func TestRecreateDockNet(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if err := RecreateDockNet("unit-test", "net1", ""); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	docknetName := GetDocknetName("unit-test", "net1", "")
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.IPv6Subnet = "2016:430::"
+	nwCfg.IPv6SubnetLen = 64
+	nwCfg.IPv6Gateway = "2016:430::1"
+	err := CreateDockNet("unit-test", "net1", "", nwCfg)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	origNw, _ := docker.InspectNetwork(docknetName)
+	origNwCopy := *origNw
+
+	// network disappears from docker
+	docker.RemoveNetwork(docknetName)
+
+	if err := RecreateDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error recreating network. Err: %v", err)
+	}
+	nw, err := docker.InspectNetwork(docknetName)
+	if err != nil {
+		t.Fatalf("network was not recreated. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "net1", "").DocknetUUID != nw.ID {
+		t.Fatalf("oper state was not updated with the new network ID")
+	}
+	if !reflect.DeepEqual(nw.IPAM, origNwCopy.IPAM) || !reflect.DeepEqual(nw.Options, origNwCopy.Options) ||
+		nw.Driver != origNwCopy.Driver {
+		t.Fatalf("recreated network %+v does not match original %+v", nw, origNwCopy)
+	}
+}
+
+func TestDnetOperStateSchemaVersions(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	stateDriver, _ := utils.GetStateDriver()
+
+	versionTests := []struct {
+		blob    string
+		version int
+		encap   string
+		pktTag  int
+		subnets int
+	}{
+		// v1
+		{`{"id":"t1.net1.","tenantName":"t1","networkName":"net1","serviceName":"","docknetUUID":"uuid1"}`,
+			1, "", 0, 0},
+		// v2
+		{`{"id":"t1.net1.","tenantName":"t1","networkName":"net1","serviceName":"","docknetUUID":"uuid1",` +
+			`"encap":"vlan","pktTag":10,"adminState":"up"}`,
+			2, "vlan", 10, 0},
+		// v3
+		{`{"id":"t1.net1.","schemaVersion":3,"tenantName":"t1","networkName":"net1","serviceName":"",` +
+			`"docknetUUID":"uuid1","adminState":"up","subnets":[{"subnet":"10.1.1.0/24"}],` +
+			`"options":{"encap":"vxlan","pkt-tag":"5000"}}`,
+			3, "vxlan", 5000, 1},
+		// a newer version with unknown fields
+		{`{"id":"t1.net1.","schemaVersion":4,"tenantName":"t1","networkName":"net1","serviceName":"",` +
+			`"docknetUUID":"uuid1","encap":"vlan","pktTag":10,"adminState":"up","newField":{"a":1}}`,
+			4, "vlan", 10, 0},
+	}
+
+	for _, vt := range versionTests {
+		if err := stateDriver.Write(docknetOperKey("t1.net1."), []byte(vt.blob)); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+
+		dnetOper := DnetOperState{}
+		dnetOper.StateDriver = stateDriver
+		if err := dnetOper.Read("t1.net1."); err != nil {
+			t.Fatalf("Error reading version %d state. Err: %v", vt.version, err)
+		}
+		if dnetOper.SchemaVersion != vt.version || dnetOper.TenantName != "t1" ||
+			dnetOper.DocknetUUID != "uuid1" || dnetOper.AdminState != "up" ||
+			dnetOper.Encap != vt.encap || dnetOper.PktTag != vt.pktTag || len(dnetOper.Subnets) != vt.subnets {
+			t.Fatalf("Unexpected state %+v read from version %d", dnetOper, vt.version)
+		}
+
+		dnets, err := ListDockNets()
+		if err != nil || len(dnets) != 1 || dnets[0].AdminState != "up" {
+			t.Fatalf("Error listing version %d state %+v. Err: %v", vt.version, dnets, err)
+		}
+
+		// writing upgrades older records to the current version
+		if err := dnetOper.Write(); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+		dnetOper.Read("t1.net1.")
+		if vt.version <= dnetOperSchemaVersion && dnetOper.SchemaVersion != dnetOperSchemaVersion {
+			t.Fatalf("Version %d state was not upgraded on write: %+v", vt.version, dnetOper)
+		}
+	}
+}
+
+func TestDocknetManagedLabels(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	origVersion := contivVersion
+	contivVersion = "1.2.3"
+	defer func() { contivVersion = origVersion }()
+
+	checkLabels := func(docknetName, ver string) {
+		nw, err := docker.InspectNetwork(docknetName)
+		if err != nil {
+			t.Fatalf("Error inspecting network %s. Err: %v", docknetName, err)
+		}
+		if nw.Labels[managedLabel] != "true" || nw.Labels[versionLabel] != ver {
+			t.Fatalf("network %s is missing the managed labels: %+v", docknetName, nw.Labels)
+		}
+	}
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	checkLabels(GetDocknetName("unit-test", "net1", ""), "1.2.3")
+
+	opts := DockNetOptions{Ephemeral: true}
+	err = CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	checkLabels(GetDocknetName("unit-test", "net2", ""), "1.2.3")
+
+	// recreated networks are labeled with the current version
+	docker.RemoveNetwork(GetDocknetName("unit-test", "net1", ""))
+	contivVersion = "1.2.4"
+	if err := RecreateDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error recreating network. Err: %v", err)
+	}
+	checkLabels(GetDocknetName("unit-test", "net1", ""), "1.2.4")
+}
+
+func TestDeleteDockNetIdempotent(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting deleted network. Err: %v", err)
+	}
+
+	// oper state without a docker network is cleared
+	err = CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	docker.RemoveNetwork(GetDocknetName("unit-test", "net1", ""))
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "net1", "") != nil {
+		t.Fatalf("oper state was not cleared")
+	}
+
+	// a network removed before docker got the delete is deleted, with the
+	// not found message docker sends
+	err = CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	docker.removeErr = errors.New(`{"message":"network net1/unit-test not found"}`)
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting removed network. Err: %v", err)
+	}
+	docker.removeErr = nil
+	if getDocknetState("unit-test", "net1", "") != nil {
+		t.Fatalf("oper state of the removed network was not cleared")
+	}
+
+	// real failures are reported
+	err = CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	docker.removeErr = errors.New("network has active endpoints")
+	if err := DeleteDockNet("unit-test", "net1", ""); err != docker.removeErr {
+		t.Fatalf("Remove failure was not reported. Err: %v", err)
+	}
+	if getDocknetState("unit-test", "net1", "") == nil {
+		t.Fatalf("oper state was cleared after a failed delete")
+	}
+}
+
+func TestDocknetExternalConnectivity(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	origLookup := lookupInterface
+	defer func() { lookupInterface = origLookup }()
+	lookupInterface = func(name string) error {
+		if name != "eth2" {
+			return errors.New("no such network interface")
+		}
+		return nil
+	}
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	for _, uplink := range []string{"", "eth3"} {
+		opts := DockNetOptions{ExternalConnectivity: true, Uplink: uplink}
+		err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts)
+		if err != ErrUplinkMissing {
+			t.Fatalf("Missing uplink %q was accepted. Err: %v", uplink, err)
+		}
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", "")); err == nil {
+		t.Fatalf("docker network was created without an uplink")
+	}
+
+	opts := DockNetOptions{ExternalConnectivity: true, Uplink: "eth2"}
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	dnet := getDocknetState("unit-test", "net1", "")
+	if dnet == nil || dnet.Options["external"] != "true" || dnet.Options["uplink"] != "eth2" {
+		t.Fatalf("external connectivity was not saved: %+v", dnet)
+	}
+}
+
+func TestDocknetOptionsOverride(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	opts := DockNetOptions{OptionsOverride: map[string]string{"encap": "vxlan", "pkt-tag": "5000", "mtu": "9000"}}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	nw, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if err != nil || nw.Options["encap"] != "vxlan" || nw.Options["pkt-tag"] != "5000" ||
+		nw.Options["mtu"] != "9000" || nw.Options["tenant"] != "unit-test" {
+		t.Fatalf("Options were not overridden: %+v. Err: %v", nw, err)
+	}
+	dnet := getDocknetState("unit-test", "net1", "")
+	if dnet == nil || dnet.Encap != "vxlan" || dnet.PktTag != 5000 {
+		t.Fatalf("oper state does not match the docker network: %+v", dnet)
+	}
+}
+
+func TestDocknetMTUAndDriverOptions(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.MTU = 9000
+	nwCfg.DriverOptions = map[string]string{"vxlan-port": "4790", "mtu": "1500"}
+	if err := CreateDockNet("unit-test", "net1", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// the MTU field wins over the mtu driver option
+	nw, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if err != nil || nw.Options["mtu"] != "9000" || nw.Options["vxlan-port"] != "4790" || nw.Options["encap"] != "vlan" {
+		t.Fatalf("Driver options were not passed: %+v. Err: %v", nw, err)
+	}
+	if dnet := getDocknetState("unit-test", "net1", ""); dnet == nil || dnet.Options["mtu"] != "9000" {
+		t.Fatalf("MTU not saved in oper state: %+v", dnet)
+	}
+
+	// options override the network config
+	opts := DockNetOptions{OptionsOverride: map[string]string{"vxlan-port": "4789"}}
+	nwCfg = fakeNwCfg("unit-test", "net2")
+	nwCfg.DriverOptions = map[string]string{"vxlan-port": "4790"}
+	if err := CreateDockNetWithOptions("unit-test", "net2", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); nw.Options["vxlan-port"] != "4789" {
+		t.Fatalf("Driver option was not overridden: %+v", nw.Options)
+	}
+
+	for _, mtu := range []int{-1, 67, 65536} {
+		nwCfg = fakeNwCfg("unit-test", "bad")
+		nwCfg.MTU = mtu
+		if err := CreateDockNet("unit-test", "bad", "", nwCfg); err != ErrInvalidMTU {
+			t.Fatalf("Expected ErrInvalidMTU for MTU %d, got: %v", mtu, err)
+		}
+	}
+
+	nwCfg = fakeNwCfg("unit-test", "bad")
+	nwCfg.DriverOptions = map[string]string{"pkt-tag": "20"}
+	if err := CreateDockNet("unit-test", "bad", "", nwCfg); err != ErrReservedDriverOption {
+		t.Fatalf("Expected ErrReservedDriverOption, got: %v", err)
+	}
+}
+
+func TestDocknetInternal(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.Internal = true
+	if err := CreateDockNet("unit-test", "net1", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if !docker.lastCreate.Internal || !getDocknetState("unit-test", "net1", "").Internal {
+		t.Fatalf("Network was not created internal")
+	}
+
+	// docker does not return the flag, recreated networks stay internal
+	if err := SetDockNetAdminState("unit-test", "net1", "", false); err != nil {
+		t.Fatalf("Error setting admin state. Err: %v", err)
+	}
+	if !docker.lastCreate.Internal {
+		t.Fatalf("Recreated network is not internal")
+	}
+	docker.RemoveNetwork(GetDocknetName("unit-test", "net1", ""))
+	if err := RecreateDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error recreating network. Err: %v", err)
+	}
+	if !docker.lastCreate.Internal {
+		t.Fatalf("Recreated network is not internal")
+	}
+}
+
+func TestDocknetIPv6Only(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net6")
+	nwCfg.SubnetIP = ""
+	nwCfg.SubnetLen = 0
+	nwCfg.Gateway = ""
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	nwCfg.IPv6Gateway = "2001:db8::1"
+	if err := CreateDockNet("unit-test", "net6", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net6", ""))
+	exp := []dockerclient.IPAMConfig{{Subnet: "2001:db8::/64", Gateway: "2001:db8::1"}}
+	if !reflect.DeepEqual(nw.IPAM.Config, exp) || nw.Options[netlabel.EnableIPv6] != "true" {
+		t.Fatalf("Unexpected IPv6 only network %+v", nw)
+	}
+
+	// IPv4 networks do not enable IPv6
+	if err := CreateDockNet("unit-test", "net4", "", fakeNwCfg("unit-test", "net4")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ = docker.InspectNetwork(GetDocknetName("unit-test", "net4", ""))
+	if _, ok := nw.Options[netlabel.EnableIPv6]; ok {
+		t.Fatalf("IPv6 enabled on an IPv4 network")
+	}
+
+	nwCfg = fakeNwCfg("unit-test", "bad")
+	nwCfg.SubnetIP = ""
+	nwCfg.SubnetLen = 0
+	if err := CreateDockNet("unit-test", "bad", "", nwCfg); err != ErrGatewayOutsideSubnet {
+		t.Fatalf("Expected ErrGatewayOutsideSubnet, got: %v", err)
+	}
+	nwCfg.Gateway = ""
+	if err := CreateDockNet("unit-test", "bad", "", nwCfg); err != ErrNoSubnet {
+		t.Fatalf("Expected ErrNoSubnet, got: %v", err)
+	}
+}
+
+func TestDocknetFabricCapabilityCheck(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer RegisterFabricCapabilityCheck(nil)
+
+	errVlanOnly := errors.New("fabric supports vlan only")
+	RegisterFabricCapabilityCheck(func(encap string) error {
+		if encap != "vlan" {
+			return errVlanOnly
+		}
+		return nil
+	})
+
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating vlan network. Err: %v", err)
+	}
+
+	nwCfg := fakeNwCfg("unit-test", "net2")
+	nwCfg.PktTagType = "vxlan"
+	nwCfg.ExtPktTag = 5000
+	if err := CreateDockNet("unit-test", "net2", "", nwCfg); err != errVlanOnly {
+		t.Fatalf("vxlan network was not rejected. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); err == nil {
+		t.Fatalf("docker network was created for a rejected encap")
+	}
+}
+
+// prefixStateDriver is a fake state driver supporting key prefix checks
+type prefixStateDriver struct {
+	state.FakeStateDriver
+	prefixChecks int
+}
+
+func (d *prefixStateDriver) KeyPrefixExists(prefix string) (bool, error) {
+	d.prefixChecks++
+	for key := range d.TestState {
+		if strings.HasPrefix(key, prefix) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestTenantHasDockNets(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetReadStateDriver(nil)
+
+	if err := CreateDockNet("blue", "net1", "", fakeNwCfg("blue", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// full read
+	for tenant, want := range map[string]bool{"blue": true, "bl": false, "red": false} {
+		if has, err := TenantHasDockNets(tenant); err != nil || has != want {
+			t.Fatalf("Tenant %s has docknets %v, expected %v. Err: %v", tenant, has, want, err)
+		}
+	}
+
+	// prefix check
+	stateDriver, _ := utils.GetStateDriver()
+	prefixDriver := &prefixStateDriver{}
+	prefixDriver.Init(&core.InstanceInfo{})
+	prefixDriver.TestState = stateDriver.(*state.FakeStateDriver).TestState
+	SetReadStateDriver(prefixDriver)
+	for tenant, want := range map[string]bool{"blue": true, "bl": false, "red": false} {
+		if has, err := TenantHasDockNets(tenant); err != nil || has != want {
+			t.Fatalf("Tenant %s has docknets %v, expected %v. Err: %v", tenant, has, want, err)
+		}
+	}
+	if prefixDriver.prefixChecks != 3 {
+		t.Fatalf("Prefix check was used %d times", prefixDriver.prefixChecks)
+	}
+}
+
+func TestCopyLegacyOperStates(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	stateDriver, _ := utils.GetStateDriver()
+	blob := `{"id":"blue.net1.","tenantName":"blue","networkName":"net1","serviceName":"","docknetUUID":"uuid1"}`
+	if err := stateDriver.Write(legacyDocknetOperPrefix+"blue.net1.", []byte(blob)); err != nil {
+		t.Fatalf("Error writing state. Err: %v", err)
+	}
+
+	count, err := CopyLegacyOperStates()
+	if err != nil || count != 1 {
+		t.Fatalf("Expected one copied state, got %d. Err: %v", count, err)
+	}
+	if _, err := stateDriver.Read(legacyDocknetOperPrefix + "blue.net1."); err != nil {
+		t.Fatalf("Legacy oper state was cleared. Err: %v", err)
+	}
+	if dnet := getDocknetState("blue", "net1", ""); dnet == nil || dnet.DocknetUUID != "uuid1" {
+		t.Fatalf("Copied oper state was not found, got %+v", dnet)
+	}
+	if has, err := TenantHasDockNets("blue"); err != nil || !has {
+		t.Fatalf("Tenant has no docknets after the copy. Err: %v", err)
+	}
+
+	if count, err := CopyLegacyOperStates(); err != nil || count != 0 {
+		t.Fatalf("Expected nothing to copy, got %d. Err: %v", count, err)
+	}
+}
+
+func TestLegacyOperStateWrites(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	stateDriver, _ := utils.GetStateDriver()
+	dnet := &DnetOperState{TenantName: "blue", NetworkName: "net1", DocknetUUID: "uuid1"}
+	dnet.ID = docknetOperID("blue", "net1", "")
+	dnet.StateDriver = stateDriver
+	if err := dnet.Write(); err != nil {
+		t.Fatalf("Error writing docknet. Err: %v", err)
+	}
+
+	// older netplugins still find the docknet by UUID
+	legacy := DnetOperState{}
+	legacy.StateDriver = stateDriver
+	if err := stateDriver.ReadState(legacyDocknetOperPrefix+dnet.ID, &legacy, unmarshalDnetOper); err != nil ||
+		legacy.DocknetUUID != "uuid1" {
+		t.Fatalf("Legacy oper state was not written, got %+v. Err: %v", legacy, err)
+	}
+
+	if err := dnet.Clear(); err != nil {
+		t.Fatalf("Error clearing docknet. Err: %v", err)
+	}
+	if _, err := stateDriver.Read(legacyDocknetOperPrefix + dnet.ID); err == nil {
+		t.Fatalf("Legacy oper state was not cleared")
+	}
+}
+
+func TestDocknetAnnotations(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	opts := DockNetOptions{Annotations: []byte(`{ "epg": "web", "policy": ["p1", "p2"] }`)}
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	compact := `{"epg":"web","policy":["p1","p2"]}`
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if nw.Options[annotationsOption] != compact {
+		t.Fatalf("Unexpected annotations option %q", nw.Options[annotationsOption])
+	}
+	if dnet := getDocknetState("unit-test", "net1", ""); string(dnet.Annotations) != compact {
+		t.Fatalf("Unexpected annotations in oper state %q", dnet.Annotations)
+	}
+
+	// not a JSON object
+	for _, annotations := range []string{`{"epg":`, `["web"]`, `null`} {
+		opts.Annotations = []byte(annotations)
+		err := CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+		if err != ErrInvalidAnnotations {
+			t.Fatalf("Expected ErrInvalidAnnotations for %s, got: %v", annotations, err)
+		}
+	}
+
+	// oversized
+	opts.Annotations = []byte(`{"epg":"` + strings.Repeat("x", maxAnnotationsSize) + `"}`)
+	err := CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+	if err != ErrAnnotationsTooLarge {
+		t.Fatalf("Expected ErrAnnotationsTooLarge, got: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); err == nil {
+		t.Fatalf("Network with invalid annotations was created")
+	}
+}
+
+func TestComputeNetworkCreate(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	opts := DockNetOptions{
+		IPv6GatewayMode: IPv6GatewayAddr1,
+		Annotations:     []byte(`{"epg":"web"}`),
+	}
+
+	nwCreate, err := ComputeNetworkCreate("unit-test", "net1", "", nwCfg, opts)
+	if err != nil {
+		t.Fatalf("Error computing network parameters. Err: %v", err)
+	}
+	if len(docker.networks) != 0 || getDocknetState("unit-test", "net1", "") != nil {
+		t.Fatalf("Computing network parameters created the network")
+	}
+
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if nwCreate.Name != nw.Name || nwCreate.Driver != nw.Driver || !reflect.DeepEqual(nwCreate.IPAM, nw.IPAM) ||
+		!reflect.DeepEqual(nwCreate.Options, nw.Options) || !reflect.DeepEqual(nwCreate.Labels, nw.Labels) {
+		t.Fatalf("Computed %+v, created %+v", nwCreate, nw)
+	}
+
+	// invalid networks fail the same validation
+	nwCfg.Gateway = "10.9.9.9"
+	if _, err := ComputeNetworkCreate("unit-test", "net2", "", nwCfg, DockNetOptions{}); err != ErrGatewayOutsideSubnet {
+		t.Fatalf("Expected ErrGatewayOutsideSubnet, got: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DrainDockNet disconnects all containers from a docknet, eg. before
+// maintenance, keeping the docker network and the oper state. Containers are
+// force disconnected if force is set. It returns the disconnected containers,
+// sorted by ID. Containers that could not be disconnected are named in the
+// error, and the others are still disconnected.
+func DrainDockNet(tenantName, networkName, serviceName string, force bool) ([]string, error) {
+	drained := []string{}
+
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return drained, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return drained, errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnetOper.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", dnetOper.DocknetName(), err)
+		return drained, err
+	}
+
+	logInfof("Draining %d containers from docker network %s", len(nw.Containers), nw.Name)
+
+	errs := []string{}
+	for ctrID := range nw.Containers {
+		err := docker.DisconnectNetwork(nw.ID, ctrID, force)
+		if err != nil {
+			log.Errorf("Error disconnecting %s from network %s. Err: %v", ctrID, nw.Name, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", ctrID, err))
+			continue
+		}
+		drained = append(drained, ctrID)
+	}
+	sort.Strings(drained)
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return drained, fmt.Errorf("error draining network %s: %s", nw.Name, strings.Join(errs, "; "))
+	}
+
+	return drained, nil
+}
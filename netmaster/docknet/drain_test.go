@@ -0,0 +1,76 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDrainDockNet(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if _, err := DrainDockNet("unit-test", "net1", "", false); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	for _, netName := range []string{"net1", "net2"} {
+		if err := CreateDockNet("unit-test", netName, "", fakeNwCfg("unit-test", netName)); err != nil {
+			t.Fatalf("Error creating network %s. Err: %v", netName, err)
+		}
+	}
+	net1 := GetDocknetName("unit-test", "net1", "")
+	net2 := GetDocknetName("unit-test", "net2", "")
+	docker.addContainer("c2", net1, net2)
+	docker.addContainer("c1", net1)
+	docker.addContainer("c3", net1)
+
+	drained, err := DrainDockNet("unit-test", "net1", "", true)
+	if err != nil {
+		t.Fatalf("Error draining network. Err: %v", err)
+	}
+	if !reflect.DeepEqual(drained, []string{"c1", "c2", "c3"}) {
+		t.Fatalf("Unexpected drained containers %v", drained)
+	}
+
+	// the network and oper state are kept, other networks are not drained
+	nw, err := docker.InspectNetwork(net1)
+	if err != nil || len(nw.Containers) != 0 {
+		t.Fatalf("Network not drained: %+v. Err: %v", nw, err)
+	}
+	if getDocknetState("unit-test", "net1", "") == nil {
+		t.Fatalf("Oper state of the drained network was cleared")
+	}
+	if nw, _ := docker.InspectNetwork(net2); len(nw.Containers) != 1 {
+		t.Fatalf("Other network was drained: %+v", nw)
+	}
+
+	// draining an empty network
+	drained, err = DrainDockNet("unit-test", "net1", "", false)
+	if err != nil || len(drained) != 0 {
+		t.Fatalf("Unexpected drain of an empty network %v. Err: %v", drained, err)
+	}
+
+	// failed disconnects are reported
+	docker.disconnectErr = errors.New("endpoint is busy")
+	drained, err = DrainDockNet("unit-test", "net2", "", false)
+	docker.disconnectErr = nil
+	if err == nil || len(drained) != 0 {
+		t.Fatalf("Expected a drain error, got %v. Err: %v", drained, err)
+	}
+}
@@ -0,0 +1,147 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ConfigField is a docknet field as recorded in oper state and as reported
+// by docker
+type ConfigField struct {
+	Name        string `json:"name"`
+	OperValue   string `json:"operValue"`
+	DockerValue string `json:"dockerValue"`
+	Differs     bool   `json:"differs"`
+}
+
+// EffectiveConfig merges the oper state of a docknet with its docker network
+type EffectiveConfig struct {
+	DocknetName   string        `json:"docknetName"`
+	OperState     DnetOperState `json:"operState"`
+	DockerMissing bool          `json:"dockerMissing"`
+	Fields        []ConfigField `json:"fields"`
+	Drifted       bool          `json:"drifted"`
+}
+
+// GetEffectiveConfig returns the docknet config recorded in oper state and the
+// docker network config, flagging the fields that differ
+func GetEffectiveConfig(tenantName, networkName, serviceName string) (EffectiveConfig, error) {
+	cfg := EffectiveConfig{}
+
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.DocknetName = dnetOper.DocknetName()
+	cfg.OperState = *dnetOper
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return cfg, errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(cfg.DocknetName)
+	if isNotFound(err) {
+		cfg.DockerMissing = true
+		cfg.Drifted = true
+		nw = &dockerclient.NetworkResource{}
+	} else if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", cfg.DocknetName, err)
+		return cfg, err
+	}
+
+	operSubnets := []string{}
+	operGateways := []string{}
+	for _, pool := range dnetOper.Subnets {
+		operSubnets = append(operSubnets, pool.Subnet)
+		operGateways = append(operGateways, pool.Gateway)
+	}
+	dockerSubnets := []string{}
+	dockerGateways := []string{}
+	for _, ipam := range nw.IPAM.Config {
+		dockerSubnets = append(dockerSubnets, ipam.Subnet)
+		dockerGateways = append(dockerGateways, ipam.Gateway)
+	}
+
+	cfg.addField("uuid", dnetOper.DocknetUUID, nw.ID)
+	cfg.addField("subnets", strings.Join(operSubnets, ","), strings.Join(dockerSubnets, ","))
+	cfg.addField("gateways", strings.Join(operGateways, ","), strings.Join(dockerGateways, ","))
+	cfg.addField("encap", dnetOper.Encap, nw.Options["encap"])
+	cfg.addField("pkt-tag", strconv.Itoa(dnetOper.PktTag), nw.Options["pkt-tag"])
+	cfg.addField("admin-state", dnetOper.AdminState, dockerAdminState(nw))
+
+	return cfg, nil
+}
+
+// addField adds a field to the config, flagging it if the values differ
+func (c *EffectiveConfig) addField(name, operValue, dockerValue string) {
+	field := ConfigField{
+		Name:        name,
+		OperValue:   operValue,
+		DockerValue: dockerValue,
+		Differs:     operValue != dockerValue,
+	}
+	c.Fields = append(c.Fields, field)
+	c.Drifted = c.Drifted || field.Differs
+}
+
+// dockerAdminState returns the admin state of a docker network
+func dockerAdminState(nw *dockerclient.NetworkResource) string {
+	if nw.ID == "" {
+		return ""
+	}
+	if nw.Options["admin-state"] == adminStateDown {
+		return adminStateDown
+	}
+
+	return adminStateUp
+}
+
+// VerifyDockNet returns an error naming the fields of a docknet that differ
+// between oper state and docker
+func VerifyDockNet(tenantName, networkName, serviceName string) error {
+	cfg, err := GetEffectiveConfig(tenantName, networkName, serviceName)
+	if err != nil {
+		return err
+	}
+	if cfg.DockerMissing {
+		return fmt.Errorf("docker network %s does not exist", cfg.DocknetName)
+	}
+
+	drifted := []string{}
+	for _, field := range cfg.Fields {
+		if field.Differs {
+			drifted = append(drifted, fmt.Sprintf("%s (oper: %q, docker: %q)",
+				field.Name, field.OperValue, field.DockerValue))
+		}
+	}
+	if len(drifted) > 0 {
+		return fmt.Errorf("docker network %s differs from oper state: %s",
+			cfg.DocknetName, strings.Join(drifted, ", "))
+	}
+
+	return nil
+}
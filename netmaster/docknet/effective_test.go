@@ -0,0 +1,66 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if _, err := GetEffectiveConfig("unit-test", "net1", ""); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// consistent
+	cfg, err := GetEffectiveConfig("unit-test", "net1", "")
+	if err != nil || cfg.Drifted || cfg.DockerMissing || len(cfg.Fields) == 0 {
+		t.Fatalf("Unexpected effective config %+v. Err: %v", cfg, err)
+	}
+	if err := VerifyDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error verifying network. Err: %v", err)
+	}
+
+	// drifted encap
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	nw.Options["encap"] = "vxlan"
+	cfg, err = GetEffectiveConfig("unit-test", "net1", "")
+	if err != nil || !cfg.Drifted {
+		t.Fatalf("Drift was not detected in %+v. Err: %v", cfg, err)
+	}
+	for _, field := range cfg.Fields {
+		if field.Differs != (field.Name == "encap") {
+			t.Fatalf("Unexpected field %+v", field)
+		}
+	}
+	if err := VerifyDockNet("unit-test", "net1", ""); err == nil {
+		t.Fatalf("Drifted network was verified")
+	}
+
+	// missing in docker
+	docker.RemoveNetwork(nw.ID)
+	cfg, err = GetEffectiveConfig("unit-test", "net1", "")
+	if err != nil || !cfg.DockerMissing || !cfg.Drifted {
+		t.Fatalf("Missing docker network was not flagged %+v. Err: %v", cfg, err)
+	}
+}
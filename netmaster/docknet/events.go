@@ -0,0 +1,283 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
+	"github.com/contiv/netplugin/utils"
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// RemovedPolicy selects what the docker event watcher does with a docknet
+// whose docker network is removed outside of netmaster. The zero value is
+// rejected.
+type RemovedPolicy int
+
+const (
+	// RemovedReport only logs the removal, the oper state is kept for the
+	// reconcile loop or the admin to repair
+	RemovedReport RemovedPolicy = iota + 1
+	// RemovedForget clears the oper state of the docknet
+	RemovedForget
+	// RemovedRecreate recreates the docker network from the oper state
+	RemovedRecreate
+)
+
+// ErrInvalidRemovedPolicy is returned for an unknown removed network policy
+var ErrInvalidRemovedPolicy = errors.New("invalid removed network policy")
+
+func (p RemovedPolicy) String() string {
+	switch p {
+	case RemovedReport:
+		return "report"
+	case RemovedForget:
+		return "forget"
+	case RemovedRecreate:
+		return "recreate"
+	}
+
+	return fmt.Sprintf("RemovedPolicy(%d)", int(p))
+}
+
+// ParseRemovedPolicy returns the removed network policy named by s, as
+// returned by String
+func ParseRemovedPolicy(s string) (RemovedPolicy, error) {
+	for _, policy := range []RemovedPolicy{RemovedReport, RemovedForget, RemovedRecreate} {
+		if policy.String() == s {
+			return policy, nil
+		}
+	}
+
+	return 0, ErrInvalidRemovedPolicy
+}
+
+// eventRetryBackoff is the delay before reconnecting to the docker events.
+// It grows while docker can not be reached, and starts over once the event
+// stream was set up.
+var eventRetryBackoff Backoff = ExponentialBackoff{
+	Initial:    time.Second,
+	Max:        time.Minute,
+	Multiplier: 2,
+}
+
+var (
+	// removalMutex protects ownRemovals, the docker networks netmaster is
+	// removing, whose events are not out of band
+	removalMutex sync.Mutex
+	ownRemovals  = make(map[string]int)
+)
+
+// expectRemoval marks a docker network as removed by netmaster until the
+// returned function is called
+func expectRemoval(docknetName string) func() {
+	removalMutex.Lock()
+	defer removalMutex.Unlock()
+	ownRemovals[docknetName]++
+
+	return func() {
+		removalMutex.Lock()
+		defer removalMutex.Unlock()
+		if ownRemovals[docknetName]--; ownRemovals[docknetName] == 0 {
+			delete(ownRemovals, docknetName)
+		}
+	}
+}
+
+// removalExpected returns true if netmaster is removing a docker network
+func removalExpected(docknetName string) bool {
+	removalMutex.Lock()
+	defer removalMutex.Unlock()
+	return ownRemovals[docknetName] > 0
+}
+
+// StartEventWatcher watches the docker events for networks of our driver
+// that are removed or replaced outside of netmaster. A replaced network is
+// adopted by its docknets unless the policy is RemovedReport, and removed
+// networks are handled per the policy. The watcher reconnects to docker when
+// the event stream fails, waiting longer while docker can not be reached. The
+// returned function stops it.
+func StartEventWatcher(policy RemovedPolicy) (func(), error) {
+	if policy != RemovedReport && policy != RemovedForget && policy != RemovedRecreate {
+		return nil, ErrInvalidRemovedPolicy
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		failures := 0
+		for {
+			if watchEvents(policy, stop) {
+				failures = 0
+			}
+			failures++
+
+			delay := eventRetryBackoff.NextDelay(failures)
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			log.Infof("Reconnecting to the docker events")
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}, nil
+}
+
+// watchEvents handles the docker network events until the event stream
+// fails or the watcher is stopped. It returns false if the event stream could
+// not be set up.
+func watchEvents(policy RemovedPolicy, stop chan struct{}) bool {
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return false
+	}
+
+	events, err := docker.MonitorEvents(nil, stop)
+	if err != nil {
+		log.Errorf("Error watching docker events. Err: %v", err)
+		return false
+	}
+
+	for event := range events {
+		if event.Error != nil {
+			log.Errorf("Error reading docker events. Err: %v", event.Error)
+			return true
+		}
+		if event.Type != "network" || (event.Action != "destroy" && event.Action != "create") {
+			continue
+		}
+		if driver, ok := event.Actor.Attributes["type"]; ok && driver != getConfig().netDriverName {
+			continue
+		}
+
+		handleNetworkEvent(docker, event.Actor.Attributes["name"], policy)
+	}
+
+	return true
+}
+
+// handleNetworkEvent checks the docknets of a docker network after it was
+// created or destroyed
+func handleNetworkEvent(docker dockerclient.Client, docknetName string, policy RemovedPolicy) {
+	if docknetName == "" || removalExpected(docknetName) {
+		return
+	}
+
+	dnets, err := ListDockNets()
+	if err != nil {
+		log.Errorf("Error getting docknet list. Err: %v", err)
+		return
+	}
+	matched := []*DnetOperState{}
+	for _, dnet := range dnets {
+		if dnet.DocknetName() == docknetName {
+			matched = append(matched, dnet)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return
+	}
+	for _, dnet := range matched {
+		dnet.StateDriver = stateDriver
+	}
+
+	nw, err := docker.InspectNetwork(docknetName)
+	if err != nil && !isNotFound(err) {
+		log.Errorf("Error inspecting network %s. Err: %v", docknetName, err)
+		return
+	}
+
+	if err == nil {
+		if nw.Driver != getConfig().netDriverName {
+			log.Warnf("docker network %s was replaced by a %s network", docknetName, nw.Driver)
+			return
+		}
+		for _, dnet := range matched {
+			if dnet.DocknetUUID == nw.ID {
+				continue
+			}
+			log.Warnf("docker network %s of docknet %s was replaced outside of netmaster", docknetName, dnet.ID)
+			if policy == RemovedReport {
+				continue
+			}
+			if !labelsMatch(nw, dnet) {
+				log.Warnf("docker network %s is not labelled for docknet %s, not adopting it", docknetName, dnet.ID)
+				continue
+			}
+
+			// netmaster may update the docknet meanwhile
+			err := dnet.update(func() error {
+				if dnet.DocknetUUID == nw.ID {
+					return state.ErrUnchanged
+				}
+				dnet.DocknetUUID = nw.ID
+				return nil
+			})
+			if core.ErrIfKeyExists(err) != nil {
+				log.Errorf("Error updating docknet %s. Err: %v", dnet.ID, err)
+			}
+		}
+		return
+	}
+
+	log.Warnf("docker network %s was removed outside of netmaster, %s", docknetName, policy)
+
+	switch policy {
+	case RemovedForget:
+		for _, dnet := range matched {
+			if err := dnet.Clear(); err != nil {
+				log.Errorf("Error clearing docknet %s. Err: %v", dnet.ID, err)
+			}
+		}
+
+	case RemovedRecreate:
+		// the docker network of a shared docknet belongs to the primary
+		for _, dnet := range matched {
+			if dnet.isSharedSecondary() {
+				continue
+			}
+			err := RecreateDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName)
+			if err != nil {
+				log.Errorf("Error recreating docknet %s. Err: %v", dnet.ID, err)
+				return
+			}
+		}
+		// the secondaries take the new network from the create event
+	}
+}
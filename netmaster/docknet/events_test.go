@@ -0,0 +1,146 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestParseRemovedPolicy(t *testing.T) {
+	for _, policy := range []RemovedPolicy{RemovedReport, RemovedForget, RemovedRecreate} {
+		parsed, err := ParseRemovedPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Fatalf("Error parsing %s, got %v. Err: %v", policy, parsed, err)
+		}
+	}
+	if _, err := ParseRemovedPolicy("delete"); err != ErrInvalidRemovedPolicy {
+		t.Fatalf("Expected ErrInvalidRemovedPolicy, got: %v", err)
+	}
+	if _, err := StartEventWatcher(0); err != ErrInvalidRemovedPolicy {
+		t.Fatalf("Expected ErrInvalidRemovedPolicy, got: %v", err)
+	}
+}
+
+func TestHandleNetworkEvent(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	docknetName := GetDocknetName("unit-test", "net1", "")
+	for _, policy := range []RemovedPolicy{RemovedReport, RemovedForget} {
+		if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+		docker.RemoveNetwork(docknetName)
+		handleNetworkEvent(docker, docknetName, policy)
+
+		dnet := getDocknetState("unit-test", "net1", "")
+		if policy == RemovedReport && dnet == nil {
+			t.Fatalf("docknet was cleared by %s", policy)
+		}
+		if policy == RemovedForget && dnet != nil {
+			t.Fatalf("docknet was not cleared by %s", policy)
+		}
+	}
+
+	// a replaced network is adopted, unless only reporting or it is not
+	// labelled for the docknet
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	oldID := getDocknetState("unit-test", "net1", "").DocknetUUID
+	docker.RemoveNetwork(docknetName)
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: docknetName, Driver: getConfig().netDriverName})
+	handleNetworkEvent(docker, docknetName, RemovedForget)
+	if uuid := getDocknetState("unit-test", "net1", "").DocknetUUID; uuid != oldID {
+		t.Fatalf("Unrelated network with the docknet name was adopted")
+	}
+	docker.RemoveNetwork(docknetName)
+	resp, _ := docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   docknetName,
+		Driver: getConfig().netDriverName,
+		Labels: map[string]string{tenantLabel: "unit-test", networkLabel: "net1"},
+	})
+
+	handleNetworkEvent(docker, docknetName, RemovedReport)
+	if uuid := getDocknetState("unit-test", "net1", "").DocknetUUID; uuid != oldID {
+		t.Fatalf("Replaced network was adopted by %s", RemovedReport)
+	}
+	handleNetworkEvent(docker, docknetName, RemovedForget)
+	if uuid := getDocknetState("unit-test", "net1", "").DocknetUUID; uuid != resp.ID {
+		t.Fatalf("Expected docknet of network %s, got %s", resp.ID, uuid)
+	}
+
+	// removals by netmaster are not out of band
+	release := expectRemoval(docknetName)
+	docker.RemoveNetwork(docknetName)
+	handleNetworkEvent(docker, docknetName, RemovedForget)
+	release()
+	if getDocknetState("unit-test", "net1", "") == nil {
+		t.Fatalf("docknet was cleared on an expected removal")
+	}
+}
+
+func TestEventWatcherRecreate(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	for _, netName := range []string{"net1", "net2"} {
+		if err := CreateDockNet("unit-test", netName, "", fakeNwCfg("unit-test", netName)); err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+
+	stop, err := StartEventWatcher(RemovedRecreate)
+	if err != nil {
+		t.Fatalf("Error starting event watcher. Err: %v", err)
+	}
+	defer stop()
+	for i := 0; !docker.monitored(); i++ {
+		if i == 100 {
+			t.Fatalf("Event watcher did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// net1 is removed outside of netmaster, net2 by netmaster
+	docknetName := GetDocknetName("unit-test", "net1", "")
+	oldID := getDocknetState("unit-test", "net1", "").DocknetUUID
+	docker.RemoveNetwork(docknetName)
+	if err := DeleteDockNet("unit-test", "net2", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+
+	for i := 0; ; i++ {
+		nw, err := docker.InspectNetwork(docknetName)
+		if err == nil && getDocknetState("unit-test", "net1", "").DocknetUUID == nw.ID {
+			if nw.ID == oldID {
+				t.Fatalf("Network was not recreated")
+			}
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Network was not recreated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); err == nil {
+		t.Fatalf("Network deleted by netmaster was recreated")
+	}
+}
@@ -0,0 +1,353 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/samalba/dockerclient"
+)
+
+// fakeDockerClient implements the network calls of dockerclient.Client
+// with an in-memory network table
+type fakeDockerClient struct {
+	dockerclient.Client
+	mutex      sync.Mutex
+	networks   map[string]*dockerclient.NetworkResource
+	containers map[string]*dockerclient.ContainerInfo
+	nextID     int
+
+	// allocGateway makes the fake driver program the gateway endpoint
+	allocGateway bool
+
+	// disconnectErr is returned by DisconnectNetwork when set
+	disconnectErr error
+
+	// removeErr is returned by RemoveNetwork when set
+	removeErr error
+
+	// createErr is returned by CreateNetwork when set
+	createErr error
+
+	// outOfBand has the networks removed without expectRemoval
+	outOfBand []string
+
+	// createFailures CreateNetwork calls fail with a server error, and the
+	// next lostCreates calls create the network but fail
+	createFailures int
+	lostCreates    int
+
+	// lastCreate is the last create request of a network
+	lastCreate dockerclient.NetworkCreate
+
+	// eventChans get the network events of MonitorEvents
+	eventChans map[chan dockerclient.EventOrError]bool
+}
+
+// notFound returns the error dockerclient returns for docker's 404 responses,
+// whose JSON body becomes the error message
+func notFound(format string, args ...interface{}) error {
+	return errors.New(fmt.Sprintf(`{"message":"`+format+`"}`, args...))
+}
+
+func newFakeDockerClient() *fakeDockerClient {
+	return &fakeDockerClient{
+		networks:   make(map[string]*dockerclient.NetworkResource),
+		containers: make(map[string]*dockerclient.ContainerInfo),
+		eventChans: make(map[chan dockerclient.EventOrError]bool),
+	}
+}
+
+// MonitorEvents returns the network create and destroy events until stopChan
+// is closed. Options are not supported.
+func (d *fakeDockerClient) MonitorEvents(options *dockerclient.MonitorEventsOptions,
+	stopChan <-chan struct{}) (<-chan dockerclient.EventOrError, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	events := make(chan dockerclient.EventOrError, 100)
+	d.eventChans[events] = true
+	go func() {
+		<-stopChan
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+		delete(d.eventChans, events)
+		close(events)
+	}()
+
+	return events, nil
+}
+
+// monitored returns true if the events are monitored
+func (d *fakeDockerClient) monitored() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return len(d.eventChans) > 0
+}
+
+// networkEvent sends a network event to the event monitors, the mutex must be
+// held
+func (d *fakeDockerClient) networkEvent(action string, nw *dockerclient.NetworkResource) {
+	event := dockerclient.EventOrError{
+		Event: dockerclient.Event{
+			Type:   "network",
+			Action: action,
+			Actor: dockerclient.Actor{
+				ID:         nw.ID,
+				Attributes: map[string]string{"name": nw.Name, "type": nw.Driver},
+			},
+		},
+	}
+	for events := range d.eventChans {
+		events <- event
+	}
+}
+
+func (d *fakeDockerClient) findNetwork(id string) *dockerclient.NetworkResource {
+	if nw, ok := d.networks[id]; ok {
+		return nw
+	}
+	for _, nw := range d.networks {
+		if nw.Name == id {
+			return nw
+		}
+	}
+
+	return nil
+}
+
+// InspectNetwork returns the network by name or ID
+func (d *fakeDockerClient) InspectNetwork(id string) (*dockerclient.NetworkResource, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	nw := d.findNetwork(id)
+	if nw == nil {
+		return nil, notFound("network %s not found", id)
+	}
+
+	return nw, nil
+}
+
+// CreateNetwork adds a network to the table
+func (d *fakeDockerClient) CreateNetwork(config *dockerclient.NetworkCreate) (*dockerclient.NetworkCreateResponse, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.createErr != nil {
+		return nil, d.createErr
+	}
+	if d.createFailures > 0 {
+		d.createFailures--
+		return nil, dockerclient.Error{StatusCode: 503, Status: "503 Service Unavailable"}
+	}
+	if config.CheckDuplicate && d.findNetwork(config.Name) != nil {
+		return nil, errors.New("network with name " + config.Name + " already exists")
+	}
+
+	d.nextID++
+	d.lastCreate = *config
+	nw := &dockerclient.NetworkResource{
+		Name:       config.Name,
+		ID:         fmt.Sprintf("fakenet%d", d.nextID),
+		Scope:      "local",
+		Driver:     config.Driver,
+		IPAM:       config.IPAM,
+		Containers: make(map[string]dockerclient.EndpointResource),
+		Options:    config.Options,
+		Labels:     config.Labels,
+	}
+	if d.allocGateway && config.Options["gw-endpoint"] == "true" {
+		gwEp := dockerclient.EndpointResource{Name: "gateway"}
+		for _, pool := range config.IPAM.Config {
+			if pool.Gateway == "" {
+				continue
+			}
+			gwAddr := pool.Gateway + "/" + strings.Split(pool.Subnet, "/")[1]
+			if strings.Contains(pool.Gateway, ":") && gwEp.IPv6Address == "" {
+				gwEp.IPv6Address = gwAddr
+			} else if !strings.Contains(pool.Gateway, ":") && gwEp.IPv4Address == "" {
+				gwEp.IPv4Address = gwAddr
+			}
+		}
+		nw.Containers["gw-"+nw.ID] = gwEp
+	}
+	d.networks[nw.ID] = nw
+	d.networkEvent("create", nw)
+	if d.lostCreates > 0 {
+		d.lostCreates--
+		return nil, dockerclient.Error{StatusCode: 502, Status: "502 Bad Gateway"}
+	}
+
+	return &dockerclient.NetworkCreateResponse{ID: nw.ID}, nil
+}
+
+// ListNetworks returns all networks. Filters are not supported.
+func (d *fakeDockerClient) ListNetworks(filters string) ([]*dockerclient.NetworkResource, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	nws := []*dockerclient.NetworkResource{}
+	for _, nw := range d.networks {
+		nws = append(nws, nw)
+	}
+
+	return nws, nil
+}
+
+// RemoveNetwork deletes the network by name or ID
+func (d *fakeDockerClient) RemoveNetwork(id string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.removeErr != nil {
+		return d.removeErr
+	}
+
+	nw := d.findNetwork(id)
+	if nw == nil {
+		return notFound("network %s not found", id)
+	}
+	if !removalExpected(nw.Name) {
+		d.outOfBand = append(d.outOfBand, nw.Name)
+	}
+	delete(d.networks, nw.ID)
+	d.networkEvent("destroy", nw)
+
+	return nil
+}
+
+// ConnectNetwork attaches a container to a network
+func (d *fakeDockerClient) ConnectNetwork(id, container string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	nw := d.findNetwork(id)
+	if nw == nil {
+		return notFound("network %s not found", id)
+	}
+	cinfo, ok := d.containers[container]
+	if !ok {
+		return notFound("No such container: %s", container)
+	}
+
+	epID := "ep-" + container + "-" + nw.ID
+	cinfo.NetworkSettings.Networks[nw.Name] = &dockerclient.EndpointSettings{
+		NetworkID:  nw.ID,
+		EndpointID: epID,
+	}
+	nw.Containers[container] = dockerclient.EndpointResource{
+		Name:       container,
+		EndpointID: epID,
+	}
+
+	return nil
+}
+
+// DisconnectNetwork detaches a container from a network
+func (d *fakeDockerClient) DisconnectNetwork(id, container string, force bool) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.disconnectErr != nil {
+		return d.disconnectErr
+	}
+
+	nw := d.findNetwork(id)
+	if nw == nil {
+		return notFound("network %s not found", id)
+	}
+	if _, ok := nw.Containers[container]; !ok {
+		return notFound("No such container: %s", container)
+	}
+	delete(nw.Containers, container)
+	if cinfo, ok := d.containers[container]; ok {
+		delete(cinfo.NetworkSettings.Networks, nw.Name)
+	}
+
+	return nil
+}
+
+// InspectContainer returns the container by ID
+func (d *fakeDockerClient) InspectContainer(id string) (*dockerclient.ContainerInfo, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if cinfo, ok := d.containers[id]; ok {
+		return cinfo, nil
+	}
+
+	return nil, notFound("No such container: %s", id)
+}
+
+// addContainer attaches a container to the given networks
+func (d *fakeDockerClient) addContainer(id string, nwNames ...string) {
+	cinfo := &dockerclient.ContainerInfo{Id: id}
+	cinfo.NetworkSettings.Networks = make(map[string]*dockerclient.EndpointSettings)
+	d.mutex.Lock()
+	d.containers[id] = cinfo
+	d.mutex.Unlock()
+	for _, name := range nwNames {
+		d.ConnectNetwork(name, id)
+	}
+}
+
+// setupFakeDocknet points docknet at a fake docker client and a fake state
+// driver. The returned function restores the previous setup.
+func setupFakeDocknet(t *testing.T) (*fakeDockerClient, func()) {
+	docker := newFakeDockerClient()
+	origClient := newDockerClient
+	newDockerClient = func() (dockerclient.Client, error) {
+		return docker, nil
+	}
+	origBackoff := getConfig().retryBackoff
+	SetRetryBackoff(ConstantBackoff{Delay: time.Millisecond})
+
+	utils.ReleaseStateDriver()
+	_, err := utils.NewStateDriver("fakedriver", &core.InstanceInfo{})
+	if err != nil {
+		t.Fatalf("Error creating fake state driver. Err: %v", err)
+	}
+
+	return docker, func() {
+		newDockerClient = origClient
+		SetRetryBackoff(origBackoff)
+		utils.ReleaseStateDriver()
+		initStateDriver()
+	}
+}
+
+// fakeNwCfg returns a network config for the fake tests
+func fakeNwCfg(tenantName, networkName string) *mastercfg.CfgNetworkState {
+	return &mastercfg.CfgNetworkState{
+		Tenant:      tenantName,
+		NetworkName: networkName,
+		PktTagType:  "vlan",
+		PktTag:      10,
+		ExtPktTag:   10,
+		SubnetIP:    "10.1.1.0",
+		SubnetLen:   24,
+		Gateway:     "10.1.1.254",
+	}
+}
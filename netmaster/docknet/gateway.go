@@ -0,0 +1,227 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// ErrGatewayOutsideSubnet is returned when the gateway is not in the subnet
+	ErrGatewayOutsideSubnet = errors.New("gateway is not in the subnet")
+
+	// ErrGatewayNetworkAddress is returned when the gateway is the subnet
+	// address (or the IPv6 subnet-router anycast address)
+	ErrGatewayNetworkAddress = errors.New("gateway is the subnet address")
+
+	// ErrGatewayBroadcastAddress is returned when the gateway is the IPv4
+	// broadcast address of the subnet
+	ErrGatewayBroadcastAddress = errors.New("gateway is the broadcast address")
+
+	// ErrGatewayHostname is returned when the gateway is a hostname and
+	// hostname resolution is not enabled
+	ErrGatewayHostname = errors.New("gateway is a hostname, not an IP address")
+
+	// ErrGatewayUnresolvable is returned when a gateway hostname does not
+	// resolve to an address in the subnet
+	ErrGatewayUnresolvable = errors.New("gateway hostname does not resolve to an address in the subnet")
+)
+
+// lookupHost resolves gateway hostnames
+var lookupHost = net.LookupHost
+
+// GatewaySource selects who tells the IPAM driver the gateways of a network
+type GatewaySource int
+
+const (
+	// GatewayFromDocker sets the gateways in the docker IPAM config
+	GatewayFromDocker GatewaySource = iota
+	// GatewayFromDriver leaves the gateways out of the docker IPAM config and
+	// passes them to the IPAM driver in the gatewaysOption IPAM option
+	GatewayFromDriver
+)
+
+// gatewaysOption is the IPAM option passing the gateways to the IPAM driver,
+// as comma separated <subnet>=<gateway> pairs
+const gatewaysOption = "gateways"
+
+// IPv6GatewayMode selects how the IPv6 gateway is derived when the network
+// has no IPv6 gateway configured
+type IPv6GatewayMode int
+
+const (
+	// IPv6GatewayNone leaves the gateway allocation to the IPAM driver
+	IPv6GatewayNone IPv6GatewayMode = iota
+	// IPv6GatewayFirstHost uses the first address after the subnet address
+	IPv6GatewayFirstHost
+	// IPv6GatewayAddr1 uses interface ID ::1, the subnet must be /64 or larger
+	IPv6GatewayAddr1
+	// IPv6GatewayEUI64 uses the modified EUI-64 interface ID of a MAC address,
+	// the subnet must be /64 or larger
+	IPv6GatewayEUI64
+)
+
+// deriveIPv6Gateway computes the gateway address within an IPv6 subnet
+func deriveIPv6Gateway(subnetCIDR string, mode IPv6GatewayMode, mac string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return "", err
+	}
+	if ipNet.IP.To4() != nil {
+		return "", fmt.Errorf("%s is not an IPv6 subnet", subnetCIDR)
+	}
+	ones, _ := ipNet.Mask.Size()
+
+	gw := make(net.IP, net.IPv6len)
+	copy(gw, ipNet.IP.To16())
+
+	switch mode {
+	case IPv6GatewayFirstHost:
+		if ones > 127 {
+			return "", fmt.Errorf("subnet %s has no host addresses", subnetCIDR)
+		}
+		gw[net.IPv6len-1] |= 1
+
+	case IPv6GatewayAddr1:
+		if ones > 64 {
+			return "", fmt.Errorf("subnet %s is smaller than /64", subnetCIDR)
+		}
+		gw[net.IPv6len-1] = 1
+
+	case IPv6GatewayEUI64:
+		if ones > 64 {
+			return "", fmt.Errorf("subnet %s is smaller than /64", subnetCIDR)
+		}
+		hwAddr, err := net.ParseMAC(mac)
+		if err != nil || len(hwAddr) != 6 {
+			return "", fmt.Errorf("invalid MAC address %q for EUI-64 gateway", mac)
+		}
+		// ff:fe is inserted in the middle of the MAC and the
+		// universal/local bit is flipped
+		copy(gw[8:11], hwAddr[0:3])
+		gw[11] = 0xff
+		gw[12] = 0xfe
+		copy(gw[13:16], hwAddr[3:6])
+		gw[8] ^= 0x02
+
+	default:
+		return "", fmt.Errorf("invalid IPv6 gateway mode %d", mode)
+	}
+
+	return gw.String(), nil
+}
+
+// validateGateway checks the gateway is a usable host address in the subnet
+func validateGateway(subnetCIDR, gateway string) error {
+	_, ipNet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return err
+	}
+
+	gwIP := net.ParseIP(gateway)
+	if gwIP == nil || !ipNet.Contains(gwIP) {
+		return ErrGatewayOutsideSubnet
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if ipNet.IP.To4() != nil {
+		// /31 and /32 subnets have no subnet or broadcast address
+		if ones >= 31 {
+			return nil
+		}
+		gwIP = gwIP.To4()
+	} else if ones == bits {
+		return nil
+	}
+
+	if gwIP.Equal(ipNet.IP) {
+		return ErrGatewayNetworkAddress
+	}
+
+	if ipNet.IP.To4() != nil {
+		bcast := make(net.IP, len(ipNet.IP))
+		for i := range ipNet.IP {
+			bcast[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+		}
+		if gwIP.Equal(bcast) {
+			return ErrGatewayBroadcastAddress
+		}
+	}
+
+	return nil
+}
+
+// resolveGateway returns the address a gateway hostname resolves to in the
+// subnet. IP address gateways are returned as is.
+func resolveGateway(subnetCIDR, gateway string, resolve bool) (string, error) {
+	if gateway == "" || net.ParseIP(gateway) != nil {
+		return gateway, nil
+	}
+	if !resolve {
+		return "", ErrGatewayHostname
+	}
+
+	_, ipNet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return "", err
+	}
+
+	addrs, err := lookupHost(gateway)
+	if err != nil {
+		log.Errorf("Error resolving gateway %s. Err: %v", gateway, err)
+		return "", ErrGatewayUnresolvable
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ipNet.Contains(ip) {
+			return ip.String(), nil
+		}
+	}
+
+	log.Errorf("Gateway %s resolves to %v, none in subnet %s", gateway, addrs, subnetCIDR)
+	return "", ErrGatewayUnresolvable
+}
+
+// resolveGatewayHostnames returns a copy of the network config with gateway
+// hostnames replaced by the addresses they resolve to
+func resolveGatewayHostnames(docknetName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (*mastercfg.CfgNetworkState, error) {
+	if net.ParseIP(nwCfg.Gateway) == nil && nwCfg.Gateway != "" ||
+		net.ParseIP(nwCfg.IPv6Gateway) == nil && nwCfg.IPv6Gateway != "" {
+		resolved := *nwCfg
+		var err error
+		resolved.Gateway, err = resolveGateway(fmt.Sprintf("%s/%d", nwCfg.SubnetIP, nwCfg.SubnetLen),
+			nwCfg.Gateway, opts.ResolveGatewayHostname)
+		if err != nil {
+			log.Errorf("Invalid gateway %s of network %s. Err: %v", nwCfg.Gateway, docknetName, err)
+			return nil, err
+		}
+		resolved.IPv6Gateway, err = resolveGateway(fmt.Sprintf("%s/%d", nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen),
+			nwCfg.IPv6Gateway, opts.ResolveGatewayHostname)
+		if err != nil {
+			log.Errorf("Invalid IPv6 gateway %s of network %s. Err: %v", nwCfg.IPv6Gateway, docknetName, err)
+			return nil, err
+		}
+		return &resolved, nil
+	}
+
+	return nwCfg, nil
+}
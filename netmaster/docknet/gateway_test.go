@@ -0,0 +1,232 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDeriveIPv6Gateway(t *testing.T) {
+	gwTests := []struct {
+		mode   IPv6GatewayMode
+		subnet string
+		mac    string
+		gw     string
+		valid  bool
+	}{
+		{IPv6GatewayFirstHost, "2016:430::/64", "", "2016:430::1", true},
+		{IPv6GatewayAddr1, "2016:430::/64", "", "2016:430::1", true},
+		{IPv6GatewayEUI64, "2016:430::/64", "02:02:0a:01:01:fe", "2016:430::2:aff:fe01:1fe", true},
+		{IPv6GatewayFirstHost, "2016:430::/100", "", "2016:430::1", true},
+		{IPv6GatewayFirstHost, "2016:430::1/128", "", "", false},
+		{IPv6GatewayAddr1, "2016:430::/100", "", "", false},
+		{IPv6GatewayEUI64, "2016:430::/100", "02:02:0a:01:01:fe", "", false},
+		{IPv6GatewayEUI64, "2016:430::/64", "", "", false},
+		{IPv6GatewayFirstHost, "10.1.1.0/24", "", "", false},
+	}
+
+	for _, gwt := range gwTests {
+		gw, err := deriveIPv6Gateway(gwt.subnet, gwt.mode, gwt.mac)
+		if gwt.valid && (err != nil || gw != gwt.gw) {
+			t.Fatalf("mode %d subnet %s: got gateway %q, expected %q. Err: %v", gwt.mode, gwt.subnet, gw, gwt.gw, err)
+		}
+		if !gwt.valid && err == nil {
+			t.Fatalf("mode %d subnet %s: expected error, got gateway %q", gwt.mode, gwt.subnet, gw)
+		}
+	}
+}
+
+func TestDocknetIPv6GatewayMode(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.IPv6Subnet = "2016:430::"
+	nwCfg.IPv6SubnetLen = 64
+
+	err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, DockNetOptions{IPv6GatewayMode: IPv6GatewayFirstHost})
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if nw.IPAM.Config[1].Gateway != "2016:430::1" {
+		t.Fatalf("Unexpected IPv6 gateway in %+v", nw.IPAM.Config)
+	}
+	if nwCfg.IPv6Gateway != "" {
+		t.Fatalf("network config was modified")
+	}
+}
+
+func TestValidateGateway(t *testing.T) {
+	gwTests := []struct {
+		subnet string
+		gw     string
+		err    error
+	}{
+		{"10.1.1.0/24", "10.1.1.254", nil},
+		{"10.1.1.0/24", "10.1.1.1", nil},
+		{"10.1.1.0/24", "10.1.1.0", ErrGatewayNetworkAddress},
+		{"10.1.1.0/24", "10.1.1.255", ErrGatewayBroadcastAddress},
+		{"10.1.0.0/16", "10.1.255.255", ErrGatewayBroadcastAddress},
+		{"10.1.1.0/24", "10.1.2.1", ErrGatewayOutsideSubnet},
+		{"10.1.1.0/24", "gateway", ErrGatewayOutsideSubnet},
+		{"10.1.1.0/31", "10.1.1.0", nil},
+		{"2016:430::/64", "2016:430::1", nil},
+		{"2016:430::/64", "2016:430::", ErrGatewayNetworkAddress},
+		{"2016:430::/64", "2016:431::1", ErrGatewayOutsideSubnet},
+	}
+
+	for _, gwt := range gwTests {
+		if err := validateGateway(gwt.subnet, gwt.gw); err != gwt.err {
+			t.Fatalf("subnet %s gateway %s: got %v, expected %v", gwt.subnet, gwt.gw, err, gwt.err)
+		}
+	}
+}
+
+func TestDocknetInvalidGateway(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.Gateway = "10.1.1.255"
+	if err := CreateDockNet("unit-test", "net1", "", nwCfg); err != ErrGatewayBroadcastAddress {
+		t.Fatalf("Expected ErrGatewayBroadcastAddress, got: %v", err)
+	}
+	if len(docker.networks) != 0 {
+		t.Fatalf("network was created with an invalid gateway")
+	}
+}
+
+func TestDocknetL2Only(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	opts := DockNetOptions{L2Only: true}
+
+	// gateway and L2 only conflict
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts); err != ErrL2OnlyGateway {
+		t.Fatalf("Expected ErrL2OnlyGateway, got: %v", err)
+	}
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.Gateway = ""
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating L2 only network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if len(nw.IPAM.Config) != 1 || nw.IPAM.Config[0].Gateway != "" || nw.Options["l2-only"] != "true" {
+		t.Fatalf("Unexpected L2 only network %+v", nw)
+	}
+
+	dnetOper := getDocknetState("unit-test", "net1", "")
+	if dnetOper == nil || !dnetOper.L2Only {
+		t.Fatalf("L2 only was not recorded in oper state: %+v", dnetOper)
+	}
+}
+
+func TestDocknetGatewaySource(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	nwCfg.IPv6Gateway = "2001:db8::1"
+
+	// docker IPAM config has the gateways by default
+	if err := CreateDockNet("unit-test", "net1", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if nw.IPAM.Config[0].Gateway != "10.1.1.254" || nw.IPAM.Config[1].Gateway != "2001:db8::1" ||
+		nw.IPAM.Options[gatewaysOption] != "" {
+		t.Fatalf("Gateways were not set in the IPAM config: %+v", nw.IPAM)
+	}
+
+	// the driver gets them in an IPAM option
+	nwCfg = fakeNwCfg("unit-test", "net2")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	nwCfg.IPv6Gateway = "2001:db8::1"
+	opts := DockNetOptions{GatewaySource: GatewayFromDriver}
+	if err := CreateDockNetWithOptions("unit-test", "net2", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ = docker.InspectNetwork(GetDocknetName("unit-test", "net2", ""))
+	if nw.IPAM.Config[0].Gateway != "" || nw.IPAM.Config[1].Gateway != "" ||
+		nw.IPAM.Options[gatewaysOption] != "10.1.1.0/24=10.1.1.254,2001:db8::/64=2001:db8::1" {
+		t.Fatalf("Gateways were not delegated to the driver: %+v", nw.IPAM)
+	}
+}
+
+func TestDocknetGatewayHostname(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	origLookup := lookupHost
+	defer func() { lookupHost = origLookup }()
+	lookupHost = func(host string) ([]string, error) {
+		switch host {
+		case "gw.example.com":
+			return []string{"2001:db8::1", "10.1.1.1"}, nil
+		case "far.example.com":
+			return []string{"10.2.2.1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	// IP gateway
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"),
+		DockNetOptions{ResolveGatewayHostname: true})
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if nw.IPAM.Config[0].Gateway != "10.1.1.254" {
+		t.Fatalf("Unexpected gateway %s", nw.IPAM.Config[0].Gateway)
+	}
+
+	// hostnames are rejected unless resolution is enabled
+	nwCfg := fakeNwCfg("unit-test", "net2")
+	nwCfg.Gateway = "gw.example.com"
+	if err := CreateDockNet("unit-test", "net2", "", nwCfg); err != ErrGatewayHostname {
+		t.Fatalf("Expected ErrGatewayHostname, got: %v", err)
+	}
+
+	// resolvable hostname
+	err = CreateDockNetWithOptions("unit-test", "net2", "", nwCfg, DockNetOptions{ResolveGatewayHostname: true})
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ = docker.InspectNetwork(GetDocknetName("unit-test", "net2", ""))
+	if nw.IPAM.Config[0].Gateway != "10.1.1.1" || nwCfg.Gateway != "gw.example.com" {
+		t.Fatalf("Unexpected gateway %s, network config gateway %s", nw.IPAM.Config[0].Gateway, nwCfg.Gateway)
+	}
+
+	// unresolvable hostname, and a hostname resolving outside the subnet
+	for _, host := range []string{"none.example.com", "far.example.com"} {
+		nwCfg = fakeNwCfg("unit-test", "net3")
+		nwCfg.Gateway = host
+		err = CreateDockNetWithOptions("unit-test", "net3", "", nwCfg, DockNetOptions{ResolveGatewayHostname: true})
+		if err != ErrGatewayUnresolvable {
+			t.Fatalf("Expected ErrGatewayUnresolvable for %s, got: %v", host, err)
+		}
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net3", "")); err == nil {
+		t.Fatalf("Network with an unresolvable gateway was created")
+	}
+}
@@ -0,0 +1,212 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// GCReport lists the docker networks of our driver whose contiv network no
+// longer exists, eg. after the state store was restored from a backup
+type GCReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Pending []DockerNetInfo   `json:"pending"` // orphans within the grace period
+	Deleted []string          `json:"deleted"` // names of the removed networks, or to remove if DryRun
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+// StartGCLoop checks the docker networks of our driver every interval, and
+// removes those whose contiv network config has been missing for the grace
+// period, with their docknet oper state. In dry run the networks are only
+// reported. Networks whose name can not be mapped to a contiv network are
+// left alone. The returned function stops the loop.
+func StartGCLoop(interval, grace time.Duration, dryRun bool) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		// when each orphan network was first seen
+		orphanSince := make(map[string]time.Time)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if ReconcilePaused() {
+				log.Debugf("docknet reconcile is paused, skipping GC")
+				continue
+			}
+			if _, err := collectOrphans(orphanSince, grace, dryRun, time.Now()); err != nil {
+				log.Errorf("Error collecting orphan docker networks. Err: %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// collectOrphans removes the docker networks without a contiv network that
+// have been orphans since before the grace period. Ephemeral networks are
+// left to their owners. orphanSince is updated with the orphans seen.
+func collectOrphans(orphanSince map[string]time.Time, grace time.Duration, dryRun bool,
+	now time.Time) (GCReport, error) {
+	report := GCReport{
+		DryRun:  dryRun,
+		Pending: []DockerNetInfo{},
+		Deleted: []string{},
+	}
+
+	// Get the state driver
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return report, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return report, errors.New("Unable to connect to docker")
+	}
+
+	nws, err := listDriverNetworks(docker, getConfig())
+	if err != nil {
+		log.Errorf("Error listing docker networks. Err: %v", err)
+		return report, err
+	}
+
+	seen := make(map[string]bool)
+	for _, nw := range nws {
+		if isEphemeral(nw) {
+			continue
+		}
+		tenantName, networkName, ok := networkOf(nw)
+		if !ok {
+			continue
+		}
+		exists, err := networkConfigExists(stateDriver, tenantName, networkName)
+		if err != nil {
+			return report, err
+		}
+		if exists {
+			continue
+		}
+
+		seen[nw.ID] = true
+		since, ok := orphanSince[nw.ID]
+		if !ok {
+			since = now
+			orphanSince[nw.ID] = now
+		}
+		if now.Sub(since) < grace {
+			report.Pending = append(report.Pending, DockerNetInfo{Name: nw.Name, ID: nw.ID, Driver: nw.Driver})
+			continue
+		}
+
+		if dryRun {
+			logInfof("GC dry run: would remove docker network %s (%s) of missing network %s/%s",
+				nw.Name, nw.ID, tenantName, networkName)
+			report.Deleted = append(report.Deleted, nw.Name)
+			continue
+		}
+
+		log.Warnf("Removing docker network %s (%s) of missing network %s/%s, orphan since %v",
+			nw.Name, nw.ID, tenantName, networkName, since)
+		if err := removeOrphan(docker, nw); err != nil {
+			log.Errorf("Error removing docker network %s. Err: %v", nw.Name, err)
+			if report.Failed == nil {
+				report.Failed = make(map[string]string)
+			}
+			report.Failed[nw.Name] = err.Error()
+			continue
+		}
+		report.Deleted = append(report.Deleted, nw.Name)
+		delete(seen, nw.ID)
+	}
+
+	// forget networks that are gone or have a network config again
+	for id := range orphanSince {
+		if !seen[id] {
+			delete(orphanSince, id)
+		}
+	}
+
+	return report, nil
+}
+
+// networkOf returns the tenant and network of a docker network, from its
+// labels or else its name
+func networkOf(nw *dockerclient.NetworkResource) (string, string, bool) {
+	if nw.Labels[tenantLabel] != "" && nw.Labels[networkLabel] != "" {
+		return nw.Labels[tenantLabel], nw.Labels[networkLabel], true
+	}
+
+	name, err := ParseDocknetNameStruct(nw.Name)
+	if err != nil {
+		log.Warnf("Unable to map docker network %s to a network. Err: %v", nw.Name, err)
+		return "", "", false
+	}
+
+	return name.Tenant, name.Network, true
+}
+
+// networkConfigExists returns true if a network of a tenant is configured
+func networkConfigExists(stateDriver core.StateDriver, tenantName, networkName string) (bool, error) {
+	nwCfg := mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	err := nwCfg.Read(mastercfg.GetNwCfgKey(networkName, tenantName))
+	if err == nil {
+		return true, nil
+	}
+	if core.ErrIfKeyExists(err) == nil {
+		return false, nil
+	}
+
+	log.Errorf("Error reading network %s of tenant %s. Err: %v", networkName, tenantName, err)
+	return false, err
+}
+
+// removeOrphan removes an orphan docker network, and its docknet if it has
+// one
+func removeOrphan(docker dockerclient.Client, nw *dockerclient.NetworkResource) error {
+	if dnet, err := FindDocknetByUUID(nw.ID); err == nil {
+		return DeleteDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName)
+	}
+
+	defer expectRemoval(nw.Name)()
+	err := docker.RemoveNetwork(nw.ID)
+	if isNotFound(err) {
+		return nil
+	}
+
+	return err
+}
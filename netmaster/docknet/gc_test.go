@@ -0,0 +1,97 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/utils"
+	"github.com/samalba/dockerclient"
+)
+
+// addFakeNwCfg writes a network config to the state store
+func addFakeNwCfg(t *testing.T, tenantName, networkName string) {
+	stateDriver, _ := utils.GetStateDriver()
+	nwCfg := fakeNwCfg(tenantName, networkName)
+	nwCfg.ID = mastercfg.GetNwCfgKey(networkName, tenantName)
+	nwCfg.StateDriver = stateDriver
+	if err := nwCfg.Write(); err != nil {
+		t.Fatalf("Error writing network config. Err: %v", err)
+	}
+}
+
+func TestCollectOrphans(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	// net1 is configured, net2 and the network created without docknet are
+	// not, the network of another driver is not ours and the ephemeral
+	// network has no network config
+	for _, netName := range []string{"net1", "net2"} {
+		if err := CreateDockNet("unit-test", netName, "", fakeNwCfg("unit-test", netName)); err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+	addFakeNwCfg(t, "unit-test", "net1")
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "net3/unit-test", Driver: getConfig().netDriverName})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "other/unit-test", Driver: "macvlan"})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   "net4/unit-test",
+		Driver: getConfig().netDriverName,
+		Labels: map[string]string{tenantLabel: "unit-test", networkLabel: "net4", ephemeralLabel: "true"},
+	})
+
+	grace := time.Minute
+	start := time.Now()
+	orphanSince := make(map[string]time.Time)
+
+	// not removed within the grace period, nor in dry run
+	report, err := collectOrphans(orphanSince, grace, false, start)
+	if err != nil || len(report.Pending) != 2 || len(report.Deleted) != 0 {
+		t.Fatalf("Unexpected GC report %+v. Err: %v", report, err)
+	}
+	report, err = collectOrphans(orphanSince, grace, true, start.Add(grace))
+	if err != nil || len(report.Deleted) != 2 || !report.DryRun {
+		t.Fatalf("Unexpected GC report %+v. Err: %v", report, err)
+	}
+	if _, err := docker.InspectNetwork("net3/unit-test"); err != nil {
+		t.Fatalf("Network was removed in dry run")
+	}
+
+	// removed after the grace period, with the docknet
+	report, err = collectOrphans(orphanSince, grace, false, start.Add(grace))
+	if err != nil || len(report.Deleted) != 2 || len(report.Failed) != 0 {
+		t.Fatalf("Unexpected GC report %+v. Err: %v", report, err)
+	}
+	for _, docknetName := range []string{"net2/unit-test", "net3/unit-test"} {
+		if _, err := docker.InspectNetwork(docknetName); err == nil {
+			t.Fatalf("Orphan network %s was not removed", docknetName)
+		}
+	}
+	if getDocknetState("unit-test", "net2", "") != nil {
+		t.Fatalf("docknet of the orphan network was not cleared")
+	}
+	for _, docknetName := range []string{"net1/unit-test", "other/unit-test", "net4/unit-test"} {
+		if _, err := docker.InspectNetwork(docknetName); err != nil {
+			t.Fatalf("Network %s was removed", docknetName)
+		}
+	}
+	if len(orphanSince) != 0 {
+		t.Fatalf("Removed networks are still tracked: %v", orphanSince)
+	}
+}
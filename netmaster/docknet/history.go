@@ -0,0 +1,69 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrHistoryNotSupported is returned when the state driver does not keep
+// prior revisions of its keys
+var ErrHistoryNotSupported = errors.New("state driver does not support revision history")
+
+// revisionReader is implemented by state drivers that keep prior revisions
+// of their keys
+type revisionReader interface {
+	// ReadRevisions returns the values of a key, newest first
+	ReadRevisions(key string) ([][]byte, error)
+}
+
+// DockNetHistory returns the revisions of the oper state of a docknet, newest
+// first. It returns ErrHistoryNotSupported if the state driver does not keep
+// revisions.
+func DockNetHistory(tenantName, networkName, serviceName string) ([]DnetOperState, error) {
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	revReader, ok := stateDriver.(revisionReader)
+	if !ok {
+		return nil, ErrHistoryNotSupported
+	}
+
+	key := docknetOperKey(docknetOperID(tenantName, networkName, serviceName))
+	revisions, err := revReader.ReadRevisions(key)
+	if err != nil {
+		log.Errorf("Error reading revisions of %s. Err: %v", key, err)
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, ErrDocknetNotFound
+	}
+
+	history := make([]DnetOperState, len(revisions))
+	for i, data := range revisions {
+		if err := unmarshalDnetOper(data, &history[i]); err != nil {
+			log.Errorf("Error decoding revision %d of %s. Err: %v", i, key, err)
+			return nil, err
+		}
+		history[i].StateDriver = stateDriver
+	}
+
+	return history, nil
+}
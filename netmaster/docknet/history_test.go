@@ -0,0 +1,90 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
+)
+
+// revisionStateDriver is a fake state driver keeping every value written
+type revisionStateDriver struct {
+	state.FakeStateDriver
+	revisions map[string][][]byte
+}
+
+func (d *revisionStateDriver) Write(key string, value []byte) error {
+	d.revisions[key] = append([][]byte{value}, d.revisions[key]...)
+	return d.FakeStateDriver.Write(key, value)
+}
+
+func (d *revisionStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	encodedState, err := marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return d.Write(key, encodedState)
+}
+
+func (d *revisionStateDriver) ReadRevisions(key string) ([][]byte, error) {
+	return d.revisions[key], nil
+}
+
+func TestDockNetHistory(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetReadStateDriver(nil)
+
+	// the fake state driver keeps no revisions
+	if _, err := DockNetHistory("unit-test", "net1", ""); err != ErrHistoryNotSupported {
+		t.Fatalf("Expected ErrHistoryNotSupported, got: %v", err)
+	}
+
+	revDriver := &revisionStateDriver{revisions: make(map[string][][]byte)}
+	revDriver.Init(&core.InstanceInfo{})
+	SetReadStateDriver(revDriver)
+
+	if _, err := DockNetHistory("unit-test", "net1", ""); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	dnet := DnetOperState{
+		TenantName:  "unit-test",
+		NetworkName: "net1",
+		AdminState:  adminStateUp,
+	}
+	dnet.ID = docknetOperID("unit-test", "net1", "")
+	dnet.StateDriver = revDriver
+	for _, adminState := range []string{adminStateUp, adminStateDown, adminStateUp} {
+		dnet.AdminState = adminState
+		if err := dnet.Write(); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+	}
+
+	history, err := DockNetHistory("unit-test", "net1", "")
+	if err != nil {
+		t.Fatalf("Error reading history. Err: %v", err)
+	}
+	if len(history) != 3 || history[0].AdminState != adminStateUp ||
+		history[1].AdminState != adminStateDown || history[2].AdminState != adminStateUp {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+}
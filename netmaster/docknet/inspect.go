@@ -0,0 +1,85 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"sort"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DocknetInfo is a docknet oper state as shown by the netmaster REST API,
+// with its docker network name. The values of sensitive options are
+// redacted.
+type DocknetInfo struct {
+	DnetOperState
+	DocknetName string `json:"docknetName"`
+}
+
+// docknetInfos sorts docknet infos by oper state ID
+type docknetInfos []DocknetInfo
+
+func (d docknetInfos) Len() int           { return len(d) }
+func (d docknetInfos) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d docknetInfos) Less(i, j int) bool { return d[i].ID < d[j].ID }
+
+// newDocknetInfo returns the info of a docknet
+func newDocknetInfo(dnet *DnetOperState) DocknetInfo {
+	return DocknetInfo{
+		DnetOperState: dnet.Diagnostics(),
+		DocknetName:   dnet.DocknetName(),
+	}
+}
+
+// ListDocknetInfo returns the info of all docknets, sorted by oper state ID
+func ListDocknetInfo() ([]DocknetInfo, error) {
+	dnets, err := ListDockNets()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []DocknetInfo{}
+	for _, dnet := range dnets {
+		infos = append(infos, newDocknetInfo(dnet))
+	}
+	sort.Sort(docknetInfos(infos))
+
+	return infos, nil
+}
+
+// InspectDockNet returns the info of the docknet with an oper state ID, or
+// ErrDocknetNotFound
+func InspectDockNet(operID string) (DocknetInfo, error) {
+	// Get the state driver
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return DocknetInfo{}, err
+	}
+
+	dnet := DnetOperState{}
+	dnet.StateDriver = stateDriver
+	if err := dnet.Read(operID); err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return DocknetInfo{}, ErrDocknetNotFound
+		}
+		log.Errorf("Error reading docknet %s. Err: %v", operID, err)
+		return DocknetInfo{}, err
+	}
+
+	return newDocknetInfo(&dnet), nil
+}
@@ -0,0 +1,60 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInspectDockNet(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearSensitiveOptions()
+
+	MarkSensitiveOption("secret")
+	opts := DockNetOptions{OptionsOverride: map[string]string{"secret": "hunter2"}}
+	for _, netName := range []string{"net2", "net1"} {
+		if err := CreateDockNetWithOptions("unit-test", netName, "", fakeNwCfg("unit-test", netName), opts); err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+
+	infos, err := ListDocknetInfo()
+	if err != nil || len(infos) != 2 || infos[0].ID != "unit-test.net1." || infos[1].ID != "unit-test.net2." {
+		t.Fatalf("Unexpected docknet list %+v. Err: %v", infos, err)
+	}
+
+	info, err := InspectDockNet("unit-test.net1.")
+	if err != nil {
+		t.Fatalf("Error inspecting docknet. Err: %v", err)
+	}
+	if info.DocknetName != "net1/unit-test" || info.DocknetUUID != getDocknetState("unit-test", "net1", "").DocknetUUID {
+		t.Fatalf("Unexpected docknet info %+v", info)
+	}
+	resp, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("Error marshaling docknet info. Err: %v", err)
+	}
+	if !strings.Contains(string(resp), `"docknetName":"net1/unit-test"`) || strings.Contains(string(resp), "hunter2") {
+		t.Fatalf("Unexpected docknet info json %s", resp)
+	}
+
+	if _, err := InspectDockNet("unit-test.net3."); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+var (
+	// ErrBadGateway is the Kind of IPAMError when docker rejected a gateway
+	ErrBadGateway = errors.New("docker rejected the gateway")
+
+	// ErrBadSubnet is the Kind of IPAMError when docker rejected a subnet
+	ErrBadSubnet = errors.New("docker rejected the subnet")
+)
+
+// IPAMError is returned when docker rejects the IPAM config of a network.
+// Kind is ErrBadGateway or ErrBadSubnet, and Value is the rejected address of
+// the Family address family, if docker reported it.
+type IPAMError struct {
+	Kind   error
+	Family string
+	Value  string
+	Err    error
+}
+
+func (e *IPAMError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+	}
+
+	return fmt.Sprintf("%v: %s %s: %v", e.Kind, e.Family, e.Value, e.Err)
+}
+
+// addrPattern matches an IPv4 or IPv6 address or CIDR
+const addrPattern = `([0-9a-fA-F.:/]+)`
+
+// ipamRejections are the IPAM errors of docker CreateNetwork. The first
+// submatch, if any, is the rejected address.
+var ipamRejections = []struct {
+	pattern *regexp.Regexp
+	kind    error
+}{
+	{regexp.MustCompile(`no matching subnet for gateway ` + addrPattern), ErrBadGateway},
+	{regexp.MustCompile(`failed to allocate gateway \(` + addrPattern + `\)`), ErrBadGateway},
+	{regexp.MustCompile(`(?i)invalid gateway address:? ` + addrPattern), ErrBadGateway},
+	{regexp.MustCompile(`(?i)invalid (?:subnet|pool) ` + addrPattern), ErrBadSubnet},
+	{regexp.MustCompile(`(?i)invalid cidr address:? ` + addrPattern), ErrBadSubnet},
+	{regexp.MustCompile(`[Pp]ool overlaps with other one on this address space`), ErrBadSubnet},
+	{regexp.MustCompile(`conflicts with network .*(?:overlap|subnet)`), ErrBadSubnet},
+}
+
+// wrapIPAMError maps the IPAM errors of docker CreateNetwork to an IPAMError.
+// Other errors are returned as is.
+func wrapIPAMError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	for _, rejection := range ipamRejections {
+		match := rejection.pattern.FindStringSubmatch(msg)
+		if match == nil {
+			continue
+		}
+
+		ipamErr := &IPAMError{Kind: rejection.kind, Err: err}
+		if len(match) > 1 {
+			ipamErr.Value = strings.TrimRight(match[1], ".")
+			ipamErr.Family = addrFamily(ipamErr.Value)
+		}
+		return ipamErr
+	}
+
+	return err
+}
+
+// addrFamily returns the address family of an IP address or CIDR, or an
+// empty string if it is neither
+func addrFamily(addr string) string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		var err error
+		ip, _, err = net.ParseCIDR(addr)
+		if err != nil {
+			return ""
+		}
+	}
+	if ip.To4() != nil {
+		return familyIPv4
+	}
+
+	return familyIPv6
+}
@@ -0,0 +1,70 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWrapIPAMError(t *testing.T) {
+	ipamTests := []struct {
+		msg    string
+		kind   error
+		family string
+		value  string
+	}{
+		{"no matching subnet for gateway 10.2.2.1", ErrBadGateway, familyIPv4, "10.2.2.1"},
+		{"no matching subnet for gateway 2001:db8::1", ErrBadGateway, familyIPv6, "2001:db8::1"},
+		{"failed to allocate gateway (10.1.1.254): Address already in use", ErrBadGateway, familyIPv4, "10.1.1.254"},
+		{"invalid subnet 2001:db8::/129 : invalid CIDR address", ErrBadSubnet, "", "2001:db8::/129"},
+		{"invalid CIDR address: 10.1.1.0/33", ErrBadSubnet, "", "10.1.1.0/33"},
+		{"invalid pool request: Pool overlaps with other one on this address space", ErrBadSubnet, "", ""},
+		{"cannot create network a (b): conflicts with network c (d): networks have overlapping IPv4", ErrBadSubnet, "", ""},
+	}
+
+	for _, test := range ipamTests {
+		err := wrapIPAMError(errors.New("500 Internal Server Error: " + test.msg))
+		ipamErr, ok := err.(*IPAMError)
+		if !ok {
+			t.Fatalf("Error %q was not mapped", test.msg)
+		}
+		if ipamErr.Kind != test.kind || ipamErr.Family != test.family || ipamErr.Value != test.value {
+			t.Fatalf("Error %q mapped to %+v", test.msg, ipamErr)
+		}
+	}
+
+	// unrecognized errors are returned as is
+	rawErr := errors.New("500 Internal Server Error: plugin not found")
+	if err := wrapIPAMError(rawErr); err != rawErr {
+		t.Fatalf("Unrecognized error was mapped to %v", err)
+	}
+	if wrapIPAMError(nil) != nil {
+		t.Fatalf("nil error was mapped")
+	}
+}
+
+func TestDocknetIPAMError(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	docker.createErr = errors.New("500 Internal Server Error: no matching subnet for gateway 10.1.1.254")
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	ipamErr, ok := err.(*IPAMError)
+	if !ok || ipamErr.Kind != ErrBadGateway || ipamErr.Value != "10.1.1.254" || ipamErr.Err != docker.createErr {
+		t.Fatalf("Unexpected create error %#v", err)
+	}
+}
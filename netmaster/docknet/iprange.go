@@ -0,0 +1,70 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"bytes"
+	"errors"
+	"net"
+)
+
+// excludeRangeOption is the IPAM option passing the excluded range to the
+// IPAM driver, as "<start>-<end>"
+const excludeRangeOption = "exclude-range"
+
+var (
+	// ErrInvalidExcludedRange is returned when the excluded range is not a
+	// valid address range
+	ErrInvalidExcludedRange = errors.New("invalid excluded address range")
+
+	// ErrExcludedRangeOutsideSubnet is returned when the excluded range is not
+	// within the subnet
+	ErrExcludedRangeOutsideSubnet = errors.New("excluded address range is outside the subnet")
+)
+
+// AddrRange is an inclusive range of addresses
+type AddrRange struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// String returns the range as "<start>-<end>"
+func (r AddrRange) String() string {
+	return r.Start + "-" + r.End
+}
+
+// validateExcludedRange checks the excluded range is a valid range within
+// the subnet
+func validateExcludedRange(subnetCIDR string, r AddrRange) error {
+	_, ipNet, err := net.ParseCIDR(subnetCIDR)
+	if err != nil {
+		return err
+	}
+
+	start := net.ParseIP(r.Start)
+	end := net.ParseIP(r.End)
+	if start == nil || end == nil || (start.To4() == nil) != (end.To4() == nil) {
+		return ErrInvalidExcludedRange
+	}
+	if bytes.Compare(start.To16(), end.To16()) > 0 {
+		return ErrInvalidExcludedRange
+	}
+	if !ipNet.Contains(start) || !ipNet.Contains(end) {
+		return ErrExcludedRangeOutsideSubnet
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestValidateExcludedRange(t *testing.T) {
+	rangeTests := []struct {
+		subnet string
+		r      AddrRange
+		err    error
+	}{
+		{"10.1.1.0/24", AddrRange{"10.1.1.100", "10.1.1.200"}, nil},
+		{"10.1.1.0/24", AddrRange{"10.1.1.100", "10.1.1.100"}, nil},
+		{"2001:db8::/64", AddrRange{"2001:db8::100", "2001:db8::1ff"}, nil},
+		{"10.1.1.0/24", AddrRange{"10.1.1.200", "10.1.1.100"}, ErrInvalidExcludedRange},
+		{"10.1.1.0/24", AddrRange{"10.1.1.100", ""}, ErrInvalidExcludedRange},
+		{"10.1.1.0/24", AddrRange{"10.1.1.100", "2001:db8::1"}, ErrInvalidExcludedRange},
+		{"10.1.1.0/24", AddrRange{"10.1.1.100", "10.1.2.10"}, ErrExcludedRangeOutsideSubnet},
+		{"10.1.1.0/24", AddrRange{"10.1.0.100", "10.1.1.10"}, ErrExcludedRangeOutsideSubnet},
+	}
+
+	for _, rt := range rangeTests {
+		if err := validateExcludedRange(rt.subnet, rt.r); err != rt.err {
+			t.Fatalf("Range %s in %s returned %v, expected %v", rt.r, rt.subnet, err, rt.err)
+		}
+	}
+}
+
+func TestDocknetExcludedRange(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	opts := DockNetOptions{ExcludedRange: &AddrRange{"10.1.2.1", "10.1.2.50"}}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts)
+	if err != ErrExcludedRangeOutsideSubnet {
+		t.Fatalf("Out of range exclusion was accepted. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", "")); err == nil {
+		t.Fatalf("docker network was created with an invalid exclusion")
+	}
+
+	opts = DockNetOptions{ExcludedRange: &AddrRange{"10.1.1.1", "10.1.1.50"}}
+	err = CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	nw, err := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if err != nil || nw.IPAM.Options[excludeRangeOption] != "10.1.1.1-10.1.1.50" {
+		t.Fatalf("Excluded range was not passed to the IPAM driver: %+v. Err: %v", nw, err)
+	}
+	dnet := getDocknetState("unit-test", "net1", "")
+	if dnet == nil || dnet.ExcludedRange == nil || *dnet.ExcludedRange != *opts.ExcludedRange {
+		t.Fatalf("Excluded range was not saved in oper state: %+v", dnet)
+	}
+}
@@ -0,0 +1,129 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	// labels attributing docker networks to contiv objects
+	tenantLabel        = "contiv.tenant"
+	networkLabel       = "contiv.network"
+	endpointGroupLabel = "contiv.endpointgroup"
+
+	// contivLabelPrefix is the prefix of the labels set by docknet
+	contivLabelPrefix = "contiv."
+)
+
+// ErrReservedLabel is returned when a label update changes a label set by
+// docknet
+var ErrReservedLabel = errors.New("label is reserved for contiv")
+
+// setObjectLabels labels a docker network with the contiv objects it
+// belongs to
+func setObjectLabels(nwCreate *dockerclient.NetworkCreate, tenantName, networkName, serviceName string) {
+	nwCreate.Labels[tenantLabel] = tenantName
+	nwCreate.Labels[networkLabel] = networkName
+	if serviceName != "" {
+		nwCreate.Labels[endpointGroupLabel] = serviceName
+	}
+}
+
+// labelsMatch returns true if a docker network is labelled with the contiv
+// objects of a docknet. The network of a shared docknet is labelled with its
+// primary tenant.
+func labelsMatch(nw *dockerclient.NetworkResource, dnet *DnetOperState) bool {
+	tenantName := dnet.TenantName
+	if dnet.isSharedSecondary() {
+		tenantName = dnet.PrimaryTenant
+	}
+
+	return nw.Labels[tenantLabel] == tenantName && nw.Labels[networkLabel] == dnet.NetworkName &&
+		nw.Labels[endpointGroupLabel] == dnet.ServiceName
+}
+
+// UpdateDockNetLabels sets labels of the docker network of a docknet. An
+// empty value removes the label. The labels set by docknet, prefixed with
+// "contiv.", can not be changed. Docker labels are immutable, so the docker
+// network is recreated, moving the attached containers to the new network.
+func UpdateDockNetLabels(tenantName, networkName, serviceName string, labels map[string]string) error {
+	for key := range labels {
+		if strings.HasPrefix(key, contivLabelPrefix) {
+			log.Errorf("Label %s is set by docknet", key)
+			return ErrReservedLabel
+		}
+	}
+
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return err
+	}
+	if dnetOper.Shared {
+		return errors.New("labels of shared docknets can not be updated")
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnetOper.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", dnetOper.ID, err)
+		return err
+	}
+
+	nwCreate := recreateRequest(dnetOper, nw)
+	nwCreate.Labels = make(map[string]string)
+	for key, val := range nw.Labels {
+		nwCreate.Labels[key] = val
+	}
+	changed := false
+	for key, val := range labels {
+		if cur, ok := nwCreate.Labels[key]; cur == val && (ok || val == "") {
+			continue
+		}
+		changed = true
+		if val == "" {
+			delete(nwCreate.Labels, key)
+		} else {
+			nwCreate.Labels[key] = val
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	logInfof("Updating labels of docker network %s", nw.Name)
+
+	nwID, _, err := replaceDockerNetwork(docker, nw, nwCreate)
+	if err != nil {
+		return err
+	}
+
+	dnetOper.DocknetUUID = nwID
+	dnetOper.Labels = nwCreate.Labels
+
+	return dnetOper.Write()
+}
@@ -0,0 +1,76 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestDocknetLabels(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if err := CreateDockNet("unit-test", "net1", "web", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	docknetName := GetDocknetName("unit-test", "net1", "web")
+	nw, _ := docker.InspectNetwork(docknetName)
+	for key, val := range map[string]string{
+		tenantLabel:        "unit-test",
+		networkLabel:       "net1",
+		endpointGroupLabel: "web",
+		versionLabel:       contivVersion,
+	} {
+		if nw.Labels[key] != val {
+			t.Fatalf("Expected label %s=%s, got labels %v", key, val, nw.Labels)
+		}
+	}
+
+	// contiv labels can not be changed
+	err := UpdateDockNetLabels("unit-test", "net1", "web", map[string]string{tenantLabel: "other"})
+	if err != ErrReservedLabel {
+		t.Fatalf("Expected ErrReservedLabel, got: %v", err)
+	}
+
+	// unchanged labels do not recreate the network
+	if err := UpdateDockNetLabels("unit-test", "net1", "web", map[string]string{"owner": ""}); err != nil {
+		t.Fatalf("Error updating labels. Err: %v", err)
+	}
+	if again, _ := docker.InspectNetwork(docknetName); again.ID != nw.ID {
+		t.Fatalf("Network was recreated without a label change")
+	}
+
+	docker.addContainer("ctr1", docknetName)
+	if err := UpdateDockNetLabels("unit-test", "net1", "web", map[string]string{"owner": "ops"}); err != nil {
+		t.Fatalf("Error updating labels. Err: %v", err)
+	}
+	nw, _ = docker.InspectNetwork(docknetName)
+	dnet := getDocknetState("unit-test", "net1", "web")
+	if nw.Labels["owner"] != "ops" || nw.Labels[tenantLabel] != "unit-test" ||
+		dnet.Labels["owner"] != "ops" || dnet.DocknetUUID != nw.ID {
+		t.Fatalf("Labels were not updated, network %v, docknet %v", nw.Labels, dnet.Labels)
+	}
+	if _, ok := nw.Containers["ctr1"]; !ok {
+		t.Fatalf("Container was not moved to the new network")
+	}
+
+	if err := UpdateDockNetLabels("unit-test", "net1", "web", map[string]string{"owner": ""}); err != nil {
+		t.Fatalf("Error updating labels. Err: %v", err)
+	}
+	if nw, _ = docker.InspectNetwork(docknetName); nw.Labels["owner"] != "" {
+		t.Fatalf("Label was not removed, got labels %v", nw.Labels)
+	}
+}
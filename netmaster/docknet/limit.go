@@ -0,0 +1,143 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrGlobalLimitReached is returned when creating a docknet would exceed the
+// SetMaxDockNets limit
+var ErrGlobalLimitReached = errors.New("maximum number of docknets reached")
+
+// docknetTracker tracks the IDs of the docknet oper states, so that the
+// global limit is enforced without reading all oper states on every create.
+// It is loaded from the state store when the limit is first enforced, and
+// kept up to date by DnetOperState Write and Clear.
+type docknetTracker struct {
+	mutex  sync.Mutex
+	loaded bool
+	ids    map[string]bool
+}
+
+var tracker = &docknetTracker{}
+
+// SetMaxDockNets sets the maximum number of docknets. Zero removes the limit.
+// The docknets are counted again when the limit is next enforced.
+func SetMaxDockNets(max int) error {
+	if max < 0 {
+		return errors.New("maximum number of docknets can not be negative")
+	}
+
+	configMutex.Lock()
+	pkgConfig.maxDocknets = max
+	configMutex.Unlock()
+
+	tracker.reset()
+	return nil
+}
+
+// RecountDockNets counts the docknets in the state store, eg. on startup or
+// after other netmasters changed the oper state
+func RecountDockNets() (int, error) {
+	tracker.reset()
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if err := tracker.load(); err != nil {
+		return 0, err
+	}
+
+	return len(tracker.ids), nil
+}
+
+// reset makes the tracker load the oper states again
+func (t *docknetTracker) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.loaded = false
+	t.ids = nil
+}
+
+// load reads the oper state IDs, the mutex must be held
+func (t *docknetTracker) load() error {
+	if t.loaded {
+		return nil
+	}
+
+	stateDriver, err := getWriteStateDriver()
+	if err != nil {
+		return err
+	}
+
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil && core.ErrIfKeyExists(err) != nil {
+		log.Errorf("Error reading docknets. Err: %v", err)
+		return err
+	}
+
+	t.ids = make(map[string]bool)
+	for _, dnet := range dnets {
+		t.ids[dnet.ID] = true
+	}
+	t.loaded = true
+
+	return nil
+}
+
+// reserve counts a docknet about to be created against the limit. It returns
+// true if the docknet was not counted yet, release must then be called if
+// the create fails.
+func (t *docknetTracker) reserve(max int, operID string) (bool, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := t.load(); err != nil {
+		return false, err
+	}
+
+	if t.ids[operID] {
+		return false, nil
+	}
+	if len(t.ids) >= max {
+		return false, ErrGlobalLimitReached
+	}
+	t.ids[operID] = true
+
+	return true, nil
+}
+
+// added records an oper state write
+func (t *docknetTracker) added(operID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.loaded {
+		t.ids[operID] = true
+	}
+}
+
+// removed records an oper state clear, or a failed create
+func (t *docknetTracker) removed(operID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.loaded {
+		delete(t.ids, operID)
+	}
+}
@@ -0,0 +1,88 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMaxDockNets(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetMaxDockNets(0)
+
+	if err := SetMaxDockNets(-1); err == nil {
+		t.Fatalf("Negative limit was accepted")
+	}
+
+	// networks created before the limit are counted
+	if err := CreateDockNet("unit-test", "net0", "", fakeNwCfg("unit-test", "net0")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	SetMaxDockNets(3)
+
+	// under the limit
+	for i := 1; i < 3; i++ {
+		netName := fmt.Sprintf("net%d", i)
+		if err := CreateDockNet("unit-test", netName, "", fakeNwCfg("unit-test", netName)); err != nil {
+			t.Fatalf("Error creating network %s. Err: %v", netName, err)
+		}
+	}
+
+	// at the limit, existing docknets can still be updated
+	if err := CreateDockNet("unit-test", "net3", "", fakeNwCfg("unit-test", "net3")); err != ErrGlobalLimitReached {
+		t.Fatalf("Expected ErrGlobalLimitReached, got: %v", err)
+	}
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error updating network. Err: %v", err)
+	}
+
+	// a failed create does not hold a slot
+	if err := DeleteDockNet("unit-test", "net2", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	nwCfg := fakeNwCfg("unit-test", "bad")
+	nwCfg.Gateway = "10.9.9.9"
+	if err := CreateDockNet("unit-test", "bad", "", nwCfg); err != ErrGatewayOutsideSubnet {
+		t.Fatalf("Expected ErrGatewayOutsideSubnet, got: %v", err)
+	}
+	docker.createErr = errors.New("500 Internal Server Error")
+	if err := CreateDockNet("unit-test", "bad", "", fakeNwCfg("unit-test", "bad")); err != docker.createErr {
+		t.Fatalf("Expected the docker error, got: %v", err)
+	}
+	docker.createErr = nil
+	if err := CreateDockNet("unit-test", "net3", "", fakeNwCfg("unit-test", "net3")); err != nil {
+		t.Fatalf("Error creating network under the limit. Err: %v", err)
+	}
+
+	// over the limit after other netmasters added docknets
+	dnet := getDocknetState("unit-test", "net3", "")
+	dnet.ID = "unit-test.net4."
+	dnet.NetworkName = "net4"
+	dnet.StateDriver.WriteState(docknetOperKey(dnet.ID), dnet, json.Marshal)
+	if count, err := RecountDockNets(); err != nil || count != 4 {
+		t.Fatalf("Recounted %d docknets. Err: %v", count, err)
+	}
+	if err := DeleteDockNet("unit-test", "net3", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if err := CreateDockNet("unit-test", "net5", "", fakeNwCfg("unit-test", "net5")); err != ErrGlobalLimitReached {
+		t.Fatalf("Expected ErrGlobalLimitReached, got: %v", err)
+	}
+}
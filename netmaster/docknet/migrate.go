@@ -0,0 +1,238 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	maxVlanTag  = 4094
+	maxVxlanTag = 0xffffff
+)
+
+// ErrEndpointsNotMigratable is returned when the endpoints of a network could
+// not be moved off the network before migrating it
+var ErrEndpointsNotMigratable = errors.New("network endpoints can not be migrated")
+
+// validatePktTag checks the packet tag is valid for the encap
+func validatePktTag(encap string, tag int) error {
+	switch encap {
+	case "vlan":
+		if tag < 1 || tag > maxVlanTag {
+			return fmt.Errorf("invalid vlan tag %d", tag)
+		}
+	case "vxlan":
+		if tag < 1 || tag > maxVxlanTag {
+			return fmt.Errorf("invalid vxlan tag %d", tag)
+		}
+	default:
+		return fmt.Errorf("invalid encap %q", encap)
+	}
+
+	return nil
+}
+
+// MigrateEncap moves a docknet to a new encap and packet tag. Docker networks
+// can not be modified, so the network is recreated with the same subnets and
+// gateways. Attached containers are disconnected first and reconnected to the
+// new network on a best effort basis.
+func MigrateEncap(tenantName, networkName, serviceName, newEncap string, newTag int) error {
+	err := validatePktTag(newEncap, newTag)
+	if err != nil {
+		return err
+	}
+
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnetOper.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", dnetOper.ID, err)
+		return err
+	}
+
+	logInfof("Migrating docker network %s to %s/%d", nw.Name, newEncap, newTag)
+
+	optUpdates := map[string]string{
+		"encap":   newEncap,
+		"pkt-tag": strconv.Itoa(newTag),
+	}
+	nwID, err := recreateDockerNetwork(docker, dnetOper, nw, optUpdates)
+	if err != nil {
+		return err
+	}
+
+	dnetOper.updateOptions(optUpdates)
+	dnetOper.DocknetUUID = nwID
+	dnetOper.Encap = newEncap
+	dnetOper.PktTag = newTag
+
+	return dnetOper.Write()
+}
+
+// recreateDockerNetwork replaces a docker network with one that has the same
+// name, IPAM config and labels, and updated driver options. Attached
+// containers are moved to the new network on a best effort basis. It returns
+// the ID of the new network.
+func recreateDockerNetwork(docker dockerclient.Client, dnet *DnetOperState, nw *dockerclient.NetworkResource,
+	optUpdates map[string]string) (string, error) {
+	nwCreate := recreateRequest(dnet, nw)
+	for key, val := range optUpdates {
+		nwCreate.Options[key] = val
+	}
+
+	nwID, _, err := replaceDockerNetwork(docker, nw, nwCreate)
+	return nwID, err
+}
+
+// recreateRequest returns a create request for a network with the same name,
+// driver, IPAM config, options and labels as an existing network. Docker does
+// not return the internal flag, it is taken from the oper state.
+func recreateRequest(dnet *DnetOperState, nw *dockerclient.NetworkResource) *dockerclient.NetworkCreate {
+	nwCreate := dockerclient.NetworkCreate{
+		Name:           nw.Name,
+		CheckDuplicate: true,
+		Driver:         nw.Driver,
+		IPAM:           nw.IPAM,
+		Internal:       dnet.Internal,
+		Options:        make(map[string]string),
+		Labels:         nw.Labels,
+	}
+	for key, val := range nw.Options {
+		nwCreate.Options[key] = val
+	}
+
+	return &nwCreate
+}
+
+// replaceDockerNetwork deletes a docker network and creates a new one from
+// nwCreate, moving the attached containers to the new network on a best
+// effort basis. It returns the ID of the new network and the containers that
+// could not be reconnected.
+func replaceDockerNetwork(docker dockerclient.Client, nw *dockerclient.NetworkResource,
+	nwCreate *dockerclient.NetworkCreate) (string, []string, error) {
+	// move the containers off the network
+	containers := []string{}
+	for ctrID := range nw.Containers {
+		err := docker.DisconnectNetwork(nw.ID, ctrID, false)
+		if err != nil {
+			log.Errorf("Error disconnecting %s from network %s. Err: %v", ctrID, nw.Name, err)
+			reconnectContainers(docker, nw.ID, containers)
+			return "", nil, ErrEndpointsNotMigratable
+		}
+		containers = append(containers, ctrID)
+	}
+
+	defer expectRemoval(nw.Name)()
+	err := docker.RemoveNetwork(nw.ID)
+	if err != nil {
+		log.Errorf("Error deleting network %s. Err: %v", nw.Name, err)
+		reconnectContainers(docker, nw.ID, containers)
+		return "", nil, err
+	}
+
+	resp, err := docker.CreateNetwork(nwCreate)
+	if err != nil {
+		err = getConfig().redactCreateError(nwCreate, err)
+		log.Errorf("Error creating network %s. Err: %v", nwCreate.Name, err)
+		return "", containers, wrapIPAMError(err)
+	}
+
+	return resp.ID, reconnectContainers(docker, resp.ID, containers), nil
+}
+
+// updateOptions updates the saved driver options of the docknet
+func (s *DnetOperState) updateOptions(optUpdates map[string]string) {
+	if s.Options == nil {
+		s.Options = make(map[string]string)
+	}
+	for key, val := range optUpdates {
+		s.Options[key] = val
+	}
+}
+
+// reconnectContainers connects containers to a network, logging failures. It
+// returns the containers that could not be connected.
+func reconnectContainers(docker dockerclient.Client, nwID string, containers []string) []string {
+	failed := []string{}
+	for _, ctrID := range containers {
+		err := docker.ConnectNetwork(nwID, ctrID)
+		if err != nil {
+			log.Errorf("Error connecting %s to network %s. Err: %v", ctrID, nwID, err)
+			failed = append(failed, ctrID)
+		}
+	}
+
+	return failed
+}
+
+// SetDockNetAdminState enables or disables the dataplane of a docknet. The
+// admin state is a driver option, so the docker network is recreated.
+func SetDockNetAdminState(tenantName, networkName, serviceName string, up bool) error {
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return err
+	}
+
+	adminState := adminStateDown
+	if up {
+		adminState = adminStateUp
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnetOper.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", dnetOper.ID, err)
+		return err
+	}
+
+	if nw.Options["admin-state"] != adminState {
+		logInfof("Setting docker network %s admin state %s", nw.Name, adminState)
+
+		optUpdates := map[string]string{"admin-state": adminState}
+		dnetOper.DocknetUUID, err = recreateDockerNetwork(docker, dnetOper, nw, optUpdates)
+		if err != nil {
+			return err
+		}
+		dnetOper.updateOptions(optUpdates)
+	}
+
+	dnetOper.AdminState = adminState
+
+	return dnetOper.Write()
+}
@@ -0,0 +1,112 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMigrateEncap(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	docknetName := GetDocknetName("unit-test", "net1", "")
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	oldID := getDocknetState("unit-test", "net1", "").DocknetUUID
+
+	// invalid encap and tag
+	if err := MigrateEncap("unit-test", "net1", "", "gre", 10); err == nil {
+		t.Fatalf("invalid encap was accepted")
+	}
+	if err := MigrateEncap("unit-test", "net1", "", "vlan", 5000); err == nil {
+		t.Fatalf("invalid vlan tag was accepted")
+	}
+	if err := MigrateEncap("unit-test", "net2", "", "vxlan", 5000); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	// vlan -> vxlan without endpoints
+	if err := MigrateEncap("unit-test", "net1", "", "vxlan", 5000); err != nil {
+		t.Fatalf("Error migrating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(docknetName)
+	dnetOper := getDocknetState("unit-test", "net1", "")
+	if nw.ID == oldID || dnetOper.DocknetUUID != nw.ID || dnetOper.Encap != "vxlan" || dnetOper.PktTag != 5000 ||
+		dnetOper.Options["encap"] != "vxlan" {
+		t.Fatalf("oper state %+v does not point to new network %s", dnetOper, nw.ID)
+	}
+	if nw.Options["encap"] != "vxlan" || nw.Options["pkt-tag"] != "5000" ||
+		nw.IPAM.Config[0].Subnet != "10.1.1.0/24" || nw.IPAM.Config[0].Gateway != "10.1.1.254" {
+		t.Fatalf("Unexpected migrated network %+v", nw)
+	}
+
+	// with endpoints that can be moved
+	docker.addContainer("ctr1", docknetName)
+	docker.addContainer("ctr2", docknetName)
+	if err := MigrateEncap("unit-test", "net1", "", "vxlan", 6000); err != nil {
+		t.Fatalf("Error migrating network. Err: %v", err)
+	}
+	nw, _ = docker.InspectNetwork(docknetName)
+	if len(nw.Containers) != 2 || nw.Options["pkt-tag"] != "6000" {
+		t.Fatalf("endpoints were not migrated: %+v", nw)
+	}
+
+	// with endpoints that can not be moved
+	docker.disconnectErr = errors.New("endpoint busy")
+	if err := MigrateEncap("unit-test", "net1", "", "vlan", 10); err != ErrEndpointsNotMigratable {
+		t.Fatalf("Expected ErrEndpointsNotMigratable, got: %v", err)
+	}
+	nw2, _ := docker.InspectNetwork(docknetName)
+	if nw2.ID != nw.ID || nw2.Options["encap"] != "vxlan" {
+		t.Fatalf("network was changed after failed migration: %+v", nw2)
+	}
+}
+
+func TestDocknetAdminState(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	docknetName := GetDocknetName("unit-test", "net1", "")
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), DockNetOptions{Disabled: true})
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(docknetName)
+	if nw.Options["admin-state"] != "down" || getDocknetState("unit-test", "net1", "").AdminState != "down" {
+		t.Fatalf("network was not created admin down: %+v", nw)
+	}
+
+	docker.addContainer("ctr1", docknetName)
+	if err := SetDockNetAdminState("unit-test", "net1", "", true); err != nil {
+		t.Fatalf("Error enabling network. Err: %v", err)
+	}
+	nw, _ = docker.InspectNetwork(docknetName)
+	dnetOper := getDocknetState("unit-test", "net1", "")
+	if nw.Options["admin-state"] != "up" || dnetOper.AdminState != "up" || dnetOper.DocknetUUID != nw.ID {
+		t.Fatalf("network was not enabled: %+v, oper state: %+v", nw, dnetOper)
+	}
+	if _, ok := nw.Containers["ctr1"]; !ok {
+		t.Fatalf("container was not moved to the enabled network")
+	}
+
+	if err := SetDockNetAdminState("unit-test", "net2", "", true); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+}
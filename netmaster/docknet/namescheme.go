@@ -0,0 +1,129 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+)
+
+// NameScheme builds the docker network names of new docknets from the tenant
+// and the network or endpoint group name. Docknets named by a scheme other
+// than the legacy one keep their name as their raw name, so they are still
+// parsed and found after the scheme changes, and existing docknets keep the
+// name they were created with.
+type NameScheme interface {
+	// DocknetName returns the docker network name of a network or endpoint
+	// group of a tenant
+	DocknetName(tenantName, netName string) string
+}
+
+// DelimitedNameScheme joins the network or endpoint group name and the tenant
+// with Separator, with the tenant first if TenantFirst is set. The default
+// tenant is left out.
+type DelimitedNameScheme struct {
+	Separator   string
+	TenantFirst bool
+}
+
+// DocknetName implements NameScheme
+func (s DelimitedNameScheme) DocknetName(tenantName, netName string) string {
+	switch {
+	case tenantName == defaultTenantName:
+		return netName
+	case s.TenantFirst:
+		return tenantName + s.Separator + netName
+	}
+
+	return netName + s.Separator + tenantName
+}
+
+// FlatNameScheme names docker networks after the network or endpoint group
+// only. The tenant is in the docker network labels. Names of networks in
+// different tenants may conflict, as resolved by the conflict policy.
+type FlatNameScheme struct{}
+
+// DocknetName implements NameScheme
+func (FlatNameScheme) DocknetName(tenantName, netName string) string {
+	return netName
+}
+
+// legacyNameScheme is the <network>[/<tenant>] naming docknets have always
+// used, whose names are parsed without a raw name
+var legacyNameScheme = DelimitedNameScheme{Separator: DocknetNameSeparator}
+
+// Names of the schemes for ParseNameScheme
+const (
+	TenantSuffixScheme = "tenant-suffix"
+	TenantPrefixScheme = "tenant-prefix"
+	FlatScheme         = "flat"
+)
+
+// ErrInvalidNameScheme is returned for an unknown name scheme or a separator
+// that is not allowed in docker network names
+var ErrInvalidNameScheme = errors.New("invalid docker network name scheme")
+
+// ParseNameScheme returns the name scheme called name, one of
+// TenantSuffixScheme, TenantPrefixScheme or FlatScheme. The separator of the
+// delimited schemes defaults to DocknetNameSeparator.
+func ParseNameScheme(name, separator string) (NameScheme, error) {
+	if separator == "" {
+		separator = DocknetNameSeparator
+	}
+	if validateRawName("a"+separator+"a") != nil {
+		return nil, ErrInvalidNameScheme
+	}
+
+	switch name {
+	case TenantSuffixScheme:
+		return DelimitedNameScheme{Separator: separator}, nil
+	case TenantPrefixScheme:
+		return DelimitedNameScheme{Separator: separator, TenantFirst: true}, nil
+	case FlatScheme:
+		return FlatNameScheme{}, nil
+	}
+
+	return nil, ErrInvalidNameScheme
+}
+
+// SetNameScheme sets the scheme naming new docker networks. A nil scheme
+// restores the legacy <network>[/<tenant>] naming.
+func SetNameScheme(scheme NameScheme) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.nameScheme = scheme
+}
+
+// docknetNameOf returns the docker network name of a docknet with the name
+// scheme of cfg, before truncation
+func docknetNameOf(cfg config, tenantName, networkName, epgName string) string {
+	scheme := cfg.nameScheme
+	if scheme == nil {
+		scheme = legacyNameScheme
+	}
+
+	return scheme.DocknetName(tenantName, docknetNetName(networkName, epgName))
+}
+
+// docknetNetName returns the network or endpoint group name a docker network
+// is named after
+func docknetNetName(networkName, epgName string) string {
+	// if epg is specified, always use that, else use nw
+	if epgName == "" {
+		return networkName
+	}
+
+	return epgName
+}
@@ -0,0 +1,390 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Docker network names are of the form <network>[/<tenant>] where <network>
+// is the endpoint group name if the docknet is for an endpoint group. The
+// tenant is left out for the default tenant. Docknets named by another
+// SetNameScheme scheme have their name as raw name.
+// Oper state IDs are always of the form <tenant>.<network>.<epg>, with an
+// empty <epg> for network level docknets. Eg: docker network "web" is oper
+// state "default.web.", and "epg1/blue" is "blue.<network of epg1>.epg1".
+const (
+	// DocknetNameSeparator separates the network and tenant in docker
+	// network names
+	DocknetNameSeparator = "/"
+	// OperIDSeparator separates the tenant, network and epg in oper state IDs
+	OperIDSeparator = "."
+	// DefaultTenantName is the tenant left out of docker network names
+	DefaultTenantName = defaultTenantName
+)
+
+// Docknets created with a raw name use it verbatim as the docker network
+// name. The raw name is saved in the oper state, which stays keyed by the
+// tenant, network and epg.
+var rawNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_./-]*$`)
+
+// ErrInvalidDocknetName is returned when a raw name is not a legal docker
+// network name
+var ErrInvalidDocknetName = errors.New("invalid docker network name")
+
+// ErrNameTooLong is the Kind of NameLengthError
+var ErrNameTooLong = errors.New("docker network name is too long")
+
+// NameLengthError is returned when a docker network name is longer than the
+// limit set by SetMaxNameLength
+type NameLengthError struct {
+	Kind  error
+	Name  string
+	Limit int
+}
+
+func (e *NameLengthError) Error() string {
+	return fmt.Sprintf("%v: %q is longer than %d characters", e.Kind, e.Name, e.Limit)
+}
+
+// nameHashLen is the number of hex digits of the name hash that replace the
+// end of truncated names
+const nameHashLen = 8
+
+// NameFormat describes how docknet names and oper state IDs are built, for
+// tools that need to build or parse them
+type NameFormat struct {
+	DocknetNameFields    []string `json:"docknetNameFields"`
+	DocknetNameSeparator string   `json:"docknetNameSeparator"`
+	DefaultTenant        string   `json:"defaultTenant"`
+	OmitDefaultTenant    bool     `json:"omitDefaultTenant"`
+	EpgReplacesNetwork   bool     `json:"epgReplacesNetwork"`
+	OperIDFields         []string `json:"operIDFields"`
+	OperIDSeparator      string   `json:"operIDSeparator"`
+}
+
+// DescribeNameFormat returns the naming format of new docknets. Names of
+// other schemes than the ones of this package are described as a single
+// network field.
+func DescribeNameFormat() NameFormat {
+	format := NameFormat{
+		DocknetNameFields:    []string{"network", "tenant"},
+		DocknetNameSeparator: DocknetNameSeparator,
+		DefaultTenant:        DefaultTenantName,
+		OmitDefaultTenant:    true,
+		EpgReplacesNetwork:   true,
+		OperIDFields:         []string{"tenant", "network", "epg"},
+		OperIDSeparator:      OperIDSeparator,
+	}
+
+	switch scheme := getConfig().nameScheme.(type) {
+	case nil:
+	case DelimitedNameScheme:
+		format.DocknetNameSeparator = scheme.Separator
+		if scheme.TenantFirst {
+			format.DocknetNameFields = []string{"tenant", "network"}
+		}
+	default:
+		format.DocknetNameFields = []string{"network"}
+		format.DocknetNameSeparator = ""
+		format.OmitDefaultTenant = false
+	}
+
+	return format
+}
+
+// GetDocknetName returns the docker network name of a new docknet, as set by
+// the SetNameScheme scheme. Names longer than the SetMaxNameLength limit are
+// truncated if truncation is enabled.
+func GetDocknetName(tenantName, networkName, epgName string) string {
+	cfg := getConfig()
+	return truncateName(cfg, docknetNameOf(cfg, tenantName, networkName, epgName))
+}
+
+// fullDocknetName returns the legacy docker network name before truncation,
+// the name docknets without a raw name have
+func fullDocknetName(tenantName, networkName, epgName string) string {
+	return legacyNameScheme.DocknetName(tenantName, docknetNetName(networkName, epgName))
+}
+
+// truncateName shortens a name longer than the name length limit to the
+// limit, replacing its end with a hash of the whole name so that truncated
+// names stay unique. Names are returned as is if truncation is disabled.
+func truncateName(cfg config, name string) string {
+	if !cfg.truncateNames || cfg.maxNameLength == 0 || len(name) <= cfg.maxNameLength {
+		return name
+	}
+
+	sum := sha1.Sum([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:nameHashLen]
+	return name[:cfg.maxNameLength-nameHashLen-1] + "-" + hash
+}
+
+// checkNameLength checks a docker network name is within the name length
+// limit
+func checkNameLength(cfg config, name string) error {
+	if cfg.maxNameLength != 0 && len(name) > cfg.maxNameLength {
+		return &NameLengthError{Kind: ErrNameTooLong, Name: name, Limit: cfg.maxNameLength}
+	}
+
+	return nil
+}
+
+// splitDocknetName splits a docker network name into the tenant name and the
+// network or endpoint group name
+func splitDocknetName(docknetName string) (string, string, error) {
+	parts := strings.Split(docknetName, DocknetNameSeparator)
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return defaultTenantName, parts[0], nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return parts[1], parts[0], nil
+	}
+
+	return "", "", fmt.Errorf("invalid docker network name %q", docknetName)
+}
+
+// DocknetName is a parsed docker network name. Service and EPG are the same,
+// since docknets of endpoint groups are named after the endpoint group.
+type DocknetName struct {
+	Tenant  string
+	Network string
+	Service string
+	EPG     string
+}
+
+// OperID returns the oper state ID of the docknet
+func (n DocknetName) OperID() string {
+	return docknetOperID(n.Tenant, n.Network, n.Service)
+}
+
+// ParseDocknetName is the reverse of GetDocknetName. It returns the tenant,
+// network and service names.
+func ParseDocknetName(docknetName string) (string, string, string, error) {
+	name, err := ParseDocknetNameStruct(docknetName)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return name.Tenant, name.Network, name.Service, nil
+}
+
+// ParseDocknetNameStruct is the reverse of GetDocknetName. Since the docker
+// network name has the endpoint group name in place of the network name, the
+// endpoint groups of the tenant are looked up to tell them apart. The oper
+// states the name scheme would name so are read first, all docknets and
+// endpoint groups are only read if none of them has the name.
+func ParseDocknetNameStruct(docknetName string) (DocknetName, error) {
+	// Get the state driver
+	cfg := getConfig()
+	stateDriver, err := getReadStateDriver(cfg)
+	if err != nil {
+		return DocknetName{}, err
+	}
+
+	dnet, err := lookupDocknetByName(stateDriver, cfg, docknetName)
+	if err != nil {
+		return DocknetName{}, err
+	}
+	if dnet == nil {
+		dnet, err = findDocknetByRawName(stateDriver, docknetName)
+		if err != nil {
+			return DocknetName{}, err
+		}
+	}
+	if dnet != nil {
+		return DocknetName{
+			Tenant:  dnet.TenantName,
+			Network: dnet.NetworkName,
+			Service: dnet.ServiceName,
+			EPG:     dnet.ServiceName,
+		}, nil
+	}
+
+	tenantName, netName, err := splitDocknetName(docknetName)
+	if err != nil {
+		return DocknetName{}, err
+	}
+
+	epg, err := findEndpointGroup(stateDriver, tenantName, netName)
+	if err != nil {
+		return DocknetName{}, err
+	}
+	if epg != nil {
+		return DocknetName{
+			Tenant:  tenantName,
+			Network: epg.NetworkName,
+			Service: netName,
+			EPG:     netName,
+		}, nil
+	}
+
+	return DocknetName{Tenant: tenantName, Network: netName}, nil
+}
+
+// validateRawName checks a raw name is a legal docker network name
+func validateRawName(name string) error {
+	if !rawNamePattern.MatchString(name) {
+		return ErrInvalidDocknetName
+	}
+
+	return nil
+}
+
+// splitSchemeName splits a docker network name into the tenant names and the
+// network or endpoint group names it is built from by the name scheme and by
+// the legacy scheme, which existing docknets keep
+func splitSchemeName(cfg config, docknetName string) [][2]string {
+	splits := [][2]string{}
+	if scheme, ok := cfg.nameScheme.(DelimitedNameScheme); ok && scheme.Separator != "" {
+		parts := strings.Split(docknetName, scheme.Separator)
+		switch {
+		case len(parts) == 2 && scheme.TenantFirst:
+			splits = append(splits, [2]string{parts[0], parts[1]})
+		case len(parts) == 2:
+			splits = append(splits, [2]string{parts[1], parts[0]})
+		}
+	}
+	if tenantName, netName, err := splitDocknetName(docknetName); err == nil {
+		splits = append(splits, [2]string{tenantName, netName})
+	}
+
+	return splits
+}
+
+// lookupDocknetByName returns the docknet named docknetName among the
+// docknets the name schemes would name so, or nil if none of them is. Only
+// their oper states and endpoint groups are read.
+func lookupDocknetByName(stateDriver core.StateDriver, cfg config, docknetName string) (*DnetOperState, error) {
+	for _, split := range splitSchemeName(cfg, docknetName) {
+		tenantName, netName := split[0], split[1]
+		operIDs := []string{docknetOperID(tenantName, netName, "")}
+
+		epgCfg := mastercfg.EndpointGroupState{}
+		epgCfg.StateDriver = stateDriver
+		err := epgCfg.Read(mastercfg.GetEndpointGroupKey(netName, tenantName))
+		if err == nil {
+			operIDs = append(operIDs, docknetOperID(tenantName, epgCfg.NetworkName, netName))
+		} else if core.ErrIfKeyExists(err) != nil {
+			log.Errorf("Error reading endpoint group %s. Err: %v", netName, err)
+			return nil, err
+		}
+
+		for _, operID := range operIDs {
+			dnet := &DnetOperState{}
+			dnet.StateDriver = stateDriver
+			if err := dnet.Read(operID); err != nil {
+				if core.ErrIfKeyExists(err) != nil {
+					log.Errorf("Error reading docknet %s. Err: %v", operID, err)
+					return nil, err
+				}
+				continue
+			}
+			if dnet.DocknetName() == docknetName && !dnet.isSharedSecondary() {
+				return dnet, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// findDocknetByRawName returns the docknet using a raw name, or nil if no
+// docknet uses it
+func findDocknetByRawName(stateDriver core.StateDriver, rawName string) (*DnetOperState, error) {
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return nil, nil
+		}
+		log.Errorf("Error reading docknets. Err: %v", err)
+		return nil, err
+	}
+
+	for _, dnet := range dnets {
+		if dnet.RawName == rawName && !dnet.isSharedSecondary() {
+			return dnet, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// findEndpointGroup returns the endpoint group of a tenant, or nil if the
+// tenant has no such endpoint group
+func findEndpointGroup(stateDriver core.StateDriver, tenantName, epgName string) (*mastercfg.EndpointGroupState, error) {
+	epgCfg := mastercfg.EndpointGroupState{}
+	epgCfg.StateDriver = stateDriver
+	epgList, err := epgCfg.ReadAll()
+	if err != nil {
+		if core.ErrIfKeyExists(err) == nil {
+			return nil, nil
+		}
+		log.Errorf("Error reading endpoint groups. Err: %v", err)
+		return nil, err
+	}
+
+	for _, state := range epgList {
+		epg := state.(*mastercfg.EndpointGroupState)
+		if epg.TenantName == tenantName && epg.GroupName == epgName {
+			return epg, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DocknetNameToOperID returns the oper state ID for a docker network name
+func DocknetNameToOperID(docknetName string) (string, error) {
+	name, err := ParseDocknetNameStruct(docknetName)
+	if err != nil {
+		return "", err
+	}
+
+	return name.OperID(), nil
+}
+
+// OperIDToDocknetName returns the docker network name for an oper state ID
+func OperIDToDocknetName(operID string) (string, error) {
+	parts := strings.Split(operID, OperIDSeparator)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("invalid docknet oper state ID %q", operID)
+	}
+
+	// Get the state driver
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return "", err
+	}
+
+	dnetOper := DnetOperState{}
+	dnetOper.StateDriver = stateDriver
+	if err := dnetOper.Read(operID); err == nil {
+		return dnetOper.DocknetName(), nil
+	}
+
+	return GetDocknetName(parts[0], parts[1], parts[2]), nil
+}
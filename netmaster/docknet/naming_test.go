@@ -0,0 +1,463 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
+	"github.com/contiv/netplugin/utils"
+	"github.com/samalba/dockerclient"
+)
+
+// addFakeEpg writes an endpoint group config to the state store
+func addFakeEpg(t *testing.T, id, tenantName, networkName, epgName string) {
+	stateDriver, _ := utils.GetStateDriver()
+	epgCfg := mastercfg.EndpointGroupState{
+		GroupName:   epgName,
+		TenantName:  tenantName,
+		NetworkName: networkName,
+	}
+	epgCfg.ID = id
+	epgCfg.StateDriver = stateDriver
+	if err := epgCfg.Write(); err != nil {
+		t.Fatalf("Error writing epg state. Err: %v", err)
+	}
+}
+
+func TestDocknetNameOperIDMapping(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	addFakeEpg(t, "1", "default", "net1", "epg1")
+	addFakeEpg(t, "2", "blue", "net2", "epg2")
+
+	mappings := []struct {
+		docknetName string
+		operID      string
+	}{
+		{"web", "default.web."},
+		{"web/blue", "blue.web."},
+		{"epg1", "default.net1.epg1"},
+		{"epg2/blue", "blue.net2.epg2"},
+	}
+
+	for _, m := range mappings {
+		operID, err := DocknetNameToOperID(m.docknetName)
+		if err != nil || operID != m.operID {
+			t.Fatalf("docker name %q mapped to %q, expected %q. Err: %v", m.docknetName, operID, m.operID, err)
+		}
+		docknetName, err := OperIDToDocknetName(operID)
+		if err != nil || docknetName != m.docknetName {
+			t.Fatalf("oper ID %q mapped to %q, expected %q. Err: %v", operID, docknetName, m.docknetName, err)
+		}
+	}
+
+	for _, name := range []string{"", "/blue", "web/", "a/b/c"} {
+		if _, err := DocknetNameToOperID(name); err == nil {
+			t.Fatalf("Invalid docker name %q was accepted", name)
+		}
+	}
+	for _, id := range []string{"", "default.web", ".web.", "a.b.c.d"} {
+		if _, err := OperIDToDocknetName(id); err == nil {
+			t.Fatalf("Invalid oper ID %q was accepted", id)
+		}
+	}
+}
+
+func TestAdoptDockNet(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	for _, name := range []string{"web", "web/blue"} {
+		docker.CreateNetwork(&dockerclient.NetworkCreate{Name: name, Driver: getConfig().netDriverName})
+		if err := AdoptDockNet(name); err != nil {
+			t.Fatalf("Error adopting network %s. Err: %v", name, err)
+		}
+	}
+
+	if dnet := getDocknetState("default", "web", ""); dnet == nil || dnet.DocknetUUID == "" {
+		t.Fatalf("default tenant network was not adopted: %+v", dnet)
+	}
+	if dnet := getDocknetState("blue", "web", ""); dnet == nil || dnet.DocknetUUID == "" {
+		t.Fatalf("non-default tenant network was not adopted: %+v", dnet)
+	}
+}
+
+func TestDescribeNameFormat(t *testing.T) {
+	format := DescribeNameFormat()
+
+	// build names the way an external tool would, from the description
+	buildName := func(fields map[string]string, order []string, sep string) string {
+		parts := []string{}
+		for _, field := range order {
+			if field == "tenant" && format.OmitDefaultTenant && fields[field] == format.DefaultTenant {
+				continue
+			}
+			if field == "network" && format.EpgReplacesNetwork && fields["epg"] != "" {
+				parts = append(parts, fields["epg"])
+				continue
+			}
+			parts = append(parts, fields[field])
+		}
+		return strings.Join(parts, sep)
+	}
+
+	for _, fields := range []map[string]string{
+		{"tenant": "default", "network": "web", "epg": ""},
+		{"tenant": "blue", "network": "web", "epg": ""},
+		{"tenant": "default", "network": "web", "epg": "epg1"},
+		{"tenant": "blue", "network": "web", "epg": "epg1"},
+	} {
+		docknetName := buildName(fields, format.DocknetNameFields, format.DocknetNameSeparator)
+		if docknetName != GetDocknetName(fields["tenant"], fields["network"], fields["epg"]) {
+			t.Fatalf("Described format built %q, encoder built %q", docknetName,
+				GetDocknetName(fields["tenant"], fields["network"], fields["epg"]))
+		}
+
+		format.OmitDefaultTenant = false
+		format.EpgReplacesNetwork = false
+		operID := buildName(fields, format.OperIDFields, format.OperIDSeparator)
+		format = DescribeNameFormat()
+		if operID != docknetOperID(fields["tenant"], fields["network"], fields["epg"]) {
+			t.Fatalf("Described format built %q, encoder built %q", operID,
+				docknetOperID(fields["tenant"], fields["network"], fields["epg"]))
+		}
+	}
+}
+
+func TestDocknetRawName(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("blue", "net1")
+	for _, name := range []string{"-ext", "ext net", "ext:net"} {
+		err := CreateDockNetWithOptions("blue", "net1", "", nwCfg, DockNetOptions{RawName: name})
+		if err != ErrInvalidDocknetName {
+			t.Fatalf("Invalid raw name %q was accepted. Err: %v", name, err)
+		}
+	}
+
+	err := CreateDockNetWithOptions("blue", "net1", "", nwCfg, DockNetOptions{RawName: "ext-net.1"})
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork("ext-net.1"); err != nil {
+		t.Fatalf("docker network was not created with the raw name. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("blue", "net1", "")); err == nil {
+		t.Fatalf("docker network was created with the encoded name")
+	}
+	if dnet := getDocknetState("blue", "net1", ""); dnet == nil || dnet.RawName != "ext-net.1" {
+		t.Fatalf("raw name was not saved in oper state: %+v", dnet)
+	}
+
+	// lookup
+	operID, err := DocknetNameToOperID("ext-net.1")
+	if err != nil || operID != "blue.net1." {
+		t.Fatalf("raw name mapped to %q. Err: %v", operID, err)
+	}
+	docknetName, err := OperIDToDocknetName("blue.net1.")
+	if err != nil || docknetName != "ext-net.1" {
+		t.Fatalf("oper ID mapped to %q. Err: %v", docknetName, err)
+	}
+	if cfg, err := GetEffectiveConfig("blue", "net1", ""); err != nil || cfg.Drifted {
+		t.Fatalf("Unexpected effective config %+v. Err: %v", cfg, err)
+	}
+
+	// delete
+	if err := DeleteDockNet("blue", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork("ext-net.1"); err == nil {
+		t.Fatalf("docker network with raw name was not deleted")
+	}
+	if dnet := getDocknetState("blue", "net1", ""); dnet != nil {
+		t.Fatalf("oper state was not cleared: %+v", dnet)
+	}
+}
+
+func TestParseDocknetNameStruct(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	addFakeEpg(t, "1", "default", "net1", "epg1")
+	addFakeEpg(t, "2", "blue", "net2", "epg2")
+	err := CreateDockNetWithOptions("red", "net3", "epg3", fakeNwCfg("red", "net3"), DockNetOptions{RawName: "ext"})
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	nameTests := []struct {
+		docknetName string
+		name        DocknetName
+	}{
+		{"web", DocknetName{Tenant: "default", Network: "web"}},
+		{"web/blue", DocknetName{Tenant: "blue", Network: "web"}},
+		{"epg1", DocknetName{Tenant: "default", Network: "net1", Service: "epg1", EPG: "epg1"}},
+		{"epg2/blue", DocknetName{Tenant: "blue", Network: "net2", Service: "epg2", EPG: "epg2"}},
+		{"ext", DocknetName{Tenant: "red", Network: "net3", Service: "epg3", EPG: "epg3"}},
+	}
+	for _, nt := range nameTests {
+		name, err := ParseDocknetNameStruct(nt.docknetName)
+		if err != nil || name != nt.name {
+			t.Fatalf("%q parsed as %+v, expected %+v. Err: %v", nt.docknetName, name, nt.name, err)
+		}
+
+		tenantName, networkName, serviceName, err := ParseDocknetName(nt.docknetName)
+		if err != nil || tenantName != nt.name.Tenant || networkName != nt.name.Network ||
+			serviceName != nt.name.Service {
+			t.Fatalf("%q parsed as %s %s %s. Err: %v", nt.docknetName, tenantName, networkName, serviceName, err)
+		}
+	}
+
+	if _, err := ParseDocknetNameStruct("a/b/c"); err == nil {
+		t.Fatalf("Invalid docker name was parsed")
+	}
+}
+
+func TestAdoptAll(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	err := CreateDockNet("blue", "tracked", "", fakeNwCfg("blue", "tracked"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	driver := getConfig().netDriverName
+	for _, name := range []string{"web", "web/blue", "a/b/c"} {
+		docker.CreateNetwork(&dockerclient.NetworkCreate{Name: name, Driver: driver})
+	}
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "foreign", Driver: "overlay"})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   "tmp/blue",
+		Driver: driver,
+		Labels: map[string]string{ephemeralLabel: "true"},
+	})
+
+	report, err := AdoptAll()
+	if err != nil {
+		t.Fatalf("Error adopting networks. Err: %v", err)
+	}
+	sort.Strings(report.Adopted)
+	if !reflect.DeepEqual(report.Adopted, []string{"web", "web/blue"}) ||
+		!reflect.DeepEqual(report.AlreadyTracked, []string{"tracked/blue"}) ||
+		!reflect.DeepEqual(report.Unparseable, []string{"a/b/c"}) || len(report.Failed) != 0 {
+		t.Fatalf("Unexpected adopt report %+v", report)
+	}
+	if getDocknetState("default", "web", "") == nil || getDocknetState("blue", "web", "") == nil {
+		t.Fatalf("networks were not adopted")
+	}
+	if getDocknetState("blue", "tmp", "") != nil {
+		t.Fatalf("ephemeral network was adopted")
+	}
+
+	// adopting again finds everything tracked
+	report, err = AdoptAll()
+	if err != nil || len(report.Adopted) != 0 || len(report.AlreadyTracked) != 3 {
+		t.Fatalf("Unexpected adopt report %+v. Err: %v", report, err)
+	}
+}
+
+func TestMaxNameLength(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetMaxNameLength(0, false)
+
+	if err := SetMaxNameLength(-1, false); err == nil {
+		t.Fatalf("Negative name length limit was accepted")
+	}
+	if err := SetMaxNameLength(nameHashLen, true); err == nil {
+		t.Fatalf("Name length limit shorter than the hash was accepted")
+	}
+
+	// "net12/unit-test" is 15 characters
+	SetMaxNameLength(15, false)
+	for _, nwName := range []string{"net1", "net12"} {
+		if err := CreateDockNet("unit-test", nwName, "", fakeNwCfg("unit-test", nwName)); err != nil {
+			t.Fatalf("Error creating network %s. Err: %v", nwName, err)
+		}
+	}
+	err := CreateDockNet("unit-test", "net123", "", fakeNwCfg("unit-test", "net123"))
+	nameErr, ok := err.(*NameLengthError)
+	if !ok || nameErr.Kind != ErrNameTooLong || nameErr.Name != "net123/unit-test" || nameErr.Limit != 15 {
+		t.Fatalf("Unexpected error %#v", err)
+	}
+	opts := DockNetOptions{RawName: "a-very-long-raw-name"}
+	if err := CreateDockNetWithOptions("unit-test", "net3", "", fakeNwCfg("unit-test", "net3"), opts); err == nil {
+		t.Fatalf("Long raw name was accepted")
+	}
+
+	// truncated names fit the limit and stay unique
+	SetMaxNameLength(15, true)
+	if name := GetDocknetName("unit-test", "net12", ""); name != "net12/unit-test" {
+		t.Fatalf("Name within the limit was truncated to %s", name)
+	}
+	name1 := GetDocknetName("unit-test", "net123", "")
+	name2 := GetDocknetName("unit-test", "net124", "")
+	if len(name1) != 15 || len(name2) != 15 || name1 == name2 {
+		t.Fatalf("Unexpected truncated names %s, %s", name1, name2)
+	}
+	if err := CreateDockNet("unit-test", "net123", "", fakeNwCfg("unit-test", "net123")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(name1); err != nil {
+		t.Fatalf("Network was not created with the truncated name. Err: %v", err)
+	}
+	if operID, err := DocknetNameToOperID(name1); err != nil || operID != "unit-test.net123." {
+		t.Fatalf("Truncated name mapped to %s. Err: %v", operID, err)
+	}
+	if err := DeleteDockNet("unit-test", "net123", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if _, err := docker.InspectNetwork(name1); err == nil {
+		t.Fatalf("Network with the truncated name was not deleted")
+	}
+}
+
+func TestNameScheme(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetNameScheme(nil)
+
+	if _, err := ParseNameScheme("tenant-infix", ""); err != ErrInvalidNameScheme {
+		t.Fatalf("Expected ErrInvalidNameScheme, got: %v", err)
+	}
+	if _, err := ParseNameScheme(TenantPrefixScheme, ":"); err != ErrInvalidNameScheme {
+		t.Fatalf("Expected ErrInvalidNameScheme for separator ':', got: %v", err)
+	}
+
+	// existing docknets keep their legacy names
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	schemeTests := []struct {
+		name      string
+		separator string
+		expName   string
+	}{
+		{TenantSuffixScheme, "", "net2/unit-test"},
+		{TenantPrefixScheme, "-", "unit-test-net2"},
+		{FlatScheme, "", "net2"},
+	}
+	for _, test := range schemeTests {
+		scheme, err := ParseNameScheme(test.name, test.separator)
+		if err != nil {
+			t.Fatalf("Error parsing name scheme %s. Err: %v", test.name, err)
+		}
+		SetNameScheme(scheme)
+
+		if name := GetDocknetName("unit-test", "net2", ""); name != test.expName {
+			t.Fatalf("Scheme %s named the network %s, expected %s", test.name, name, test.expName)
+		}
+		if name := GetDocknetName("default", "net2", ""); name != "net2" {
+			t.Fatalf("Scheme %s named the default tenant network %s", test.name, name)
+		}
+		if err := CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2")); err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+		if _, err := docker.InspectNetwork(test.expName); err != nil {
+			t.Fatalf("Network was not created as %s. Err: %v", test.expName, err)
+		}
+
+		// names of both schemes are parsed
+		for docknetName, expNetwork := range map[string]string{test.expName: "net2", "net1/unit-test": "net1"} {
+			tenantName, nwName, _, err := ParseDocknetName(docknetName)
+			if err != nil || tenantName != "unit-test" || nwName != expNetwork {
+				t.Fatalf("Name %s parsed as %s, %s. Err: %v", docknetName, tenantName, nwName, err)
+			}
+		}
+		if name := getDocknetState("unit-test", "net1", "").DocknetName(); name != "net1/unit-test" {
+			t.Fatalf("Existing docknet was renamed %s by scheme %s", name, test.name)
+		}
+
+		if err := DeleteDockNet("unit-test", "net2", ""); err != nil {
+			t.Fatalf("Error deleting network. Err: %v", err)
+		}
+		if _, err := docker.InspectNetwork(test.expName); err == nil {
+			t.Fatalf("Network %s was not deleted", test.expName)
+		}
+	}
+}
+
+// readAllCountingDriver is a state driver counting the reads of all the
+// states under a base key
+type readAllCountingDriver struct {
+	core.StateDriver
+	readAlls int
+}
+
+func (d *readAllCountingDriver) ReadAll(baseKey string) ([][]byte, error) {
+	d.readAlls++
+	return d.StateDriver.ReadAll(baseKey)
+}
+
+func (d *readAllCountingDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	d.readAlls++
+	return d.StateDriver.(state.KeyReader).ReadAllKeys(baseKey)
+}
+
+func (d *readAllCountingDriver) ReadAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	d.readAlls++
+	return d.StateDriver.ReadAllState(baseKey, sType, unmarshal)
+}
+
+func TestParseDocknetNameLookup(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetReadStateDriver(nil)
+
+	addFakeEpg(t, mastercfg.GetEndpointGroupKey("epg1", "blue"), "blue", "net1", "epg1")
+	if err := CreateDockNet("blue", "net1", "", fakeNwCfg("blue", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if err := CreateDockNet("blue", "net1", "epg1", fakeNwCfg("blue", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	stateDriver, _ := utils.GetStateDriver()
+	counting := &readAllCountingDriver{StateDriver: stateDriver}
+	SetReadStateDriver(counting)
+
+	nameTests := map[string]DocknetName{
+		"net1/blue": {Tenant: "blue", Network: "net1"},
+		"epg1/blue": {Tenant: "blue", Network: "net1", Service: "epg1", EPG: "epg1"},
+	}
+	for docknetName, expName := range nameTests {
+		name, err := ParseDocknetNameStruct(docknetName)
+		if err != nil || name != expName {
+			t.Fatalf("%q parsed as %+v, expected %+v. Err: %v", docknetName, name, expName, err)
+		}
+	}
+	if counting.readAlls != 0 {
+		t.Fatalf("Names of existing docknets were parsed with %d full reads", counting.readAlls)
+	}
+
+	// names without a docknet are parsed by reading all of them
+	if name, err := ParseDocknetNameStruct("web/blue"); err != nil || name.Network != "web" {
+		t.Fatalf("Unexpected name %+v. Err: %v", name, err)
+	}
+	if counting.readAlls == 0 {
+		t.Fatalf("Name without a docknet was not looked up in all docknets")
+	}
+}
@@ -0,0 +1,121 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// OptionValidation selects how user-provided driver options with keys that
+// have no registered schema are handled
+type OptionValidation int
+
+const (
+	// LenientOptions warns about unknown option keys
+	LenientOptions OptionValidation = iota
+	// StrictOptions rejects unknown option keys
+	StrictOptions
+)
+
+var (
+	// ErrUnknownOption is the Kind of OptionError for a key with no schema
+	ErrUnknownOption = errors.New("unknown network option")
+
+	// ErrInvalidOption is the Kind of OptionError for a value rejected by
+	// the validator of its key
+	ErrInvalidOption = errors.New("invalid network option")
+)
+
+// OptionError is returned when a user-provided driver option is rejected.
+// Value is redacted for sensitive options.
+type OptionError struct {
+	Kind  error
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *OptionError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%v %s", e.Kind, e.Key)
+	}
+
+	return fmt.Sprintf("%v %s=%q: %v", e.Kind, e.Key, e.Value, e.Err)
+}
+
+// RegisterOptionSchema registers a driver option key that may be set in
+// DockNetOptions.OptionsOverride. The validator checks the values of the key,
+// a nil validator accepts any value. The options computed by docknet are
+// always known.
+func RegisterOptionSchema(key string, validator func(string) error) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	// copy on write, snapshots may be using the old map
+	schema := make(map[string]func(string) error, len(pkgConfig.optionSchema)+1)
+	for k, v := range pkgConfig.optionSchema {
+		schema[k] = v
+	}
+	schema[key] = validator
+	pkgConfig.optionSchema = schema
+}
+
+// clearOptionSchema removes all registered option keys
+func clearOptionSchema() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.optionSchema = nil
+}
+
+// SetOptionValidation sets whether unknown option keys are rejected or only
+// logged. Values of known keys are always validated.
+func SetOptionValidation(mode OptionValidation) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.optionValidation = mode
+}
+
+// validateOptions returns the user-provided options with the surrounding
+// spaces of keys and values trimmed, after validating them against the
+// registered schema
+func (c config) validateOptions(docknetName string, opts map[string]string) (map[string]string, error) {
+	normalized := make(map[string]string, len(opts))
+	for key, val := range opts {
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+
+		validator, known := c.optionSchema[key]
+		switch {
+		case known && validator != nil:
+			if err := validator(val); err != nil {
+				return nil, &OptionError{Kind: ErrInvalidOption, Key: key, Value: c.redactValue(key, val), Err: err}
+			}
+		case known || reservedOptions[key]:
+		case c.optionValidation == StrictOptions:
+			return nil, &OptionError{Kind: ErrUnknownOption, Key: key}
+		default:
+			log.Warnf("Unknown option %s of network %s", key, docknetName)
+		}
+
+		normalized[key] = val
+	}
+
+	return normalized, nil
+}
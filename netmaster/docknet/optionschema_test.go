@@ -0,0 +1,109 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+// validateMTU accepts MTUs from 576 to 9216
+func validateMTU(val string) error {
+	mtu, err := strconv.Atoi(val)
+	if err != nil {
+		return err
+	}
+	if mtu < 576 || mtu > 9216 {
+		return fmt.Errorf("mtu %d is out of range", mtu)
+	}
+
+	return nil
+}
+
+func TestOptionSchema(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearOptionSchema()
+	defer SetOptionValidation(LenientOptions)
+
+	RegisterOptionSchema("mtu", validateMTU)
+	RegisterOptionSchema("description", nil)
+
+	for _, mode := range []OptionValidation{LenientOptions, StrictOptions} {
+		SetOptionValidation(mode)
+		netName := fmt.Sprintf("net%d", mode)
+
+		// known and valid, values are normalized
+		opts := DockNetOptions{OptionsOverride: map[string]string{" mtu": "9000 ", "description": "web tier"}}
+		if err := CreateDockNetWithOptions("unit-test", netName, "", fakeNwCfg("unit-test", netName), opts); err != nil {
+			t.Fatalf("Mode %d: error creating network with valid options. Err: %v", mode, err)
+		}
+		nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", netName, ""))
+		if nw.Options["mtu"] != "9000" || nw.Options["description"] != "web tier" {
+			t.Fatalf("Mode %d: options not normalized: %v", mode, nw.Options)
+		}
+
+		// reserved options are known
+		opts = DockNetOptions{OptionsOverride: map[string]string{"pkt-tag": "10"}}
+		if err := CreateDockNetWithOptions("unit-test", netName, "", fakeNwCfg("unit-test", netName), opts); err != nil {
+			t.Fatalf("Mode %d: error creating network with a reserved option. Err: %v", mode, err)
+		}
+
+		// known and invalid
+		opts = DockNetOptions{OptionsOverride: map[string]string{"mtu": "90000"}}
+		err := CreateDockNetWithOptions("unit-test", "bad", "", fakeNwCfg("unit-test", "bad"), opts)
+		if optErr, ok := err.(*OptionError); !ok || optErr.Kind != ErrInvalidOption || optErr.Key != "mtu" {
+			t.Fatalf("Mode %d: expected an invalid option error, got: %v", mode, err)
+		}
+	}
+
+	// unknown keys are only rejected in strict mode
+	opts := DockNetOptions{OptionsOverride: map[string]string{"mut": "9000"}}
+	err := CreateDockNetWithOptions("unit-test", "typo", "", fakeNwCfg("unit-test", "typo"), opts)
+	if optErr, ok := err.(*OptionError); !ok || optErr.Kind != ErrUnknownOption || optErr.Key != "mut" {
+		t.Fatalf("Expected an unknown option error, got: %v", err)
+	}
+	if getDocknetState("unit-test", "typo", "") != nil {
+		t.Fatalf("Network with an unknown option was created")
+	}
+
+	SetOptionValidation(LenientOptions)
+	if err := CreateDockNetWithOptions("unit-test", "typo", "", fakeNwCfg("unit-test", "typo"), opts); err != nil {
+		t.Fatalf("Error creating network with an unknown option in lenient mode. Err: %v", err)
+	}
+}
+
+func TestOptionErrorRedacted(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearOptionSchema()
+	defer clearSensitiveOptions()
+
+	MarkSensitiveOption("bgp-key")
+	RegisterOptionSchema("bgp-key", func(val string) error {
+		if len(val) < 8 {
+			return fmt.Errorf("key is too short")
+		}
+		return nil
+	})
+
+	opts := DockNetOptions{OptionsOverride: map[string]string{"bgp-key": "s3cret"}}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err == nil || err.Error() != `invalid network option bgp-key="[redacted]": key is too short` {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,260 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// DockNetSpec is a desired docknet
+type DockNetSpec struct {
+	TenantName  string
+	NetworkName string
+	ServiceName string
+	NwCfg       *mastercfg.CfgNetworkState
+	Options     DockNetOptions
+}
+
+// operID returns the oper state ID of the docknet
+func (spec *DockNetSpec) operID() string {
+	return docknetOperID(spec.TenantName, spec.NetworkName, spec.ServiceName)
+}
+
+// DockNetUpdate is a docknet that differs from its desired spec
+type DockNetUpdate struct {
+	Spec    DockNetSpec
+	Current *DnetOperState
+	Changes []string // the fields that differ
+}
+
+// DockNetPlan has the changes needed to make the docknets match a desired set
+type DockNetPlan struct {
+	Creates []DockNetSpec
+	Updates []DockNetUpdate
+	Deletes []*DnetOperState
+}
+
+// Empty returns true if the docknets already match the desired set
+func (p *DockNetPlan) Empty() bool {
+	return len(p.Creates) == 0 && len(p.Updates) == 0 && len(p.Deletes) == 0
+}
+
+// Plan compares the desired docknets with the docknet oper states and returns
+// the docknets to create, update and delete. Docknets that are not in the
+// desired set are deleted. Nothing is changed until the plan is applied.
+func Plan(desired []DockNetSpec) (DockNetPlan, error) {
+	plan := DockNetPlan{
+		Creates: []DockNetSpec{},
+		Updates: []DockNetUpdate{},
+		Deletes: []*DnetOperState{},
+	}
+	cfg := getConfig()
+
+	stateDriver, err := getWriteStateDriver()
+	if err != nil {
+		return plan, err
+	}
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil {
+		log.Errorf("Error getting docknet list. Err: %v", err)
+		return plan, err
+	}
+	current := make(map[string]*DnetOperState)
+	for _, dnet := range dnets {
+		current[dnet.ID] = dnet
+	}
+
+	desiredIDs := make(map[string]bool)
+	for _, spec := range desired {
+		operID := spec.operID()
+		if desiredIDs[operID] {
+			return plan, fmt.Errorf("docknet %s is specified more than once", operID)
+		}
+		if spec.Options.Ephemeral {
+			return plan, fmt.Errorf("docknet %s is ephemeral and can not be planned", operID)
+		}
+		desiredIDs[operID] = true
+
+		nwCreate, err := buildSpecNetworkCreate(cfg, spec)
+		if err != nil {
+			return plan, fmt.Errorf("invalid docknet %s: %v", operID, err)
+		}
+
+		dnet, ok := current[operID]
+		if !ok {
+			plan.Creates = append(plan.Creates, spec)
+			continue
+		}
+
+		want := newDnetOper(spec.TenantName, spec.NetworkName, spec.ServiceName, spec.NwCfg, spec.Options, nwCreate)
+		if changes := docknetChanges(dnet, want); len(changes) > 0 {
+			plan.Updates = append(plan.Updates, DockNetUpdate{Spec: spec, Current: dnet, Changes: changes})
+		}
+	}
+
+	for _, dnet := range dnets {
+		if !desiredIDs[dnet.ID] {
+			plan.Deletes = append(plan.Deletes, dnet)
+		}
+	}
+
+	return plan, nil
+}
+
+// Apply makes the changes in a plan. Deletes are done first to free up names
+// and subnets, then updates and creates. Failures are collected and returned
+// together.
+func Apply(plan DockNetPlan) error {
+	errs := []string{}
+	for _, dnet := range plan.Deletes {
+		err := DeleteDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("delete %s: %v", dnet.ID, err))
+		}
+	}
+	for _, update := range plan.Updates {
+		if err := updateDockNet(update); err != nil {
+			errs = append(errs, fmt.Sprintf("update %s: %v", update.Current.ID, err))
+		}
+	}
+	for _, spec := range plan.Creates {
+		err := CreateDockNetWithOptions(spec.TenantName, spec.NetworkName, spec.ServiceName, spec.NwCfg, spec.Options)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("create %s: %v", spec.operID(), err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error applying docknet plan: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// buildSpecNetworkCreate builds the docker network create request of a spec
+func buildSpecNetworkCreate(cfg config, spec DockNetSpec) (*dockerclient.NetworkCreate, error) {
+	docknetName := truncateName(cfg, docknetNameOf(cfg, spec.TenantName, spec.NetworkName, spec.ServiceName))
+	if spec.Options.RawName != "" {
+		if err := validateRawName(spec.Options.RawName); err != nil {
+			return nil, err
+		}
+		docknetName = spec.Options.RawName
+	}
+
+	nwCfg, err := resolveGatewayHostnames(docknetName, spec.NwCfg, spec.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	nwCreate, err := buildNetworkCreate(cfg, docknetName, nwCfg, spec.Options)
+	if err != nil {
+		return nil, err
+	}
+	setObjectLabels(nwCreate, spec.TenantName, spec.NetworkName, spec.ServiceName)
+
+	return nwCreate, nil
+}
+
+// docknetChanges returns the fields of a docknet that differ from the desired
+// oper state
+func docknetChanges(current, want *DnetOperState) []string {
+	changes := []string{}
+	if current.DocknetName() != want.DocknetName() {
+		changes = append(changes, "name")
+	}
+	if !reflect.DeepEqual(current.Subnets, want.Subnets) {
+		changes = append(changes, "subnets")
+	}
+	if !stringMapsEqual(current.Options, want.Options) {
+		changes = append(changes, "options")
+	}
+	if !stringMapsEqual(current.IPAMOptions, want.IPAMOptions) {
+		changes = append(changes, "ipamOptions")
+	}
+	if current.Default != want.Default {
+		changes = append(changes, "default")
+	}
+
+	return changes
+}
+
+// stringMapsEqual compares two maps, treating nil and empty maps as equal
+func stringMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, val := range a {
+		if bval, ok := b[key]; !ok || bval != val {
+			return false
+		}
+	}
+
+	return true
+}
+
+// updateDockNet replaces the docker network of a docknet with one matching
+// its spec, moving the attached containers to the new network
+func updateDockNet(update DockNetUpdate) error {
+	spec := update.Spec
+	nwCreate, err := buildSpecNetworkCreate(getConfig(), spec)
+	if err != nil {
+		return err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return errors.New("Unable to connect to docker")
+	}
+
+	logInfof("Updating docknet %s: %v", update.Current.ID, update.Changes)
+
+	var nwID string
+	nw, err := docker.InspectNetwork(update.Current.DocknetUUID)
+	if isNotFound(err) {
+		resp, err := docker.CreateNetwork(nwCreate)
+		if err != nil {
+			err = getConfig().redactCreateError(nwCreate, err)
+			log.Errorf("Error creating network %s. Err: %v", nwCreate.Name, err)
+			return wrapIPAMError(err)
+		}
+		nwID = resp.ID
+	} else if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", update.Current.ID, err)
+		return err
+	} else {
+		nwID, _, err = replaceDockerNetwork(docker, nw, nwCreate)
+		if err != nil {
+			return err
+		}
+	}
+
+	dnetOper := newDnetOper(spec.TenantName, spec.NetworkName, spec.ServiceName, spec.NwCfg, spec.Options, nwCreate)
+	dnetOper.DocknetUUID = nwID
+	dnetOper.CreatedAt = update.Current.CreatedAt
+	dnetOper.StateDriver = update.Current.StateDriver
+
+	return dnetOper.writeDocknet()
+}
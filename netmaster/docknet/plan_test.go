@@ -0,0 +1,92 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestPlanApply(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	for _, nwName := range []string{"net1", "net2", "net4"} {
+		err := CreateDockNet("unit-test", nwName, "", fakeNwCfg("unit-test", nwName))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+	docker.addContainer("ctr1", "net1/unit-test")
+
+	// net1 moves to a new vlan, net2 goes away, net3 is new, net4 is unchanged
+	nwCfg1 := fakeNwCfg("unit-test", "net1")
+	nwCfg1.PktTag = 20
+	desired := []DockNetSpec{
+		{TenantName: "unit-test", NetworkName: "net1", NwCfg: nwCfg1},
+		{TenantName: "unit-test", NetworkName: "net3", NwCfg: fakeNwCfg("unit-test", "net3")},
+		{TenantName: "unit-test", NetworkName: "net4", NwCfg: fakeNwCfg("unit-test", "net4")},
+	}
+
+	plan, err := Plan(desired)
+	if err != nil {
+		t.Fatalf("Error planning. Err: %v", err)
+	}
+	if len(plan.Creates) != 1 || plan.Creates[0].NetworkName != "net3" {
+		t.Fatalf("Unexpected creates %+v", plan.Creates)
+	}
+	if len(plan.Deletes) != 1 || plan.Deletes[0].NetworkName != "net2" {
+		t.Fatalf("Unexpected deletes %+v", plan.Deletes)
+	}
+	if len(plan.Updates) != 1 || plan.Updates[0].Current.NetworkName != "net1" ||
+		len(plan.Updates[0].Changes) != 1 || plan.Updates[0].Changes[0] != "options" {
+		t.Fatalf("Unexpected updates %+v", plan.Updates)
+	}
+
+	// planning changes nothing
+	if getDocknetState("unit-test", "net2", "") == nil || getDocknetState("unit-test", "net3", "") != nil {
+		t.Fatalf("Planning changed the oper state")
+	}
+
+	if err := Apply(plan); err != nil {
+		t.Fatalf("Error applying plan. Err: %v", err)
+	}
+
+	if dnet := getDocknetState("unit-test", "net1", ""); dnet == nil || dnet.PktTag != 20 {
+		t.Fatalf("net1 was not updated: %+v", dnet)
+	}
+	nw, err := docker.InspectNetwork("net1/unit-test")
+	if err != nil || nw.Options["pkt-tag"] != "20" || len(nw.Containers) != 1 {
+		t.Fatalf("docker network of net1 was not updated: %+v. Err: %v", nw, err)
+	}
+	if getDocknetState("unit-test", "net2", "") != nil {
+		t.Fatalf("net2 was not deleted")
+	}
+	if getDocknetState("unit-test", "net3", "") == nil {
+		t.Fatalf("net3 was not created")
+	}
+
+	// nothing left to do
+	plan, err = Plan(desired)
+	if err != nil || !plan.Empty() {
+		t.Fatalf("Unexpected plan after apply %+v. Err: %v", plan, err)
+	}
+
+	// invalid specs fail planning
+	desired = append(desired, desired[0])
+	if _, err := Plan(desired); err == nil {
+		t.Fatalf("Duplicate spec was accepted")
+	}
+}
@@ -0,0 +1,177 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Subnet pools are named CIDRs that docknets take their subnet from. A pool
+// can be used whole by a single docknet, or shared by carving it into
+// smaller subnets. The subnets in use are found from the oper state of the
+// docknets using the pool, so nothing else needs to be persisted.
+
+var (
+	// ErrSubnetPoolNotFound is returned for a pool that is not registered
+	ErrSubnetPoolNotFound = errors.New("subnet pool not found")
+
+	// ErrSubnetPoolExhausted is returned when a pool has no free subnet
+	ErrSubnetPoolExhausted = errors.New("subnet pool is exhausted")
+)
+
+var (
+	// poolMutex protects subnetPools and serializes subnet allocation
+	poolMutex   sync.Mutex
+	subnetPools = make(map[string]*net.IPNet)
+)
+
+// RegisterSubnetPool adds a named subnet pool
+func RegisterSubnetPool(name, cidr string) error {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+	if pool, ok := subnetPools[name]; ok && pool.String() != ipNet.String() {
+		return fmt.Errorf("subnet pool %s is already registered as %s", name, pool)
+	}
+	subnetPools[name] = ipNet
+
+	return nil
+}
+
+// UnregisterSubnetPool removes a named subnet pool. Docknets already using
+// the pool keep their subnets.
+func UnregisterSubnetPool(name string) {
+	poolMutex.Lock()
+	defer poolMutex.Unlock()
+	delete(subnetPools, name)
+}
+
+// allocatePoolSubnet returns a network config using a free subnet of the
+// pool. The subnet is the whole pool when prefixLen is 0. The gateway is the
+// first host of the subnet, except for L2 only networks. poolMutex must be
+// held until the docknet oper state is written.
+func allocatePoolSubnet(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (*mastercfg.CfgNetworkState, error) {
+	pool, ok := subnetPools[opts.SubnetPool]
+	if !ok {
+		return nil, ErrSubnetPoolNotFound
+	}
+
+	poolLen, bits := pool.Mask.Size()
+	prefixLen := opts.SubnetPoolPrefixLen
+	if prefixLen == 0 {
+		prefixLen = poolLen
+	}
+	if prefixLen < poolLen || prefixLen > bits {
+		return nil, fmt.Errorf("invalid prefix length %d for subnet pool %s", prefixLen, pool)
+	}
+
+	// subnets of the pool in use
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil && core.ErrIfKeyExists(err) != nil {
+		log.Errorf("Error getting docknet list. Err: %v", err)
+		return nil, err
+	}
+	used := []*net.IPNet{}
+	for _, dnet := range dnets {
+		if dnet.SubnetPool != opts.SubnetPool {
+			continue
+		}
+		for _, subnet := range dnet.Subnets {
+			if _, ipNet, err := net.ParseCIDR(subnet.Subnet); err == nil && pool.Contains(ipNet.IP) {
+				used = append(used, ipNet)
+			}
+		}
+	}
+
+	subnet := &net.IPNet{IP: pool.IP, Mask: net.CIDRMask(prefixLen, bits)}
+	for ; pool.Contains(subnet.IP); subnet = nextSubnet(subnet) {
+		if !overlapsAny(subnet, used) {
+			break
+		}
+	}
+	if !pool.Contains(subnet.IP) {
+		return nil, ErrSubnetPoolExhausted
+	}
+
+	poolCfg := *nwCfg
+	poolCfg.SubnetIP = subnet.IP.String()
+	poolCfg.SubnetLen = uint(prefixLen)
+	poolCfg.Gateway = ""
+	if !opts.L2Only && bits-prefixLen > 1 {
+		poolCfg.Gateway = nextIP(subnet.IP).String()
+	}
+
+	return &poolCfg, nil
+}
+
+// nextSubnet returns the subnet of the same size following a subnet. The IP
+// wraps around to zero after the last subnet of the address space.
+func nextSubnet(subnet *net.IPNet) *net.IPNet {
+	ones, bits := subnet.Mask.Size()
+	ip := make(net.IP, len(subnet.IP))
+	copy(ip, subnet.IP)
+
+	// add one at the last bit of the prefix
+	bit := uint(bits - ones)
+	for i := len(ip) - 1 - int(bit/8); i >= 0; i-- {
+		sum := uint(ip[i]) + (1 << (bit % 8))
+		ip[i] = byte(sum)
+		if sum < 256 {
+			break
+		}
+		bit = 0
+	}
+
+	return &net.IPNet{IP: ip, Mask: subnet.Mask}
+}
+
+// nextIP returns the address following ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// overlapsAny returns true if the subnet overlaps any of the other subnets
+func overlapsAny(subnet *net.IPNet, others []*net.IPNet) bool {
+	for _, other := range others {
+		if subnet.Contains(other.IP) || other.Contains(subnet.IP) {
+			return true
+		}
+	}
+
+	return false
+}
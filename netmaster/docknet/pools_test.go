@@ -0,0 +1,119 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestNextSubnet(t *testing.T) {
+	subnetTests := []struct{ subnet, next string }{
+		{"10.1.1.0/26", "10.1.1.64/26"},
+		{"10.1.1.192/26", "10.1.2.0/26"},
+		{"10.1.255.0/24", "10.2.0.0/24"},
+		{"10.0.0.0/8", "11.0.0.0/8"},
+		{"255.255.255.0/24", "0.0.0.0/24"},
+		{"2001:db8:0:ffff::/64", "2001:db8:1::/64"},
+	}
+
+	for _, st := range subnetTests {
+		_, subnet, _ := net.ParseCIDR(st.subnet)
+		if next := nextSubnet(subnet).String(); next != st.next {
+			t.Fatalf("Subnet after %s is %s, expected %s", st.subnet, next, st.next)
+		}
+	}
+}
+
+func TestSubnetPools(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer UnregisterSubnetPool("shared")
+	defer UnregisterSubnetPool("whole")
+
+	if err := RegisterSubnetPool("bad", "10.10.0.0"); err == nil {
+		t.Fatalf("Invalid pool was registered")
+	}
+	if err := RegisterSubnetPool("shared", "10.10.0.0/24"); err != nil {
+		t.Fatalf("Error registering pool. Err: %v", err)
+	}
+	if err := RegisterSubnetPool("shared", "10.20.0.0/24"); err == nil {
+		t.Fatalf("Pool was registered twice")
+	}
+	if err := RegisterSubnetPool("whole", "10.30.0.0/24"); err != nil {
+		t.Fatalf("Error registering pool. Err: %v", err)
+	}
+
+	opts := DockNetOptions{SubnetPool: "missing"}
+	err := CreateDockNetWithOptions("unit-test", "net0", "", fakeNwCfg("unit-test", "net0"), opts)
+	if err != ErrSubnetPoolNotFound {
+		t.Fatalf("Missing pool was resolved. Err: %v", err)
+	}
+
+	// the shared pool has four /26 subnets
+	opts = DockNetOptions{SubnetPool: "shared", SubnetPoolPrefixLen: 26}
+	for i := 0; i < 4; i++ {
+		nwName := fmt.Sprintf("net%d", i)
+		err := CreateDockNetWithOptions("unit-test", nwName, "", fakeNwCfg("unit-test", nwName), opts)
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+
+		subnet := fmt.Sprintf("10.10.0.%d/26", i*64)
+		gw := fmt.Sprintf("10.10.0.%d", i*64+1)
+		nw, err := docker.InspectNetwork(GetDocknetName("unit-test", nwName, ""))
+		if err != nil || nw.IPAM.Config[0].Subnet != subnet || nw.IPAM.Config[0].Gateway != gw {
+			t.Fatalf("Network %s was not given %s: %+v. Err: %v", nwName, subnet, nw, err)
+		}
+		if dnet := getDocknetState("unit-test", nwName, ""); dnet == nil || dnet.SubnetPool != "shared" {
+			t.Fatalf("Pool was not saved in oper state: %+v", dnet)
+		}
+	}
+	err = CreateDockNetWithOptions("unit-test", "net4", "", fakeNwCfg("unit-test", "net4"), opts)
+	if err != ErrSubnetPoolExhausted {
+		t.Fatalf("Exhausted pool allocated a subnet. Err: %v", err)
+	}
+
+	// deleted networks free their subnet
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	err = CreateDockNetWithOptions("unit-test", "net4", "", fakeNwCfg("unit-test", "net4"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net4", "")); nw.IPAM.Config[0].Subnet != "10.10.0.64/26" {
+		t.Fatalf("Freed subnet was not reused: %+v", nw)
+	}
+
+	// the whole pool goes to one network
+	opts = DockNetOptions{SubnetPool: "whole", L2Only: true}
+	nwCfg := fakeNwCfg("unit-test", "net5")
+	nwCfg.Gateway = ""
+	if err := CreateDockNetWithOptions("unit-test", "net5", "", nwCfg, opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net5", ""))
+	if nw.IPAM.Config[0].Subnet != "10.30.0.0/24" || nw.IPAM.Config[0].Gateway != "" {
+		t.Fatalf("Network was not given the whole pool: %+v", nw)
+	}
+	nwCfg = fakeNwCfg("unit-test", "net6")
+	nwCfg.Gateway = ""
+	if err := CreateDockNetWithOptions("unit-test", "net6", "", nwCfg, opts); err != ErrSubnetPoolExhausted {
+		t.Fatalf("Used pool was allocated again. Err: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// ErrPrefixProviderMissing is returned when a network asks for a
+	// delegated IPv6 prefix and no prefix provider is registered
+	ErrPrefixProviderMissing = errors.New("no IPv6 prefix provider registered")
+
+	// ErrPrefixTimeout is returned when the prefix provider has no prefix
+	// for the network before the timeout
+	ErrPrefixTimeout = errors.New("timed out waiting for a delegated IPv6 prefix")
+
+	// ErrInvalidPrefix is returned when the prefix provider returns a prefix
+	// that is not an IPv6 CIDR
+	ErrInvalidPrefix = errors.New("invalid delegated IPv6 prefix")
+)
+
+// defaultPrefixTimeout is how long to wait for a delegated prefix when the
+// network does not set a timeout
+const defaultPrefixTimeout = 30 * time.Second
+
+// prefixPollInterval is how often the prefix provider is asked for a prefix
+var prefixPollInterval = time.Second
+
+// IPv6PrefixProvider returns the IPv6 subnet delegated to a network, eg. by
+// DHCPv6 prefix delegation, in CIDR notation. It returns an empty string
+// while no prefix is available yet.
+type IPv6PrefixProvider func(tenantName, networkName string) (string, error)
+
+// RegisterIPv6PrefixProvider sets the provider of delegated IPv6 prefixes.
+// Setting nil removes the provider.
+func RegisterIPv6PrefixProvider(provider IPv6PrefixProvider) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.prefixProvider = provider
+}
+
+// resolveDelegatedPrefix returns a copy of the network config with the IPv6
+// subnet set to the prefix delegated to the network. It waits for the prefix
+// provider to have a prefix, up to the IPv6PrefixTimeout of the options.
+func resolveDelegatedPrefix(cfg config, docknetName string, nwCfg *mastercfg.CfgNetworkState,
+	opts DockNetOptions) (*mastercfg.CfgNetworkState, error) {
+	if cfg.prefixProvider == nil {
+		log.Errorf("Network %s needs a delegated IPv6 prefix, no provider registered", docknetName)
+		return nil, ErrPrefixProviderMissing
+	}
+
+	timeout := opts.IPv6PrefixTimeout
+	if timeout == 0 {
+		timeout = defaultPrefixTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		prefix, err := cfg.prefixProvider(nwCfg.Tenant, nwCfg.NetworkName)
+		if err != nil {
+			log.Errorf("Error getting IPv6 prefix for network %s. Err: %v", docknetName, err)
+			return nil, err
+		}
+		if prefix != "" {
+			_, ipNet, err := net.ParseCIDR(prefix)
+			if err != nil || ipNet.IP.To4() != nil {
+				log.Errorf("Invalid IPv6 prefix %q for network %s", prefix, docknetName)
+				return nil, ErrInvalidPrefix
+			}
+
+			resolved := *nwCfg
+			resolved.IPv6Subnet = ipNet.IP.String()
+			ones, _ := ipNet.Mask.Size()
+			resolved.IPv6SubnetLen = uint(ones)
+			logInfof("Using delegated IPv6 prefix %s for network %s", ipNet, docknetName)
+			return &resolved, nil
+		}
+
+		if !time.Now().Add(prefixPollInterval).Before(deadline) {
+			log.Errorf("No IPv6 prefix delegated to network %s in %v", docknetName, timeout)
+			return nil, ErrPrefixTimeout
+		}
+		time.Sleep(prefixPollInterval)
+	}
+}
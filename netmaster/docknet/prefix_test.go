@@ -0,0 +1,72 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelegatedIPv6Prefix(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer RegisterIPv6PrefixProvider(nil)
+
+	origInterval := prefixPollInterval
+	defer func() { prefixPollInterval = origInterval }()
+	prefixPollInterval = time.Millisecond
+
+	opts := DockNetOptions{
+		IPv6PrefixDelegation: true,
+		IPv6PrefixTimeout:    time.Second,
+		IPv6GatewayMode:      IPv6GatewayAddr1,
+	}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != ErrPrefixProviderMissing {
+		t.Fatalf("Expected ErrPrefixProviderMissing, got: %v", err)
+	}
+
+	// the prefix is delegated on the second call
+	calls := 0
+	RegisterIPv6PrefixProvider(func(tenantName, networkName string) (string, error) {
+		calls++
+		if networkName != "net1" || calls < 2 {
+			return "", nil
+		}
+		return "2001:db8:1::/56", nil
+	})
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("Prefix provider was called %d times", calls)
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if len(nw.IPAM.Config) != 2 || nw.IPAM.Config[1].Subnet != "2001:db8:1::/56" ||
+		nw.IPAM.Config[1].Gateway != "2001:db8:1::1" {
+		t.Fatalf("Unexpected IPAM config %+v", nw.IPAM.Config)
+	}
+
+	// no prefix for net2
+	opts.IPv6PrefixTimeout = 20 * time.Millisecond
+	err = CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+	if err != ErrPrefixTimeout {
+		t.Fatalf("Expected ErrPrefixTimeout, got: %v", err)
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); err == nil {
+		t.Fatalf("Network without a prefix was created")
+	}
+}
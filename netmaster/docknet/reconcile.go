@@ -0,0 +1,257 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/contiv/netplugin/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ReconcileMode selects what Reconcile may change. There is no default mode,
+// the zero value is rejected.
+type ReconcileMode int
+
+const (
+	// ReportOnly only reports the differences between the oper state and
+	// docker, nothing is changed
+	ReportOnly ReconcileMode = iota + 1
+	// RepairSafe adopts orphan docker networks whose name maps to a docknet,
+	// and recreates docker networks missing for an oper state. Nothing is
+	// deleted.
+	RepairSafe
+	// RepairDestructive also deletes the orphan docker networks that can not
+	// be adopted
+	RepairDestructive
+)
+
+// ErrInvalidReconcileMode is returned for an unknown reconcile mode
+var ErrInvalidReconcileMode = errors.New("invalid reconcile mode")
+
+func (m ReconcileMode) String() string {
+	switch m {
+	case ReportOnly:
+		return "report-only"
+	case RepairSafe:
+		return "repair-safe"
+	case RepairDestructive:
+		return "repair-destructive"
+	}
+
+	return fmt.Sprintf("ReconcileMode(%d)", int(m))
+}
+
+// ParseReconcileMode returns the reconcile mode named by s, as returned by
+// String
+func ParseReconcileMode(s string) (ReconcileMode, error) {
+	for _, mode := range []ReconcileMode{ReportOnly, RepairSafe, RepairDestructive} {
+		if mode.String() == s {
+			return mode, nil
+		}
+	}
+
+	return 0, ErrInvalidReconcileMode
+}
+
+// ReconcileReport has the differences found by Reconcile and the repairs it
+// made. OrphanNetworks and MissingNetworks are the differences found before
+// any repair.
+type ReconcileReport struct {
+	Mode            ReconcileMode     `json:"mode"`
+	OrphanNetworks  []DockerNetInfo   `json:"orphanNetworks"`
+	MissingNetworks []string          `json:"missingNetworks"`
+	Adopted         []string          `json:"adopted"`
+	Recreated       []string          `json:"recreated"`
+	Deleted         []string          `json:"deleted"`
+	Failed          map[string]string `json:"failed,omitempty"`
+}
+
+// Reconcile compares the docknet oper states with the docker networks using
+// our driver, and repairs the differences the mode allows. Ephemeral networks
+// are not orphans and are left alone.
+func Reconcile(mode ReconcileMode) (ReconcileReport, error) {
+	report := ReconcileReport{
+		Mode:            mode,
+		OrphanNetworks:  []DockerNetInfo{},
+		MissingNetworks: []string{},
+		Adopted:         []string{},
+		Recreated:       []string{},
+		Deleted:         []string{},
+	}
+	if mode != ReportOnly && mode != RepairSafe && mode != RepairDestructive {
+		return report, ErrInvalidReconcileMode
+	}
+
+	dnets, err := ListDockNets()
+	if err != nil {
+		return report, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return report, errors.New("Unable to connect to docker")
+	}
+
+	nws, err := listDriverNetworks(docker, getConfig())
+	if err != nil {
+		log.Errorf("Error listing docker networks. Err: %v", err)
+		return report, err
+	}
+
+	dockerIDs := make(map[string]bool)
+	for _, nw := range nws {
+		dockerIDs[nw.ID] = true
+	}
+	missing := []*DnetOperState{}
+	for _, dnet := range dnets {
+		if !dockerIDs[dnet.DocknetUUID] {
+			missing = append(missing, dnet)
+			report.MissingNetworks = append(report.MissingNetworks, dnet.ID)
+		}
+	}
+	report.OrphanNetworks = orphanNetworks(dnets, nws)
+
+	if mode == ReportOnly {
+		return report, nil
+	}
+
+	failed := func(name string, err error) {
+		if report.Failed == nil {
+			report.Failed = make(map[string]string)
+		}
+		report.Failed[name] = err.Error()
+	}
+
+	// Get the state driver
+	stateDriver, err := utils.GetStateDriver()
+	if err != nil {
+		log.Warnf("Couldn't read global config %v", err)
+		return report, err
+	}
+
+	for _, dnet := range missing {
+		err := RecreateDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName)
+		if err != nil {
+			failed(dnet.ID, err)
+			continue
+		}
+		report.Recreated = append(report.Recreated, dnet.ID)
+	}
+
+	for _, orphan := range report.OrphanNetworks {
+		if orphan.OperID != "" {
+			nw, err := docker.InspectNetwork(orphan.ID)
+			if err != nil {
+				failed(orphan.Name, err)
+				continue
+			}
+			name, err := ParseDocknetNameStruct(orphan.Name)
+			if err != nil {
+				failed(orphan.Name, err)
+				continue
+			}
+			if _, err := adoptNetwork(stateDriver, name, nw); err != nil {
+				failed(orphan.Name, err)
+				continue
+			}
+			report.Adopted = append(report.Adopted, orphan.Name)
+			continue
+		}
+
+		if mode != RepairDestructive {
+			continue
+		}
+		log.Warnf("Deleting orphan docker network %s (%s)", orphan.Name, orphan.ID)
+		release := expectRemoval(orphan.Name)
+		err := docker.RemoveNetwork(orphan.ID)
+		release()
+		if err != nil {
+			log.Errorf("Error deleting docker network %s. Err: %v", orphan.Name, err)
+			failed(orphan.Name, err)
+			continue
+		}
+		report.Deleted = append(report.Deleted, orphan.Name)
+	}
+
+	if len(report.Failed) > 0 {
+		return report, fmt.Errorf("error repairing %d docker networks", len(report.Failed))
+	}
+
+	return report, nil
+}
+
+// reconcilePaused is set while reconcile loops skip their work
+var reconcilePaused int32
+
+// PauseReconcile makes the reconcile loops skip their work, eg. during a
+// maintenance window. The loops keep running, and other docknet operations
+// are not affected.
+func PauseReconcile() {
+	atomic.StoreInt32(&reconcilePaused, 1)
+	log.Warnf("docknet reconcile paused")
+}
+
+// ResumeReconcile makes the reconcile loops do their work again
+func ResumeReconcile() {
+	atomic.StoreInt32(&reconcilePaused, 0)
+	log.Warnf("docknet reconcile resumed")
+}
+
+// ReconcilePaused returns true if reconcile is paused
+func ReconcilePaused() bool {
+	return atomic.LoadInt32(&reconcilePaused) != 0
+}
+
+// StartReconcileLoop runs Reconcile in the background every interval, unless
+// reconcile is paused. The returned function stops the loop, and waits for a
+// reconcile in progress to complete.
+func StartReconcileLoop(interval time.Duration, mode ReconcileMode) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if ReconcilePaused() {
+				log.Debugf("docknet reconcile is paused, skipping")
+				continue
+			}
+			if _, err := Reconcile(mode); err != nil {
+				log.Errorf("Error reconciling docknets. Err: %v", err)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,164 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+// docknetUUIDs returns the docker network ID of each docknet
+func docknetUUIDs(t *testing.T) map[string]string {
+	dnets, err := ListDockNets()
+	if err != nil {
+		t.Fatalf("Error listing docknets. Err: %v", err)
+	}
+
+	uuids := make(map[string]string)
+	for _, dnet := range dnets {
+		uuids[dnet.ID] = dnet.DocknetUUID
+	}
+	return uuids
+}
+
+func TestReconcileModes(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	// net1 is consistent, net2 is missing in docker, "web" can be adopted
+	// and "a/b/c" can not
+	for _, netName := range []string{"net1", "net2"} {
+		if err := CreateDockNet("unit-test", netName, "", fakeNwCfg("unit-test", netName)); err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+	docker.RemoveNetwork(GetDocknetName("unit-test", "net2", ""))
+	driver := getConfig().netDriverName
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "web", Driver: driver})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "a/b/c", Driver: driver})
+	docker.CreateNetwork(&dockerclient.NetworkCreate{
+		Name:   "tmp/unit-test",
+		Driver: driver,
+		Labels: map[string]string{ephemeralLabel: "true"},
+	})
+
+	if _, err := Reconcile(0); err != ErrInvalidReconcileMode {
+		t.Fatalf("Expected ErrInvalidReconcileMode, got: %v", err)
+	}
+
+	// report only
+	nwCount := len(docker.networks)
+	before := docknetUUIDs(t)
+	report, err := Reconcile(ReportOnly)
+	if err != nil {
+		t.Fatalf("Error reconciling. Err: %v", err)
+	}
+	if len(report.OrphanNetworks) != 2 || !reflect.DeepEqual(report.MissingNetworks, []string{"unit-test.net2."}) ||
+		len(report.Adopted)+len(report.Recreated)+len(report.Deleted) != 0 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if len(docker.networks) != nwCount || !reflect.DeepEqual(before, docknetUUIDs(t)) {
+		t.Fatalf("Report only reconcile made changes")
+	}
+
+	// safe repairs
+	report, err = Reconcile(RepairSafe)
+	if err != nil {
+		t.Fatalf("Error reconciling. Err: %v", err)
+	}
+	if !reflect.DeepEqual(report.Adopted, []string{"web"}) ||
+		!reflect.DeepEqual(report.Recreated, []string{"unit-test.net2."}) || len(report.Deleted) != 0 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if _, err := docker.InspectNetwork("a/b/c"); err != nil {
+		t.Fatalf("Safe reconcile deleted a docker network")
+	}
+	if dnet := getDocknetState("default", "web", ""); dnet == nil {
+		t.Fatalf("Orphan network was not adopted")
+	}
+	if _, err := docker.InspectNetwork(GetDocknetName("unit-test", "net2", "")); err != nil {
+		t.Fatalf("Missing network was not recreated")
+	}
+
+	// destructive repairs
+	docker.outOfBand = nil
+	report, err = Reconcile(RepairDestructive)
+	if err != nil {
+		t.Fatalf("Error reconciling. Err: %v", err)
+	}
+	if !reflect.DeepEqual(report.Deleted, []string{"a/b/c"}) || len(report.Adopted) != 0 {
+		t.Fatalf("Unexpected report %+v", report)
+	}
+	if _, err := docker.InspectNetwork("a/b/c"); err == nil {
+		t.Fatalf("Orphan network was not deleted")
+	}
+	if len(docker.outOfBand) != 0 {
+		t.Fatalf("Orphan networks were removed without expectRemoval: %v", docker.outOfBand)
+	}
+	if _, err := docker.InspectNetwork("tmp/unit-test"); err != nil {
+		t.Fatalf("Ephemeral network was deleted")
+	}
+}
+
+func TestParseReconcileMode(t *testing.T) {
+	for _, mode := range []ReconcileMode{ReportOnly, RepairSafe, RepairDestructive} {
+		parsed, err := ParseReconcileMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Fatalf("Error parsing %s, got %v. Err: %v", mode, parsed, err)
+		}
+	}
+	for _, s := range []string{"", "repair", "ReconcileMode(0)"} {
+		if _, err := ParseReconcileMode(s); err != ErrInvalidReconcileMode {
+			t.Fatalf("Expected ErrInvalidReconcileMode for %q, got: %v", s, err)
+		}
+	}
+}
+
+func TestPauseReconcile(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer ResumeReconcile()
+
+	PauseReconcile()
+	if !ReconcilePaused() {
+		t.Fatalf("Reconcile was not paused")
+	}
+	stop := StartReconcileLoop(time.Millisecond, RepairSafe)
+	defer stop()
+
+	// other operations are not blocked
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// the orphan is not adopted while paused
+	docker.CreateNetwork(&dockerclient.NetworkCreate{Name: "web", Driver: getConfig().netDriverName})
+	time.Sleep(20 * time.Millisecond)
+	if getDocknetState("default", "web", "") != nil {
+		t.Fatalf("Reconcile ran while paused")
+	}
+
+	ResumeReconcile()
+	for i := 0; getDocknetState("default", "web", "") == nil; i++ {
+		if i == 100 {
+			t.Fatalf("Reconcile did not run after resuming")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
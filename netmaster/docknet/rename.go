@@ -0,0 +1,170 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/samalba/dockerclient"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// RenameResult is the outcome of renaming the tenant of one docknet
+type RenameResult struct {
+	OldID          string   `json:"oldID"`
+	NewID          string   `json:"newID"`
+	OldName        string   `json:"oldName"`
+	NewName        string   `json:"newName"`
+	Containers     int      `json:"containers"`
+	NotReconnected []string `json:"notReconnected,omitempty"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// RenameReport has the outcome of renaming a tenant for each of its docknets
+type RenameReport struct {
+	OldTenant string         `json:"oldTenant"`
+	NewTenant string         `json:"newTenant"`
+	Networks  []RenameResult `json:"networks"`
+}
+
+// RenameTenant moves all docknets of a tenant to a new tenant name. The
+// tenant is part of the docker network name and docker networks can not be
+// renamed, so every docker network of the tenant is recreated and its
+// containers are disconnected while that happens. Containers are reconnected
+// on a best effort basis. The outcome for each network is in the report, and
+// an error is returned if any network could not be renamed.
+func RenameTenant(oldTenant, newTenant string) (RenameReport, error) {
+	report := RenameReport{
+		OldTenant: oldTenant,
+		NewTenant: newTenant,
+		Networks:  []RenameResult{},
+	}
+
+	if newTenant == "" || newTenant == oldTenant ||
+		strings.Contains(newTenant, DocknetNameSeparator) || strings.Contains(newTenant, OperIDSeparator) {
+		return report, fmt.Errorf("invalid tenant name %q", newTenant)
+	}
+
+	// read from the primary, since the docknets are updated
+	stateDriver, err := getWriteStateDriver()
+	if err != nil {
+		return report, err
+	}
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil {
+		log.Errorf("Error getting docknet list. Err: %v", err)
+		return report, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return report, errors.New("Unable to connect to docker")
+	}
+
+	failed := 0
+	for _, dnet := range dnets {
+		if dnet.TenantName != oldTenant {
+			continue
+		}
+
+		result := renameDocknetTenant(docker, dnet, newTenant)
+		if result.Error != "" {
+			failed++
+		}
+		report.Networks = append(report.Networks, result)
+	}
+
+	if failed > 0 {
+		return report, fmt.Errorf("error renaming %d of %d networks of tenant %s", failed,
+			len(report.Networks), oldTenant)
+	}
+
+	return report, nil
+}
+
+// renameDocknetTenant recreates the docker network of a docknet under a new
+// tenant and moves its oper state
+func renameDocknetTenant(docker dockerclient.Client, dnet *DnetOperState, newTenant string) RenameResult {
+	newDnet := *dnet
+	newDnet.TenantName = newTenant
+	newDnet.ID = docknetOperID(newTenant, dnet.NetworkName, dnet.ServiceName)
+
+	result := RenameResult{
+		OldID:   dnet.ID,
+		NewID:   newDnet.ID,
+		OldName: dnet.DocknetName(),
+		NewName: newDnet.DocknetName(),
+	}
+
+	logInfof("Renaming docker network %s to %s", result.OldName, result.NewName)
+
+	// make sure nothing uses the new name before touching the network
+	if result.NewName != result.OldName {
+		if _, err := docker.InspectNetwork(result.NewName); err == nil {
+			result.Error = fmt.Sprintf("docker network %s already exists", result.NewName)
+			return result
+		}
+	}
+	if _, err := readDocknetOper(newTenant, dnet.NetworkName, dnet.ServiceName); err == nil {
+		result.Error = fmt.Sprintf("docknet %s already exists", newDnet.ID)
+		return result
+	}
+
+	nw, err := docker.InspectNetwork(dnet.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", result.OldName, err)
+		result.Error = err.Error()
+		return result
+	}
+	result.Containers = len(nw.Containers)
+
+	nwCreate := recreateRequest(dnet, nw)
+	nwCreate.Name = result.NewName
+	nwCreate.Options["tenant"] = newTenant
+	nwCreate.IPAM.Options = make(map[string]string)
+	for key, val := range nw.IPAM.Options {
+		nwCreate.IPAM.Options[key] = val
+	}
+	nwCreate.IPAM.Options["tenant"] = newTenant
+
+	nwID, notReconnected, err := replaceDockerNetwork(docker, nw, nwCreate)
+	result.NotReconnected = notReconnected
+	if err != nil {
+		// the old oper state is kept so that the network can be recreated
+		result.Error = err.Error()
+		return result
+	}
+
+	newDnet.DocknetUUID = nwID
+	newDnet.Options = nwCreate.Options
+	newDnet.IPAMOptions = nwCreate.IPAM.Options
+	if err := newDnet.Write(); err != nil {
+		log.Errorf("Error writing docknet %s. Err: %v", newDnet.ID, err)
+		result.Error = err.Error()
+		return result
+	}
+	if err := dnet.Clear(); err != nil {
+		log.Errorf("Error clearing docknet %s. Err: %v", dnet.ID, err)
+		result.Error = err.Error()
+	}
+
+	return result
+}
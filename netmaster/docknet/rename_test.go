@@ -0,0 +1,91 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestRenameTenant(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	for _, nwName := range []string{"net1", "net2"} {
+		err := CreateDockNet("blue", nwName, "", fakeNwCfg("blue", nwName))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+	docker.addContainer("ctr1", "net1/blue")
+
+	if _, err := RenameTenant("blue", "blue.1"); err == nil {
+		t.Fatalf("Invalid tenant name was accepted")
+	}
+
+	report, err := RenameTenant("blue", "red")
+	if err != nil {
+		t.Fatalf("Error renaming tenant. Err: %v", err)
+	}
+	if len(report.Networks) != 2 {
+		t.Fatalf("Unexpected rename report %+v", report)
+	}
+	for _, result := range report.Networks {
+		if result.Error != "" || len(result.NotReconnected) != 0 {
+			t.Fatalf("Unexpected rename result %+v", result)
+		}
+		if result.OldName == "net1/blue" && result.Containers != 1 {
+			t.Fatalf("Unexpected rename result %+v", result)
+		}
+	}
+
+	for _, nwName := range []string{"net1", "net2"} {
+		if _, err := docker.InspectNetwork(nwName + "/blue"); err == nil {
+			t.Fatalf("docker network %s/blue was not removed", nwName)
+		}
+		nw, err := docker.InspectNetwork(nwName + "/red")
+		if err != nil || nw.Options["tenant"] != "red" || nw.IPAM.Options["tenant"] != "red" {
+			t.Fatalf("docker network %s/red was not created: %+v. Err: %v", nwName, nw, err)
+		}
+		if getDocknetState("blue", nwName, "") != nil {
+			t.Fatalf("oper state of %s was not moved", nwName)
+		}
+		dnet := getDocknetState("red", nwName, "")
+		if dnet == nil || dnet.DocknetUUID != nw.ID || dnet.Options["tenant"] != "red" {
+			t.Fatalf("oper state of %s was not moved: %+v", nwName, dnet)
+		}
+	}
+	if ctr, _ := docker.InspectContainer("ctr1"); ctr.NetworkSettings.Networks["net1/red"] == nil {
+		t.Fatalf("container was not moved to the new network: %+v", ctr.NetworkSettings.Networks)
+	}
+
+	// networks whose new name is taken are left alone
+	err = CreateDockNet("green", "net1", "", fakeNwCfg("green", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	report, err = RenameTenant("red", "green")
+	if err == nil || len(report.Networks) != 2 {
+		t.Fatalf("Conflicting rename succeeded: %+v", report)
+	}
+	for _, result := range report.Networks {
+		if (result.Error != "") != (result.OldName == "net1/red") {
+			t.Fatalf("Unexpected rename result %+v", result)
+		}
+	}
+	if getDocknetState("red", "net1", "") == nil || getDocknetState("green", "net2", "") == nil {
+		t.Fatalf("Unexpected oper state after partial rename")
+	}
+}
@@ -0,0 +1,242 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/samalba/dockerclient"
+	"golang.org/x/net/context"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	defaultRetryAttempts  = 3
+	statusTooManyRequests = 429
+)
+
+var defaultRetryBackoff = ExponentialBackoff{
+	Initial:    100 * time.Millisecond,
+	Max:        2 * time.Second,
+	Multiplier: 2,
+}
+
+// Backoff decides how long to wait before retrying a failed call
+type Backoff interface {
+	// NextDelay returns the delay after the given failed attempt, counting
+	// from 1
+	NextDelay(attempt int) time.Duration
+}
+
+// ExponentialBackoff waits Initial after the first attempt and multiplies the
+// delay by Multiplier after each attempt, up to Max. A zero Max does not cap
+// the delay.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// NextDelay returns the delay after the given attempt
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	delay := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt-1))
+	if b.Max > 0 && delay > float64(b.Max) {
+		return b.Max
+	}
+
+	return time.Duration(delay)
+}
+
+// ConstantBackoff waits the same delay after every attempt
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+// NextDelay returns the delay after the given attempt
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration {
+	return b.Delay
+}
+
+// retry calls fn until it succeeds, fails with an error that is not worth
+// retrying, or runs out of attempts. It waits for the backoff delay between
+// attempts, and gives up early when ctx is cancelled.
+func retry(ctx context.Context, cfg config, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || attempt >= cfg.retryAttempts {
+			return err
+		}
+
+		delay := cfg.retryBackoff.NextDelay(attempt)
+		if after := retryAfter(err); after > 0 {
+			delay = after
+		}
+		log.Warnf("Attempt %d failed, retrying in %v. Err: %v", attempt, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// createNetworkRetry creates a docker network, retrying the failures docker
+// may recover from. A network of our driver created by an attempt whose
+// response was lost is used as is.
+func createNetworkRetry(cfg config, docker dockerclient.Client, nwCreate *dockerclient.NetworkCreate) (string, error) {
+	var nwID string
+	attempt := 0
+	err := retry(context.Background(), cfg, func() error {
+		attempt++
+		if attempt > 1 {
+			nw, err := docker.InspectNetwork(nwCreate.Name)
+			if err == nil && cfg.ownsDriver(nw.Driver) {
+				nwID = nw.ID
+				return nil
+			}
+		}
+
+		resp, err := docker.CreateNetwork(nwCreate)
+		if err != nil && isRetryable(err) {
+			// the error is logged by retry
+			return cfg.redactCreateError(nwCreate, err)
+		} else if err != nil {
+			return err
+		}
+		nwID = resp.ID
+		return nil
+	})
+
+	return nwID, err
+}
+
+// removeNetworkRetry removes a docker network, retrying the failures docker
+// may recover from
+func removeNetworkRetry(cfg config, docker dockerclient.Client, id string) error {
+	return retry(context.Background(), cfg, func() error {
+		return docker.RemoveNetwork(id)
+	})
+}
+
+// isNotFound returns true if docker did not find the network or container of
+// a request. dockerclient only returns ErrNotFound for 404 responses without
+// a body; docker sends a JSON message, which dockerclient returns as the
+// error, eg. {"message":"network web not found"}.
+func isNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == dockerclient.ErrNotFound {
+		return true
+	}
+	if dErr, ok := err.(dockerclient.Error); ok {
+		return dErr.StatusCode == http.StatusNotFound
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "no such")
+}
+
+// isRetryable returns false for errors that a retry will not fix
+func isRetryable(err error) bool {
+	if isNotFound(err) {
+		return false
+	}
+	if dErr, ok := err.(dockerclient.Error); ok {
+		return dErr.StatusCode >= 500 || dErr.StatusCode == statusTooManyRequests
+	}
+
+	return true
+}
+
+// dockerclient does not return the response headers with its errors, so the
+// Retry-After header of rate limited responses is added to the status, which
+// dockerclient does return.
+var retryAfterStatus = regexp.MustCompile(`\(retry after ([0-9a-zµ.]+)\)$`)
+
+// rateLimitTransport adds the Retry-After header of rate limited responses to
+// the response status
+type rateLimitTransport struct {
+	http.RoundTripper
+}
+
+// RoundTrip sends the request
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || resp.StatusCode != statusTooManyRequests {
+		return resp, err
+	}
+
+	if after := parseRetryAfter(resp.Header.Get("Retry-After")); after > 0 {
+		resp.Status = fmt.Sprintf("%s (retry after %v)", resp.Status, after)
+	}
+
+	return resp, nil
+}
+
+// watchRateLimit makes the docker client report the Retry-After header of
+// rate limited responses
+func watchRateLimit(docker *dockerclient.DockerClient) {
+	transport := docker.HTTPClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	docker.HTTPClient.Transport = rateLimitTransport{transport}
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return date.Sub(time.Now())
+	}
+
+	return 0
+}
+
+// retryAfter returns the delay asked for by a rate limited response, or 0
+func retryAfter(err error) time.Duration {
+	dErr, ok := err.(dockerclient.Error)
+	if !ok || dErr.StatusCode != statusTooManyRequests {
+		return 0
+	}
+
+	match := retryAfterStatus.FindStringSubmatch(dErr.Status)
+	if match == nil {
+		return 0
+	}
+	after, _ := time.ParseDuration(match[1])
+
+	return after
+}
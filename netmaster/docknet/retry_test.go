@@ -0,0 +1,240 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/samalba/dockerclient"
+	"golang.org/x/net/context"
+)
+
+func TestBackoffDelays(t *testing.T) {
+	backoffTests := []struct {
+		backoff Backoff
+		delays  []time.Duration
+	}{
+		{
+			ExponentialBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2},
+			[]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+				800 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			ExponentialBackoff{Initial: time.Second, Multiplier: 3},
+			[]time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 27 * time.Second},
+		},
+		{
+			ConstantBackoff{Delay: 50 * time.Millisecond},
+			[]time.Duration{50 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond},
+		},
+	}
+
+	for _, bt := range backoffTests {
+		for i, exp := range bt.delays {
+			if delay := bt.backoff.NextDelay(i + 1); delay != exp {
+				t.Fatalf("%+v delay after attempt %d is %v, expected %v", bt.backoff, i+1, delay, exp)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	origCfg := getConfig()
+	defer SetRetryBackoff(origCfg.retryBackoff)
+
+	if err := SetRetryBackoff(nil); err == nil {
+		t.Fatalf("nil backoff was accepted")
+	}
+	SetRetryBackoff(ConstantBackoff{Delay: time.Millisecond})
+
+	// retried until the attempts run out
+	calls := 0
+	connErr := errors.New("connection refused")
+	err := retry(context.Background(), getConfig(), func() error {
+		calls++
+		return connErr
+	})
+	if err != connErr || calls != defaultRetryAttempts {
+		t.Fatalf("retry returned %v after %d calls", err, calls)
+	}
+
+	// succeeds after a transient failure
+	calls = 0
+	err = retry(context.Background(), getConfig(), func() error {
+		calls++
+		if calls == 1 {
+			return dockerclient.Error{StatusCode: 503}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("retry returned %v after %d calls", err, calls)
+	}
+
+	// client errors are not retried
+	calls = 0
+	err = retry(context.Background(), getConfig(), func() error {
+		calls++
+		return dockerclient.ErrNotFound
+	})
+	if err != dockerclient.ErrNotFound || calls != 1 {
+		t.Fatalf("retry returned %v after %d calls", err, calls)
+	}
+
+	// as are the not found messages of docker
+	notFound := errors.New(`{"message":"network web not found"}`)
+	calls = 0
+	err = retry(context.Background(), getConfig(), func() error {
+		calls++
+		return notFound
+	})
+	if err != notFound || calls != 1 {
+		t.Fatalf("retry returned %v after %d calls", err, calls)
+	}
+
+	// cancellation stops the wait between attempts
+	SetRetryBackoff(ConstantBackoff{Delay: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	start := time.Now()
+	err = retry(ctx, getConfig(), func() error {
+		calls++
+		cancel()
+		return connErr
+	})
+	if err != context.Canceled || calls != 1 || time.Since(start) > time.Minute {
+		t.Fatalf("retry returned %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	origCfg := getConfig()
+	defer SetRetryBackoff(origCfg.retryBackoff)
+	SetRetryBackoff(ConstantBackoff{Delay: time.Millisecond})
+
+	// docker daemon rate limiting the first request
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(statusTooManyRequests)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	docker, err := dockerclient.NewDockerClient(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error creating docker client. Err: %v", err)
+	}
+	watchRateLimit(docker)
+
+	start := time.Now()
+	err = retry(context.Background(), getConfig(), func() error {
+		_, err := docker.ListNetworks("")
+		return err
+	})
+	if err != nil || requests != 2 {
+		t.Fatalf("retry returned %v after %d requests", err, requests)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retry did not wait for the Retry-After delay, waited %v", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if after := parseRetryAfter("120"); after != 2*time.Minute {
+		t.Fatalf("Unexpected Retry-After %v", after)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if after := parseRetryAfter(date); after < 59*time.Minute || after > time.Hour {
+		t.Fatalf("Unexpected Retry-After %v", after)
+	}
+	if after := parseRetryAfter("soon"); after != 0 {
+		t.Fatalf("Unexpected Retry-After %v", after)
+	}
+
+	errTests := []struct {
+		err   error
+		after time.Duration
+	}{
+		{dockerclient.Error{StatusCode: 429, Status: "429 Too Many Requests (retry after 1m30s)"}, 90 * time.Second},
+		{dockerclient.Error{StatusCode: 429, Status: "429 Too Many Requests (retry after 500ms)"}, 500 * time.Millisecond},
+		{dockerclient.Error{StatusCode: 429, Status: "429 Too Many Requests"}, 0},
+		{dockerclient.Error{StatusCode: 503, Status: "503 Service Unavailable (retry after 1s)"}, 0},
+		{errors.New("connection refused"), 0},
+	}
+	for _, et := range errTests {
+		if after := retryAfter(et.err); after != et.after {
+			t.Fatalf("Retry-After of %v is %v, expected %v", et.err, after, et.after)
+		}
+	}
+}
+
+func TestCreateDeleteRetry(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetRetryAttempts(defaultRetryAttempts)
+
+	if err := SetRetryAttempts(0); err == nil {
+		t.Fatalf("0 retry attempts were accepted")
+	}
+
+	// docker briefly unavailable
+	docker.createFailures = 2
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// a network created by an attempt that failed is used
+	docker.lostCreates = 1
+	if err := CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	nws, _ := docker.ListNetworks("")
+	if len(nws) != 2 {
+		t.Fatalf("Expected 2 docker networks, got %d", len(nws))
+	}
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net2", ""))
+	if getDocknetState("unit-test", "net2", "").DocknetUUID != nw.ID {
+		t.Fatalf("docknet does not have the created network")
+	}
+
+	// the attempts run out
+	SetRetryAttempts(2)
+	docker.createFailures = 2
+	if err := CreateDockNet("unit-test", "net3", "", fakeNwCfg("unit-test", "net3")); err == nil {
+		t.Fatalf("Create succeeded after the attempts ran out")
+	}
+	docker.createFailures = 0
+
+	// deleting is idempotent
+	docker.RemoveNetwork(GetDocknetName("unit-test", "net1", ""))
+	for i := 0; i < 2; i++ {
+		if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+			t.Fatalf("Error deleting network. Err: %v", err)
+		}
+	}
+	if getDocknetState("unit-test", "net1", "") != nil {
+		t.Fatalf("docknet was not deleted")
+	}
+}
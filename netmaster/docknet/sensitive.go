@@ -0,0 +1,197 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/contiv/netplugin/state"
+	"github.com/samalba/dockerclient"
+)
+
+// redactedValue replaces the values of sensitive options in logs, errors and
+// diagnostics
+const redactedValue = "[redacted]"
+
+// MarkSensitiveOption marks a driver or IPAM option, or an annotation, whose
+// value must not be logged or shown in diagnostics, eg. a BGP key. Marking
+// the annotations option redacts all annotations. The option is also
+// redacted when inspecting the state store.
+func MarkSensitiveOption(key string) {
+	state.MarkSensitiveField(key)
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	// copy on write, snapshots may be using the old map
+	sensitive := make(map[string]bool, len(pkgConfig.sensitiveOptions)+1)
+	for k := range pkgConfig.sensitiveOptions {
+		sensitive[k] = true
+	}
+	sensitive[key] = true
+	pkgConfig.sensitiveOptions = sensitive
+}
+
+// clearSensitiveOptions removes all sensitive option marks
+func clearSensitiveOptions() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.sensitiveOptions = nil
+}
+
+// redactValue returns the value of an option as it may be shown
+func (c config) redactValue(key, val string) string {
+	if c.sensitiveOptions[key] {
+		return redactedValue
+	}
+	if key == annotationsOption {
+		return c.redactAnnotations(val)
+	}
+
+	return val
+}
+
+// redactOptions returns a copy of the options with the sensitive values
+// redacted
+func (c config) redactOptions(opts map[string]string) map[string]string {
+	if opts == nil {
+		return nil
+	}
+
+	redacted := make(map[string]string, len(opts))
+	for key, val := range opts {
+		redacted[key] = c.redactValue(key, val)
+	}
+
+	return redacted
+}
+
+// redactAnnotations returns the annotations JSON object with the values of
+// sensitive annotations redacted
+func (c config) redactAnnotations(annotations string) string {
+	if len(c.sensitiveOptions) == 0 || annotations == "" {
+		return annotations
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(annotations), &obj); err != nil {
+		// not an object, can not tell what is sensitive
+		return redactedValue
+	}
+
+	redacted := false
+	for key := range obj {
+		if c.sensitiveOptions[key] {
+			obj[key] = json.RawMessage(`"` + redactedValue + `"`)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return annotations
+	}
+
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return redactedValue
+	}
+
+	return string(buf)
+}
+
+// sensitiveValues returns the values of the sensitive options and
+// annotations of a network create request
+func (c config) sensitiveValues(nwCreate *dockerclient.NetworkCreate) []string {
+	if len(c.sensitiveOptions) == 0 {
+		return nil
+	}
+
+	values := []string{}
+	for _, opts := range []map[string]string{nwCreate.Options, nwCreate.IPAM.Options} {
+		for key, val := range opts {
+			if c.sensitiveOptions[key] && val != "" {
+				values = append(values, val)
+			}
+		}
+	}
+
+	var annotations map[string]interface{}
+	json.Unmarshal([]byte(nwCreate.Options[annotationsOption]), &annotations)
+	for key, val := range annotations {
+		if str, ok := val.(string); ok && c.sensitiveOptions[key] && str != "" {
+			values = append(values, str)
+		}
+	}
+
+	return values
+}
+
+// redactNetworkCreate returns a copy of a network create request with the
+// sensitive values redacted, for logging
+func (c config) redactNetworkCreate(nwCreate *dockerclient.NetworkCreate) *dockerclient.NetworkCreate {
+	redacted := *nwCreate
+	redacted.Options = c.redactOptions(nwCreate.Options)
+	redacted.IPAM.Options = c.redactOptions(nwCreate.IPAM.Options)
+
+	return &redacted
+}
+
+// redactCreateError removes the sensitive values of a network create request
+// from an error returned by docker for it. Errors without sensitive values
+// are returned as is.
+func (c config) redactCreateError(nwCreate *dockerclient.NetworkCreate, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	for _, val := range c.sensitiveValues(nwCreate) {
+		msg = strings.Replace(msg, val, redactedValue, -1)
+	}
+	if msg == err.Error() {
+		return err
+	}
+
+	return errors.New(msg)
+}
+
+// Diagnostics returns a copy of the docknet oper state to show in
+// diagnostics output, with the values of sensitive options redacted
+func (s *DnetOperState) Diagnostics() DnetOperState {
+	cfg := getConfig()
+	diag := *s
+	diag.Options = cfg.redactOptions(s.Options)
+	diag.IPAMOptions = cfg.redactOptions(s.IPAMOptions)
+	if cfg.sensitiveOptions[annotationsOption] && len(s.Annotations) > 0 {
+		diag.Annotations = json.RawMessage(`"` + redactedValue + `"`)
+	} else if len(s.Annotations) > 0 {
+		diag.Annotations = json.RawMessage(cfg.redactAnnotations(string(s.Annotations)))
+	}
+
+	return diag
+}
+
+// dnetOperFields is DnetOperState without its String method
+type dnetOperFields DnetOperState
+
+// String formats the docknet oper state with the values of sensitive options
+// redacted
+func (s *DnetOperState) String() string {
+	diag := s.Diagnostics()
+	return fmt.Sprintf("%+v", dnetOperFields(diag))
+}
@@ -0,0 +1,162 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/contiv/netplugin/state"
+	"github.com/contiv/netplugin/utils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestSensitiveOptionRedaction(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearSensitiveOptions()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	MarkSensitiveOption("bgp-key")
+	MarkSensitiveOption("password")
+
+	opts := DockNetOptions{
+		OptionsOverride: map[string]string{"bgp-key": "s3cret", "mtu": "9000"},
+		Annotations:     json.RawMessage(`{"password": "hunter2", "owner": "ops"}`),
+	}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// docker still gets the real values
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	if nw.Options["bgp-key"] != "s3cret" || !strings.Contains(nw.Options[annotationsOption], "hunter2") {
+		t.Fatalf("Sensitive options not passed to docker: %v", nw.Options)
+	}
+
+	// diagnostics
+	dnet := getDocknetState("unit-test", "net1", "")
+	diag := dnet.Diagnostics()
+	if diag.Options["bgp-key"] != redactedValue || diag.Options["mtu"] != "9000" {
+		t.Fatalf("Unexpected diagnostics options: %v", diag.Options)
+	}
+	var annotations map[string]string
+	if err := json.Unmarshal(diag.Annotations, &annotations); err != nil {
+		t.Fatalf("Invalid diagnostics annotations %s. Err: %v", diag.Annotations, err)
+	}
+	if annotations["password"] != redactedValue || annotations["owner"] != "ops" {
+		t.Fatalf("Unexpected diagnostics annotations: %v", annotations)
+	}
+	if dnet.Options["bgp-key"] != "s3cret" {
+		t.Fatalf("Diagnostics changed the oper state: %v", dnet.Options)
+	}
+
+	// string
+	str := dnet.String()
+	if !strings.Contains(str, redactedValue) || strings.Contains(str, "s3cret") || strings.Contains(str, "hunter2") {
+		t.Fatalf("Sensitive values not redacted in %s", str)
+	}
+
+	// errors
+	docker.createErr = errors.New("invalid option bgp-key=s3cret for password hunter2")
+	err = CreateDockNetWithOptions("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"), opts)
+	docker.createErr = nil
+	if err == nil {
+		t.Fatalf("Network create did not fail")
+	}
+	if msg := err.Error(); msg != "invalid option bgp-key=[redacted] for password [redacted]" {
+		t.Fatalf("Sensitive values not redacted in error %q", msg)
+	}
+
+	// logs
+	if logs.Len() == 0 {
+		t.Fatalf("Nothing was logged")
+	}
+	if strings.Contains(logs.String(), "s3cret") || strings.Contains(logs.String(), "hunter2") {
+		t.Fatalf("Sensitive values logged: %s", logs.String())
+	}
+}
+
+func TestRedactAllAnnotations(t *testing.T) {
+	defer clearSensitiveOptions()
+	MarkSensitiveOption(annotationsOption)
+
+	dnet := DnetOperState{
+		Options:     map[string]string{annotationsOption: `{"owner":"ops"}`},
+		Annotations: json.RawMessage(`{"owner":"ops"}`),
+	}
+	diag := dnet.Diagnostics()
+	if diag.Options[annotationsOption] != redactedValue || string(diag.Annotations) != `"`+redactedValue+`"` {
+		t.Fatalf("Annotations not redacted: %v %s", diag.Options, diag.Annotations)
+	}
+}
+
+func TestSensitiveOptionEncryption(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearSensitiveOptions()
+	defer state.SetStateEncryption(nil)
+
+	dir, err := ioutil.TempDir("", "docknet")
+	if err != nil {
+		t.Fatalf("Error creating temp dir. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key")
+	ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))), 0600)
+	wrapper, err := state.NewFileKeyWrapper(keyFile)
+	if err != nil {
+		t.Fatalf("Error reading key file. Err: %v", err)
+	}
+	if err := state.SetStateEncryption(wrapper); err != nil {
+		t.Fatalf("Error setting state encryption. Err: %v", err)
+	}
+
+	MarkSensitiveOption("bgp-key")
+	opts := DockNetOptions{OptionsOverride: map[string]string{"bgp-key": "s3cret", "mtu": "9000"}}
+	if err := CreateDockNetWithOptions("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"), opts); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// the tenant and legacy oper states are encrypted in the store
+	stateDriver, _ := utils.GetStateDriver()
+	for _, prefix := range []string{docknetOperPrefix("unit-test"), legacyDocknetOperPrefix} {
+		values, err := stateDriver.ReadAll(prefix)
+		if err != nil || len(values) != 1 {
+			t.Fatalf("Expected a docknet oper state under %s, got %d. Err: %v", prefix, len(values), err)
+		}
+		if bytes.Contains(values[0], []byte("s3cret")) || !bytes.Contains(values[0], []byte("9000")) {
+			t.Fatalf("Sensitive option not encrypted in %s", values[0])
+		}
+	}
+
+	dnet := getDocknetState("unit-test", "net1", "")
+	if dnet == nil || dnet.Options["bgp-key"] != "s3cret" {
+		t.Fatalf("Sensitive option not decrypted, got %+v", dnet)
+	}
+}
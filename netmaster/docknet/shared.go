@@ -0,0 +1,97 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrDocknetExists is returned when a shared docknet would replace a docknet
+// of the secondary tenant
+var ErrDocknetExists = errors.New("docknet already exists")
+
+// CreateSharedDockNet creates a docker network for a network of the primary
+// tenant that is also visible in the secondary tenant, ie. one L2 segment in
+// two tenants. The docker network is named after the primary tenant, and oper
+// state is recorded under both tenants with the same docker network UUID.
+// DeleteDockNet keeps the docker network until both tenants deleted it.
+func CreateSharedDockNet(primaryTenant, secondaryTenant, network string, nwCfg *mastercfg.CfgNetworkState) error {
+	if primaryTenant == "" || secondaryTenant == "" || primaryTenant == secondaryTenant {
+		return errors.New("a shared network needs two different tenants")
+	}
+
+	// the secondary tenant must not have the network already
+	if dnet, err := readDocknetOper(secondaryTenant, network, ""); err == nil &&
+		!(dnet.Shared && dnet.PrimaryTenant == primaryTenant) {
+		log.Errorf("Network %s of tenant %s already exists", network, secondaryTenant)
+		return ErrDocknetExists
+	}
+
+	if _, err := createDockNet(primaryTenant, network, "", nwCfg, DockNetOptions{}); err != nil {
+		return err
+	}
+
+	primary, err := readDocknetOper(primaryTenant, network, "")
+	if err != nil {
+		return err
+	}
+	primary.Shared = true
+	primary.SharedWith = secondaryTenant
+	primary.PrimaryTenant = primaryTenant
+	if err := primary.Write(); err != nil {
+		log.Errorf("Error writing docknet %s. Err: %v", primary.ID, err)
+		return err
+	}
+
+	secondary := *primary
+	secondary.TenantName = secondaryTenant
+	secondary.ID = docknetOperID(secondaryTenant, network, "")
+	secondary.SharedWith = primaryTenant
+	secondary.Default = false
+	if err := secondary.Write(); err != nil {
+		log.Errorf("Error writing docknet %s. Err: %v", secondary.ID, err)
+		return err
+	}
+
+	logInfof("Shared docker network %s with tenant %s", primary.DocknetName(), secondaryTenant)
+
+	return nil
+}
+
+// sharedInUse returns true if the other tenant of a shared docknet still
+// references its docker network
+func (s *DnetOperState) sharedInUse() bool {
+	if !s.Shared || s.SharedWith == "" {
+		return false
+	}
+
+	other, err := readDocknetOper(s.SharedWith, s.NetworkName, s.ServiceName)
+	if err != nil {
+		return false
+	}
+
+	return other.Shared && other.DocknetUUID == s.DocknetUUID
+}
+
+// isSharedSecondary returns true for the oper state of the secondary tenant
+// of a shared docknet
+func (s *DnetOperState) isSharedSecondary() bool {
+	return s.Shared && s.PrimaryTenant != "" && s.PrimaryTenant != s.TenantName
+}
@@ -0,0 +1,101 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestCreateSharedDockNet(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if err := CreateSharedDockNet("blue", "blue", "net1", fakeNwCfg("blue", "net1")); err == nil {
+		t.Fatalf("Network shared with its own tenant was accepted")
+	}
+
+	if err := CreateSharedDockNet("blue", "red", "net1", fakeNwCfg("blue", "net1")); err != nil {
+		t.Fatalf("Error creating shared network. Err: %v", err)
+	}
+	if len(docker.networks) != 1 {
+		t.Fatalf("Expected one docker network, got %d", len(docker.networks))
+	}
+
+	primary := getDocknetState("blue", "net1", "")
+	secondary := getDocknetState("red", "net1", "")
+	if primary == nil || secondary == nil {
+		t.Fatalf("Oper state not recorded under both tenants: %v %v", primary, secondary)
+	}
+	if !primary.Shared || !secondary.Shared || primary.SharedWith != "red" || secondary.SharedWith != "blue" {
+		t.Fatalf("Shared flags not set: %+v %+v", primary, secondary)
+	}
+	if primary.DocknetUUID == "" || secondary.DocknetUUID != primary.DocknetUUID {
+		t.Fatalf("Oper states reference different networks %q and %q", primary.DocknetUUID, secondary.DocknetUUID)
+	}
+	if secondary.DocknetName() != "net1/blue" {
+		t.Fatalf("Secondary docknet name is %q", secondary.DocknetName())
+	}
+
+	// the secondary tenant can not have its own network by the same name
+	if err := CreateSharedDockNet("green", "red", "net1", fakeNwCfg("green", "net1")); err != ErrDocknetExists {
+		t.Fatalf("Expected ErrDocknetExists, got: %v", err)
+	}
+
+	// deleting one tenant's reference keeps the docker network
+	if err := DeleteDockNet("blue", "net1", ""); err != nil {
+		t.Fatalf("Error deleting shared network. Err: %v", err)
+	}
+	if getDocknetState("blue", "net1", "") != nil {
+		t.Fatalf("Oper state of the primary tenant was not cleared")
+	}
+	if _, err := docker.InspectNetwork(primary.DocknetUUID); err != nil {
+		t.Fatalf("Shared docker network was deleted while in use. Err: %v", err)
+	}
+
+	// deleting the last reference deletes the docker network
+	if err := DeleteDockNet("red", "net1", ""); err != nil {
+		t.Fatalf("Error deleting shared network. Err: %v", err)
+	}
+	if getDocknetState("red", "net1", "") != nil {
+		t.Fatalf("Oper state of the secondary tenant was not cleared")
+	}
+	if len(docker.networks) != 0 {
+		t.Fatalf("Shared docker network was not deleted: %v", docker.networks)
+	}
+}
+
+func TestDeleteSharedDockNetSecondaryFirst(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if err := CreateSharedDockNet("blue", "red", "net1", fakeNwCfg("blue", "net1")); err != nil {
+		t.Fatalf("Error creating shared network. Err: %v", err)
+	}
+
+	if err := DeleteDockNet("red", "net1", ""); err != nil {
+		t.Fatalf("Error deleting shared network. Err: %v", err)
+	}
+	if len(docker.networks) != 1 {
+		t.Fatalf("Shared docker network was deleted while in use")
+	}
+
+	if err := DeleteDockNet("blue", "net1", ""); err != nil {
+		t.Fatalf("Error deleting shared network. Err: %v", err)
+	}
+	if len(docker.networks) != 0 {
+		t.Fatalf("Shared docker network was not deleted: %v", docker.networks)
+	}
+}
@@ -0,0 +1,101 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"net"
+
+	"github.com/samalba/dockerclient"
+)
+
+// ErrSubnetOutsideSupernet is returned when a subnet of a network is not
+// within an allowed supernet of its tenant
+var ErrSubnetOutsideSupernet = errors.New("subnet is outside the allowed supernets of the tenant")
+
+// SetTenantSupernet adds a supernet the networks of a tenant may take their
+// subnets from. Once a tenant has a supernet of an address family, its
+// subnets of that family must each be within one of its supernets. Tenants
+// without supernets are not restricted.
+func SetTenantSupernet(tenantName, cidr string) error {
+	_, supernet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	// copy on write, snapshots may be using the old map
+	supernets := make(map[string][]*net.IPNet, len(pkgConfig.tenantSupernets)+1)
+	for tenant, nets := range pkgConfig.tenantSupernets {
+		supernets[tenant] = nets
+	}
+	nets := make([]*net.IPNet, len(supernets[tenantName]), len(supernets[tenantName])+1)
+	copy(nets, supernets[tenantName])
+	supernets[tenantName] = append(nets, supernet)
+	pkgConfig.tenantSupernets = supernets
+
+	return nil
+}
+
+// clearTenantSupernets removes the supernets of all tenants
+func clearTenantSupernets() {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.tenantSupernets = nil
+}
+
+// checkTenantSupernets returns ErrSubnetOutsideSupernet if a subnet of a
+// network is not within the supernets of its tenant for its address family
+func (c config) checkTenantSupernets(tenantName string, nwCreate *dockerclient.NetworkCreate) error {
+	supernets := c.tenantSupernets[tenantName]
+	if len(supernets) == 0 {
+		return nil
+	}
+
+	for _, ipam := range nwCreate.IPAM.Config {
+		_, subnet, err := net.ParseCIDR(ipam.Subnet)
+		if err != nil {
+			return err
+		}
+
+		restricted, allowed := false, false
+		for _, supernet := range supernets {
+			if (supernet.IP.To4() == nil) != (subnet.IP.To4() == nil) {
+				continue
+			}
+			restricted = true
+			if subnetWithin(subnet, supernet) {
+				allowed = true
+				break
+			}
+		}
+		if restricted && !allowed {
+			return ErrSubnetOutsideSupernet
+		}
+	}
+
+	return nil
+}
+
+// subnetWithin returns true if subnet is contained in supernet
+func subnetWithin(subnet, supernet *net.IPNet) bool {
+	subLen, _ := subnet.Mask.Size()
+	superLen, _ := supernet.Mask.Size()
+
+	return subLen >= superLen && supernet.Contains(subnet.IP)
+}
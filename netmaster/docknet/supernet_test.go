@@ -0,0 +1,95 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestTenantSupernet(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearTenantSupernets()
+
+	if err := SetTenantSupernet("blue", "10.1.0.0"); err == nil {
+		t.Fatalf("Invalid supernet was accepted")
+	}
+	if err := SetTenantSupernet("blue", "10.1.0.0/16"); err != nil {
+		t.Fatalf("Error setting supernet. Err: %v", err)
+	}
+	if err := SetTenantSupernet("blue", "192.168.0.0/16"); err != nil {
+		t.Fatalf("Error setting supernet. Err: %v", err)
+	}
+
+	// in range of either supernet
+	if err := CreateDockNet("blue", "net1", "", fakeNwCfg("blue", "net1")); err != nil {
+		t.Fatalf("Error creating network in the first supernet. Err: %v", err)
+	}
+	nwCfg := fakeNwCfg("blue", "net2")
+	nwCfg.SubnetIP = "192.168.5.0"
+	nwCfg.Gateway = "192.168.5.1"
+	if err := CreateDockNet("blue", "net2", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network in the second supernet. Err: %v", err)
+	}
+
+	// IPv6 is not restricted without an IPv6 supernet
+	nwCfg = fakeNwCfg("blue", "net3")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	if err := CreateDockNet("blue", "net3", "", nwCfg); err != nil {
+		t.Fatalf("Error creating dual-stack network. Err: %v", err)
+	}
+
+	// out of range, including additional pools and subnets larger than a supernet
+	nwCfg = fakeNwCfg("blue", "bad")
+	nwCfg.SubnetIP = "10.2.1.0"
+	nwCfg.Gateway = "10.2.1.254"
+	if err := CreateDockNet("blue", "bad", "", nwCfg); err != ErrSubnetOutsideSupernet {
+		t.Fatalf("Expected ErrSubnetOutsideSupernet, got: %v", err)
+	}
+	opts := DockNetOptions{AdditionalPools: []IPAMPool{{Subnet: "172.16.1.0/24"}}}
+	err := CreateDockNetWithOptions("blue", "bad", "", fakeNwCfg("blue", "bad"), opts)
+	if err != ErrSubnetOutsideSupernet {
+		t.Fatalf("Expected ErrSubnetOutsideSupernet, got: %v", err)
+	}
+	nwCfg = fakeNwCfg("blue", "bad")
+	nwCfg.SubnetIP = "10.0.0.0"
+	nwCfg.SubnetLen = 8
+	if err := CreateDockNet("blue", "bad", "", nwCfg); err != ErrSubnetOutsideSupernet {
+		t.Fatalf("Expected ErrSubnetOutsideSupernet, got: %v", err)
+	}
+	if getDocknetState("blue", "bad", "") != nil {
+		t.Fatalf("Network outside the supernets was created")
+	}
+
+	if err := SetTenantSupernet("blue", "2001:db8:1::/48"); err != nil {
+		t.Fatalf("Error setting IPv6 supernet. Err: %v", err)
+	}
+	nwCfg = fakeNwCfg("blue", "net4")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	if err := CreateDockNet("blue", "net4", "", nwCfg); err != ErrSubnetOutsideSupernet {
+		t.Fatalf("Expected ErrSubnetOutsideSupernet for IPv6, got: %v", err)
+	}
+
+	// no restriction configured for the tenant
+	nwCfg = fakeNwCfg("red", "net1")
+	nwCfg.SubnetIP = "172.16.1.0"
+	nwCfg.Gateway = "172.16.1.1"
+	if err := CreateDockNet("red", "net1", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network of an unrestricted tenant. Err: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/samalba/dockerclient"
+)
+
+// ErrTagConflict is the Kind of TagConflictError
+var ErrTagConflict = errors.New("packet tag is used by another network on the segment")
+
+// TagConflictError is returned when a network would share its packet tag
+// with a network of another tenant or network on the same fabric segment.
+// Existing is the docker network name of the other network.
+type TagConflictError struct {
+	Kind     error
+	Encap    string
+	Tag      int
+	Uplink   string
+	Existing string
+}
+
+func (e *TagConflictError) Error() string {
+	return fmt.Sprintf("%v: %s %d is used by %s", e.Kind, e.Encap, e.Tag, e.Existing)
+}
+
+// SetTagConflictCheck enables rejecting networks whose packet tag is used by
+// another network on the same fabric segment. A vxlan VNI is unique across
+// the fabric, and a vlan ID is unique per uplink. The docknets of the
+// endpoint groups of a network share its tag and do not conflict.
+func SetTagConflictCheck(enabled bool) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	pkgConfig.tagConflictCheck = enabled
+}
+
+// checkTagConflict returns a TagConflictError if the packet tag of a network
+// is used by another network on its segment
+func checkTagConflict(tenantName, networkName string, nwCreate *dockerclient.NetworkCreate) error {
+	encap := nwCreate.Options["encap"]
+	tag, _ := strconv.Atoi(nwCreate.Options["pkt-tag"])
+	uplink := nwCreate.Options["uplink"]
+
+	dnets, err := FindByPktTag(encap, tag)
+	if err != nil {
+		return err
+	}
+
+	for _, dnet := range dnets {
+		if dnet.TenantName == tenantName && dnet.NetworkName == networkName {
+			continue
+		}
+		// vlans are scoped to the uplink
+		if encap == "vlan" && dnet.Options["uplink"] != uplink {
+			continue
+		}
+
+		return &TagConflictError{
+			Kind:     ErrTagConflict,
+			Encap:    encap,
+			Tag:      tag,
+			Uplink:   uplink,
+			Existing: dnet.DocknetName(),
+		}
+	}
+
+	return nil
+}
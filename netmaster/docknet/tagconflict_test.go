@@ -0,0 +1,84 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"testing"
+)
+
+func TestTagConflict(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer SetTagConflictCheck(false)
+
+	origLookup := lookupInterface
+	defer func() { lookupInterface = origLookup }()
+	lookupInterface = func(name string) error { return nil }
+
+	SetTagConflictCheck(true)
+
+	vxlanCfg := func(tenant, network string, vni int) *DockNetSpec {
+		nwCfg := fakeNwCfg(tenant, network)
+		nwCfg.PktTagType = "vxlan"
+		nwCfg.PktTag = vni
+		nwCfg.ExtPktTag = vni
+		return &DockNetSpec{TenantName: tenant, NetworkName: network, NwCfg: nwCfg}
+	}
+	vlanCfg := func(tenant, network string, vlan int, uplink string) *DockNetSpec {
+		nwCfg := fakeNwCfg(tenant, network)
+		nwCfg.PktTag = vlan
+		nwCfg.ExtPktTag = vlan
+		spec := &DockNetSpec{TenantName: tenant, NetworkName: network, NwCfg: nwCfg}
+		if uplink != "" {
+			spec.Options = DockNetOptions{ExternalConnectivity: true, Uplink: uplink}
+		}
+		return spec
+	}
+
+	tagTests := []struct {
+		spec     *DockNetSpec
+		existing string
+	}{
+		{vxlanCfg("blue", "vx1", 5000), ""},
+		// same VNI anywhere on the fabric
+		{vxlanCfg("red", "vx2", 5000), "vx1/blue"},
+		{vxlanCfg("red", "vx2", 5001), ""},
+		{vlanCfg("blue", "vl1", 100, "eth1"), ""},
+		// same vlan on the same uplink
+		{vlanCfg("red", "vl2", 100, "eth1"), "vl1/blue"},
+		// same vlan on another uplink, and a vxlan VNI with the same number
+		{vlanCfg("red", "vl2", 100, "eth2"), ""},
+		{vxlanCfg("red", "vx3", 100), ""},
+		// the endpoint groups of a network share its tag
+		{&DockNetSpec{TenantName: "blue", NetworkName: "vx1", ServiceName: "web",
+			NwCfg: vxlanCfg("blue", "vx1", 5000).NwCfg}, ""},
+	}
+
+	for _, test := range tagTests {
+		spec := test.spec
+		err := CreateDockNetWithOptions(spec.TenantName, spec.NetworkName, spec.ServiceName, spec.NwCfg, spec.Options)
+		if test.existing == "" {
+			if err != nil {
+				t.Fatalf("Error creating network %s. Err: %v", spec.NetworkName, err)
+			}
+			continue
+		}
+		tagErr, ok := err.(*TagConflictError)
+		if !ok || tagErr.Kind != ErrTagConflict || tagErr.Existing != test.existing {
+			t.Fatalf("Expected a conflict with %s for %s, got: %v", test.existing, spec.NetworkName, err)
+		}
+	}
+}
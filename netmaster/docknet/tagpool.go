@@ -0,0 +1,123 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Packet tag pools are ranges of vlan IDs or vxlan VNIs that docknets take
+// their tag from when the network config has none. Like subnet pools, the
+// tags in use are found from the oper state of the docknets, so a tag is
+// released when its docknets are deleted.
+
+// ErrPktTagPoolExhausted is returned when a packet tag pool has no free tag
+var ErrPktTagPoolExhausted = errors.New("packet tag pool is exhausted")
+
+// tagRange is an inclusive range of packet tags
+type tagRange struct {
+	start int
+	end   int
+}
+
+var (
+	// tagPoolMutex protects tagPools and serializes tag allocation
+	tagPoolMutex sync.Mutex
+	tagPools     = make(map[string]tagRange)
+)
+
+// SetPktTagPool sets the range of packet tags of an encap, vlan or vxlan,
+// that networks without a packet tag get the lowest free tag from. The
+// docknets of the endpoint groups of a network share its tag.
+func SetPktTagPool(encap string, start, end int) error {
+	if err := validatePktTag(encap, start); err != nil {
+		return err
+	}
+	if err := validatePktTag(encap, end); err != nil {
+		return err
+	}
+	if start > end {
+		return fmt.Errorf("invalid %s tag pool %d-%d", encap, start, end)
+	}
+
+	tagPoolMutex.Lock()
+	defer tagPoolMutex.Unlock()
+	tagPools[encap] = tagRange{start: start, end: end}
+
+	return nil
+}
+
+// clearPktTagPools removes all packet tag pools
+func clearPktTagPools() {
+	tagPoolMutex.Lock()
+	defer tagPoolMutex.Unlock()
+	tagPools = make(map[string]tagRange)
+}
+
+// allocatePktTag returns a network config using the tag of the network's
+// other docknets, or else the lowest free tag of the pool of its encap. The
+// config is returned as is if there is no pool for the encap. tagPoolMutex
+// must be held until the docknet oper state is written.
+func allocatePktTag(stateDriver core.StateDriver, tenantName, networkName string,
+	nwCfg *mastercfg.CfgNetworkState) (*mastercfg.CfgNetworkState, error) {
+	pool, ok := tagPools[nwCfg.PktTagType]
+	if !ok {
+		return nwCfg, nil
+	}
+
+	// tags of the encap in use
+	dnets, err := readAllDocknets(stateDriver)
+	if err != nil && core.ErrIfKeyExists(err) != nil {
+		log.Errorf("Error getting docknet list. Err: %v", err)
+		return nil, err
+	}
+	used := make(map[int]bool)
+	tag := 0
+	for _, dnet := range dnets {
+		if dnet.Encap != nwCfg.PktTagType {
+			continue
+		}
+		if dnet.TenantName == tenantName && dnet.NetworkName == networkName {
+			tag = dnet.PktTag
+		}
+		used[dnet.PktTag] = true
+	}
+
+	for t := pool.start; tag == 0 && t <= pool.end; t++ {
+		if !used[t] {
+			tag = t
+		}
+	}
+	if tag == 0 {
+		return nil, ErrPktTagPoolExhausted
+	}
+
+	tagCfg := *nwCfg
+	if tagCfg.PktTagType == "vxlan" {
+		tagCfg.ExtPktTag = tag
+	} else {
+		tagCfg.PktTag = tag
+	}
+
+	return &tagCfg, nil
+}
@@ -0,0 +1,113 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// untaggedNwCfg returns a network config without a packet tag
+func untaggedNwCfg(tenantName, networkName, encap string) *mastercfg.CfgNetworkState {
+	nwCfg := fakeNwCfg(tenantName, networkName)
+	nwCfg.PktTagType = encap
+	nwCfg.PktTag = 0
+	nwCfg.ExtPktTag = 0
+
+	return nwCfg
+}
+
+func TestPktTagPool(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearPktTagPools()
+
+	if err := SetPktTagPool("vlan", 100, 99); err == nil {
+		t.Fatalf("Empty tag pool was accepted")
+	}
+	if err := SetPktTagPool("vlan", 100, 5000); err == nil {
+		t.Fatalf("Tag pool out of the vlan range was accepted")
+	}
+	if err := SetPktTagPool("vlan", 100, 102); err != nil {
+		t.Fatalf("Error setting vlan tag pool. Err: %v", err)
+	}
+	if err := SetPktTagPool("vxlan", 5000, 5001); err != nil {
+		t.Fatalf("Error setting vxlan tag pool. Err: %v", err)
+	}
+
+	// a network with a tag in the pool range keeps its tag
+	nwCfg := fakeNwCfg("unit-test", "tagged")
+	nwCfg.PktTag = 101
+	if err := CreateDockNet("unit-test", "tagged", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// allocation skips the tags in use
+	expTags := map[string]int{"net1": 100, "net2": 102}
+	for _, netName := range []string{"net1", "net2"} {
+		if err := CreateDockNet("unit-test", netName, "", untaggedNwCfg("unit-test", netName, "vlan")); err != nil {
+			t.Fatalf("Error creating network %s. Err: %v", netName, err)
+		}
+		nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", netName, ""))
+		if nw.Options["pkt-tag"] != fmt.Sprintf("%d", expTags[netName]) {
+			t.Fatalf("Expected tag %d for %s, got %s", expTags[netName], netName, nw.Options["pkt-tag"])
+		}
+		if dnet := getDocknetState("unit-test", netName, ""); dnet.PktTag != expTags[netName] {
+			t.Fatalf("Tag not recorded in oper state: %+v", dnet)
+		}
+	}
+
+	// endpoint groups share the tag of their network
+	if err := CreateDockNet("unit-test", "net1", "epg1", untaggedNwCfg("unit-test", "net1", "vlan")); err != nil {
+		t.Fatalf("Error creating endpoint group network. Err: %v", err)
+	}
+	if dnet := getDocknetState("unit-test", "net1", "epg1"); dnet.PktTag != 100 {
+		t.Fatalf("Endpoint group did not get its network's tag: %+v", dnet)
+	}
+
+	// exhaustion
+	err := CreateDockNet("unit-test", "net3", "", untaggedNwCfg("unit-test", "net3", "vlan"))
+	if err != ErrPktTagPoolExhausted {
+		t.Fatalf("Expected ErrPktTagPoolExhausted, got: %v", err)
+	}
+
+	// pools are per encap
+	if err := CreateDockNet("unit-test", "vx1", "", untaggedNwCfg("unit-test", "vx1", "vxlan")); err != nil {
+		t.Fatalf("Error creating vxlan network. Err: %v", err)
+	}
+	if dnet := getDocknetState("unit-test", "vx1", ""); dnet.PktTag != 5000 {
+		t.Fatalf("Expected VNI 5000, got: %+v", dnet)
+	}
+
+	// a tag is released when all docknets using it are deleted
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if err := CreateDockNet("unit-test", "net3", "", untaggedNwCfg("unit-test", "net3", "vlan")); err != ErrPktTagPoolExhausted {
+		t.Fatalf("Tag of a network with endpoint groups was released, err: %v", err)
+	}
+	if err := DeleteDockNet("unit-test", "net1", "epg1"); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if err := CreateDockNet("unit-test", "net3", "", untaggedNwCfg("unit-test", "net3", "vlan")); err != nil {
+		t.Fatalf("Error creating network with a released tag. Err: %v", err)
+	}
+	if dnet := getDocknetState("unit-test", "net3", ""); dnet.PktTag != 100 {
+		t.Fatalf("Expected the released tag 100, got: %+v", dnet)
+	}
+}
@@ -0,0 +1,179 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of topology graph nodes
+const (
+	NodeTenant  = "tenant"
+	NodeNetwork = "network"
+	NodeService = "service"
+)
+
+// GraphNode is a tenant, network or service of the docknet topology. Network
+// and service nodes backed by a docknet have its subnet, encap and UUID as
+// attributes.
+type GraphNode struct {
+	ID    string            `json:"id"`
+	Kind  string            `json:"kind"`
+	Label string            `json:"label"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// GraphEdge links a tenant to its networks, and a network to its services
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Graph is the docknet topology, with nodes and edges sorted by ID
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type graphNodes []GraphNode
+
+func (n graphNodes) Len() int           { return len(n) }
+func (n graphNodes) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+func (n graphNodes) Less(i, j int) bool { return n[i].ID < n[j].ID }
+
+type graphEdges []GraphEdge
+
+func (e graphEdges) Len() int      { return len(e) }
+func (e graphEdges) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e graphEdges) Less(i, j int) bool {
+	if e[i].From != e[j].From {
+		return e[i].From < e[j].From
+	}
+	return e[i].To < e[j].To
+}
+
+// TopologyGraph builds the tenant, network and service hierarchy of the
+// docknets in the oper state
+func TopologyGraph() (Graph, error) {
+	graph := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}}
+
+	dnets, err := ListDockNets()
+	if err != nil {
+		return graph, err
+	}
+
+	nodes := make(map[string]*GraphNode)
+	addNode := func(id, kind, label string) *GraphNode {
+		if node, ok := nodes[id]; ok {
+			return node
+		}
+		node := &GraphNode{ID: id, Kind: kind, Label: label}
+		nodes[id] = node
+		return node
+	}
+	edges := make(map[GraphEdge]bool)
+
+	for _, dnet := range dnets {
+		tenantID := NodeTenant + ":" + dnet.TenantName
+		addNode(tenantID, NodeTenant, dnet.TenantName)
+
+		netID := NodeNetwork + ":" + dnet.TenantName + "/" + dnet.NetworkName
+		node := addNode(netID, NodeNetwork, dnet.NetworkName)
+		edges[GraphEdge{From: tenantID, To: netID}] = true
+
+		if dnet.ServiceName != "" {
+			svcID := NodeService + ":" + dnet.TenantName + "/" + dnet.NetworkName + "/" + dnet.ServiceName
+			node = addNode(svcID, NodeService, dnet.ServiceName)
+			edges[GraphEdge{From: netID, To: svcID}] = true
+		}
+
+		node.Attrs = docknetAttrs(dnet)
+	}
+
+	for _, node := range nodes {
+		graph.Nodes = append(graph.Nodes, *node)
+	}
+	for edge := range edges {
+		graph.Edges = append(graph.Edges, edge)
+	}
+	sort.Sort(graphNodes(graph.Nodes))
+	sort.Sort(graphEdges(graph.Edges))
+
+	return graph, nil
+}
+
+// docknetAttrs returns the graph node attributes of a docknet
+func docknetAttrs(dnet *DnetOperState) map[string]string {
+	attrs := map[string]string{
+		"uuid":    dnet.DocknetUUID,
+		"docknet": dnet.DocknetName(),
+	}
+	subnets := []string{}
+	for _, pool := range dnet.Subnets {
+		subnets = append(subnets, pool.Subnet)
+	}
+	if len(subnets) > 0 {
+		attrs["subnet"] = strings.Join(subnets, ",")
+	}
+	if dnet.Encap != "" {
+		attrs["encap"] = dnet.Encap
+		attrs["pktTag"] = strconv.Itoa(dnet.PktTag)
+	}
+
+	return attrs
+}
+
+// DOT returns the graph in the graphviz DOT language
+func (g Graph) DOT() string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph docknet {\n")
+	for _, node := range g.Nodes {
+		keys := []string{}
+		for key := range node.Attrs {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		label := node.Label
+		for _, key := range keys {
+			label += "\n" + key + "=" + node.Attrs[key]
+		}
+		fmt.Fprintf(&buf, "  %s [shape=%s, label=%s];\n", strconv.Quote(node.ID),
+			dotShape(node.Kind), strconv.Quote(label))
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&buf, "  %s -> %s;\n", strconv.Quote(edge.From), strconv.Quote(edge.To))
+	}
+	buf.WriteString("}\n")
+
+	return buf.String()
+}
+
+// dotShape returns the DOT shape of a kind of node
+func dotShape(kind string) string {
+	switch kind {
+	case NodeTenant:
+		return "folder"
+	case NodeNetwork:
+		return "box"
+	}
+
+	return "ellipse"
+}
@@ -0,0 +1,68 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTopologyGraph(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	// two tenants, three networks, one service
+	for _, dn := range []struct{ tenant, network, service string }{
+		{"blue", "net1", ""},
+		{"blue", "net1", "web"},
+		{"blue", "net2", ""},
+		{"red", "net1", ""},
+	} {
+		err := CreateDockNet(dn.tenant, dn.network, dn.service, fakeNwCfg(dn.tenant, dn.network))
+		if err != nil {
+			t.Fatalf("Error creating network. Err: %v", err)
+		}
+	}
+
+	graph, err := TopologyGraph()
+	if err != nil {
+		t.Fatalf("Error building topology. Err: %v", err)
+	}
+	if len(graph.Nodes) != 6 || len(graph.Edges) != 4 {
+		t.Fatalf("Unexpected graph %+v", graph)
+	}
+
+	kinds := make(map[string]int)
+	for _, node := range graph.Nodes {
+		kinds[node.Kind]++
+		if node.Kind != NodeTenant && (node.Attrs["subnet"] != "10.1.1.0/24" ||
+			node.Attrs["encap"] != "vlan" || node.Attrs["uuid"] == "") {
+			t.Fatalf("Unexpected attributes of %+v", node)
+		}
+	}
+	if kinds[NodeTenant] != 2 || kinds[NodeNetwork] != 3 || kinds[NodeService] != 1 {
+		t.Fatalf("Unexpected node kinds %v", kinds)
+	}
+
+	if _, err := json.Marshal(graph); err != nil {
+		t.Fatalf("Error encoding graph. Err: %v", err)
+	}
+	dot := graph.DOT()
+	if !strings.Contains(dot, `"network:blue/net1" -> "service:blue/net1/web";`) {
+		t.Fatalf("Unexpected DOT output:\n%s", dot)
+	}
+}
@@ -0,0 +1,230 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// PoolUtilization has the address usage of a subnet. Total is capped at
+// math.MaxUint64 for large IPv6 subnets.
+type PoolUtilization struct {
+	Subnet      string  `json:"subnet"`
+	Family      string  `json:"family"`
+	Total       uint64  `json:"total"`
+	Allocated   uint64  `json:"allocated"`
+	PercentUsed float64 `json:"percentUsed"`
+}
+
+// UtilizationReport has the address usage of a docknet, per subnet and per
+// address family
+type UtilizationReport struct {
+	Pools    []PoolUtilization          `json:"pools"`
+	Families map[string]PoolUtilization `json:"families"`
+}
+
+// SubnetUtilization reports how many addresses of each subnet of a docknet
+// are used by its endpoints and gateways
+func SubnetUtilization(tenantName, networkName, serviceName string) (UtilizationReport, error) {
+	report := UtilizationReport{
+		Pools:    []PoolUtilization{},
+		Families: make(map[string]PoolUtilization),
+	}
+
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return report, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return report, errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnetOper.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", dnetOper.DocknetName(), err)
+		return report, err
+	}
+
+	// addresses of the attached endpoints
+	epAddrs := []net.IP{}
+	for _, ep := range nw.Containers {
+		for _, addr := range []string{ep.IPv4Address, ep.IPv6Address} {
+			if addr == "" {
+				continue
+			}
+			if ip := net.ParseIP(strings.Split(addr, "/")[0]); ip != nil {
+				epAddrs = append(epAddrs, ip)
+			}
+		}
+	}
+
+	for _, pool := range dnetOper.Subnets {
+		_, ipNet, err := net.ParseCIDR(pool.Subnet)
+		if err != nil {
+			log.Errorf("Invalid subnet %s for network %s", pool.Subnet, dnetOper.DocknetName())
+			return report, err
+		}
+
+		usage := PoolUtilization{
+			Subnet: pool.Subnet,
+			Family: familyIPv4,
+			Total:  subnetHostCount(ipNet),
+		}
+		if ipNet.IP.To4() == nil {
+			usage.Family = familyIPv6
+		}
+
+		gw := net.ParseIP(pool.Gateway)
+		if gw != nil {
+			usage.Allocated++
+		}
+		for _, ip := range epAddrs {
+			if ipNet.Contains(ip) && !ip.Equal(gw) {
+				usage.Allocated++
+			}
+		}
+		usage.PercentUsed = percentUsed(usage.Allocated, usage.Total)
+		report.Pools = append(report.Pools, usage)
+
+		family := report.Families[usage.Family]
+		family.Family = usage.Family
+		family.Total = addCapped(family.Total, usage.Total)
+		family.Allocated += usage.Allocated
+		family.PercentUsed = percentUsed(family.Allocated, family.Total)
+		report.Families[usage.Family] = family
+	}
+
+	return report, nil
+}
+
+// Allocation is an address allocated to an endpoint of a docknet. An
+// endpoint with an IPv4 and an IPv6 address has an allocation per family.
+type Allocation struct {
+	ContainerID string `json:"containerID"`
+	EndpointID  string `json:"endpointID"`
+	Family      string `json:"family"`
+	IP          string `json:"ip"`
+	MAC         string `json:"mac,omitempty"`
+}
+
+type allocations []Allocation
+
+func (a allocations) Len() int      { return len(a) }
+func (a allocations) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a allocations) Less(i, j int) bool {
+	if a[i].ContainerID != a[j].ContainerID {
+		return a[i].ContainerID < a[j].ContainerID
+	}
+	return a[i].Family < a[j].Family
+}
+
+// ListAllocations returns the addresses allocated to the endpoints attached
+// to a docknet, sorted by container ID, as reported by docker
+func ListAllocations(tenantName, networkName, serviceName string) ([]Allocation, error) {
+	dnetOper, err := readDocknetOper(tenantName, networkName, serviceName)
+	if err != nil {
+		return nil, err
+	}
+
+	// connect to docker
+	docker, err := newDockerClient()
+	if err != nil {
+		log.Errorf("Unable to connect to docker. Error %v", err)
+		return nil, errors.New("Unable to connect to docker")
+	}
+
+	nw, err := docker.InspectNetwork(dnetOper.DocknetUUID)
+	if err != nil {
+		log.Errorf("Error inspecting network %s. Err: %v", dnetOper.DocknetName(), err)
+		return nil, err
+	}
+
+	allocs := []Allocation{}
+	for ctrID, ep := range nw.Containers {
+		for _, addr := range []string{ep.IPv4Address, ep.IPv6Address} {
+			ip := net.ParseIP(strings.Split(addr, "/")[0])
+			if ip == nil {
+				continue
+			}
+
+			alloc := Allocation{
+				ContainerID: ctrID,
+				EndpointID:  ep.EndpointID,
+				Family:      familyIPv4,
+				IP:          ip.String(),
+				MAC:         ep.MacAddress,
+			}
+			if ip.To4() == nil {
+				alloc.Family = familyIPv6
+			}
+			allocs = append(allocs, alloc)
+		}
+	}
+	sort.Sort(allocations(allocs))
+
+	return allocs, nil
+}
+
+// subnetHostCount returns the number of usable host addresses in a subnet.
+// The network and broadcast addresses of IPv4 subnets are not counted,
+// except in /31 and /32 subnets.
+func subnetHostCount(ipNet *net.IPNet) uint64 {
+	ones, bits := ipNet.Mask.Size()
+	hostBits := uint(bits - ones)
+	if hostBits >= 64 {
+		return math.MaxUint64
+	}
+
+	count := uint64(1) << hostBits
+	if bits == 32 && hostBits > 1 {
+		count -= 2
+	}
+
+	return count
+}
+
+// addCapped adds two counts, capping the sum at math.MaxUint64
+func addCapped(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+
+	return a + b
+}
+
+// percentUsed returns allocated as a percentage of total
+func percentUsed(allocated, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(allocated) * 100 / float64(total)
+}
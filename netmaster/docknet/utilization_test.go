@@ -0,0 +1,144 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/samalba/dockerclient"
+)
+
+func TestSubnetUtilization(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	opts := DockNetOptions{AdditionalPools: []IPAMPool{{Subnet: "10.2.2.0/30"}}}
+	err := CreateDockNetWithOptions("unit-test", "net1", "", nwCfg, opts)
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+
+	// attach 9 endpoints to 10.1.1.0/24, one of them dual-stack, and 2 to 10.2.2.0/30
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	for i := 1; i <= 9; i++ {
+		nw.Containers[fmt.Sprintf("ep%d", i)] = dockerclient.EndpointResource{
+			IPv4Address: fmt.Sprintf("10.1.1.%d/24", i),
+		}
+	}
+	ep := nw.Containers["ep1"]
+	ep.IPv6Address = "2001:db8::1/64"
+	nw.Containers["ep1"] = ep
+	nw.Containers["ep10"] = dockerclient.EndpointResource{IPv4Address: "10.2.2.1/30"}
+	nw.Containers["ep11"] = dockerclient.EndpointResource{IPv4Address: "10.2.2.2/30"}
+
+	report, err := SubnetUtilization("unit-test", "net1", "")
+	if err != nil {
+		t.Fatalf("Error getting subnet utilization. Err: %v", err)
+	}
+
+	expPools := []PoolUtilization{
+		// endpoints and the gateway
+		{Subnet: "10.1.1.0/24", Family: familyIPv4, Total: 254, Allocated: 10},
+		{Subnet: "2001:db8::/64", Family: familyIPv6, Total: math.MaxUint64, Allocated: 1},
+		{Subnet: "10.2.2.0/30", Family: familyIPv4, Total: 2, Allocated: 2, PercentUsed: 100},
+	}
+	if len(report.Pools) != len(expPools) {
+		t.Fatalf("Unexpected pools %+v", report.Pools)
+	}
+	for i, exp := range expPools {
+		pool := report.Pools[i]
+		if pool.Subnet != exp.Subnet || pool.Family != exp.Family || pool.Total != exp.Total ||
+			pool.Allocated != exp.Allocated {
+			t.Fatalf("Pool %+v does not match expected %+v", pool, exp)
+		}
+		if exp.PercentUsed != 0 && pool.PercentUsed != exp.PercentUsed {
+			t.Fatalf("Pool %+v does not match expected %+v", pool, exp)
+		}
+	}
+
+	v4 := report.Families[familyIPv4]
+	if v4.Total != 256 || v4.Allocated != 12 || v4.PercentUsed != float64(12)*100/256 {
+		t.Fatalf("Unexpected IPv4 utilization %+v", v4)
+	}
+	if v6 := report.Families[familyIPv6]; v6.Total != math.MaxUint64 || v6.Allocated != 1 {
+		t.Fatalf("Unexpected IPv6 utilization %+v", v6)
+	}
+
+	if _, err := SubnetUtilization("unit-test", "net2", ""); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+}
+
+func TestListAllocations(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if _, err := ListAllocations("unit-test", "net1", ""); err != ErrDocknetNotFound {
+		t.Fatalf("Expected ErrDocknetNotFound, got: %v", err)
+	}
+
+	nwCfg := fakeNwCfg("unit-test", "net1")
+	nwCfg.IPv6Subnet = "2001:db8::"
+	nwCfg.IPv6SubnetLen = 64
+	if err := CreateDockNet("unit-test", "net1", "", nwCfg); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	allocs, err := ListAllocations("unit-test", "net1", "")
+	if err != nil || len(allocs) != 0 {
+		t.Fatalf("Expected no allocations, got %v. Err: %v", allocs, err)
+	}
+
+	nw, _ := docker.InspectNetwork(GetDocknetName("unit-test", "net1", ""))
+	nw.Containers["ctr2"] = dockerclient.EndpointResource{
+		EndpointID:  "ep2",
+		MacAddress:  "02:02:0a:01:01:02",
+		IPv4Address: "10.1.1.2/24",
+		IPv6Address: "2001:db8::2/64",
+	}
+	nw.Containers["ctr1"] = dockerclient.EndpointResource{
+		EndpointID:  "ep1",
+		MacAddress:  "02:02:0a:01:01:01",
+		IPv4Address: "10.1.1.1/24",
+	}
+	nw.Containers["ctr3"] = dockerclient.EndpointResource{
+		EndpointID:  "ep3",
+		IPv6Address: "2001:db8::3/64",
+	}
+
+	allocs, err = ListAllocations("unit-test", "net1", "")
+	if err != nil {
+		t.Fatalf("Error listing allocations. Err: %v", err)
+	}
+	expAllocs := []Allocation{
+		{ContainerID: "ctr1", EndpointID: "ep1", Family: familyIPv4, IP: "10.1.1.1", MAC: "02:02:0a:01:01:01"},
+		{ContainerID: "ctr2", EndpointID: "ep2", Family: familyIPv4, IP: "10.1.1.2", MAC: "02:02:0a:01:01:02"},
+		{ContainerID: "ctr2", EndpointID: "ep2", Family: familyIPv6, IP: "2001:db8::2", MAC: "02:02:0a:01:01:02"},
+		{ContainerID: "ctr3", EndpointID: "ep3", Family: familyIPv6, IP: "2001:db8::3"},
+	}
+	if len(allocs) != len(expAllocs) {
+		t.Fatalf("Expected allocations %v, got %v", expAllocs, allocs)
+	}
+	for i := range expAllocs {
+		if allocs[i] != expAllocs[i] {
+			t.Fatalf("Expected allocation %+v, got %+v", expAllocs[i], allocs[i])
+		}
+	}
+}
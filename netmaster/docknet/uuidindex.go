@@ -0,0 +1,112 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The UUID index maps docker network UUIDs to docknet oper state IDs in the
+// state store, so that FindDocknetByUUID does not read all oper states. It is
+// kept outside of the docknet paths of the tenants, which only have oper
+// states. Entries are written with the oper state and checked against it on
+// lookup, so a stale or missing entry only costs a full scan, which also
+// repairs it.
+const docknetUUIDPath = mastercfg.StateOperPath + "docknet-uuid/%s"
+
+// indexUUID points the index entry of the docker network UUID at the oper
+// state. Shared docknets are indexed by their primary.
+func (s *DnetOperState) indexUUID() {
+	if s.DocknetUUID == "" || s.isSharedSecondary() {
+		return
+	}
+
+	key := fmt.Sprintf(docknetUUIDPath, s.DocknetUUID)
+	if err := s.StateDriver.Write(key, []byte(s.ID)); err != nil {
+		log.Warnf("Error indexing docknet %s by UUID. Err: %v", s.ID, err)
+	}
+}
+
+// unindexUUID removes the index entry of the docker network UUID if it points
+// at the oper state
+func (s *DnetOperState) unindexUUID(uuid string) {
+	if uuid == "" {
+		return
+	}
+
+	key := fmt.Sprintf(docknetUUIDPath, uuid)
+	operID, err := s.StateDriver.Read(key)
+	if err != nil || string(operID) != s.ID {
+		return
+	}
+	if err := s.StateDriver.ClearState(key); err != nil {
+		log.Warnf("Error removing UUID index of docknet %s. Err: %v", s.ID, err)
+	}
+}
+
+// FindDocknetByUUID find the docknet by UUID
+func FindDocknetByUUID(dnetID string) (*DnetOperState, error) {
+	stateDriver, err := getReadStateDriver(getConfig())
+	if err != nil {
+		return nil, err
+	}
+	writeDriver, err := getWriteStateDriver()
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf(docknetUUIDPath, dnetID)
+	if operID, err := stateDriver.Read(key); err == nil {
+		dnet := &DnetOperState{}
+		dnet.StateDriver = stateDriver
+		if err := dnet.Read(string(operID)); err == nil && dnet.DocknetUUID == dnetID && dnet.validOperID() {
+			// updates must go to the primary
+			dnet.StateDriver = writeDriver
+			return dnet, nil
+		}
+		log.Debugf("Stale UUID index entry %s for docknet %s", dnetID, operID)
+	} else if core.ErrIfKeyExists(err) != nil {
+		log.Warnf("Error reading UUID index. Err: %v", err)
+	}
+
+	dnetOperList, err := ListDockNets()
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk all dnets and find the matching UUID, preferring the primary of
+	// shared docknets
+	var found *DnetOperState
+	for _, dnet := range dnetOperList {
+		if dnet.DocknetUUID == dnetID && (found == nil || found.isSharedSecondary()) {
+			found = dnet
+		}
+	}
+	if found == nil {
+		return nil, errors.New("docknet UUID not found")
+	}
+
+	// repair the index
+	found.indexUUID()
+
+	return found, nil
+}
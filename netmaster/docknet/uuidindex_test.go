@@ -0,0 +1,66 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/contiv/netplugin/utils"
+)
+
+func TestFindDocknetByUUIDIndex(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+
+	if err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1")); err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	dnet := getDocknetState("unit-test", "net1", "")
+
+	stateDriver, _ := utils.GetStateDriver()
+	key := fmt.Sprintf(docknetUUIDPath, dnet.DocknetUUID)
+	if operID, err := stateDriver.Read(key); err != nil || string(operID) != dnet.ID {
+		t.Fatalf("docknet was not indexed, got %q. Err: %v", operID, err)
+	}
+
+	found, err := FindDocknetByUUID(dnet.DocknetUUID)
+	if err != nil || found.ID != dnet.ID {
+		t.Fatalf("Error finding docknet by UUID, got %v. Err: %v", found, err)
+	}
+
+	// a stale entry falls back to the scan, which repairs it
+	if err := stateDriver.Write(key, []byte("unit-test.net2.")); err != nil {
+		t.Fatalf("Error writing index entry. Err: %v", err)
+	}
+	found, err = FindDocknetByUUID(dnet.DocknetUUID)
+	if err != nil || found.ID != dnet.ID {
+		t.Fatalf("Error finding docknet by UUID, got %v. Err: %v", found, err)
+	}
+	if operID, _ := stateDriver.Read(key); string(operID) != dnet.ID {
+		t.Fatalf("Stale index entry was not repaired, got %q", operID)
+	}
+
+	if err := DeleteDockNet("unit-test", "net1", ""); err != nil {
+		t.Fatalf("Error deleting network. Err: %v", err)
+	}
+	if _, err := stateDriver.Read(key); err == nil {
+		t.Fatalf("Index entry was not removed with the docknet")
+	}
+	if _, err := FindDocknetByUUID(dnet.DocknetUUID); err == nil {
+		t.Fatalf("Deleted docknet was found by UUID")
+	}
+}
@@ -0,0 +1,106 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/samalba/dockerclient"
+)
+
+// webhookTimeout is how long a create webhook can take to respond
+var webhookTimeout = 10 * time.Second
+
+// CreateHookRequest describes a docker network about to be created
+type CreateHookRequest struct {
+	TenantName  string     `json:"tenantName"`
+	NetworkName string     `json:"networkName"`
+	ServiceName string     `json:"serviceName,omitempty"`
+	DocknetName string     `json:"docknetName"`
+	Encap       string     `json:"encap"`
+	PktTag      int        `json:"pktTag"`
+	Subnets     []IPAMPool `json:"subnets"`
+}
+
+// CreateHook is called before a docker network is created. Returning an
+// error aborts the creation.
+type CreateHook func(req CreateHookRequest) error
+
+// newCreateHookRequest describes a network create request to the hooks
+func newCreateHookRequest(tenantName, networkName, serviceName string,
+	nwCreate *dockerclient.NetworkCreate) CreateHookRequest {
+	req := CreateHookRequest{
+		TenantName:  tenantName,
+		NetworkName: networkName,
+		ServiceName: serviceName,
+		DocknetName: nwCreate.Name,
+		Encap:       nwCreate.Options["encap"],
+		Subnets:     []IPAMPool{},
+	}
+	req.PktTag, _ = strconv.Atoi(nwCreate.Options["pkt-tag"])
+	for _, ipam := range nwCreate.IPAM.Config {
+		req.Subnets = append(req.Subnets, IPAMPool{Subnet: ipam.Subnet, Gateway: ipam.Gateway})
+	}
+
+	return req
+}
+
+// runCreateHooks calls the create hooks in the order they were registered,
+// stopping at the first error
+func runCreateHooks(cfg config, req CreateHookRequest) error {
+	for _, hook := range cfg.createHooks {
+		if err := hook(req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RegisterCreateWebhook posts the create request of every docker network to
+// url as JSON before the network is created. The creation is aborted if the
+// webhook does not respond with a 2xx status.
+func RegisterCreateWebhook(url string) {
+	RegisterCreateHook(func(req CreateHookRequest) error {
+		return postCreateWebhook(url, req)
+	})
+}
+
+// postCreateWebhook posts a create request to a webhook
+func postCreateWebhook(url string, req CreateHookRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create webhook %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("create webhook %s returned %s", url, resp.Status)
+	}
+
+	return nil
+}
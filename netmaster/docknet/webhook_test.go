@@ -0,0 +1,101 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docknet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCreateWebhook(t *testing.T) {
+	docker, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearCreateHooks()
+
+	reqs := []CreateHookRequest{}
+	status := http.StatusOK
+	delay := time.Duration(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := CreateHookRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Error decoding webhook request. Err: %v", err)
+		}
+		reqs = append(reqs, req)
+		time.Sleep(delay)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	RegisterCreateWebhook(server.URL)
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != nil {
+		t.Fatalf("Error creating network. Err: %v", err)
+	}
+	if len(reqs) != 1 || reqs[0].TenantName != "unit-test" || reqs[0].NetworkName != "net1" ||
+		reqs[0].DocknetName != "net1/unit-test" || reqs[0].Encap != "vlan" || reqs[0].PktTag != 10 ||
+		len(reqs[0].Subnets) != 1 || reqs[0].Subnets[0].Subnet != "10.1.1.0/24" {
+		t.Fatalf("Unexpected webhook requests %+v", reqs)
+	}
+
+	// failure aborts the creation
+	status = http.StatusConflict
+	err = CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"))
+	if err == nil {
+		t.Fatalf("Network was created after the webhook failed")
+	}
+	if _, err := docker.InspectNetwork("net2/unit-test"); err == nil {
+		t.Fatalf("docker network was created after the webhook failed")
+	}
+	if getDocknetState("unit-test", "net2", "") != nil {
+		t.Fatalf("oper state was written after the webhook failed")
+	}
+
+	// so does a timeout
+	origTimeout := webhookTimeout
+	defer func() { webhookTimeout = origTimeout }()
+	webhookTimeout = 10 * time.Millisecond
+	status = http.StatusOK
+	delay = 200 * time.Millisecond
+	err = CreateDockNet("unit-test", "net2", "", fakeNwCfg("unit-test", "net2"))
+	if err == nil {
+		t.Fatalf("Network was created after the webhook timed out")
+	}
+}
+
+func TestCreateHook(t *testing.T) {
+	_, cleanup := setupFakeDocknet(t)
+	defer cleanup()
+	defer clearCreateHooks()
+
+	calls := []string{}
+	RegisterCreateHook(func(req CreateHookRequest) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	RegisterCreateHook(func(req CreateHookRequest) error {
+		calls = append(calls, "second")
+		return ErrDockerNetworkMissing
+	})
+
+	err := CreateDockNet("unit-test", "net1", "", fakeNwCfg("unit-test", "net1"))
+	if err != ErrDockerNetworkMissing || len(calls) != 2 || calls[0] != "first" {
+		t.Fatalf("Unexpected hook calls %v. Err: %v", calls, err)
+	}
+}
@@ -183,6 +183,21 @@ func (gc *Cfg) initVXLANBitset(vxlans string) (*resources.AutoVXLANCfgResource,
 	return vxlanRsrcCfg, nil
 }
 
+// resourceManager returns the state resource manager writing through the
+// state driver of the config, so that the tags of a network are allocated in
+// its batch of state writes
+func (gc *Cfg) resourceManager() (core.ResourceManager, error) {
+	rm, err := resources.GetStateResourceManager()
+	if err != nil {
+		return nil, err
+	}
+	if gc.StateDriver == nil {
+		return rm, nil
+	}
+
+	return rm.WithStateDriver(gc.StateDriver), nil
+}
+
 // GetVxlansInUse gets the vlans that are currently in use
 func (gc *Cfg) GetVxlansInUse() (uint, string) {
 	tempRm, err := resources.GetStateResourceManager()
@@ -198,11 +213,10 @@ func (gc *Cfg) GetVxlansInUse() (uint, string) {
 // AllocVXLAN allocates a new vxlan; ids for both the vxlan and vlan are returned.
 func (gc *Cfg) AllocVXLAN(reqVxlan uint) (vxlan uint, localVLAN uint, err error) {
 
-	tempRm, err := resources.GetStateResourceManager()
+	ra, err := gc.resourceManager()
 	if err != nil {
 		return 0, 0, err
 	}
-	ra := core.ResourceManager(tempRm)
 
 	g := &Oper{}
 	g.StateDriver = gc.StateDriver
@@ -232,11 +246,10 @@ func (gc *Cfg) AllocVXLAN(reqVxlan uint) (vxlan uint, localVLAN uint, err error)
 
 // FreeVXLAN returns a VXLAN id to the pool.
 func (gc *Cfg) FreeVXLAN(vxlan uint, localVLAN uint) error {
-	tempRm, err := resources.GetStateResourceManager()
+	ra, err := gc.resourceManager()
 	if err != nil {
 		return err
 	}
-	ra := core.ResourceManager(tempRm)
 
 	g := &Oper{}
 	g.StateDriver = gc.StateDriver
@@ -289,11 +302,10 @@ func (gc *Cfg) GetVlansInUse() (uint, string) {
 
 // AllocVLAN allocates a new VLAN resource. Returns an ID.
 func (gc *Cfg) AllocVLAN(reqVlan uint) (uint, error) {
-	tempRm, err := resources.GetStateResourceManager()
+	ra, err := gc.resourceManager()
 	if err != nil {
 		return 0, err
 	}
-	ra := core.ResourceManager(tempRm)
 
 	vlan, err := ra.AllocateResourceVal("global", resources.AutoVLANResource, reqVlan)
 	if err != nil {
@@ -306,11 +318,10 @@ func (gc *Cfg) AllocVLAN(reqVlan uint) (uint, error) {
 
 // FreeVLAN releases a VLAN for a given ID.
 func (gc *Cfg) FreeVLAN(vlan uint) error {
-	tempRm, err := resources.GetStateResourceManager()
+	ra, err := gc.resourceManager()
 	if err != nil {
 		return err
 	}
-	ra := core.ResourceManager(tempRm)
 
 	return ra.DeallocateResourceVal("global", resources.AutoVLANResource, vlan)
 }
@@ -51,6 +51,12 @@ type ConfigNetwork struct {
 	IPv6Gateway    string
 	Vrf            string
 
+	// docker network flags, internal networks have no external
+	// connectivity and attachable networks let containers be connected
+	// with docker network connect
+	Internal   bool
+	Attachable bool
+
 	// eps associated with the network
 	Endpoints []ConfigEP
 }
@@ -19,20 +19,47 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/daemon"
+	"github.com/contiv/netplugin/netmaster/docknet"
+	"github.com/contiv/netplugin/state"
+	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/version"
 )
 
 type cliOpts struct {
-	help         bool
-	debug        bool
-	clusterStore string
-	listenURL    string
-	clusterMode  string
-	version      bool
+	help            bool
+	debug           bool
+	clusterStore    string
+	storeSecurity   core.DbSecurity
+	storeEncryption utils.StateEncryption
+	storeCache      bool
+	storeCacheAge   time.Duration
+	listenURL       string
+	clusterMode     string
+	version         bool
+	dockerHost      string
+	dockerCertPath  string
+	dockerTLSVerify bool
+	reconcileEvery  time.Duration
+	reconcileMode   string
+	removedPolicy   string
+	nameScheme      string
+	nameSeparator   string
+	sensitiveOpts   string
+	gcEvery         time.Duration
+	gcGrace         time.Duration
+	gcDryRun        bool
+	chkEvery        time.Duration
+	chkRepair       bool
+	chkGrace        time.Duration
+	backupSecrets   bool
+	auditRecords    int
+	auditAge        time.Duration
 }
 
 var flagSet *flag.FlagSet
@@ -56,6 +83,16 @@ func parseOpts(opts *cliOpts) error {
 		"cluster-store",
 		"etcd://127.0.0.1:2379",
 		"Etcd or Consul cluster store url.")
+	utils.AddDbSecurityFlags(flagSet, &opts.storeSecurity)
+	utils.AddStateEncryptionFlags(flagSet, &opts.storeEncryption)
+	flagSet.BoolVar(&opts.storeCache,
+		"cluster-store-cache",
+		false,
+		"Cache the state listed from the state store, invalidated by watches")
+	flagSet.DurationVar(&opts.storeCacheAge,
+		"cluster-store-cache-max-age",
+		time.Minute,
+		"Time the cached state is used before it is reloaded, 0 to use it until it changes")
 	flagSet.StringVar(&opts.listenURL,
 		"listen-url",
 		":9999",
@@ -69,6 +106,81 @@ func parseOpts(opts *cliOpts) error {
 		false,
 		"prints current version")
 
+	// docker endpoint defaults to the docker client environment
+	dockerEnv := docknet.DockerEndpointFromEnv()
+	flagSet.StringVar(&opts.dockerHost,
+		"docker-host",
+		dockerEnv.Host,
+		"Docker daemon url, unix:///path or tcp://host:port")
+	flagSet.StringVar(&opts.dockerCertPath,
+		"docker-cert-path",
+		dockerEnv.CertPath,
+		"Directory with the ca.pem, cert.pem and key.pem files to connect to docker with TLS")
+	flagSet.BoolVar(&opts.dockerTLSVerify,
+		"docker-tls-verify",
+		dockerEnv.TLSVerify,
+		"Verify the docker daemon certificate")
+	flagSet.DurationVar(&opts.reconcileEvery,
+		"docknet-reconcile-interval",
+		0,
+		"Interval to reconcile docker networks with the docknet state, 0 to disable")
+	flagSet.StringVar(&opts.reconcileMode,
+		"docknet-reconcile-mode",
+		docknet.RepairSafe.String(),
+		"{report-only, repair-safe, repair-destructive}")
+	flagSet.StringVar(&opts.removedPolicy,
+		"docknet-removed-policy",
+		"",
+		"Watch docker events and {report, forget, recreate} the docker networks removed outside of netmaster, empty to disable")
+	flagSet.StringVar(&opts.nameScheme,
+		"docknet-name-scheme",
+		docknet.TenantSuffixScheme,
+		"Name new docker networks {tenant-suffix, tenant-prefix, flat}, existing networks keep their names")
+	flagSet.StringVar(&opts.nameSeparator,
+		"docknet-name-separator",
+		docknet.DocknetNameSeparator,
+		"Separator of the network and tenant in docker network names")
+	flagSet.StringVar(&opts.sensitiveOpts,
+		"docknet-sensitive-options",
+		"",
+		"Comma separated driver and IPAM options or annotations whose values are redacted, and encrypted in the state with a state encryption key, eg. bgp-key")
+	flagSet.DurationVar(&opts.gcEvery,
+		"docknet-gc-interval",
+		0,
+		"Interval to remove the docker networks whose network was deleted, 0 to disable")
+	flagSet.DurationVar(&opts.gcGrace,
+		"docknet-gc-grace",
+		10*time.Minute,
+		"Time a docker network must be without its network before it is removed")
+	flagSet.BoolVar(&opts.gcDryRun,
+		"docknet-gc-dry-run",
+		false,
+		"Only log the docker networks the GC would remove")
+	flagSet.DurationVar(&opts.chkEvery,
+		"state-chk-interval",
+		0,
+		"Interval to check the consistency of the network, endpoint, IPAM, OVS and docknet state, 0 to disable")
+	flagSet.BoolVar(&opts.chkRepair,
+		"state-chk-repair",
+		false,
+		"Repair the inconsistencies found by the state check")
+	flagSet.DurationVar(&opts.chkGrace,
+		"state-chk-grace",
+		5*time.Minute,
+		"Time an inconsistency must persist before it is repaired")
+	flagSet.BoolVar(&opts.backupSecrets,
+		"state-backup-secrets",
+		false,
+		"Serve state store backups with the sensitive fields, which are redacted and can not be restored otherwise")
+	flagSet.IntVar(&opts.auditRecords,
+		"audit-max-records",
+		10000,
+		"Number of most recent audit log records to keep, 0 for no limit")
+	flagSet.DurationVar(&opts.auditAge,
+		"audit-max-age",
+		0,
+		"Age of the oldest audit log record to keep, 0 for no limit")
+
 	return flagSet.Parse(os.Args[1:])
 }
 
@@ -90,6 +202,27 @@ func execOpts(opts *cliOpts) {
 	if opts.debug {
 		log.SetLevel(log.DebugLevel)
 	}
+
+	err := docknet.SetDockerEndpoint(docknet.DockerEndpoint{
+		Host:      opts.dockerHost,
+		CertPath:  opts.dockerCertPath,
+		TLSVerify: opts.dockerTLSVerify,
+	})
+	if err != nil {
+		log.Fatalf("Invalid docker endpoint. Error: %s", err)
+	}
+
+	scheme, err := docknet.ParseNameScheme(opts.nameScheme, opts.nameSeparator)
+	if err != nil {
+		log.Fatalf("Invalid docknet name scheme %q. Error: %s", opts.nameScheme, err)
+	}
+	docknet.SetNameScheme(scheme)
+
+	for _, option := range strings.Split(opts.sensitiveOpts, ",") {
+		if option = strings.TrimSpace(option); option != "" {
+			docknet.MarkSensitiveOption(option)
+		}
+	}
 }
 
 func main() {
@@ -102,11 +235,46 @@ func main() {
 	// execute options
 	execOpts(&opts)
 
+	if err := utils.LoadDbPassword(&opts.storeSecurity); err != nil {
+		log.Fatalf("Failed to read the cluster store password. Error: %s", err)
+	}
+
+	if err := utils.InitStateEncryption(opts.storeEncryption); err != nil {
+		log.Fatalf("Failed to set the state encryption. Error: %s", err)
+	}
+
+	reconcileMode, err := docknet.ParseReconcileMode(opts.reconcileMode)
+	if err != nil {
+		log.Fatalf("Invalid docknet reconcile mode %q. Error: %s", opts.reconcileMode, err)
+	}
+
+	var removedPolicy docknet.RemovedPolicy
+	if opts.removedPolicy != "" {
+		removedPolicy, err = docknet.ParseRemovedPolicy(opts.removedPolicy)
+		if err != nil {
+			log.Fatalf("Invalid docknet removed policy %q. Error: %s", opts.removedPolicy, err)
+		}
+	}
+
 	// create master daemon
 	d := &daemon.MasterDaemon{
-		ListenURL:    opts.listenURL,
-		ClusterStore: opts.clusterStore,
-		ClusterMode:  opts.clusterMode,
+		ListenURL:                opts.listenURL,
+		ClusterStore:             opts.clusterStore,
+		ClusterStoreSecurity:     opts.storeSecurity,
+		ClusterStoreCache:        opts.storeCache,
+		ClusterStoreCacheMaxAge:  opts.storeCacheAge,
+		ClusterMode:              opts.clusterMode,
+		DocknetReconcileInterval: opts.reconcileEvery,
+		DocknetReconcileMode:     reconcileMode,
+		DocknetRemovedPolicy:     removedPolicy,
+		DocknetGCInterval:        opts.gcEvery,
+		DocknetGCGrace:           opts.gcGrace,
+		DocknetGCDryRun:          opts.gcDryRun,
+		StateChkInterval:         opts.chkEvery,
+		StateChkRepair:           opts.chkRepair,
+		StateChkGrace:            opts.chkGrace,
+		StateBackupSecrets:       opts.backupSecrets,
+		AuditRetention:           state.AuditRetention{MaxRecords: opts.auditRecords, MaxAge: opts.auditAge},
 	}
 
 	// initialize master daemon
@@ -378,33 +378,30 @@ func UpdateEndpointHandler(w http.ResponseWriter, r *http.Request, vars map[stri
 
 		epCfg.ID = getEpName(key, &intent.ConfigEP{Container: epUpdReq.EndpointID})
 
-		err = epCfg.Read(epCfg.ID)
-		if err != nil {
-			return nil, err
-		}
-
 		provider := &mastercfg.Provider{}
 		provider.IPAddress = epUpdReq.IPAddress
 		provider.Tenant = epUpdReq.Tenant
 		provider.Network = epUpdReq.Network
 		provider.ContainerID = epUpdReq.ContainerID
 		provider.Labels = make(map[string]string)
-
-		if epCfg.Labels == nil {
-			//endpoint cfg doesnt have labels
-			epCfg.Labels = make(map[string]string)
-		}
-
 		for k, v := range epUpdReq.Labels {
 			provider.Labels[k] = v
-			epCfg.Labels[k] = v
 		}
 		provider.EpIDKey = epCfg.ID
-		//maintain the containerId in endpointstat for recovery
-		epCfg.ContainerID = epUpdReq.ContainerID
-		epCfg.EPCommonName = epUpdReq.EPCommonName
 
-		err = epCfg.Write()
+		err = epCfg.Update(func() error {
+			if epCfg.Labels == nil {
+				//endpoint cfg doesnt have labels
+				epCfg.Labels = make(map[string]string)
+			}
+			for k, v := range epUpdReq.Labels {
+				epCfg.Labels[k] = v
+			}
+			//maintain the containerId in endpointstat for recovery
+			epCfg.ContainerID = epUpdReq.ContainerID
+			epCfg.EPCommonName = epUpdReq.EPCommonName
+			return nil
+		})
 		if err != nil {
 			log.Errorf("error writing ep config. Error: %s", err)
 			return nil, err
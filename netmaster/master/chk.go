@@ -0,0 +1,616 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/docknet"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
+	"github.com/contiv/netplugin/utils"
+	"github.com/contiv/netplugin/utils/netutils"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Kinds of the inconsistencies found by CheckState
+const (
+	// ChkLeakedIP is an address allocated in a network without an endpoint
+	// or service LB
+	ChkLeakedIP = "leaked-ip"
+	// ChkUnallocatedIP is an endpoint or service LB address that is free in
+	// its network
+	ChkUnallocatedIP = "unallocated-ip"
+	// ChkDuplicateIP is an address of several endpoints or service LBs of a
+	// network
+	ChkDuplicateIP = "duplicate-ip"
+	// ChkDanglingEndpoint is an endpoint of a missing network
+	ChkDanglingEndpoint = "dangling-endpoint"
+	// ChkDanglingOperEndpoint is an endpoint oper state of a missing endpoint
+	ChkDanglingOperEndpoint = "dangling-oper-endpoint"
+	// ChkStaleOperEndpoint is an endpoint oper state that does not match its
+	// endpoint
+	ChkStaleOperEndpoint = "stale-oper-endpoint"
+	// ChkMissingOVSPort is an endpoint oper state without an OVS port in the
+	// OVS oper state of its host
+	ChkMissingOVSPort = "missing-ovs-port"
+	// ChkDanglingOVSPort is an OVS port without an endpoint oper state
+	ChkDanglingOVSPort = "dangling-ovs-port"
+	// ChkMissingDocknet is a network without a docknet, in docker mode
+	ChkMissingDocknet = "missing-docknet"
+	// ChkDanglingDocknet is a docknet of a missing network
+	ChkDanglingDocknet = "dangling-docknet"
+	// ChkMissingDockerNetwork is a docknet whose docker network is missing
+	ChkMissingDockerNetwork = "missing-docker-network"
+)
+
+// ChkOptions selects the repairs made by CheckState
+type ChkOptions struct {
+	Repair bool          // repair the issues that can be repaired
+	Grace  time.Duration // time an issue must persist before it is repaired
+}
+
+// ChkIssue is an inconsistency found by CheckState, identified by its kind
+// and key, eg. the network and address of a leaked IP. Since is when a check
+// of this netmaster first found it.
+type ChkIssue struct {
+	Kind       string    `json:"kind"`
+	Key        string    `json:"key"`
+	Detail     string    `json:"detail"`
+	Since      time.Time `json:"since"`
+	Repairable bool      `json:"repairable"`
+	Repaired   bool      `json:"repaired"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ChkReport has the issues found by CheckState, ordered by kind and key
+type ChkReport struct {
+	Time    time.Time      `json:"time"`
+	Repair  bool           `json:"repair"`
+	Checked map[string]int `json:"checked"` // number of states checked, by type
+	Issues  []ChkIssue     `json:"issues"`
+}
+
+type chkIssues []ChkIssue
+
+func (l chkIssues) Len() int      { return len(l) }
+func (l chkIssues) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l chkIssues) Less(i, j int) bool {
+	if l[i].Kind != l[j].Kind {
+		return l[i].Kind < l[j].Kind
+	}
+	return l[i].Key < l[j].Key
+}
+
+var (
+	// chkMutex serializes the checks and protects chkSince, when each issue
+	// was first found, by kind and key
+	chkMutex sync.Mutex
+	chkSince = make(map[string]time.Time)
+)
+
+// stateChecker collects the issues of a check
+type stateChecker struct {
+	opts   ChkOptions
+	report *ChkReport
+	seen   map[string]bool
+}
+
+// found adds an issue to the report. It is repaired by repair if the repair
+// was asked and the issue is older than the grace period. Issues without a
+// repair are only reported.
+func (c *stateChecker) found(kind, key, detail string, repair func() error) {
+	id := kind + "/" + key
+	since, ok := chkSince[id]
+	if !ok {
+		since = c.report.Time
+		chkSince[id] = since
+	}
+	c.seen[id] = true
+
+	issue := ChkIssue{
+		Kind:       kind,
+		Key:        key,
+		Detail:     detail,
+		Since:      since,
+		Repairable: repair != nil,
+	}
+	if repair != nil && c.opts.Repair && c.report.Time.Sub(since) >= c.opts.Grace {
+		log.Warnf("Repairing %s %s: %s", kind, key, detail)
+		if err := repair(); err != nil {
+			log.Errorf("Error repairing %s %s. Err: %v", kind, key, err)
+			issue.Error = err.Error()
+		} else {
+			issue.Repaired = true
+		}
+	}
+
+	c.report.Issues = append(c.report.Issues, issue)
+}
+
+// CheckState cross-validates the network and endpoint config, the address
+// allocations, the endpoint and OVS oper states and, in docker mode, the
+// docknets and their docker networks. If opts.Repair is set, it repairs the
+// leaked and unallocated addresses, the dangling endpoints and endpoint oper
+// states, and the missing docknets and docker networks found for at least
+// opts.Grace. The other issues are only reported, the OVS oper state is owned
+// by netplugin and the dangling docknets are left to the docknet GC.
+func CheckState(stateDriver core.StateDriver, opts ChkOptions) (ChkReport, error) {
+	chkMutex.Lock()
+	defer chkMutex.Unlock()
+
+	report := ChkReport{
+		Time:    time.Now(),
+		Repair:  opts.Repair,
+		Checked: make(map[string]int),
+		Issues:  []ChkIssue{},
+	}
+	c := &stateChecker{opts: opts, report: &report, seen: make(map[string]bool)}
+
+	// no address is allocated or released during the check
+	addrMutex.Lock()
+	nets, err := c.checkEndpoints(stateDriver)
+	addrMutex.Unlock()
+	if err != nil {
+		return report, err
+	}
+
+	// creating a docker network allocates its gateway through netmaster, so
+	// the docknets are checked without the address lock
+	if GetClusterMode() == "docker" {
+		if aci, _ := IsAciConfigured(); !aci {
+			if err := c.checkDocknets(nets); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	for id := range chkSince {
+		if !c.seen[id] {
+			delete(chkSince, id)
+		}
+	}
+	sort.Sort(chkIssues(report.Issues))
+
+	return report, nil
+}
+
+// normalizeAddr returns the canonical form of an IPv4 or IPv6 address
+func normalizeAddr(addr string) string {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.String()
+	}
+
+	return addr
+}
+
+// checkEndpoints checks the endpoints, the address allocations and the
+// endpoint and OVS oper states. It returns the networks by ID.
+func (c *stateChecker) checkEndpoints(stateDriver core.StateDriver) (map[string]*mastercfg.CfgNetworkState, error) {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = stateDriver
+	nwList, err := nwCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	nets := make(map[string]*mastercfg.CfgNetworkState)
+	netIDs := []string{}
+	for _, s := range nwList {
+		nw := s.(*mastercfg.CfgNetworkState)
+		nets[nw.ID] = nw
+		netIDs = append(netIDs, nw.ID)
+	}
+	sort.Strings(netIDs)
+	c.report.Checked["networks"] = len(nets)
+
+	epCfg := &mastercfg.CfgEndpointState{}
+	epCfg.StateDriver = stateDriver
+	epList, err := epCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	eps := make(map[string]*mastercfg.CfgEndpointState)
+	epIDs := []string{}
+	for _, s := range epList {
+		ep := s.(*mastercfg.CfgEndpointState)
+		eps[ep.ID] = ep
+		epIDs = append(epIDs, ep.ID)
+	}
+	sort.Strings(epIDs)
+	c.report.Checked["endpoints"] = len(eps)
+
+	// the endpoints and service LBs using each address, by network
+	netAddrs := make(map[string]map[string][]string)
+	addUser := func(netID, addr, user string) {
+		if netAddrs[netID] == nil {
+			netAddrs[netID] = make(map[string][]string)
+		}
+		addr = normalizeAddr(addr)
+		netAddrs[netID][addr] = append(netAddrs[netID][addr], user)
+	}
+	for _, epID := range epIDs {
+		ep := eps[epID]
+		if _, ok := nets[ep.NetID]; !ok {
+			c.found(ChkDanglingEndpoint, ep.ID, fmt.Sprintf("network %s does not exist", ep.NetID), func() error {
+				_, err := DeleteEndpointID(stateDriver, ep.ID)
+				return err
+			})
+			continue
+		}
+
+		for _, addr := range []string{ep.IPAddress, ep.IPv6Address} {
+			if addr != "" {
+				addUser(ep.NetID, addr, "endpoint "+ep.ID)
+			}
+		}
+	}
+
+	// the service LB addresses are allocated without endpoint
+	svcCfg := &mastercfg.CfgServiceLBState{}
+	svcCfg.StateDriver = stateDriver
+	svcList, err := svcCfg.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	for _, s := range svcList {
+		svc := s.(*mastercfg.CfgServiceLBState)
+		if svc.IPAddress != "" {
+			addUser(svc.Network+"."+svc.Tenant, svc.IPAddress, "service LB "+svc.ID)
+		}
+	}
+	c.report.Checked["serviceLBs"] = len(svcList)
+
+	for _, netID := range netIDs {
+		c.checkAllocations(nets[netID], netAddrs[netID])
+	}
+
+	if err := c.checkOperEndpoints(stateDriver, eps); err != nil {
+		return nil, err
+	}
+
+	return nets, nil
+}
+
+// checkAllocations checks the addresses allocated in a network against the
+// addresses of its endpoints and service LBs
+func (c *stateChecker) checkAllocations(nw *mastercfg.CfgNetworkState, addrUsers map[string][]string) {
+	addrs := []string{}
+	for addr := range addrUsers {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		addr, users := addr, addrUsers[addr]
+		if len(users) > 1 {
+			c.found(ChkDuplicateIP, nw.ID+"/"+addr,
+				fmt.Sprintf("address of %s", strings.Join(users, ", ")), nil)
+		}
+		if !addressAllocated(nw, addr) {
+			c.found(ChkUnallocatedIP, nw.ID+"/"+addr,
+				fmt.Sprintf("address of %s is free in the network", users[0]), func() error {
+					return reserveAddress(nw, addr)
+				})
+		}
+	}
+
+	for _, addr := range allocatedAddresses(nw) {
+		addr := addr
+		if _, ok := addrUsers[addr]; !ok {
+			c.found(ChkLeakedIP, nw.ID+"/"+addr, "address is allocated without an endpoint or service LB", func() error {
+				return releaseLeakedAddress(nw, addr)
+			})
+		}
+	}
+}
+
+// addressAllocated returns true if an endpoint address is allocated in its
+// network. Addresses outside of the subnet are not checked.
+func addressAllocated(nw *mastercfg.CfgNetworkState, addr string) bool {
+	if netutils.IsIPv6(addr) {
+		hostID, err := netutils.GetIPv6HostID(nw.IPv6Subnet, nw.IPv6SubnetLen, addr)
+		return err != nil || nw.IPv6AllocMap[hostID]
+	}
+
+	ipAddrValue, err := netutils.GetIPNumber(nw.SubnetIP, nw.SubnetLen, 32, addr)
+	return err != nil || nw.IPAllocMap.Test(ipAddrValue)
+}
+
+// allocatedAddresses returns the addresses allocated in a network, other than
+// the gateways and the reserved addresses, ordered
+func allocatedAddresses(nw *mastercfg.CfgNetworkState) []string {
+	addrs := []string{}
+
+	if nw.SubnetIP != "" {
+		allocated := nw.IPAllocMap.Clone()
+		netutils.ClearReservedEntries(allocated, nw.SubnetLen)
+		netutils.ClearBitsOutsideRange(allocated, nw.IPAddrRange, nw.SubnetLen)
+		for idx, found := allocated.NextSet(0); found; idx, found = allocated.NextSet(idx + 1) {
+			addr, err := netutils.GetSubnetIP(nw.SubnetIP, nw.SubnetLen, 32, idx)
+			if err != nil {
+				log.Warnf("Error getting address %d of network %s. Err: %v", idx, nw.ID, err)
+				continue
+			}
+			if nw.Gateway == "" || addr != normalizeAddr(nw.Gateway) {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+
+	for hostID, allocated := range nw.IPv6AllocMap {
+		if !allocated {
+			continue
+		}
+		addr, err := netutils.GetSubnetIPv6(nw.IPv6Subnet, nw.IPv6SubnetLen, hostID)
+		if err != nil {
+			log.Warnf("Error getting address %s of network %s. Err: %v", hostID, nw.ID, err)
+			continue
+		}
+		addr = normalizeAddr(addr)
+		if nw.IPv6Gateway == "" || addr != normalizeAddr(nw.IPv6Gateway) {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+// reserveAddress allocates the address of an endpoint in its network, as
+// found in the network state when it is written
+func reserveAddress(nw *mastercfg.CfgNetworkState, addr string) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = nw.StateDriver
+	nwCfg.ID = nw.ID
+
+	return nwCfg.Update(func() error {
+		if addressAllocated(nwCfg, addr) {
+			return state.ErrUnchanged
+		}
+
+		if netutils.IsIPv6(addr) {
+			hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, addr)
+			if err != nil {
+				return err
+			}
+			netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
+		} else {
+			ipAddrValue, err := netutils.GetIPNumber(nwCfg.SubnetIP, nwCfg.SubnetLen, 32, addr)
+			if err != nil {
+				return err
+			}
+			nwCfg.IPAllocMap.Set(ipAddrValue)
+		}
+		nwCfg.EpAddrCount++
+
+		return nil
+	})
+}
+
+// releaseLeakedAddress releases an address allocated without an endpoint, as
+// found in the network state when it is written
+func releaseLeakedAddress(nw *mastercfg.CfgNetworkState, addr string) error {
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = nw.StateDriver
+	nwCfg.ID = nw.ID
+
+	return nwCfg.Update(func() error {
+		if !netutils.IsIPv6(addr) {
+			return releaseNetworkAddress(nwCfg, addr)
+		}
+
+		// releaseNetworkAddress gets the host ID of IPv6 addresses in the
+		// IPv4 subnet
+		hostID, err := netutils.GetIPv6HostID(nwCfg.IPv6Subnet, nwCfg.IPv6SubnetLen, addr)
+		if err != nil {
+			return err
+		}
+		if !nwCfg.IPv6AllocMap[hostID] {
+			return state.ErrUnchanged
+		}
+		delete(nwCfg.IPv6AllocMap, hostID)
+		nwCfg.EpAddrCount--
+
+		return nil
+	})
+}
+
+// checkOperEndpoints checks the endpoint oper states against the endpoints
+// and the OVS oper states of their hosts
+func (c *stateChecker) checkOperEndpoints(stateDriver core.StateDriver,
+	eps map[string]*mastercfg.CfgEndpointState) error {
+	operEp := &drivers.OvsOperEndpointState{}
+	operEp.StateDriver = stateDriver
+	operList, err := operEp.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	opers := make(map[string]*drivers.OvsOperEndpointState)
+	operIDs := []string{}
+	for _, s := range operList {
+		oper := s.(*drivers.OvsOperEndpointState)
+		opers[oper.ID] = oper
+		operIDs = append(operIDs, oper.ID)
+	}
+	sort.Strings(operIDs)
+	c.report.Checked["operEndpoints"] = len(opers)
+
+	ovsOper := &drivers.OvsDriverOperState{}
+	ovsOper.StateDriver = stateDriver
+	ovsList, err := ovsOper.ReadAll()
+	if core.ErrIfKeyExists(err) != nil {
+		return err
+	}
+	hosts := make(map[string]*drivers.OvsDriverOperState)
+	hostIDs := []string{}
+	for _, s := range ovsList {
+		host := s.(*drivers.OvsDriverOperState)
+		hosts[host.ID] = host
+		hostIDs = append(hostIDs, host.ID)
+	}
+	sort.Strings(hostIDs)
+	c.report.Checked["ovsHosts"] = len(hosts)
+
+	for _, operID := range operIDs {
+		oper := opers[operID]
+		ep, ok := eps[oper.ID]
+		if !ok {
+			c.found(ChkDanglingOperEndpoint, oper.ID, "endpoint does not exist", oper.Clear)
+			continue
+		}
+		if !oper.Matches(ep) {
+			c.found(ChkStaleOperEndpoint, oper.ID, "oper state does not match the endpoint", nil)
+		}
+		if host, ok := hosts[oper.HomingHost]; ok && host.LocalEpInfo[oper.ID] == nil {
+			c.found(ChkMissingOVSPort, oper.ID, fmt.Sprintf("host %s has no OVS port", host.ID), nil)
+		}
+	}
+
+	for _, hostID := range hostIDs {
+		host := hosts[hostID]
+		epIDs := []string{}
+		for epID := range host.LocalEpInfo {
+			epIDs = append(epIDs, epID)
+		}
+		sort.Strings(epIDs)
+
+		for _, epID := range epIDs {
+			if _, ok := opers[epID]; !ok {
+				c.found(ChkDanglingOVSPort, hostID+"/"+epID,
+					fmt.Sprintf("OVS port %s has no endpoint oper state", host.LocalEpInfo[epID].Ovsportname), nil)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkDocknets checks the docknets against the networks and the docker
+// networks
+func (c *stateChecker) checkDocknets(nets map[string]*mastercfg.CfgNetworkState) error {
+	dnets, err := docknet.ListDockNets()
+	if err != nil {
+		return err
+	}
+	c.report.Checked["docknets"] = len(dnets)
+
+	// networks with a docknet
+	docknetNets := make(map[string]bool)
+	dnetsByID := make(map[string]*docknet.DnetOperState)
+	for _, dnet := range dnets {
+		dnetsByID[dnet.ID] = dnet
+		netID := mastercfg.GetNwCfgKey(dnet.NetworkName, dnet.TenantName)
+		if _, ok := nets[netID]; !ok {
+			c.found(ChkDanglingDocknet, dnet.ID, fmt.Sprintf("network %s does not exist", netID), nil)
+			continue
+		}
+		if dnet.ServiceName == "" {
+			docknetNets[netID] = true
+		}
+	}
+
+	netIDs := []string{}
+	for netID := range nets {
+		netIDs = append(netIDs, netID)
+	}
+	sort.Strings(netIDs)
+	for _, netID := range netIDs {
+		nw := nets[netID]
+		if nw.NwType == "infra" || docknetNets[netID] {
+			continue
+		}
+		c.found(ChkMissingDocknet, netID, "network has no docknet", func() error {
+			return docknet.CreateDockNet(nw.Tenant, nw.NetworkName, "", nw)
+		})
+	}
+
+	reconcile, err := docknet.Reconcile(docknet.ReportOnly)
+	if err != nil {
+		return err
+	}
+	for _, operID := range reconcile.MissingNetworks {
+		dnet := dnetsByID[operID]
+		if dnet == nil {
+			continue
+		}
+		c.found(ChkMissingDockerNetwork, operID, fmt.Sprintf("docker network %s does not exist", dnet.DocknetUUID),
+			func() error {
+				return docknet.RecreateDockNet(dnet.TenantName, dnet.NetworkName, dnet.ServiceName)
+			})
+	}
+
+	return nil
+}
+
+// StartChkLoop checks the state every interval, repairing it as opts allow,
+// see CheckState. The returned function stops the loop.
+func StartChkLoop(interval time.Duration, opts ChkOptions) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer close(done)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if docknet.ReconcilePaused() {
+				log.Debugf("docknet reconcile is paused, skipping the state check")
+				continue
+			}
+
+			stateDriver, err := utils.GetStateDriver()
+			if err != nil {
+				log.Errorf("Error getting the state driver. Err: %v", err)
+				continue
+			}
+			report, err := CheckState(stateDriver, opts)
+			if err != nil {
+				log.Errorf("Error checking the state. Err: %v", err)
+				continue
+			}
+
+			repaired := 0
+			for _, issue := range report.Issues {
+				if issue.Repaired {
+					repaired++
+				}
+			}
+			if len(report.Issues) > 0 {
+				log.Warnf("State check found %d issues, repaired %d", len(report.Issues), repaired)
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
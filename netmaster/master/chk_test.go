@@ -0,0 +1,153 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/drivers"
+	"github.com/contiv/netplugin/netmaster/intent"
+	"github.com/contiv/netplugin/netmaster/mastercfg"
+)
+
+// chkIssueKeys returns the kind and key of the issues, and the repaired ones
+func chkIssueKeys(report ChkReport) ([]string, []string) {
+	issues, repaired := []string{}, []string{}
+	for _, issue := range report.Issues {
+		issues = append(issues, issue.Kind+" "+issue.Key)
+		if issue.Repaired {
+			repaired = append(repaired, issue.Kind+" "+issue.Key)
+		}
+	}
+	return issues, repaired
+}
+
+func TestCheckState(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                  : "tenant-one",
+        "Networks"  : [{
+            "Name"              : "orange",
+            "SubnetCIDR"        : "10.1.1.0/24",
+            "Gateway"           : "10.1.1.254",
+            "Endpoints" : [
+            {
+                "Container"     : "myContainer1"
+            },
+            {
+                "Container"     : "myContainer2"
+            }
+            ]
+        }]
+    }]}`)
+
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("Error reading the network. Err: %v", err)
+	}
+	// an address without endpoint
+	if _, err := networkAllocAddress(nwCfg, "", false); err != nil {
+		t.Fatalf("Error allocating an address. Err: %v", err)
+	}
+
+	// a service LB address is allocated without endpoint
+	svcCfg := &intent.ConfigServiceLB{ServiceName: "svc", Tenant: "tenant-one", Network: "orange"}
+	if err := CreateServiceLB(fakeDriver, svcCfg); err != nil {
+		t.Fatalf("Error creating the service LB. Err: %v", err)
+	}
+	defer DeleteServiceLB(fakeDriver, "svc", "tenant-one")
+
+	// an endpoint address released
+	ep2 := &mastercfg.CfgEndpointState{}
+	ep2.StateDriver = fakeDriver
+	if err := ep2.Read("orange.tenant-one-myContainer2"); err != nil {
+		t.Fatalf("Error reading the endpoint. Err: %v", err)
+	}
+	if err := networkReleaseAddress(nwCfg, ep2.IPAddress); err != nil {
+		t.Fatalf("Error releasing an address. Err: %v", err)
+	}
+
+	// an endpoint of a missing network, an oper state of a missing endpoint
+	// and an OVS port without oper state
+	epCfg := &mastercfg.CfgEndpointState{NetID: "blue.tenant-one", IPAddress: "10.2.2.1"}
+	epCfg.StateDriver = fakeDriver
+	epCfg.ID = "blue.tenant-one-myContainer3"
+	operEp := &drivers.OvsOperEndpointState{NetID: "orange.tenant-one"}
+	operEp.StateDriver = fakeDriver
+	operEp.ID = "orange.tenant-one-myContainer4"
+	ovsOper := &drivers.OvsDriverOperState{LocalEpInfo: map[string]*drivers.EpInfo{
+		"orange.tenant-one-myContainer5": {Ovsportname: "vport5"},
+	}}
+	ovsOper.StateDriver = fakeDriver
+	ovsOper.ID = "host1"
+	for _, s := range []interface {
+		Write() error
+	}{epCfg, operEp, ovsOper} {
+		if err := s.Write(); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+	}
+
+	expected := []string{
+		"dangling-endpoint blue.tenant-one-myContainer3",
+		"dangling-oper-endpoint orange.tenant-one-myContainer4",
+		"dangling-ovs-port host1/orange.tenant-one-myContainer5",
+		"leaked-ip orange.tenant-one/10.1.1.3",
+		"unallocated-ip orange.tenant-one/" + ep2.IPAddress,
+	}
+	check := func(opts ChkOptions, expected, expectedRepaired []string) {
+		report, err := CheckState(fakeDriver, opts)
+		if err != nil {
+			t.Fatalf("Error checking the state. Err: %v", err)
+		}
+		issues, repaired := chkIssueKeys(report)
+		if len(issues) != len(expected) || len(repaired) != len(expectedRepaired) {
+			t.Fatalf("Expected issues %v repaired %v, got %v repaired %v", expected, expectedRepaired, issues, repaired)
+		}
+		for i := range expected {
+			if issues[i] != expected[i] {
+				t.Fatalf("Expected issues %v, got %v", expected, issues)
+			}
+		}
+		for i := range expectedRepaired {
+			if repaired[i] != expectedRepaired[i] {
+				t.Fatalf("Expected repaired %v, got %v", expectedRepaired, repaired)
+			}
+		}
+	}
+
+	check(ChkOptions{}, expected, []string{})
+	// nothing is repaired within the grace period
+	check(ChkOptions{Repair: true, Grace: time.Hour}, expected, []string{})
+
+	repaired := append(append([]string{}, expected[:2]...), expected[3:]...)
+	check(ChkOptions{Repair: true}, expected, repaired)
+	check(ChkOptions{}, expected[2:3], []string{})
+
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("Error reading the network. Err: %v", err)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1-10.1.1.2, 10.1.1.4, 10.1.1.254" {
+		t.Fatalf("Unexpected allocated addresses %s", allocated)
+	}
+}
@@ -24,4 +24,21 @@ const (
 	GetServiceRESTEndpoint = "service"
 	//GetServicesRESTEndpoint is the REST endpoint to request info of all services
 	GetServicesRESTEndpoint = "services"
+	// GetDocknetRESTEndpoint is the REST endpoint to inspect a docknet by oper state ID
+	GetDocknetRESTEndpoint = "docknet"
+	// GetDocknetsRESTEndpoint is the REST endpoint to list the docknets
+	GetDocknetsRESTEndpoint = "docknets"
+	// StateBackupRESTEndpoint is the REST endpoint to back up the state store
+	StateBackupRESTEndpoint = "state/backup"
+	// StateRestoreRESTEndpoint is the REST endpoint to restore a state store backup
+	StateRestoreRESTEndpoint = "state/restore"
+	// GetMetricsRESTEndpoint is the REST endpoint to get the netmaster metrics
+	GetMetricsRESTEndpoint = "metrics"
+	// StateInspectRESTEndpoint is the REST endpoint to inspect the state store
+	StateInspectRESTEndpoint = "debug/state"
+	// StateChkRESTEndpoint is the REST endpoint to check and repair the
+	// consistency of the state
+	StateChkRESTEndpoint = "state/chk"
+	// AuditRESTEndpoint is the REST endpoint to read the audit log
+	AuditRESTEndpoint = "audit"
 )
@@ -22,6 +22,7 @@ import (
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
 
 	log "github.com/Sirupsen/logrus"
@@ -116,57 +117,79 @@ func CreateEndpoint(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkSta
 	epCfg.ServiceName = ep.ServiceName
 	epCfg.EPCommonName = epReq.EPCommonName
 
-	// Allocate addresses
-	err = allocSetEpAddress(ep, epCfg, nwCfg)
-	if err != nil {
-		log.Errorf("error allocating and/or reserving IP. Error: %s", err)
-		return nil, err
-	}
-
-	// cleanup relies on var err being used for all error checking
-	defer freeAddrOnErr(nwCfg, epCfg.IPAddress, &err)
-
-	// Set endpoint group
-	// Skip for infra nw
-	if nwCfg.NwType != "infra" {
-		epCfg.EndpointGroupKey = mastercfg.GetEndpointGroupKey(ep.ServiceName, nwCfg.Tenant)
-		epCfg.EndpointGroupID, err = mastercfg.GetEndpointGroupID(stateDriver, ep.ServiceName, nwCfg.Tenant)
-		if err != nil {
-			log.Errorf("Error getting endpoint group ID for %s.%s. Err: %v", ep.ServiceName, nwCfg.ID, err)
-			return nil, err
+	// The address, the endpoint counts and the endpoint are written
+	// together, so that a failure does not leave the address allocated. The
+	// batch reads its own copy of the network, nwCfg is updated once the
+	// batch is committed.
+	var nw *mastercfg.CfgNetworkState
+	allocated := false
+	err = state.RunBatch(nwCfg.StateDriver, func(batch *state.Batch) error {
+		nw = &mastercfg.CfgNetworkState{}
+		nw.StateDriver = batch
+		if err := nw.Read(nwCfg.ID); err != nil {
+			log.Errorf("error reading network %s. Error: %s", nwCfg.ID, err)
+			return err
 		}
 
-		if epCfg.EndpointGroupKey != "" {
-			epgCfg := &mastercfg.EndpointGroupState{}
-			epgCfg.StateDriver = stateDriver
-			err = epgCfg.Read(epCfg.EndpointGroupKey)
+		// Allocate addresses
+		if err := allocSetEpAddress(ep, epCfg, nw); err != nil {
+			log.Errorf("error allocating and/or reserving IP. Error: %s", err)
+			return err
+		}
+		allocated = true
+
+		// Set endpoint group
+		// Skip for infra nw
+		if nw.NwType != "infra" {
+			var err error
+			epCfg.EndpointGroupKey = mastercfg.GetEndpointGroupKey(ep.ServiceName, nw.Tenant)
+			epCfg.EndpointGroupID, err = mastercfg.GetEndpointGroupID(stateDriver, ep.ServiceName, nw.Tenant)
 			if err != nil {
-				log.Errorf("Error reading Epg info for EP: %+v. Error: %v", ep, err)
-				return nil, err
+				log.Errorf("Error getting endpoint group ID for %s.%s. Err: %v", ep.ServiceName, nw.ID, err)
+				return err
 			}
 
-			epgCfg.EpCount++
-
-			err = epgCfg.Write()
-			if err != nil {
-				log.Errorf("Error saving epg state: %+v", epgCfg)
-				return nil, err
+			if epCfg.EndpointGroupKey != "" {
+				epgCfg := &mastercfg.EndpointGroupState{}
+				epgCfg.StateDriver = batch
+				epgCfg.ID = epCfg.EndpointGroupKey
+				err = epgCfg.Update(func() error {
+					epgCfg.EpCount++
+					return nil
+				})
+				if err != nil {
+					log.Errorf("Error updating Epg info for EP: %+v. Error: %v", ep, err)
+					return err
+				}
 			}
 		}
-	}
 
-	err = nwCfg.IncrEpCount()
-	if err != nil {
-		log.Errorf("Error incrementing ep count. Err: %v", err)
-		return nil, err
-	}
+		if err := nw.IncrEpCount(); err != nil {
+			log.Errorf("Error incrementing ep count. Err: %v", err)
+			return err
+		}
 
-	err = epCfg.Write()
+		epCfg.StateDriver = batch
+		err := epCfg.Write()
+		epCfg.StateDriver = stateDriver
+		if err != nil {
+			log.Errorf("error writing ep config. Error: %s", err)
+		}
+		return err
+	})
 	if err != nil {
-		log.Errorf("error writing ep config. Error: %s", err)
+		// an address requested by the endpoint may have been allocated
+		// before, eg. by docker, it is freed as before the batch
+		if allocated && ep.IPAddress != "" {
+			freeAddrOnErr(nwCfg, ep.IPAddress, &err)
+		}
+		log.Errorf("error writing endpoint %s. Error: %s", epCfg.ID, err)
 		return nil, err
 	}
 
+	nw.StateDriver = nwCfg.StateDriver
+	*nwCfg = *nw
+
 	return epCfg, nil
 }
 
@@ -197,12 +220,6 @@ func CreateEndpoints(stateDriver core.StateDriver, tenant *intent.ConfigTenant)
 				return err
 			}
 		}
-
-		err = nwCfg.Write()
-		if err != nil {
-			log.Errorf("error writing nw config. Error: %s", err)
-			return err
-		}
 	}
 
 	return err
@@ -217,49 +234,52 @@ func DeleteEndpointID(stateDriver core.StateDriver, epID string) (*mastercfg.Cfg
 		return nil, err
 	}
 
-	nwCfg := &mastercfg.CfgNetworkState{}
-	nwCfg.StateDriver = stateDriver
-	err = nwCfg.Read(epCfg.NetID)
-
-	// Network may already be deleted if infra nw
-	// If network present, free up nw resources
-	if err == nil && epCfg.IPAddress != "" {
-		err = networkReleaseAddress(nwCfg, epCfg.IPAddress)
-		if err != nil {
-			log.Errorf("Error releasing endpoint state for: %s. Err: %v", epCfg.IPAddress, err)
-		}
+	// The address, the endpoint counts and the endpoint are written
+	// together
+	err = state.RunBatch(stateDriver, func(batch *state.Batch) error {
+		nwCfg := &mastercfg.CfgNetworkState{}
+		nwCfg.StateDriver = batch
+		err := nwCfg.Read(epCfg.NetID)
 
-		if epCfg.EndpointGroupKey != "" {
-			epgCfg := &mastercfg.EndpointGroupState{}
-			epgCfg.StateDriver = stateDriver
-			err = epgCfg.Read(epCfg.EndpointGroupKey)
+		// Network may already be deleted if infra nw
+		// If network present, free up nw resources
+		if err == nil && epCfg.IPAddress != "" {
+			err = networkReleaseAddress(nwCfg, epCfg.IPAddress)
 			if err != nil {
-				log.Errorf("Error reading EPG for endpoint: %+v", epCfg)
+				log.Errorf("Error releasing endpoint state for: %s. Err: %v", epCfg.IPAddress, err)
 			}
 
-			epgCfg.EpCount--
+			if epCfg.EndpointGroupKey != "" {
+				epgCfg := &mastercfg.EndpointGroupState{}
+				epgCfg.StateDriver = batch
+				epgCfg.ID = epCfg.EndpointGroupKey
+				err = epgCfg.Update(func() error {
+					epgCfg.EpCount--
+					return nil
+				})
+				if err != nil {
+					log.Errorf("error writing epg config. Error: %s", err)
+				}
+			}
 
-			// write updated epg state
-			err = epgCfg.Write()
+			// decrement ep count
+			err = nwCfg.DecrEpCount()
 			if err != nil {
-				log.Errorf("error writing epg config. Error: %s", err)
+				log.Errorf("error writing nw config. Error: %s", err)
 			}
 		}
 
-		// decrement ep count
-		nwCfg.EpCount--
-
-		// write modified nw state
-		err = nwCfg.Write()
+		// Even if network not present (already deleted), cleanup ep cfg
+		epCfg.StateDriver = batch
+		err = epCfg.Clear()
+		epCfg.StateDriver = stateDriver
 		if err != nil {
-			log.Errorf("error writing nw config. Error: %s", err)
+			log.Errorf("error writing ep config. Error: %s", err)
 		}
-	}
-
-	// Even if network not present (already deleted), cleanup ep cfg
-	err = epCfg.Clear()
+		return err
+	})
 	if err != nil {
-		log.Errorf("error writing ep config. Error: %s", err)
+		log.Errorf("error deleting endpoint %s. Error: %s", epID, err)
 		return nil, err
 	}
 
@@ -309,8 +329,10 @@ func CreateEpBindings(epBindings *[]intent.ConfigEP) error {
 			if cfg.EndpointID != ep.Container {
 				continue
 			}
-			cfg.HomingHost = ep.Host
-			err = cfg.Write()
+			err = cfg.Update(func() error {
+				cfg.HomingHost = ep.Host
+				return nil
+			})
 			if err != nil {
 				log.Errorf("error updating epCfg. Error: %s", err)
 				return err
@@ -203,21 +203,20 @@ func UpdateEndpointGroup(bandwidth, groupName, tenantName string, Dscp, burst in
 		return errors.New("Error finding endpointGroup key ")
 	}
 
-	// Read etcd driver
 	epCfg := mastercfg.EndpointGroupState{}
 	epCfg.StateDriver = stateDriver
+	epCfg.ID = key
 
-	err = epCfg.Read(key)
+	//update the epGroup state
+	err = epCfg.Update(func() error {
+		epCfg.DSCP = Dscp
+		epCfg.Bandwidth = bandwidth
+		epCfg.Burst = burst
+		return nil
+	})
 	if err != nil {
-		log.Errorf("Error finding endpointgroup %s. Err: %v", key, err)
-		return err
+		log.Errorf("Error updating endpointgroup %s. Err: %v", key, err)
 	}
 
-	//update the epGroup state
-	epCfg.DSCP = Dscp
-	epCfg.Bandwidth = bandwidth
-	epCfg.Burst = burst
-
-	//Write to etcd
-	return epCfg.Write()
+	return err
 }
@@ -688,3 +688,74 @@ func TestGetAllocatedIPs(t *testing.T) {
 		log.Fatalf("got networks '%s' expected '%s'", networks, expectedAllocedIPs)
 	}
 }
+
+// conflictDriver runs conflict before the first versioned write of key, as
+// another writer changing it meanwhile
+type conflictDriver struct {
+	*state.FakeStateDriver
+	key      string
+	conflict func()
+}
+
+func (d *conflictDriver) WriteVersion(key string, value []byte, version uint64) error {
+	if key == d.key && d.conflict != nil {
+		conflict := d.conflict
+		d.conflict = nil
+		conflict()
+	}
+
+	return d.FakeStateDriver.WriteVersion(key, value, version)
+}
+
+func TestAllocAddressConflict(t *testing.T) {
+	cfgBytes := []byte(`{
+    "Tenants" : [{
+        "Name"                : "tenant-one",
+        "Networks"  : [{
+            "Name"            : "orange",
+            "SubnetCIDR"      : "10.1.1.0/24",
+            "Gateway"         : "10.1.1.254"
+        }]
+    }]}`)
+	initFakeStateDriver(t)
+	defer deinitFakeStateDriver()
+
+	applyConfig(t, cfgBytes)
+
+	// another writer allocates an address after the network is read
+	var otherAddr string
+	driver := &conflictDriver{FakeStateDriver: fakeDriver, key: "/contiv.io/state/nets/orange.tenant-one"}
+	driver.conflict = func() {
+		other := &mastercfg.CfgNetworkState{}
+		other.StateDriver = fakeDriver
+		if err := other.Read("orange.tenant-one"); err != nil {
+			t.Fatalf("Error reading the network. Err: %v", err)
+		}
+		addr, err := networkAllocAddress(other, "", false)
+		if err != nil {
+			t.Fatalf("Error allocating the conflicting address. Err: %v", err)
+		}
+		otherAddr = addr
+	}
+
+	nwCfg := &mastercfg.CfgNetworkState{}
+	nwCfg.StateDriver = driver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("Error reading the network. Err: %v", err)
+	}
+	addr, err := networkAllocAddress(nwCfg, "", false)
+	if err != nil {
+		t.Fatalf("Error allocating an address. Err: %v", err)
+	}
+	if otherAddr != "10.1.1.1" || addr != "10.1.1.2" {
+		t.Fatalf("Expected the addresses 10.1.1.1 and 10.1.1.2, got %s and %s", otherAddr, addr)
+	}
+
+	nwCfg.StateDriver = fakeDriver
+	if err := nwCfg.Read("orange.tenant-one"); err != nil {
+		t.Fatalf("Error reading the network. Err: %v", err)
+	}
+	if allocated := ListAllocatedIPs(nwCfg); allocated != "10.1.1.1-10.1.1.2, 10.1.1.254" || nwCfg.EpAddrCount != 2 {
+		t.Fatalf("Expected both addresses allocated, got %q and %d addresses", allocated, nwCfg.EpAddrCount)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/contiv/netplugin/netmaster/gstate"
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils/netutils"
 
 	log "github.com/Sirupsen/logrus"
@@ -94,10 +95,16 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		return nil
 	}
 
+	// IPv6 only networks have no IPv4 subnet
+	ipv4 := network.SubnetCIDR != "" || network.IPv6SubnetCIDR == ""
 	subnetIP, subnetLen, _ := netutils.ParseCIDR(network.SubnetCIDR)
-	err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
-	if err != nil {
-		return err
+	if ipv4 {
+		err = netutils.ValidateNetworkRangeParams(subnetIP, subnetLen)
+		if err != nil {
+			return err
+		}
+	} else if network.Gateway != "" {
+		return core.Errorf("gateway %s without IPv4 subnet", network.Gateway)
 	}
 
 	ipv6Subnet, ipv6SubnetLen, _ := netutils.ParseCIDR(network.IPv6SubnetCIDR)
@@ -112,15 +119,20 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		SubnetLen:     subnetLen,
 		IPv6Subnet:    ipv6Subnet,
 		IPv6SubnetLen: ipv6SubnetLen,
+		Internal:      network.Internal,
+		Attachable:    network.Attachable,
 	}
 
 	nwCfg.ID = networkID
 	nwCfg.StateDriver = stateDriver
 
-	netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
-	subnetAddr := netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen)
-	nwCfg.SubnetIP = subnetAddr
-	nwCfg.IPAddrRange = netutils.GetIPAddrRange(subnetIP, subnetLen)
+	subnetAddr := ""
+	if ipv4 {
+		netutils.InitSubnetBitset(&nwCfg.IPAllocMap, nwCfg.SubnetLen)
+		subnetAddr = netutils.GetSubnetAddr(nwCfg.SubnetIP, nwCfg.SubnetLen)
+		nwCfg.SubnetIP = subnetAddr
+		nwCfg.IPAddrRange = netutils.GetIPAddrRange(subnetIP, subnetLen)
+	}
 
 	if network.Gateway != "" {
 		nwCfg.Gateway = network.Gateway
@@ -150,15 +162,21 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 		netutils.ReserveIPv6HostID(hostID, &nwCfg.IPv6AllocMap)
 	}
 
+	// The pkt tags and the network are written together, so that a failure
+	// does not leave the tags allocated
+	batch := state.NewBatch(stateDriver)
+	batchCfg := gCfg
+	batchCfg.StateDriver = batch
+
 	// Allocate pkt tags
 	reqPktTag := uint(network.PktTag)
 	if nwCfg.PktTagType == "vlan" {
-		pktTag, err = gCfg.AllocVLAN(reqPktTag)
+		pktTag, err = batchCfg.AllocVLAN(reqPktTag)
 		if err != nil {
 			return err
 		}
 	} else if nwCfg.PktTagType == "vxlan" {
-		extPktTag, pktTag, err = gCfg.AllocVXLAN(reqPktTag)
+		extPktTag, pktTag, err = batchCfg.AllocVXLAN(reqPktTag)
 		if err != nil {
 			return err
 		}
@@ -167,8 +185,16 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 	nwCfg.ExtPktTag = int(extPktTag)
 	nwCfg.PktTag = int(pktTag)
 
+	nwCfg.StateDriver = batch
 	err = nwCfg.Write()
+	nwCfg.StateDriver = stateDriver
+	if err != nil {
+		return err
+	}
+
+	err = batch.Commit()
 	if err != nil {
+		log.Errorf("Error writing network %s. Err: %v", nwCfg.ID, err)
 		return err
 	}
 
@@ -184,10 +210,15 @@ func CreateNetwork(network intent.ConfigNetwork, stateDriver core.StateDriver, t
 	}
 
 	if GetClusterMode() == "docker" {
-		// Create the network in docker
+		// Create the network in docker. The network plugin reads the network
+		// state when docker creates it, so the docknet is not in the batch,
+		// the network is removed again if it fails.
 		err = docknet.CreateDockNet(tenantName, network.Name, "", nwCfg)
 		if err != nil {
 			log.Errorf("Error creating network %s in docker. Err: %v", nwCfg.ID, err)
+			if delErr := deleteNetworkState(stateDriver, nwCfg, &gCfg); delErr != nil {
+				log.Errorf("Error removing network %s. Err: %v", nwCfg.ID, delErr)
+			}
 			return err
 		}
 	}
@@ -279,8 +310,18 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 		return err
 	}
 
+	return deleteNetworkState(stateDriver, nwCfg, gCfg)
+}
+
+// deleteNetworkState frees the resources of a network and removes it, in one
+// batch of state writes
+func deleteNetworkState(stateDriver core.StateDriver, nwCfg *mastercfg.CfgNetworkState, gCfg *gstate.Cfg) error {
+	batch := state.NewBatch(stateDriver)
+	batchCfg := *gCfg
+	batchCfg.StateDriver = batch
+
 	// Free resource associated with the network
-	err = freeNetworkResources(stateDriver, nwCfg, gCfg)
+	err := freeNetworkResources(batch, nwCfg, &batchCfg)
 	if err != nil {
 		// Error while freeing up vlan/vxlan/subnet/gateway resources
 		// This can only happen because of defects in code
@@ -288,13 +329,21 @@ func DeleteNetworkID(stateDriver core.StateDriver, netID string) error {
 		return err
 	}
 
-	err = nwCfg.Clear()
+	batchNw := *nwCfg
+	batchNw.StateDriver = batch
+	err = batchNw.Clear()
 	if err != nil {
 		log.Errorf("error writing nw config. Error: %s", err)
 		return err
 	}
 
-	return err
+	err = batch.Commit()
+	if err != nil {
+		log.Errorf("Error removing network %s. Err: %v", nwCfg.ID, err)
+		return err
+	}
+
+	return nil
 }
 
 // DeleteNetworks removes all the virtual networks for a given tenant.
@@ -413,6 +462,25 @@ func ListAvailableIPs(nwCfg *mastercfg.CfgNetworkState) string {
 // Allocate an address from the network
 func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv6 bool) (string, error) {
 	var ipAddress string
+
+	// the address is allocated again if another writer changed the network
+	// meanwhile
+	err := nwCfg.Update(func() error {
+		var err error
+		ipAddress, err = allocNetworkAddress(nwCfg, reqAddr, isIPv6)
+		return err
+	})
+	if err != nil {
+		log.Errorf("error writing nw config. Error: %s", err)
+		return "", err
+	}
+
+	return ipAddress, nil
+}
+
+// allocNetworkAddress allocates an address in the network state
+func allocNetworkAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv6 bool) (string, error) {
+	var ipAddress string
 	var ipAddrValue uint
 	var found bool
 	var err error
@@ -484,17 +552,24 @@ func networkAllocAddress(nwCfg *mastercfg.CfgNetworkState, reqAddr string, isIPv
 		nwCfg.IPAllocMap.Set(ipAddrValue)
 	}
 
-	err = nwCfg.Write()
+	return ipAddress, nil
+}
+
+// networkReleaseAddress release the ip address
+func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+	err := nwCfg.Update(func() error {
+		return releaseNetworkAddress(nwCfg, ipAddress)
+	})
 	if err != nil {
 		log.Errorf("error writing nw config. Error: %s", err)
-		return "", err
+		return err
 	}
 
-	return ipAddress, nil
+	return nil
 }
 
-// networkReleaseAddress release the ip address
-func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
+// releaseNetworkAddress releases an address in the network state
+func releaseNetworkAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) error {
 	isIPv6 := netutils.IsIPv6(ipAddress)
 	if isIPv6 {
 		hostID, err := netutils.GetIPv6HostID(nwCfg.SubnetIP, nwCfg.SubnetLen, ipAddress)
@@ -526,12 +601,6 @@ func networkReleaseAddress(nwCfg *mastercfg.CfgNetworkState, ipAddress string) e
 		nwCfg.IPAllocMap.Clear(ipAddrValue)
 	}
 
-	err := nwCfg.Write()
-	if err != nil {
-		log.Errorf("error writing nw config. Error: %s", err)
-		return err
-	}
-
 	return nil
 }
 
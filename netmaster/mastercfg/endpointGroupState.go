@@ -21,6 +21,7 @@ import (
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
 )
 
 // EndpointGroupState implements the State interface for endpoint group implemented using
@@ -63,6 +64,13 @@ func (s *EndpointGroupState) WatchAll(rsps chan core.WatchState) error {
 		rsps)
 }
 
+// Update rereads the state, applies update and writes it, retrying if another
+// writer changed it meanwhile. See state.UpdateState.
+func (s *EndpointGroupState) Update(update func() error) error {
+	key := fmt.Sprintf(epGroupConfigPath, s.ID)
+	return state.UpdateState(s.StateDriver, key, s, json.Unmarshal, json.Marshal, update)
+}
+
 // Clear removes the state.
 func (s *EndpointGroupState) Clear() error {
 	key := fmt.Sprintf(epGroupConfigPath, s.ID)
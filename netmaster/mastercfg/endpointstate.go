@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
 )
 
 // CfgEndpointState implements the State interface for an endpoint implemented using
@@ -65,6 +66,13 @@ func (s *CfgEndpointState) WatchAll(rsps chan core.WatchState) error {
 		rsps)
 }
 
+// Update rereads the state, applies update and writes it, retrying if another
+// writer changed it meanwhile. See state.UpdateState.
+func (s *CfgEndpointState) Update(update func() error) error {
+	key := fmt.Sprintf(endpointConfigPath, s.ID)
+	return state.UpdateState(s.StateDriver, key, s, json.Unmarshal, json.Marshal, update)
+}
+
 // Clear removes the state.
 func (s *CfgEndpointState) Clear() error {
 	key := fmt.Sprintf(endpointConfigPath, s.ID)
@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
 	"github.com/jainvipin/bitset"
 )
 
@@ -39,6 +40,14 @@ const (
 	epGroupConfigPath        = epGroupConfigPathPrefix + "%s"
 )
 
+func init() {
+	// the IPAM allocations change with every endpoint, which is recorded by
+	// itself in the audit log
+	for _, field := range []string{"ipAllocMap", "ipv6AllocMap", "ipv6LastHost"} {
+		state.SkipAuditField(field)
+	}
+}
+
 // CfgNetworkState implements the State interface for a network implemented using
 // vlans with ovs. The state is stored as Json objects.
 type CfgNetworkState struct {
@@ -61,6 +70,16 @@ type CfgNetworkState struct {
 	IPv6Gateway   string          `json:"ipv6Gateway"`
 	IPv6AllocMap  map[string]bool `json:"ipv6AllocMap"`
 	IPv6LastHost  string          `json:"ipv6LastHost"`
+
+	// MTU of the network, the driver default if zero
+	MTU int `json:"mtu,omitempty"`
+	// DriverOptions are passed to the network driver as is
+	DriverOptions map[string]string `json:"driverOptions,omitempty"`
+	// Internal networks have no external connectivity
+	Internal bool `json:"internal,omitempty"`
+	// Attachable networks let containers be connected with docker network
+	// connect
+	Attachable bool `json:"attachable,omitempty"`
 }
 
 // Write the state.
@@ -92,16 +111,27 @@ func (s *CfgNetworkState) Clear() error {
 	return s.StateDriver.ClearState(key)
 }
 
+// Update rereads the state, applies update and writes it, retrying if another
+// writer changed it meanwhile. See state.UpdateState.
+func (s *CfgNetworkState) Update(update func() error) error {
+	key := fmt.Sprintf(networkConfigPath, s.ID)
+	return state.UpdateState(s.StateDriver, key, s, json.Unmarshal, json.Marshal, update)
+}
+
 // IncrEpCount Increments endpoint count
 func (s *CfgNetworkState) IncrEpCount() error {
-	s.EpCount++
-	return s.Write()
+	return s.Update(func() error {
+		s.EpCount++
+		return nil
+	})
 }
 
 // DecrEpCount decrements endpoint count
 func (s *CfgNetworkState) DecrEpCount() error {
-	s.EpCount--
-	return s.Write()
+	return s.Update(func() error {
+		s.EpCount--
+		return nil
+	})
 }
 
 //GetNwCfgKey returns the key for network state
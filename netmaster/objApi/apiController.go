@@ -27,6 +27,7 @@ import (
 	"github.com/contiv/netplugin/netmaster/intent"
 	"github.com/contiv/netplugin/netmaster/master"
 	"github.com/contiv/netplugin/netmaster/mastercfg"
+	"github.com/contiv/netplugin/state"
 	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/objdb"
@@ -56,12 +57,15 @@ type BgpInspect struct {
 var apiCtrler *APIController
 
 // NewAPIController creates a new controller
-func NewAPIController(router *mux.Router, objdbClient objdb.API, storeURL string) *APIController {
+func NewAPIController(router *mux.Router, objdbClient objdb.API, storeURL string, storeSecurity core.DbSecurity) *APIController {
 	ctrler := new(APIController)
 	ctrler.router = router
 	ctrler.objdbClient = objdbClient
 
 	// init modeldb
+	if err := utils.SecureObjdb(storeSecurity); err != nil {
+		log.Fatalf("Error setting up the state store client. Err: %v", err)
+	}
 	modeldb.Init(storeURL)
 
 	// initialize the model objects
@@ -1810,6 +1814,12 @@ func (ac *APIController) TenantDelete(tenant *contivModel.Tenant) error {
 	err = master.DeleteTenant(stateDriver, &tenantCfg)
 	if err != nil {
 		log.Errorf("Error deleting tenant %s. Err: %v", tenant.TenantName, err)
+		return nil
+	}
+
+	// remove what is left in the state of the tenant
+	if err := state.ClearTenantState(stateDriver, tenant.TenantName); err != nil {
+		log.Errorf("Error clearing state of tenant %s. Err: %v", tenant.TenantName, err)
 	}
 
 	return nil
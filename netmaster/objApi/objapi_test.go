@@ -88,7 +88,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Create a new api controller
-	apiController = NewAPIController(router, objdbClient, "etcd://127.0.0.1:2379")
+	apiController = NewAPIController(router, objdbClient, "etcd://127.0.0.1:2379", core.DbSecurity{})
 
 	ofnetMaster := ofnet.NewOfnetMaster("127.0.0.1", ofnet.OFNET_MASTER_PORT)
 	if ofnetMaster == nil {
@@ -61,6 +61,12 @@ func GetStateResourceManager() (*StateResourceManager, error) {
 	return gStateResourceManager, nil
 }
 
+// WithStateDriver returns a resource manager of the same resources reading
+// and writing through sd, eg. a batch of state writes
+func (rm *StateResourceManager) WithStateDriver(sd core.StateDriver) *StateResourceManager {
+	return &StateResourceManager{stateDriver: sd}
+}
+
 // ReleaseStateResourceManager releases the singleton instance of the state
 // based resource manager
 func ReleaseStateResourceManager() {
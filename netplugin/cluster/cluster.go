@@ -26,6 +26,7 @@ import (
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/netplugin/plugin"
+	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/utils/netutils"
 	"github.com/contiv/objdb"
 
@@ -351,11 +352,11 @@ func GetLocalAddr() (string, error) {
 }
 
 // Init initializes the cluster module
-func Init(storeURL string) error {
+func Init(storeURL string, storeSecurity core.DbSecurity) error {
 	var err error
 
 	// Create an objdb client
-	ObjdbClient, err = objdb.NewClient(storeURL)
+	ObjdbClient, err = utils.NewObjdbClient(storeURL, storeSecurity)
 
 	return err
 }
@@ -27,6 +27,7 @@ import (
 	"github.com/contiv/netplugin/netplugin/agent"
 	"github.com/contiv/netplugin/netplugin/cluster"
 	"github.com/contiv/netplugin/netplugin/plugin"
+	"github.com/contiv/netplugin/utils"
 	"github.com/contiv/netplugin/version"
 
 	log "github.com/Sirupsen/logrus"
@@ -76,6 +77,8 @@ type cliOpts struct {
 	vlanIntf   StringSlice // Uplink interface for VLAN switching
 	version    bool
 	dbURL      string // state store URL
+	dbSecurity core.DbSecurity
+	encryption utils.StateEncryption
 }
 
 func configureSyslog(syslogParam string) {
@@ -159,6 +162,8 @@ func main() {
 		"cluster-store",
 		"etcd://127.0.0.1:2379",
 		"state store url")
+	utils.AddDbSecurityFlags(flagSet, &opts.dbSecurity)
+	utils.AddStateEncryptionFlags(flagSet, &opts.encryption)
 
 	err = flagSet.Parse(os.Args[1:])
 	if err != nil {
@@ -207,6 +212,14 @@ func main() {
 		opts.vtepIP = opts.ctrlIP
 	}
 
+	if err := utils.LoadDbPassword(&opts.dbSecurity); err != nil {
+		log.Fatalf("Failed to read the cluster store password. Error: %s", err)
+	}
+
+	if err := utils.InitStateEncryption(opts.encryption); err != nil {
+		log.Fatalf("Failed to set the state encryption. Error: %s", err)
+	}
+
 	// parse store URL
 	parts := strings.Split(opts.dbURL, "://")
 	if len(parts) < 2 {
@@ -226,6 +239,7 @@ func main() {
 			VtepIP:     opts.vtepIP,
 			UplinkIntf: opts.vlanIntf,
 			DbURL:      opts.dbURL,
+			DbSecurity: opts.dbSecurity,
 			PluginMode: opts.pluginMode,
 		},
 	}
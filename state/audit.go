@@ -0,0 +1,702 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AuditPath is the base key of the audit log in the state store
+const AuditPath = "/contiv.io/audit/"
+
+// audited operations
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+var (
+	// skippedAuditMutex protects skippedAuditFields, the field names marked
+	// by SkipAuditField
+	skippedAuditMutex  sync.Mutex
+	skippedAuditFields = make(map[string]bool)
+)
+
+// AuditChange is a changed field of an audited state. Field is the path of
+// the field in the JSON state, eg. "pktTag" or "linkSets.Ports", or empty if
+// the value is not a JSON object. A missing old or new value is nil.
+type AuditChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+// AuditRecord is an entry of the audit log
+type AuditRecord struct {
+	Time   time.Time     `json:"time"`
+	Actor  string        `json:"actor"`
+	Op     string        `json:"op"`
+	Key    string        `json:"key"`
+	Change []AuditChange `json:"change"`
+}
+
+// AuditFilter selects the records returned by ReadAuditLog. Empty fields
+// select all records.
+type AuditFilter struct {
+	Key    string    // key prefix of the audited states
+	Since  time.Time // oldest record
+	Before time.Time // records older than this, to page back in the log
+	Limit  int       // number of most recent records
+}
+
+// AuditRetention limits the records kept by TrimAuditLog. Zero fields do not
+// limit the records.
+type AuditRetention struct {
+	MaxRecords int           // number of most recent records
+	MaxAge     time.Duration // age of the oldest record
+}
+
+// AuditedStateDriver is a state driver recording the writes and clears of
+// the keys under its base keys in the audit log, as the actor, or the actor
+// of RunAs. Records are written after the change and are never updated; a
+// failure to record a change is logged and does not fail it. Sensitive
+// fields are redacted from the records, see MarkSensitiveField, and skipped
+// fields are left out, see SkipAuditField.
+type AuditedStateDriver struct {
+	driver   core.StateDriver
+	actor    string
+	baseKeys []string
+
+	// runMutex serializes RunAs
+	runMutex sync.Mutex
+
+	mutex    sync.Mutex
+	seq      uint64
+	runActor string
+}
+
+// NewAuditedStateDriver returns a state driver auditing the changes made
+// through it to the keys under baseKeys
+func NewAuditedStateDriver(d core.StateDriver, actor string, baseKeys ...string) *AuditedStateDriver {
+	return &AuditedStateDriver{
+		driver:   d,
+		actor:    actor,
+		baseKeys: baseKeys,
+	}
+}
+
+// SkipAuditField marks a field or map key of the audited states whose changes
+// are not recorded, eg. allocation bitmaps changing with every endpoint.
+// Updates changing only skipped fields are not recorded.
+func SkipAuditField(name string) {
+	skippedAuditMutex.Lock()
+	defer skippedAuditMutex.Unlock()
+	skippedAuditFields[name] = true
+}
+
+// skippedAuditField returns true if the changes of a field are not recorded
+func skippedAuditField(name string) bool {
+	skippedAuditMutex.Lock()
+	defer skippedAuditMutex.Unlock()
+	return skippedAuditFields[name]
+}
+
+// RunAs calls f and records the changes made through the driver meanwhile
+// as made by actor, eg. the client of a REST request. The calls are
+// serialized; changes made by other goroutines while f runs are recorded as
+// made by actor too.
+func (a *AuditedStateDriver) RunAs(actor string, f func()) {
+	a.runMutex.Lock()
+	defer a.runMutex.Unlock()
+
+	a.mutex.Lock()
+	a.runActor = actor
+	a.mutex.Unlock()
+	defer func() {
+		a.mutex.Lock()
+		a.runActor = ""
+		a.mutex.Unlock()
+	}()
+
+	f()
+}
+
+// currentActor returns the actor of the changes made now
+func (a *AuditedStateDriver) currentActor() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.runActor != "" {
+		return a.runActor
+	}
+
+	return a.actor
+}
+
+// audits returns true if the changes of a key are recorded
+func (a *AuditedStateDriver) audits(key string) bool {
+	for _, baseKey := range a.baseKeys {
+		if strings.HasPrefix(key, baseKey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// oldValue returns the value of a key before a change, nil if it does not
+// exist or cannot be read
+func (a *AuditedStateDriver) oldValue(key string) []byte {
+	value, err := a.driver.Read(key)
+	if err != nil {
+		if core.ErrIfKeyExists(err) != nil {
+			log.Warnf("Error reading %q for the audit log. Err: %v", key, err)
+		}
+		return nil
+	}
+
+	return value
+}
+
+// record appends the change of a key from oldValue to newValue, nil if it
+// was created or deleted, to the audit log
+func (a *AuditedStateDriver) record(key string, oldValue, newValue []byte) {
+	r := AuditRecord{
+		Time:   time.Now(),
+		Actor:  a.currentActor(),
+		Op:     AuditUpdate,
+		Key:    key,
+		Change: diffValues(decodeAuditValue(oldValue), decodeAuditValue(newValue)),
+	}
+	if oldValue == nil {
+		r.Op = AuditCreate
+	} else if newValue == nil {
+		r.Op = AuditDelete
+	} else if len(r.Change) == 0 {
+		// only skipped fields changed
+		return
+	}
+
+	value, err := json.Marshal(r)
+	if err != nil {
+		log.Errorf("Error encoding the audit record of %q. Err: %v", key, err)
+		return
+	}
+
+	// versioned drivers only create the record, so that it is never
+	// overwritten
+	versioned, isVersioned := a.driver.(VersionedDriver)
+	for i := 0; i < maxUpdateRetries; i++ {
+		a.mutex.Lock()
+		a.seq++
+		recordKey := AuditPath + auditRecordName(r.Time, a.seq)
+		a.mutex.Unlock()
+
+		if isVersioned {
+			err = versioned.WriteVersion(recordKey, value, 0)
+		} else {
+			err = a.driver.Write(recordKey, value)
+		}
+		if err != ErrStateConflict {
+			break
+		}
+	}
+	if err != nil {
+		log.Errorf("Error writing the audit record of %q. Err: %v", key, err)
+	}
+}
+
+// auditRecordName returns the name of a record under AuditPath. The names
+// are ordered by the record time.
+func auditRecordName(t time.Time, seq uint64) string {
+	return fmt.Sprintf("%020d-%08d", t.UnixNano(), seq)
+}
+
+// auditRecordTime returns the time of a record from its name
+func auditRecordTime(name string) (time.Time, error) {
+	nsec, err := strconv.ParseInt(strings.SplitN(name, "-", 2)[0], 10, 64)
+	if err != nil {
+		return time.Time{}, core.Errorf("invalid audit record name %q", name)
+	}
+
+	return time.Unix(0, nsec), nil
+}
+
+// decodeAuditValue decodes a JSON value, with the sensitive fields redacted
+// and the skipped fields removed. Other values are returned as strings.
+func decodeAuditValue(value []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	var decoded interface{}
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil || decoder.More() {
+		return string(value)
+	}
+
+	return skipAuditFields(redactFields(decoded))
+}
+
+// skipAuditFields removes the skipped fields from a decoded JSON value
+func skipAuditFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if skippedAuditField(key) {
+				delete(v, key)
+			} else {
+				v[key] = skipAuditFields(field)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = skipAuditFields(elem)
+		}
+	}
+
+	return value
+}
+
+// diffValues returns the changes from an old to a new decoded value, by
+// field for JSON objects, ordered by field
+func diffValues(oldValue, newValue interface{}) []AuditChange {
+	changes := []AuditChange{}
+	diffFields("", oldValue, newValue, &changes)
+	return changes
+}
+
+// diffFields appends the changes of the field at path to changes. A missing
+// object is compared as an empty one.
+func diffFields(path string, oldValue, newValue interface{}, changes *[]AuditChange) {
+	oldObj, oldIsObj := oldValue.(map[string]interface{})
+	newObj, newIsObj := newValue.(map[string]interface{})
+	if (oldIsObj || oldValue == nil) && (newIsObj || newValue == nil) && (oldIsObj || newIsObj) {
+		names := []string{}
+		for name := range oldObj {
+			names = append(names, name)
+		}
+		for name := range newObj {
+			if _, ok := oldObj[name]; !ok {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fieldPath := name
+			if path != "" {
+				fieldPath = path + "." + name
+			}
+			diffFields(fieldPath, oldObj[name], newObj[name], changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(oldValue, newValue) {
+		*changes = append(*changes, AuditChange{Field: path, Old: oldValue, New: newValue})
+	}
+}
+
+// auditRecordNames returns the names of the records under AuditPath, oldest
+// first
+func auditRecordNames(d core.StateDriver) ([]string, error) {
+	lister, ok := d.(ChildLister)
+	if !ok {
+		return nil, core.Errorf("state driver does not list keys")
+	}
+
+	names, err := lister.ListChildren(AuditPath)
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// ReadAuditLog reads the records of the audit log selected by a filter,
+// oldest first. The record keys are listed and the records in the time range
+// of the filter are read newest first, until the limit is reached.
+func ReadAuditLog(d core.StateDriver, filter AuditFilter) ([]AuditRecord, error) {
+	names, err := auditRecordNames(d)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []AuditRecord{}
+	for i := len(names) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(records) == filter.Limit {
+			break
+		}
+
+		t, err := auditRecordTime(names[i])
+		if err != nil {
+			log.Warnf("Skipping audit record. Err: %v", err)
+			continue
+		}
+		if t.Before(filter.Since) {
+			break
+		}
+		if !filter.Before.IsZero() && !t.Before(filter.Before) {
+			continue
+		}
+
+		key := AuditPath + names[i]
+		value, err := d.Read(key)
+		if err != nil {
+			// trimmed since the keys were listed
+			if core.ErrIfKeyExists(err) == nil {
+				continue
+			}
+			return nil, err
+		}
+		r := AuditRecord{}
+		if err := json.Unmarshal(value, &r); err != nil {
+			log.Warnf("Skipping invalid audit record %q. Err: %v", key, err)
+			continue
+		}
+		if !strings.HasPrefix(r.Key, filter.Key) {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	// oldest first
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, nil
+}
+
+// TrimAuditLog deletes the records of the audit log beyond the retention
+// limits, oldest first, and returns the number of deleted records
+func TrimAuditLog(d core.StateDriver, retention AuditRetention) (int, error) {
+	names, err := auditRecordNames(d)
+	if err != nil {
+		return 0, err
+	}
+
+	trim := 0
+	if retention.MaxRecords > 0 && len(names) > retention.MaxRecords {
+		trim = len(names) - retention.MaxRecords
+	}
+	if retention.MaxAge > 0 {
+		oldest := time.Now().Add(-retention.MaxAge)
+		for trim < len(names) {
+			t, err := auditRecordTime(names[trim])
+			if err == nil && !t.Before(oldest) {
+				break
+			}
+			trim++
+		}
+	}
+
+	for i, name := range names[:trim] {
+		if err := d.ClearState(AuditPath + name); err != nil {
+			return i, err
+		}
+	}
+
+	return trim, nil
+}
+
+// Init initializes the underlying driver
+func (a *AuditedStateDriver) Init(instInfo *core.InstanceInfo) error {
+	return a.driver.Init(instInfo)
+}
+
+// Deinit deinitializes the underlying driver
+func (a *AuditedStateDriver) Deinit() {
+	a.driver.Deinit()
+}
+
+// Write writes to the underlying driver, recording the change
+func (a *AuditedStateDriver) Write(key string, value []byte) error {
+	if !a.audits(key) {
+		return a.driver.Write(key, value)
+	}
+
+	oldValue := a.oldValue(key)
+	if err := a.driver.Write(key, value); err != nil {
+		return err
+	}
+	a.record(key, oldValue, value)
+
+	return nil
+}
+
+// WriteTTL writes a key with a TTL to the underlying driver, recording the
+// change. Its expiry is not recorded.
+func (a *AuditedStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	writer, ok := a.driver.(TTLWriter)
+	if !ok {
+		return core.Errorf("state driver does not expire keys")
+	}
+	if !a.audits(key) {
+		return writer.WriteTTL(key, value, ttl)
+	}
+
+	oldValue := a.oldValue(key)
+	if err := writer.WriteTTL(key, value, ttl); err != nil {
+		return err
+	}
+	a.record(key, oldValue, value)
+
+	return nil
+}
+
+// Read reads from the underlying driver
+func (a *AuditedStateDriver) Read(key string) ([]byte, error) {
+	return a.driver.Read(key)
+}
+
+// ReadAll reads the values under a base key from the underlying driver
+func (a *AuditedStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	return a.driver.ReadAll(baseKey)
+}
+
+// ReadAllKeys reads the values under a base key by key from the underlying
+// driver
+func (a *AuditedStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	reader, ok := a.driver.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+
+	return reader.ReadAllKeys(baseKey)
+}
+
+// KeyPrefixExists checks whether there are keys under a base key in the
+// underlying driver
+func (a *AuditedStateDriver) KeyPrefixExists(baseKey string) (bool, error) {
+	checker, ok := a.driver.(KeyPrefixChecker)
+	if !ok {
+		return false, core.Errorf("state driver does not check key prefixes")
+	}
+
+	return checker.KeyPrefixExists(baseKey)
+}
+
+// ListChildren lists the names right below a base key in the underlying
+// driver
+func (a *AuditedStateDriver) ListChildren(baseKey string) ([]string, error) {
+	lister, ok := a.driver.(ChildLister)
+	if !ok {
+		return nil, core.Errorf("state driver does not list keys")
+	}
+
+	return lister.ListChildren(baseKey)
+}
+
+// ReadVersion reads a key and its version from the underlying driver. If
+// it does not version keys, the version is 0.
+func (a *AuditedStateDriver) ReadVersion(key string) ([]byte, uint64, error) {
+	versioned, ok := a.driver.(VersionedDriver)
+	if !ok {
+		value, err := a.driver.Read(key)
+		return value, 0, err
+	}
+
+	return versioned.ReadVersion(key)
+}
+
+// WriteVersion writes a key at a version to the underlying driver,
+// recording the change. If it does not version keys, the key is written
+// unconditionally.
+func (a *AuditedStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	versioned, ok := a.driver.(VersionedDriver)
+	if !ok {
+		return a.Write(key, value)
+	}
+	if !a.audits(key) {
+		return versioned.WriteVersion(key, value, version)
+	}
+
+	var oldValue []byte
+	if version != 0 {
+		oldValue = a.oldValue(key)
+	}
+	if err := versioned.WriteVersion(key, value, version); err != nil {
+		return err
+	}
+	a.record(key, oldValue, value)
+
+	return nil
+}
+
+// Txn applies the ops in a transaction of the underlying driver, recording
+// the changes
+func (a *AuditedStateDriver) Txn(ops []TxnOp) error {
+	txn, ok := a.driver.(TxnDriver)
+	if !ok {
+		return ErrTxnUnsupported
+	}
+
+	oldValues := make([][]byte, len(ops))
+	for i, op := range ops {
+		if a.audits(op.Key) && !(op.Check && op.Version == 0) {
+			oldValues[i] = a.oldValue(op.Key)
+		}
+	}
+	if err := txn.Txn(ops); err != nil {
+		return err
+	}
+
+	for i, op := range ops {
+		switch {
+		case !a.audits(op.Key):
+		case op.Delete:
+			if oldValues[i] != nil {
+				a.record(op.Key, oldValues[i], nil)
+			}
+		default:
+			a.record(op.Key, oldValues[i], op.Value)
+		}
+	}
+
+	return nil
+}
+
+// WatchAll watches the underlying driver
+func (a *AuditedStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return a.driver.WatchAll(baseKey, rsps)
+}
+
+// WatchAllFiltered watches the keys matching a filter in the underlying
+// driver
+func (a *AuditedStateDriver) WatchAllFiltered(baseKey string, match func(string) bool, rsps chan [2][]byte) error {
+	watcher, ok := a.driver.(FilteredWatcher)
+	if !ok {
+		return core.Errorf("state driver does not filter watches")
+	}
+
+	return watcher.WatchAllFiltered(baseKey, match, rsps)
+}
+
+// ClearState clears the key in the underlying driver, recording the change
+func (a *AuditedStateDriver) ClearState(key string) error {
+	if !a.audits(key) {
+		return a.driver.ClearState(key)
+	}
+
+	oldValue := a.oldValue(key)
+	if err := a.driver.ClearState(key); err != nil {
+		return err
+	}
+	if oldValue != nil {
+		a.record(key, oldValue, nil)
+	}
+
+	return nil
+}
+
+// ClearAll clears the keys under a base key in the underlying driver,
+// recording the changes
+func (a *AuditedStateDriver) ClearAll(baseKey string) error {
+	clearer, ok := a.driver.(RecursiveClearer)
+	if !ok {
+		return core.Errorf("state driver does not clear base keys")
+	}
+
+	oldValues := map[string][]byte{}
+	if reader, ok := a.driver.(KeyReader); ok {
+		values, err := reader.ReadAllKeys(baseKey)
+		if core.ErrIfKeyExists(err) != nil {
+			log.Warnf("Error reading %q for the audit log. Err: %v", baseKey, err)
+		}
+		for key, value := range values {
+			if a.audits(key) {
+				oldValues[key] = value
+			}
+		}
+	}
+
+	if err := clearer.ClearAll(baseKey); err != nil {
+		return err
+	}
+
+	keys := []string{}
+	for key := range oldValues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		a.record(key, oldValues[key], nil)
+	}
+
+	return nil
+}
+
+// ReadState reads a state from the underlying driver
+func (a *AuditedStateDriver) ReadState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) error {
+	return a.driver.ReadState(key, value, unmarshal)
+}
+
+// ReadAllState reads all states under a base key from the underlying driver.
+// The states write through the audited driver.
+func (a *AuditedStateDriver) ReadAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	return readAllStateCommon(a, baseKey, sType, unmarshal)
+}
+
+// WatchAllState watches the states under a base key in the underlying
+// driver. The states write through the audited driver.
+func (a *AuditedStateDriver) WatchAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	byteRsps := make(chan [2][]byte, 1)
+	recvErr := make(chan error, 1)
+
+	err := a.WatchAll(baseKey, byteRsps)
+	if err != nil {
+		log.Errorf("WatchAll returned %v", err)
+		return err
+	}
+
+	for {
+		go channelStateEvents(a, baseKey, sType, unmarshal, byteRsps, rsps, recvErr)
+
+		err = <-recvErr
+		log.Errorf("Err from channelStateEvents %v", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// WriteState writes a state to the underlying driver, recording the change
+func (a *AuditedStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	encodedState, err := marshalState(key, value, marshal)
+	if err != nil {
+		return err
+	}
+
+	return a.Write(key, encodedState)
+}
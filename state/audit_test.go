@@ -0,0 +1,154 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestAuditedStateDriver(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	a := NewAuditedStateDriver(d, "tester", "/contiv.io/state/")
+
+	start := time.Now()
+	a.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1","pktTag":1,"authToken":"t1"}`))
+	a.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1","pktTag":2,"authToken":"t2","ipam":{"gw":"10.1.1.1"}}`))
+	a.Write("/contiv.io/oper/nets/net1", []byte(`{"id":"net1"}`))
+	a.Write("/contiv.io/state/eps/ep1", []byte("ep1"))
+	a.ClearState("/contiv.io/state/nets/net1")
+	a.ClearState("/contiv.io/state/nets/net2")
+	a.ClearAll("/contiv.io/state/eps/")
+
+	records, err := ReadAuditLog(a, AuditFilter{})
+	if err != nil {
+		t.Fatalf("Error reading the audit log. Err: %v", err)
+	}
+	changes := []string{}
+	for _, r := range records {
+		if r.Actor != "tester" || r.Time.Before(start) {
+			t.Fatalf("Unexpected record %+v", r)
+		}
+		change, _ := json.Marshal(r.Change)
+		changes = append(changes, r.Op+" "+r.Key+" "+string(change))
+	}
+	expected := []string{
+		`create /contiv.io/state/nets/net1 [{"field":"authToken","new":"[redacted]"},` +
+			`{"field":"id","new":"net1"},{"field":"pktTag","new":1}]`,
+		`update /contiv.io/state/nets/net1 [{"field":"ipam.gw","new":"10.1.1.1"},` +
+			`{"field":"pktTag","old":1,"new":2}]`,
+		`create /contiv.io/state/eps/ep1 [{"field":"","new":"ep1"}]`,
+		`delete /contiv.io/state/nets/net1 [{"field":"authToken","old":"[redacted]"},` +
+			`{"field":"id","old":"net1"},{"field":"ipam.gw","old":"10.1.1.1"},{"field":"pktTag","old":2}]`,
+		`delete /contiv.io/state/eps/ep1 [{"field":"","old":"ep1"}]`,
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d records, got %v", len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("Expected record %d to be %s, got %s", i, expected[i], changes[i])
+		}
+	}
+
+	records, err = ReadAuditLog(a, AuditFilter{Key: "/contiv.io/state/nets/", Limit: 1})
+	if err != nil || len(records) != 1 || records[0].Op != AuditDelete {
+		t.Fatalf("Expected the last network record, got %+v. Err: %v", records, err)
+	}
+	records, err = ReadAuditLog(a, AuditFilter{Since: time.Now()})
+	if err != nil || len(records) != 0 {
+		t.Fatalf("Expected no records, got %+v. Err: %v", records, err)
+	}
+}
+
+func TestAuditSkippedFields(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	a := NewAuditedStateDriver(d, "tester", "/contiv.io/state/")
+	SkipAuditField("allocMap")
+
+	a.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1","allocMap":"1"}`))
+	a.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1","allocMap":"3"}`))
+	a.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1","allocMap":"7","epCount":3}`))
+
+	records, err := ReadAuditLog(a, AuditFilter{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected the create and the epCount update, got %+v. Err: %v", records, err)
+	}
+	for _, r := range records {
+		for _, change := range r.Change {
+			if change.Field == "allocMap" {
+				t.Fatalf("Skipped field recorded in %+v", r)
+			}
+		}
+	}
+}
+
+func TestAuditRunAs(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	a := NewAuditedStateDriver(d, "tester", "/contiv.io/state/")
+
+	a.RunAs("rest@10.1.1.1", func() {
+		a.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1"}`))
+	})
+	a.Write("/contiv.io/state/nets/net2", []byte(`{"id":"net2"}`))
+
+	records, err := ReadAuditLog(a, AuditFilter{})
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v. Err: %v", records, err)
+	}
+	if records[0].Actor != "rest@10.1.1.1" || records[1].Actor != "tester" {
+		t.Fatalf("Unexpected actors of %+v", records)
+	}
+}
+
+func TestAuditPagingAndTrim(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		recordTime := now.Add(time.Duration(i-10) * time.Hour)
+		r, _ := json.Marshal(AuditRecord{Time: recordTime, Op: AuditCreate, Key: "/contiv.io/state/nets/net1"})
+		d.Write(AuditPath+auditRecordName(recordTime, uint64(i)), r)
+	}
+
+	page, err := ReadAuditLog(d, AuditFilter{Limit: 3})
+	if err != nil || len(page) != 3 || !page[2].Time.Equal(now.Add(-time.Hour)) {
+		t.Fatalf("Expected the 3 most recent records, got %+v. Err: %v", page, err)
+	}
+	page, err = ReadAuditLog(d, AuditFilter{Limit: 3, Before: page[0].Time})
+	if err != nil || len(page) != 3 || !page[2].Time.Equal(now.Add(-4*time.Hour)) {
+		t.Fatalf("Expected the previous page, got %+v. Err: %v", page, err)
+	}
+
+	trimmed, err := TrimAuditLog(d, AuditRetention{MaxRecords: 8})
+	if err != nil || trimmed != 2 {
+		t.Fatalf("Expected 2 records trimmed, got %d. Err: %v", trimmed, err)
+	}
+	trimmed, err = TrimAuditLog(d, AuditRetention{MaxRecords: 8, MaxAge: 3*time.Hour + time.Minute})
+	if err != nil || trimmed != 5 {
+		t.Fatalf("Expected 5 old records trimmed, got %d. Err: %v", trimmed, err)
+	}
+	records, err := ReadAuditLog(d, AuditFilter{})
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected 3 records kept, got %+v. Err: %v", records, err)
+	}
+}
@@ -0,0 +1,235 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// BackupVersion is the version of the backups written by NewBackup. Restore
+// only accepts this version.
+const BackupVersion = 1
+
+// backupBasePath is the path of the contiv state in the state store
+const backupBasePath = "/contiv.io/"
+
+var (
+	// backupSkipped are the paths of the state owned by running processes,
+	// eg. the leader lock, and of the audit log, which a restore must not
+	// rewrite. They are not backed up or restored.
+	backupSkipped = []string{backupBasePath + "lock/", backupBasePath + "service/", AuditPath}
+
+	// backupJSONPaths are the paths of the config states and objects, which
+	// are JSON objects
+	backupJSONPaths = []string{backupBasePath + "state/", backupBasePath + "obj/"}
+)
+
+// ErrStateNotEmpty is returned when restoring a backup over existing state
+// without clearing it
+var ErrStateNotEmpty = errors.New("state store has contiv state, restore with clear to replace it")
+
+// KeyReader is implemented by the state drivers that read the values under a
+// base key by key
+type KeyReader interface {
+	ReadAllKeys(baseKey string) (map[string][]byte, error)
+}
+
+// BackupEntry is a key of a backup
+type BackupEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Backup is an archive of the contiv config and oper state in the state store.
+// A backup with redacted sensitive fields can not be restored.
+type Backup struct {
+	Version  int           `json:"version"`
+	Created  time.Time     `json:"created"`
+	Redacted bool          `json:"redacted,omitempty"`
+	Entries  []BackupEntry `json:"entries"`
+}
+
+type backupEntries []BackupEntry
+
+func (e backupEntries) Len() int           { return len(e) }
+func (e backupEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e backupEntries) Less(i, j int) bool { return e[i].Key < e[j].Key }
+
+// backupSkips returns true if the key is not backed up
+func backupSkips(key string) bool {
+	for _, path := range backupSkipped {
+		if strings.HasPrefix(key, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewBackup reads the contiv state in the state store into a backup, ordered
+// by key
+func NewBackup(d core.StateDriver) (*Backup, error) {
+	reader, ok := d.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+
+	values, err := reader.ReadAllKeys(backupBasePath)
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	b := &Backup{Version: BackupVersion, Created: time.Now(), Entries: []BackupEntry{}}
+	for key, value := range values {
+		if !backupSkips(key) {
+			b.Entries = append(b.Entries, BackupEntry{Key: key, Value: value})
+		}
+	}
+	sort.Sort(backupEntries(b.Entries))
+
+	return b, nil
+}
+
+// Redact replaces the values of the sensitive fields in the JSON entries, as
+// InspectState does, and marks the backup redacted if any was replaced.
+func (b *Backup) Redact() {
+	for i, entry := range b.Entries {
+		var decoded interface{}
+		decoder := json.NewDecoder(bytes.NewReader(entry.Value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded); err != nil || decoder.More() {
+			continue
+		}
+		orig, err := json.Marshal(decoded)
+		if err != nil {
+			continue
+		}
+		redacted, err := json.Marshal(redactFields(decoded))
+		if err != nil || bytes.Equal(orig, redacted) {
+			continue
+		}
+
+		b.Entries[i].Value = redacted
+		b.Redacted = true
+	}
+}
+
+// Validate checks that a backup can be restored: it must be of the current
+// version and not redacted, its keys must be contiv state other than the
+// state of running processes, and config states and objects must be JSON
+// objects.
+func (b *Backup) Validate() error {
+	if b.Version != BackupVersion {
+		return core.Errorf("unsupported backup version %d, expected %d", b.Version, BackupVersion)
+	}
+	if b.Redacted {
+		return core.Errorf("backup has redacted sensitive fields")
+	}
+
+	keys := make(map[string]bool)
+	for _, entry := range b.Entries {
+		if !strings.HasPrefix(entry.Key, backupBasePath) || strings.HasSuffix(entry.Key, "/") {
+			return core.Errorf("invalid key %q in backup", entry.Key)
+		}
+		if backupSkips(entry.Key) {
+			return core.Errorf("key %q of a running process in backup", entry.Key)
+		}
+		if keys[entry.Key] {
+			return core.Errorf("duplicate key %q in backup", entry.Key)
+		}
+		keys[entry.Key] = true
+
+		for _, path := range backupJSONPaths {
+			obj := map[string]interface{}{}
+			if strings.HasPrefix(entry.Key, path) && json.Unmarshal(entry.Value, &obj) != nil {
+				return core.Errorf("key %q in backup is not a JSON object", entry.Key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// backupKeys returns the keys of the contiv state that are backed up
+func backupKeys(d core.StateDriver) ([]string, error) {
+	reader, ok := d.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+
+	values, err := reader.ReadAllKeys(backupBasePath)
+	if core.ErrIfKeyExists(err) != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range values {
+		if !backupSkips(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// RestoreBackup validates a backup and writes its keys to the state store. It
+// returns ErrStateNotEmpty if the store has contiv state, unless clear is set.
+// Then the contiv state is cleared first, except for the state of running
+// processes and the audit log. The processes reading the state must be
+// restarted to use the restored state.
+func RestoreBackup(d core.StateDriver, b *Backup, clear bool) error {
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	existing, err := backupKeys(d)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 && !clear {
+		return ErrStateNotEmpty
+	}
+	for _, key := range existing {
+		if err := d.ClearState(key); err != nil {
+			log.Errorf("Error clearing %s before the restore. Err: %v", key, err)
+			return err
+		}
+	}
+	if len(existing) > 0 {
+		log.Infof("Cleared %d keys before the restore", len(existing))
+	}
+
+	for i, entry := range b.Entries {
+		if err := d.Write(entry.Key, entry.Value); err != nil {
+			log.Errorf("Error restoring %s, restored %d of %d keys. Err: %v", entry.Key, i, len(b.Entries), err)
+			return err
+		}
+	}
+
+	log.Infof("Restored %d keys from the backup of %v", len(b.Entries), b.Created)
+
+	return nil
+}
@@ -0,0 +1,121 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestBackupRestore(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1"}`))
+	d.Write("/contiv.io/oper/docknet-uuid/abc", []byte("net1"))
+	d.Write("/contiv.io/lock/netmaster", []byte("leader"))
+
+	backup, err := NewBackup(d)
+	if err != nil {
+		t.Fatalf("Error backing up. Err: %v", err)
+	}
+	if len(backup.Entries) != 2 || backup.Entries[0].Key != "/contiv.io/oper/docknet-uuid/abc" {
+		t.Fatalf("Expected the state and oper keys in order, got %+v", backup.Entries)
+	}
+
+	// the archive survives encoding
+	archive, _ := json.Marshal(backup)
+	restored := &Backup{}
+	if err := json.Unmarshal(archive, restored); err != nil {
+		t.Fatalf("Error decoding backup. Err: %v", err)
+	}
+
+	r := &FakeStateDriver{}
+	r.Init(&core.InstanceInfo{})
+	if err := RestoreBackup(r, restored, false); err != nil {
+		t.Fatalf("Error restoring. Err: %v", err)
+	}
+	if value, err := r.Read("/contiv.io/state/nets/net1"); err != nil || string(value) != `{"id":"net1"}` {
+		t.Fatalf("Expected the network state, got %q. Err: %v", value, err)
+	}
+	if _, err := r.Read("/contiv.io/lock/netmaster"); err == nil {
+		t.Fatalf("Leader lock was restored")
+	}
+
+	for _, invalid := range []*Backup{
+		{Version: BackupVersion + 1},
+		{Version: BackupVersion, Entries: []BackupEntry{{Key: "/other/key"}}},
+		{Version: BackupVersion, Entries: []BackupEntry{{Key: "/contiv.io/service/netmaster/1"}}},
+		{Version: BackupVersion, Entries: []BackupEntry{{Key: "/contiv.io/state/nets/net1", Value: []byte("net1")}}},
+	} {
+		if err := RestoreBackup(r, invalid, true); err == nil {
+			t.Fatalf("Invalid backup %+v was restored", invalid)
+		}
+	}
+}
+
+func TestBackupRestoreClear(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1"}`))
+	backup, err := NewBackup(d)
+	if err != nil {
+		t.Fatalf("Error backing up. Err: %v", err)
+	}
+
+	d.Write("/contiv.io/state/nets/net2", []byte(`{"id":"net2"}`))
+	d.Write("/contiv.io/lock/netmaster", []byte("leader"))
+	d.Write(AuditPath+"1", []byte(`{}`))
+	if err := RestoreBackup(d, backup, false); err != ErrStateNotEmpty {
+		t.Fatalf("Expected the non empty state to be refused, got %v", err)
+	}
+	if err := RestoreBackup(d, backup, true); err != nil {
+		t.Fatalf("Error restoring. Err: %v", err)
+	}
+	if _, err := d.Read("/contiv.io/state/nets/net2"); err == nil {
+		t.Fatalf("State missing from the backup was not cleared")
+	}
+	if _, err := d.Read("/contiv.io/state/nets/net1"); err != nil {
+		t.Fatalf("State of the backup was not restored. Err: %v", err)
+	}
+	for _, key := range []string{"/contiv.io/lock/netmaster", AuditPath + "1"} {
+		if _, err := d.Read(key); err != nil {
+			t.Fatalf("Skipped key %s was cleared. Err: %v", key, err)
+		}
+	}
+}
+
+func TestBackupRedact(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1"}`))
+	backup, _ := NewBackup(d)
+	backup.Redact()
+	if backup.Redacted || string(backup.Entries[0].Value) != `{"id":"net1"}` {
+		t.Fatalf("Backup without secrets was redacted, got %+v", backup)
+	}
+
+	d.Write("/contiv.io/state/bgp/host1", []byte(`{"id":"host1","password":"pw"}`))
+	backup, _ = NewBackup(d)
+	backup.Redact()
+	if !backup.Redacted || string(backup.Entries[0].Value) != `{"id":"host1","password":"[redacted]"}` {
+		t.Fatalf("Expected the password redacted, got %+v", backup)
+	}
+	if err := backup.Validate(); err == nil {
+		t.Fatalf("Redacted backup is valid")
+	}
+}
@@ -0,0 +1,354 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// JournalPath is where batches are journaled until they are applied
+const JournalPath = "/contiv.io/journal/"
+
+// ErrTxnUnsupported is returned by a TxnDriver when its store does not apply
+// transactions
+var ErrTxnUnsupported = errors.New("state store does not support transactions")
+
+// TxnOp is a write of a key in a batch, or a delete if Delete is set. If
+// Check is set, the op is applied only if the key is still at Version, or
+// does not exist when Version is 0.
+type TxnOp struct {
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"`
+	Delete  bool   `json:"delete,omitempty"`
+	Check   bool   `json:"check,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// TxnDriver is implemented by the state drivers applying several writes and
+// deletes atomically
+type TxnDriver interface {
+	// Txn applies all the ops, or none of them. It returns ErrStateConflict
+	// if a checked key was changed, and ErrTxnUnsupported if the store does
+	// not apply transactions.
+	Txn(ops []TxnOp) error
+}
+
+// journalEntry is a batch in the journal. The state drivers read the values
+// of a base key without their keys, so the entry has its key.
+type journalEntry struct {
+	Key string  `json:"key"`
+	Ops []TxnOp `json:"ops"`
+}
+
+// Batch is a state driver collecting the writes and deletes of several
+// states, to apply them together with Commit. Reads see the pending writes of
+// the batch, except ReadAll and ReadAllState, and watches, which go to the
+// underlying driver. The versions read with ReadVersion are checked when the
+// batch is committed, so UpdateState on a batch fails the commit instead of
+// overwriting another writer.
+//
+// Drivers implementing TxnDriver commit the batch in a transaction. Others,
+// like etcd v2, have no multi-key transactions, so Commit journals the batch
+// before applying it one key at a time. This fallback is not atomic: other
+// readers may see the batch partially applied while it is committed, and if
+// Commit fails or the process dies while applying it, the batch stays
+// partially applied until ReplayJournal applies the rest. Only netmaster
+// replays the journal, when it becomes the leader; netplugin never does.
+type Batch struct {
+	driver core.StateDriver
+
+	mutex sync.Mutex
+	ops   []TxnOp
+}
+
+// NewBatch returns a batch of writes to a state driver
+func NewBatch(d core.StateDriver) *Batch {
+	return &Batch{driver: d}
+}
+
+// Init is a no-op, the underlying driver is already initialized.
+func (b *Batch) Init(instInfo *core.InstanceInfo) error {
+	return nil
+}
+
+// Deinit is a no-op.
+func (b *Batch) Deinit() {
+}
+
+// add records an op, replacing an earlier op of the same key. The version
+// check of the earlier op is kept.
+func (b *Batch) add(op TxnOp) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for i := range b.ops {
+		if b.ops[i].Key == op.Key {
+			op.Check, op.Version = b.ops[i].Check, b.ops[i].Version
+			b.ops = append(b.ops[:i], b.ops[i+1:]...)
+			break
+		}
+	}
+	b.ops = append(b.ops, op)
+}
+
+// pending returns the pending op of key
+func (b *Batch) pending(key string) (TxnOp, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for _, op := range b.ops {
+		if op.Key == key {
+			return op, true
+		}
+	}
+
+	return TxnOp{}, false
+}
+
+// Write records a write of key.
+func (b *Batch) Write(key string, value []byte) error {
+	b.add(TxnOp{Key: key, Value: value})
+	return nil
+}
+
+// ClearState records a delete of key.
+func (b *Batch) ClearState(key string) error {
+	b.add(TxnOp{Key: key, Delete: true})
+	return nil
+}
+
+// Read returns the pending value of key, or reads it from the driver.
+func (b *Batch) Read(key string) ([]byte, error) {
+	value, _, err := b.ReadVersion(key)
+	return value, err
+}
+
+// ReadVersion returns the pending value of key and the version it was read
+// at, or reads them from the driver.
+func (b *Batch) ReadVersion(key string) ([]byte, uint64, error) {
+	if op, ok := b.pending(key); ok {
+		if op.Delete {
+			return []byte{}, 0, core.Errorf("Key not found! key: %v", key)
+		}
+		return op.Value, op.Version, nil
+	}
+
+	versioned, ok := b.driver.(VersionedDriver)
+	if !ok {
+		value, err := b.driver.Read(key)
+		return value, 0, err
+	}
+
+	return versioned.ReadVersion(key)
+}
+
+// WriteVersion records a write of key, checked at commit to be still at
+// version, or not to exist if version is 0.
+func (b *Batch) WriteVersion(key string, value []byte, version uint64) error {
+	b.add(TxnOp{Key: key, Value: value, Check: true, Version: version})
+	return nil
+}
+
+// ReadAll reads from the driver, without the pending writes.
+func (b *Batch) ReadAll(baseKey string) ([][]byte, error) {
+	return b.driver.ReadAll(baseKey)
+}
+
+// WatchAll watches the driver.
+func (b *Batch) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return b.driver.WatchAll(baseKey, rsps)
+}
+
+// WriteState records a write of a core.State to key.
+func (b *Batch) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	encodedState, err := marshalState(key, value, marshal)
+	if err != nil {
+		return err
+	}
+
+	return b.Write(key, encodedState)
+}
+
+// ReadState reads key into a core.State, seeing the pending writes.
+func (b *Batch) ReadState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) error {
+	encodedState, err := b.Read(key)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalState(key, encodedState, value, unmarshal)
+}
+
+// ReadAllState reads from the driver, without the pending writes.
+func (b *Batch) ReadAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	return readAllStateCommon(b, baseKey, sType, unmarshal)
+}
+
+// WatchAllState watches the driver.
+func (b *Batch) WatchAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	return b.driver.WatchAllState(baseKey, sType, unmarshal, rsps)
+}
+
+// Commit applies the pending writes and deletes, in a transaction if the
+// driver implements TxnDriver, else journaled. It returns ErrStateConflict if
+// a key read with ReadVersion was changed since. The batch is empty
+// afterwards.
+func (b *Batch) Commit() error {
+	b.mutex.Lock()
+	ops := b.ops
+	b.ops = nil
+	b.mutex.Unlock()
+
+	if len(ops) == 0 {
+		return nil
+	}
+
+	if txn, ok := b.driver.(TxnDriver); ok {
+		if err := txn.Txn(ops); err != ErrTxnUnsupported {
+			return err
+		}
+	}
+
+	// without a transaction, a key changed between the check and the write
+	// is overwritten
+	if err := checkVersions(b.driver, ops); err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	journalKey := JournalPath + hex.EncodeToString(id)
+	entry, err := json.Marshal(journalEntry{Key: journalKey, Ops: ops})
+	if err != nil {
+		return err
+	}
+	if err := b.driver.Write(journalKey, entry); err != nil {
+		log.Errorf("Error journaling batch. Err: %v", err)
+		return err
+	}
+
+	if err := applyBatch(b.driver, ops); err != nil {
+		log.Errorf("Error applying batch %s, it is replayed on restart. Err: %v", journalKey, err)
+		return err
+	}
+
+	return b.driver.ClearState(journalKey)
+}
+
+// RunBatch runs ops on a new batch of d and commits it. If a key read with
+// ReadVersion in the batch was changed by another writer before the commit,
+// ops is run again on a new batch, so it must read the states it changes
+// from the batch.
+func RunBatch(d core.StateDriver, ops func(b *Batch) error) error {
+	for i := 0; ; i++ {
+		b := NewBatch(d)
+		if err := ops(b); err != nil {
+			return err
+		}
+
+		err := b.Commit()
+		if err != ErrStateConflict || i == maxUpdateRetries {
+			return err
+		}
+		log.Infof("State was changed by another writer, retrying the batch")
+	}
+}
+
+// checkVersions returns ErrStateConflict if a checked key of ops is not at
+// its version
+func checkVersions(d core.StateDriver, ops []TxnOp) error {
+	versioned, ok := d.(VersionedDriver)
+	if !ok {
+		return nil
+	}
+
+	for _, op := range ops {
+		if !op.Check {
+			continue
+		}
+		_, version, err := versioned.ReadVersion(op.Key)
+		if err != nil {
+			if core.ErrIfKeyExists(err) != nil {
+				return err
+			}
+			version = 0
+		}
+		if version != op.Version {
+			return ErrStateConflict
+		}
+	}
+
+	return nil
+}
+
+// applyBatch applies the ops of a batch in order, without their checks
+func applyBatch(d core.StateDriver, ops []TxnOp) error {
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = d.ClearState(op.Key)
+		} else {
+			err = d.Write(op.Key, op.Value)
+		}
+		if core.ErrIfKeyExists(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplayJournal applies the batches left in the journal by a process that
+// died while committing them. It must run before the state is written, eg.
+// when netmaster becomes the leader.
+func ReplayJournal(d core.StateDriver) error {
+	values, err := d.ReadAll(JournalPath)
+	if err != nil {
+		return core.ErrIfKeyExists(err)
+	}
+
+	for _, value := range values {
+		entry := journalEntry{}
+		if err := json.Unmarshal(value, &entry); err != nil || entry.Key == "" {
+			log.Errorf("Error decoding journaled batch %q. Err: %v", value, err)
+			continue
+		}
+
+		log.Warnf("Replaying batch %s of %d writes", entry.Key, len(entry.Ops))
+		if err := applyBatch(d, entry.Ops); err != nil {
+			return err
+		}
+		if err := d.ClearState(entry.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
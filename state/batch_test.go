@@ -0,0 +1,213 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func newBatchTestDriver(t *testing.T) *FakeStateDriver {
+	d := &FakeStateDriver{}
+	if err := d.Init(&core.InstanceInfo{}); err != nil {
+		t.Fatalf("Error initializing the fake driver. Err: %v", err)
+	}
+	return d
+}
+
+// noTxnDriver is a fake driver without transactions, committing batches
+// through the journal
+type noTxnDriver struct {
+	*FakeStateDriver
+}
+
+func (d noTxnDriver) Txn(ops []TxnOp) error {
+	return ErrTxnUnsupported
+}
+
+func TestBatchCommit(t *testing.T) {
+	fake := newBatchTestDriver(t)
+	for _, d := range []core.StateDriver{fake, noTxnDriver{fake}} {
+		d.Write("/test/old", []byte("old"))
+
+		b := NewBatch(d)
+		b.Write("/test/new", []byte("v1"))
+		b.Write("/test/new", []byte("v2"))
+		b.ClearState("/test/old")
+
+		// pending ops are seen by the batch only
+		if val, err := b.Read("/test/new"); err != nil || string(val) != "v2" {
+			t.Fatalf("Expected pending write v2, got %q. Err: %v", val, err)
+		}
+		if _, err := b.Read("/test/old"); err == nil {
+			t.Fatalf("Pending delete was not seen by the batch")
+		}
+		if _, err := d.Read("/test/new"); err == nil {
+			t.Fatalf("Pending write was applied before commit")
+		}
+
+		if err := b.Commit(); err != nil {
+			t.Fatalf("Error committing batch. Err: %v", err)
+		}
+		if val, err := d.Read("/test/new"); err != nil || string(val) != "v2" {
+			t.Fatalf("Expected v2, got %q. Err: %v", val, err)
+		}
+		if _, err := d.Read("/test/old"); err == nil {
+			t.Fatalf("Delete was not applied")
+		}
+		if entries, _ := d.ReadAll(JournalPath); len(entries) != 0 {
+			t.Fatalf("Journal was not cleared, got %d entries", len(entries))
+		}
+		d.ClearState("/test/new")
+	}
+}
+
+func TestBatchConflict(t *testing.T) {
+	fake := newBatchTestDriver(t)
+	for _, d := range []core.StateDriver{fake, noTxnDriver{fake}} {
+		d.Write("/test/key", []byte("v1"))
+
+		b := NewBatch(d)
+		val, version, err := b.ReadVersion("/test/key")
+		if err != nil || string(val) != "v1" {
+			t.Fatalf("Expected v1, got %q. Err: %v", val, err)
+		}
+		b.WriteVersion("/test/key", []byte("v2"), version)
+		b.Write("/test/other", []byte("other"))
+
+		// the pending write keeps the version read
+		if _, pending, _ := b.ReadVersion("/test/key"); pending != version {
+			t.Fatalf("Expected pending version %d, got %d", version, pending)
+		}
+
+		// another writer changes the key before the commit
+		d.Write("/test/key", []byte("v3"))
+		if err := b.Commit(); err != ErrStateConflict {
+			t.Fatalf("Expected a conflict, got %v", err)
+		}
+		if val, _ := d.Read("/test/key"); string(val) != "v3" {
+			t.Fatalf("Conflicting batch overwrote the key with %q", val)
+		}
+		if _, err := d.Read("/test/other"); err == nil {
+			t.Fatalf("Conflicting batch was partially applied")
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	d := newBatchTestDriver(t)
+	d.Write("/test/key", []byte("v1"))
+
+	attempts := 0
+	err := RunBatch(d, func(b *Batch) error {
+		attempts++
+		_, version, err := b.ReadVersion("/test/key")
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			d.Write("/test/key", []byte("other"))
+		}
+		return b.WriteVersion("/test/key", []byte(fmt.Sprintf("attempt %d", attempts)), version)
+	})
+	if err != nil {
+		t.Fatalf("Error running batch. Err: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("Expected the batch to be retried once, got %d attempts", attempts)
+	}
+	if val, _ := d.Read("/test/key"); string(val) != "attempt 2" {
+		t.Fatalf("Expected the retried write, got %q", val)
+	}
+}
+
+// failingWriteDriver fails the first write of a key, as a netmaster dying
+// while applying a batch would
+type failingWriteDriver struct {
+	noTxnDriver
+	failKey string
+}
+
+func (d *failingWriteDriver) Write(key string, value []byte) error {
+	if key == d.failKey {
+		d.failKey = ""
+		return errors.New("write failed")
+	}
+	return d.noTxnDriver.Write(key, value)
+}
+
+func TestReplayPartialBatch(t *testing.T) {
+	d := &failingWriteDriver{noTxnDriver: noTxnDriver{newBatchTestDriver(t)}, failKey: "/test/b"}
+
+	b := NewBatch(d)
+	b.Write("/test/a", []byte("a"))
+	b.Write("/test/b", []byte("b"))
+	b.Write("/test/c", []byte("c"))
+	if err := b.Commit(); err == nil {
+		t.Fatalf("Commit succeeded with a failing write")
+	}
+
+	// the batch stays partially applied until the journal is replayed
+	if val, err := d.Read("/test/a"); err != nil || string(val) != "a" {
+		t.Fatalf("Expected a, got %q. Err: %v", val, err)
+	}
+	for _, key := range []string{"/test/b", "/test/c"} {
+		if _, err := d.Read(key); err == nil {
+			t.Fatalf("%s was written after the failed write", key)
+		}
+	}
+
+	if err := ReplayJournal(d); err != nil {
+		t.Fatalf("Error replaying journal. Err: %v", err)
+	}
+	for _, key := range []string{"/test/a", "/test/b", "/test/c"} {
+		if val, err := d.Read(key); err != nil || string(val) != key[len(key)-1:] {
+			t.Fatalf("Unexpected value of %s after replay: %q. Err: %v", key, val, err)
+		}
+	}
+	if values, _ := d.ReadAll(JournalPath); len(values) != 0 {
+		t.Fatalf("Replayed batch was not cleared: %q", values)
+	}
+}
+
+func TestReplayJournal(t *testing.T) {
+	d := newBatchTestDriver(t)
+	d.Write("/test/old", []byte("old"))
+
+	// a batch journaled by a netmaster that died before applying it
+	entry, _ := json.Marshal(journalEntry{
+		Key: JournalPath + "dead",
+		Ops: []TxnOp{{Key: "/test/new", Value: []byte("new")}, {Key: "/test/old", Delete: true}},
+	})
+	d.Write(JournalPath+"dead", entry)
+
+	if err := ReplayJournal(d); err != nil {
+		t.Fatalf("Error replaying journal. Err: %v", err)
+	}
+	if val, err := d.Read("/test/new"); err != nil || string(val) != "new" {
+		t.Fatalf("Expected new, got %q. Err: %v", val, err)
+	}
+	if _, err := d.Read("/test/old"); err == nil {
+		t.Fatalf("Delete was not replayed")
+	}
+	if _, err := d.Read(JournalPath + "dead"); err == nil {
+		t.Fatalf("Replayed batch was not cleared")
+	}
+}
@@ -0,0 +1,372 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// cacheEntry is the result of a ReadAll or ReadAllKeys of a base key
+type cacheEntry struct {
+	values    [][]byte
+	keyValues map[string][]byte
+	err       error
+	loaded    time.Time
+}
+
+// CachedStateDriver is a state driver serving ReadAll, ReadAllKeys and
+// ReadAllState of the underlying driver from memory. Writes go to the
+// underlying driver and invalidate the cached base keys they are under. The
+// first read of a base key watches it, and a change by another process
+// invalidates it. If the underlying driver can not watch the base key, it is
+// not cached.
+//
+// The watch may miss a change made while it is set up, so entries are
+// reloaded after maxAge, unless it is 0. Read and the other calls are not
+// cached.
+type CachedStateDriver struct {
+	driver core.StateDriver
+	maxAge time.Duration
+
+	watchMutex sync.Mutex // serializes setting up watches
+
+	mutex      sync.Mutex
+	entries    map[string]*cacheEntry // ReadAll results
+	keyEntries map[string]*cacheEntry // ReadAllKeys results
+	watched    map[string]bool        // base keys watched, false if the watch failed
+	gen        uint64                 // incremented on every invalidation
+}
+
+// NewCachedStateDriver returns a cache in front of a state driver
+func NewCachedStateDriver(d core.StateDriver, maxAge time.Duration) *CachedStateDriver {
+	return &CachedStateDriver{
+		driver:     d,
+		maxAge:     maxAge,
+		entries:    make(map[string]*cacheEntry),
+		keyEntries: make(map[string]*cacheEntry),
+		watched:    make(map[string]bool),
+	}
+}
+
+// Init initializes the underlying driver
+func (c *CachedStateDriver) Init(instInfo *core.InstanceInfo) error {
+	return c.driver.Init(instInfo)
+}
+
+// Deinit drops the cache and deinitializes the underlying driver
+func (c *CachedStateDriver) Deinit() {
+	c.invalidate(func(string) bool { return true })
+	c.driver.Deinit()
+}
+
+// invalidate drops the cached base keys matching drop
+func (c *CachedStateDriver) invalidate(drop func(baseKey string) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.gen++
+	for baseKey := range c.entries {
+		if drop(baseKey) {
+			delete(c.entries, baseKey)
+		}
+	}
+	for baseKey := range c.keyEntries {
+		if drop(baseKey) {
+			delete(c.keyEntries, baseKey)
+		}
+	}
+}
+
+// cached returns the entry of a base key in entries if it is loaded, and the
+// generation to store a new one at
+func (c *CachedStateDriver) cached(entries map[string]*cacheEntry, baseKey string) (*cacheEntry, uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := entries[baseKey]
+	if ok && (c.maxAge == 0 || time.Since(entry.loaded) < c.maxAge) {
+		return entry, c.gen
+	}
+
+	return nil, c.gen
+}
+
+// store caches the entry of a base key read at generation gen, unless a
+// change during the read invalidated it
+func (c *CachedStateDriver) store(entries map[string]*cacheEntry, baseKey string, gen uint64,
+	entry *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.gen == gen {
+		entry.loaded = time.Now()
+		entries[baseKey] = entry
+	}
+}
+
+// invalidateKey drops the cached base keys a key is under
+func (c *CachedStateDriver) invalidateKey(key string) {
+	c.invalidate(func(baseKey string) bool { return strings.HasPrefix(key, baseKey) })
+}
+
+// watch invalidates a base key when it changes. It returns false if the base
+// key can not be watched.
+func (c *CachedStateDriver) watch(baseKey string) bool {
+	c.watchMutex.Lock()
+	defer c.watchMutex.Unlock()
+
+	c.mutex.Lock()
+	watched, ok := c.watched[baseKey]
+	c.mutex.Unlock()
+	if ok {
+		return watched
+	}
+
+	rsps := make(chan [2][]byte)
+	err := c.driver.WatchAll(baseKey, rsps)
+	if err != nil {
+		log.Warnf("Unable to watch %s, it is not cached. Err: %v", baseKey, err)
+	} else {
+		go c.drainWatch(baseKey, rsps)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.watched[baseKey] = err == nil
+
+	return err == nil
+}
+
+// drainWatch invalidates a base key on every change. The drivers never stop
+// watches, so neither does it.
+func (c *CachedStateDriver) drainWatch(baseKey string, rsps chan [2][]byte) {
+	for range rsps {
+		c.invalidate(func(key string) bool { return key == baseKey })
+	}
+}
+
+// Write writes to the underlying driver
+func (c *CachedStateDriver) Write(key string, value []byte) error {
+	defer c.invalidateKey(key)
+	return c.driver.Write(key, value)
+}
+
+// Read reads from the underlying driver
+func (c *CachedStateDriver) Read(key string) ([]byte, error) {
+	return c.driver.Read(key)
+}
+
+// ReadAll returns the values under a base key, from the cache if it is
+// loaded. Only successful reads, and reads of missing base keys, are cached.
+func (c *CachedStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	entry, gen := c.cached(c.entries, baseKey)
+	if entry != nil {
+		return append([][]byte(nil), entry.values...), entry.err
+	}
+
+	// watch before reading, so that a change after the read invalidates it
+	if !c.watch(baseKey) {
+		return c.driver.ReadAll(baseKey)
+	}
+
+	values, err := c.driver.ReadAll(baseKey)
+	if core.ErrIfKeyExists(err) != nil {
+		return values, err
+	}
+
+	c.store(c.entries, baseKey, gen, &cacheEntry{values: values, err: err})
+	return append([][]byte(nil), values...), err
+}
+
+// ReadAllKeys returns the values under a base key by key, cached like ReadAll
+func (c *CachedStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	reader, ok := c.driver.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+
+	entry, gen := c.cached(c.keyEntries, baseKey)
+	if entry != nil {
+		return copyKeyValues(entry.keyValues), entry.err
+	}
+
+	// watch before reading, so that a change after the read invalidates it
+	if !c.watch(baseKey) {
+		return reader.ReadAllKeys(baseKey)
+	}
+
+	values, err := reader.ReadAllKeys(baseKey)
+	if core.ErrIfKeyExists(err) != nil {
+		return values, err
+	}
+
+	c.store(c.keyEntries, baseKey, gen, &cacheEntry{keyValues: values, err: err})
+	return copyKeyValues(values), err
+}
+
+// copyKeyValues copies a map of values by key, so that callers do not change
+// the cached one
+func copyKeyValues(values map[string][]byte) map[string][]byte {
+	if values == nil {
+		return nil
+	}
+
+	copied := make(map[string][]byte, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+// KeyPrefixExists checks whether there are keys under a base key in the
+// underlying driver
+func (c *CachedStateDriver) KeyPrefixExists(baseKey string) (bool, error) {
+	checker, ok := c.driver.(KeyPrefixChecker)
+	if !ok {
+		return false, core.Errorf("state driver does not check key prefixes")
+	}
+
+	return checker.KeyPrefixExists(baseKey)
+}
+
+// ListChildren lists the names right below a base key in the underlying
+// driver
+func (c *CachedStateDriver) ListChildren(baseKey string) ([]string, error) {
+	lister, ok := c.driver.(ChildLister)
+	if !ok {
+		return nil, core.Errorf("state driver does not list keys")
+	}
+
+	return lister.ListChildren(baseKey)
+}
+
+// ReadVersion reads a key and its version from the underlying driver. If
+// it does not version keys, the version is 0.
+func (c *CachedStateDriver) ReadVersion(key string) ([]byte, uint64, error) {
+	versioned, ok := c.driver.(VersionedDriver)
+	if !ok {
+		value, err := c.driver.Read(key)
+		return value, 0, err
+	}
+
+	return versioned.ReadVersion(key)
+}
+
+// WriteVersion writes a key at a version to the underlying driver. If it
+// does not version keys, the key is written unconditionally.
+func (c *CachedStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	versioned, ok := c.driver.(VersionedDriver)
+	if !ok {
+		return c.Write(key, value)
+	}
+
+	defer c.invalidateKey(key)
+	return versioned.WriteVersion(key, value, version)
+}
+
+// Txn applies the ops in a transaction of the underlying driver
+func (c *CachedStateDriver) Txn(ops []TxnOp) error {
+	txn, ok := c.driver.(TxnDriver)
+	if !ok {
+		return ErrTxnUnsupported
+	}
+
+	defer func() {
+		for _, op := range ops {
+			c.invalidateKey(op.Key)
+		}
+	}()
+	return txn.Txn(ops)
+}
+
+// WriteTTL writes a key with a TTL to the underlying driver
+func (c *CachedStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	writer, ok := c.driver.(TTLWriter)
+	if !ok {
+		return core.Errorf("state driver does not expire keys")
+	}
+
+	defer c.invalidateKey(key)
+	return writer.WriteTTL(key, value, ttl)
+}
+
+// WatchAll watches the underlying driver
+func (c *CachedStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return c.driver.WatchAll(baseKey, rsps)
+}
+
+// WatchAllFiltered watches the keys matching a filter in the underlying
+// driver
+func (c *CachedStateDriver) WatchAllFiltered(baseKey string, match func(string) bool, rsps chan [2][]byte) error {
+	watcher, ok := c.driver.(FilteredWatcher)
+	if !ok {
+		return core.Errorf("state driver does not filter watches")
+	}
+
+	return watcher.WatchAllFiltered(baseKey, match, rsps)
+}
+
+// ClearState clears the key in the underlying driver
+func (c *CachedStateDriver) ClearState(key string) error {
+	defer c.invalidateKey(key)
+	return c.driver.ClearState(key)
+}
+
+// ClearAll clears the keys under a base key in the underlying driver
+func (c *CachedStateDriver) ClearAll(baseKey string) error {
+	clearer, ok := c.driver.(RecursiveClearer)
+	if !ok {
+		return core.Errorf("state driver does not clear base keys")
+	}
+
+	defer c.invalidate(func(key string) bool {
+		return strings.HasPrefix(key, baseKey) || strings.HasPrefix(baseKey, key)
+	})
+	return clearer.ClearAll(baseKey)
+}
+
+// ReadState reads a state from the underlying driver
+func (c *CachedStateDriver) ReadState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) error {
+	return c.driver.ReadState(key, value, unmarshal)
+}
+
+// ReadAllState reads all states under a base key, from the cache if it is
+// loaded
+func (c *CachedStateDriver) ReadAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	return readAllStateCommon(c, baseKey, sType, unmarshal)
+}
+
+// WatchAllState watches the underlying driver
+func (c *CachedStateDriver) WatchAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	return c.driver.WatchAllState(baseKey, sType, unmarshal, rsps)
+}
+
+// WriteState writes a state to the underlying driver
+func (c *CachedStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	defer c.invalidateKey(key)
+	return c.driver.WriteState(key, value, marshal)
+}
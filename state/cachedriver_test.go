@@ -0,0 +1,128 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// watchedFakeDriver is a fake driver counting ReadAll calls, whose watches
+// are sent to by the test
+type watchedFakeDriver struct {
+	FakeStateDriver
+	readAlls int
+	watches  map[string]chan [2][]byte
+}
+
+func (d *watchedFakeDriver) ReadAll(baseKey string) ([][]byte, error) {
+	d.readAlls++
+	return d.FakeStateDriver.ReadAll(baseKey)
+}
+
+func (d *watchedFakeDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	d.readAlls++
+	return d.FakeStateDriver.ReadAllKeys(baseKey)
+}
+
+func (d *watchedFakeDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	d.watches[baseKey] = rsps
+	return nil
+}
+
+func TestCachedStateDriver(t *testing.T) {
+	d := &watchedFakeDriver{watches: make(map[string]chan [2][]byte)}
+	if err := d.Init(&core.InstanceInfo{}); err != nil {
+		t.Fatalf("Error initializing the fake driver. Err: %v", err)
+	}
+	d.Write("/test/a/1", []byte("1"))
+	c := NewCachedStateDriver(d, 0)
+
+	readAll := func(expected int) {
+		values, err := c.ReadAll("/test/a/")
+		if err != nil || len(values) != expected {
+			t.Fatalf("Expected %d values, got %q. Err: %v", expected, values, err)
+		}
+	}
+
+	readAll(1)
+	readAll(1)
+	if d.readAlls != 1 {
+		t.Fatalf("Expected ReadAll from the cache, got %d reads", d.readAlls)
+	}
+
+	// writes through the cache invalidate it
+	c.Write("/test/a/2", []byte("2"))
+	readAll(2)
+	if d.readAlls != 2 {
+		t.Fatalf("Write did not invalidate the cache, got %d reads", d.readAlls)
+	}
+
+	// writes by other processes are invalidated by the watch
+	d.Write("/test/a/3", []byte("3"))
+	readAll(2)
+	d.watches["/test/a/"] <- [2][]byte{[]byte("3"), nil}
+	for i := 0; ; i++ {
+		if values, _ := c.ReadAll("/test/a/"); len(values) == 3 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Watch did not invalidate the cache")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// ReadAllKeys is cached and invalidated the same way
+	reads := d.readAlls
+	readAllKeys := func(expected int) {
+		values, err := c.ReadAllKeys("/test/a/")
+		if err != nil || len(values) != expected {
+			t.Fatalf("Expected %d values, got %q. Err: %v", expected, values, err)
+		}
+		delete(values, "/test/a/1")
+	}
+	readAllKeys(3)
+	readAllKeys(3)
+	if d.readAlls != reads+1 {
+		t.Fatalf("Expected ReadAllKeys from the cache, got %d reads", d.readAlls-reads)
+	}
+	c.ClearState("/test/a/3")
+	readAllKeys(2)
+	d.Write("/test/a/4", []byte("4"))
+	d.watches["/test/a/"] <- [2][]byte{[]byte("4"), nil}
+	for i := 0; ; i++ {
+		if values, _ := c.ReadAllKeys("/test/a/"); len(values) == 3 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Watch did not invalidate the cached keys")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// base keys that can not be watched are not cached
+	f := &FakeStateDriver{}
+	f.Init(&core.InstanceInfo{})
+	f.Write("/test/b/1", []byte("1"))
+	c = NewCachedStateDriver(f, 0)
+	c.ReadAll("/test/b/")
+	f.Write("/test/b/2", []byte("2"))
+	if values, _ := c.ReadAll("/test/b/"); len(values) != 2 {
+		t.Fatalf("Unwatched base key was cached, got %q", values)
+	}
+}
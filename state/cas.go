@@ -0,0 +1,131 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// maxUpdateRetries is the number of times UpdateState retries a conflict
+const maxUpdateRetries = 10
+
+var (
+	// ErrStateConflict is returned when a versioned write finds the key
+	// changed by another writer
+	ErrStateConflict = errors.New("state was changed by another writer")
+
+	// ErrUnchanged is returned by an UpdateState update to skip the write
+	ErrUnchanged = errors.New("state is unchanged")
+)
+
+// VersionedDriver is implemented by the state drivers that write a key only
+// if it was not changed since it was read. Versions are opaque and non-zero.
+type VersionedDriver interface {
+	// ReadVersion reads the value of a key and its version
+	ReadVersion(key string) ([]byte, uint64, error)
+	// WriteVersion writes a key if it is still at version, or if it does not
+	// exist when version is 0. It returns ErrStateConflict otherwise.
+	WriteVersion(key string, value []byte, version uint64) error
+}
+
+// ReadStateVersion reads a state and its version. Drivers not implementing
+// VersionedDriver return version 0.
+func ReadStateVersion(d core.StateDriver, key string, value core.State,
+	unmarshal func([]byte, interface{}) error) (uint64, error) {
+	versioned, ok := d.(VersionedDriver)
+	if !ok {
+		return 0, d.ReadState(key, value, unmarshal)
+	}
+
+	encodedState, version, err := versioned.ReadVersion(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return version, unmarshalState(key, encodedState, value, unmarshal)
+}
+
+// WriteStateVersion writes a state if it is still at version, or if it does
+// not exist when version is 0. Drivers not implementing VersionedDriver write
+// it unconditionally.
+func WriteStateVersion(d core.StateDriver, key string, value core.State,
+	marshal func(interface{}) ([]byte, error), version uint64) error {
+	versioned, ok := d.(VersionedDriver)
+	if !ok {
+		return d.WriteState(key, value, marshal)
+	}
+
+	encodedState, err := marshalState(key, value, marshal)
+	if err != nil {
+		return err
+	}
+
+	return versioned.WriteVersion(key, encodedState, version)
+}
+
+// UpdateState reads an existing state into value, applies update and writes
+// it if no other writer changed it meanwhile. On a conflict, value is read
+// again and update reapplied, so update must only depend on value. If update
+// returns ErrUnchanged, nothing is written.
+func UpdateState(d core.StateDriver, key string, value core.State,
+	unmarshal func([]byte, interface{}) error, marshal func(interface{}) ([]byte, error),
+	update func() error) error {
+	for i := 0; ; i++ {
+		resetState(value)
+		version, err := ReadStateVersion(d, key, value, unmarshal)
+		if err != nil {
+			return err
+		}
+
+		err = update()
+		if err == ErrUnchanged {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = WriteStateVersion(d, key, value, marshal, version)
+		if err != ErrStateConflict || i == maxUpdateRetries {
+			return err
+		}
+		log.Infof("State %s was changed by another writer, retrying the update", key)
+	}
+}
+
+// resetState clears a state read before, keeping its state driver, so that
+// fields missing in the next read are not left over
+func resetState(value core.State) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	v = v.Elem()
+
+	stateDriver := v.FieldByName("CommonState").FieldByName("StateDriver")
+	if !stateDriver.IsValid() {
+		return
+	}
+	saved := reflect.New(stateDriver.Type()).Elem()
+	saved.Set(stateDriver)
+
+	v.Set(reflect.Zero(v.Type()))
+	v.FieldByName("CommonState").FieldByName("StateDriver").Set(saved)
+}
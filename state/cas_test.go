@@ -0,0 +1,75 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestUpdateState(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	key := "/contiv.io/oper/test/1"
+
+	created := &testState{IntField: 1}
+	created.ID = "1"
+	if err := WriteStateVersion(d, key, created, json.Marshal, 0); err != nil {
+		t.Fatalf("Error creating state. Err: %v", err)
+	}
+	if err := WriteStateVersion(d, key, created, json.Marshal, 0); err != ErrStateConflict {
+		t.Fatalf("Expected ErrStateConflict creating an existing state, got: %v", err)
+	}
+
+	// another writer changes the state during the first update
+	value := &testState{}
+	value.StateDriver = d
+	updates := 0
+	err := UpdateState(d, key, value, json.Unmarshal, json.Marshal, func() error {
+		updates++
+		if updates == 1 {
+			other := &testState{IntField: 10}
+			other.ID = "1"
+			d.WriteState(key, other, json.Marshal)
+		}
+		value.IntField++
+		return nil
+	})
+	if err != nil || updates != 2 {
+		t.Fatalf("Expected the update to be retried once, got %d updates. Err: %v", updates, err)
+	}
+
+	read := &testState{}
+	if err := d.ReadState(key, read, json.Unmarshal); err != nil || read.IntField != 11 {
+		t.Fatalf("Expected the update applied to the other write, got %+v. Err: %v", read, err)
+	}
+	if value.StateDriver != d {
+		t.Fatalf("State driver was not kept")
+	}
+
+	// an unchanged state is not written
+	version, _ := ReadStateVersion(d, key, read, json.Unmarshal)
+	err = UpdateState(d, key, value, json.Unmarshal, json.Marshal, func() error { return ErrUnchanged })
+	if after, _ := ReadStateVersion(d, key, read, json.Unmarshal); err != nil || after != version {
+		t.Fatalf("Unchanged state was written. Err: %v", err)
+	}
+
+	if err := UpdateState(d, key+"0", value, json.Unmarshal, json.Marshal, func() error { return nil }); err == nil {
+		t.Fatalf("Updated a missing state")
+	}
+}
@@ -17,6 +17,8 @@ package state
 
 import (
 	"errors"
+	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -26,8 +28,12 @@ import (
 	log "github.com/Sirupsen/logrus"
 )
 
-// Max times to retry in case of failure
-const maxConsulRetries = 10
+const (
+	maxConsulRetries = 10               // Max times to retry in case of failure
+	consulWatchWait  = 5 * time.Minute  // longest wait of a blocking query
+	minConsulTTL     = 10 * time.Second // shortest session TTL
+	maxConsulTxnOps  = 64               // most ops of a transaction
+)
 
 // ConsulStateDriverConfig encapsulates the configuration parameters to
 // initialize consul client
@@ -52,6 +58,17 @@ func (d *ConsulStateDriver) Init(instInfo *core.InstanceInfo) error {
 	cfg := api.Config{
 		Address: strings.TrimPrefix(instInfo.DbURL, "consul://"),
 	}
+	if sec := instInfo.DbSecurity; sec.Username != "" {
+		cfg.HttpAuth = &api.HttpBasicAuth{Username: sec.Username, Password: sec.Password}
+	}
+	if instInfo.DbSecurity.UseTLS() {
+		transport, err := NewTLSTransport(instInfo.DbSecurity)
+		if err != nil {
+			return err
+		}
+		cfg.Scheme = "https"
+		cfg.HttpClient = &http.Client{Transport: transport}
+	}
 
 	d.Client, err = api.NewClient(&cfg)
 
@@ -116,6 +133,125 @@ func (d *ConsulStateDriver) Read(key string) ([]byte, error) {
 	return kv.Value, err
 }
 
+// WriteTTL writes a key held by a consul session with the TTL, which deletes
+// the key when it expires. A key written again renews its session, keeping
+// the TTL it was first written with. Consul TTLs are at least 10s, and
+// sessions expire up to twice the TTL after their last renewal.
+func (d *ConsulStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	if ttl < minConsulTTL {
+		return core.Errorf("TTL %s is less than the consul minimum %s", ttl, minConsulTTL)
+	}
+	key = processKey(key)
+
+	kv, _, err := d.Client.KV().Get(key, nil)
+	if err != nil {
+		return err
+	}
+	if kv != nil && kv.Session != "" {
+		if entry, _, err := d.Client.Session().Renew(kv.Session, nil); err == nil && entry != nil {
+			_, err = d.Client.KV().Put(&api.KVPair{Key: key, Value: value}, nil)
+			return err
+		}
+	}
+
+	session, _, err := d.Client.Session().CreateNoChecks(&api.SessionEntry{
+		Behavior:  api.SessionBehaviorDelete,
+		TTL:       ttl.String(),
+		LockDelay: time.Millisecond,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	ok, _, err := d.Client.KV().Acquire(&api.KVPair{Key: key, Value: value, Session: session}, nil)
+	if err == nil && !ok {
+		err = core.Errorf("key %s is held by another session", key)
+	}
+	if err != nil {
+		d.Client.Session().Destroy(session, nil)
+	}
+
+	return err
+}
+
+// ReadVersion reads a key and its modify index
+func (d *ConsulStateDriver) ReadVersion(key string) ([]byte, uint64, error) {
+	kv, _, err := d.Client.KV().Get(processKey(key), nil)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	if kv == nil {
+		return []byte{}, 0, core.Errorf("Key not found")
+	}
+
+	return kv.Value, kv.ModifyIndex, nil
+}
+
+// WriteVersion writes a key if it is still at the modify index version, or if
+// it does not exist when version is 0
+func (d *ConsulStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	pair := &api.KVPair{Key: processKey(key), Value: value, ModifyIndex: version}
+	ok, _, err := d.Client.KV().CAS(pair, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrStateConflict
+	}
+
+	return nil
+}
+
+// consulTxnKVOp is a key op of a consul transaction
+type consulTxnKVOp struct {
+	Verb  string
+	Key   string
+	Value []byte `json:",omitempty"`
+	Index uint64 `json:",omitempty"`
+}
+
+// consulTxnOp is an op of a consul transaction
+type consulTxnOp struct {
+	KV consulTxnKVOp
+}
+
+// Txn applies the ops in a consul transaction. Consul servers older than 0.7
+// have no transactions and a transaction has at most maxConsulTxnOps ops, the
+// other batches return ErrTxnUnsupported.
+func (d *ConsulStateDriver) Txn(ops []TxnOp) error {
+	if len(ops) > maxConsulTxnOps {
+		return ErrTxnUnsupported
+	}
+
+	txnOps := []consulTxnOp{}
+	for _, op := range ops {
+		kvOp := consulTxnKVOp{Verb: "set", Key: processKey(op.Key), Value: op.Value}
+		switch {
+		case op.Delete && op.Check:
+			kvOp.Verb, kvOp.Value, kvOp.Index = "delete-cas", nil, op.Version
+		case op.Delete:
+			kvOp.Verb, kvOp.Value = "delete", nil
+		case op.Check:
+			kvOp.Verb, kvOp.Index = "cas", op.Version
+		}
+		txnOps = append(txnOps, consulTxnOp{KV: kvOp})
+	}
+
+	_, err := d.Client.Raw().Write("/v1/txn", txnOps, nil, nil)
+	if err != nil {
+		// the transaction is rolled back with a 409 if a check failed
+		switch {
+		case strings.HasPrefix(err.Error(), "Unexpected response code: 409"):
+			return ErrStateConflict
+		case strings.HasPrefix(err.Error(), "Unexpected response code: 404"),
+			strings.HasPrefix(err.Error(), "Unexpected response code: 405"):
+			return ErrTxnUnsupported
+		}
+	}
+
+	return err
+}
+
 // ReadAll state from baseKey.
 func (d *ConsulStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 	baseKey = processKey(baseKey)
@@ -137,118 +273,181 @@ func (d *ConsulStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 	return values, nil
 }
 
-func (d *ConsulStateDriver) channelConsulEvents(baseKey string, kvCache map[string]*api.KVPair,
-	consulRsps chan api.KVPairs, rsps chan [2][]byte, retErr chan error, stop chan bool) {
-	for {
-		select {
-		// block on change notifications
-		case kvs := <-consulRsps:
-			kvsRcvd := map[string]*api.KVPair{}
-			// Generate Create/Modifiy events for the keys recvd
-			for _, kv := range kvs {
-				// XXX: The logic below assumes that the node returned is always a node
-				// of interest. Eg: If we set a watch on /a/b/c, then we are mostly
-				// interested in changes in that directory i.e. changes to /a/b/c/d1..d2
-				// This works for now as the constructs like network and endpoints that
-				// need to be watched are organized as above. Need to revisit when
-				// this assumption changes.
-				kvsRcvd[kv.Key] = kv
-				rsp := [2][]byte{nil, nil}
-				rsp[0] = kv.Value
-				if kvSeen, ok := kvCache[kv.Key]; !ok {
-					log.Debugf("Received create for key: %q, kv: %+v", kv.Key, kv)
-				} else if kvSeen.ModifyIndex != kv.ModifyIndex {
-					log.Debugf("Received modify for key: %q, kv: %+v", kv.Key, kv)
-					rsp[1] = kvSeen.Value
-				} else {
-					// no changes to the key, skipping
-					log.Debugf("Skipping key with no changes: %s", kv.Key)
-					continue
-				}
-				//update the map of seen keys
-				kvCache[kv.Key] = kv
+// ReadAllKeys reads the values under baseKey by key. The keys have the
+// leading slash consul drops.
+func (d *ConsulStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	kvs, _, err := d.Client.KV().List(processKey(baseKey), nil)
+	if err != nil {
+		return nil, err
+	}
+	if kvs == nil {
+		return nil, core.Errorf("Key not found")
+	}
 
-				//channel the translated response
-				rsps <- rsp
-			}
+	values := make(map[string][]byte)
+	for _, kv := range kvs {
+		values["/"+kv.Key] = kv.Value
+	}
 
-			// Generate Delete events for missing keys
-			for key, kv := range kvCache {
-				if _, ok := kvsRcvd[key]; !ok {
-					log.Infof("Received delete for key: %q, Pair: %+v", kv.Key, kv)
-					rsps <- [2][]byte{nil, kv.Value}
-					// remove this key from the map of seen keys
-					delete(kvCache, key)
-				}
-			}
+	return values, nil
+}
+
+// consulEvents channels the create, modify and delete events of the keys
+// returned by a blocking query, as compared to the keys seen before. kvCache
+// is updated with the keys returned.
+func consulEvents(kvCache map[string]*api.KVPair, kvs api.KVPairs, rsps chan [2][]byte) {
+	kvsRcvd := map[string]*api.KVPair{}
+	// Generate Create/Modifiy events for the keys recvd
+	for _, kv := range kvs {
+		// XXX: The logic below assumes that the node returned is always a node
+		// of interest. Eg: If we set a watch on /a/b/c, then we are mostly
+		// interested in changes in that directory i.e. changes to /a/b/c/d1..d2
+		// This works for now as the constructs like network and endpoints that
+		// need to be watched are organized as above. Need to revisit when
+		// this assumption changes.
+		kvsRcvd[kv.Key] = kv
+		rsp := [2][]byte{nil, nil}
+		rsp[0] = kv.Value
+		if kvSeen, ok := kvCache[kv.Key]; !ok {
+			log.Debugf("Received create for key: %q, kv: %+v", kv.Key, kv)
+		} else if kvSeen.ModifyIndex != kv.ModifyIndex {
+			log.Debugf("Received modify for key: %q, kv: %+v", kv.Key, kv)
+			rsp[1] = kvSeen.Value
+		} else {
+			// no changes to the key, skipping
+			continue
+		}
+		//update the map of seen keys
+		kvCache[kv.Key] = kv
+
+		//channel the translated response
+		rsps <- rsp
+	}
+
+	// Generate Delete events for missing keys
+	for key, kv := range kvCache {
+		if _, ok := kvsRcvd[key]; !ok {
+			log.Infof("Received delete for key: %q, Pair: %+v", kv.Key, kv)
+			rsps <- [2][]byte{nil, kv.Value}
+			// remove this key from the map of seen keys
+			delete(kvCache, key)
+		}
+	}
+}
+
+// filterKVPairs returns the pairs of kvs whose keys, with the leading slash
+// consul drops, match match. A nil match matches all keys.
+func filterKVPairs(kvs api.KVPairs, match func(string) bool) api.KVPairs {
+	if match == nil {
+		return kvs
+	}
+
+	filtered := api.KVPairs{}
+	for _, kv := range kvs {
+		if match("/" + kv.Key) {
+			filtered = append(filtered, kv)
+		}
+	}
+
+	return filtered
+}
+
+// watchConsulKeys runs blocking queries on the keys under baseKey from
+// waitIndex on, and channels the changes of the keys matching match. Errors
+// are retried, as the etcd watch does.
+func (d *ConsulStateDriver) watchConsulKeys(baseKey string, match func(string) bool, waitIndex uint64,
+	kvCache map[string]*api.KVPair, rsps chan [2][]byte) {
+	for {
+		kvs, qm, err := d.Client.KV().List(baseKey,
+			&api.QueryOptions{WaitIndex: waitIndex, WaitTime: consulWatchWait})
+		if err != nil {
+			log.Errorf("Consul watch: error %v for %s. Retrying..", err, baseKey)
+			time.Sleep(time.Second)
+			continue
+		}
 
-		case <-stop:
-			log.Infof("Stop request received")
-			return
+		// Consul returns success and a nil kv when a key is not found.
+		// This shall translate into appropriate 'Delete' events or
+		// no events (depending on whether some keys were seen before)
+		consulEvents(kvCache, filterKVPairs(kvs, match), rsps)
+
+		// The index goes backwards when the consul state is reset, eg. after
+		// a snapshot restore. Start over from the current state then.
+		if qm.LastIndex < waitIndex {
+			log.Warnf("Consul watch: index of %s went back to %d, resetting", baseKey, qm.LastIndex)
+			waitIndex = 0
+			continue
 		}
+		waitIndex = qm.LastIndex
 	}
 }
 
-// WatchAll state transitions from baseKey
+// WatchAll state transitions from baseKey. Like the etcd watch, it returns
+// once the watch is set up, and the changes are channeled in the background.
 func (d *ConsulStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return d.WatchAllFiltered(baseKey, nil, rsps)
+}
+
+// WatchAllFiltered watches the state transitions of the keys under baseKey
+// matching match, like WatchAll
+func (d *ConsulStateDriver) WatchAllFiltered(baseKey string, match func(string) bool, rsps chan [2][]byte) error {
 	baseKey = processKey(baseKey)
-	consulRsps := make(chan api.KVPairs, 1)
-	stop := make(chan bool, 1)
-	recvErr := make(chan error, 2)
 
 	// Consul returns all the keys as return value of List(). The following maps helps
 	// track the state that has been seen and used to appropriately generate
 	// create, modify and delete events
 	kvCache := map[string]*api.KVPair{}
-	// read with index=0 to fetch all existing keys
-	var waitIndex uint64
-	kvs, qm, err := d.Client.KV().List(baseKey, &api.QueryOptions{WaitIndex: waitIndex})
+	kvs, qm, err := d.Client.KV().List(baseKey, nil)
 	if err != nil {
 		log.Errorf("consul read failed for key %q. Error: %s", baseKey, err)
 		return err
 	}
-	// Consul returns success and a nil kv when a key is not found.
-	// Treat this as starting with no state.
-	// XXX: shall we fail the watch in this case?
-	if kvs == nil {
-		kvs = api.KVPairs{}
-	}
-	for _, kv := range kvs {
+	for _, kv := range filterKVPairs(kvs, match) {
 		kvCache[kv.Key] = kv
 	}
-	waitIndex = qm.LastIndex
 
-	go d.channelConsulEvents(baseKey, kvCache, consulRsps, rsps, recvErr, stop)
+	go d.watchConsulKeys(baseKey, match, qm.LastIndex, kvCache, rsps)
 
-	for {
-		select {
-		case err := <-recvErr:
-			return err
-		default:
-			kvs, qm, err := d.Client.KV().List(baseKey, &api.QueryOptions{WaitIndex: waitIndex})
-			if err != nil {
-				if api.IsServerError(err) || strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "connection refused") {
-					log.Warnf("Consul watch: server error: %v for %s. Retrying..", err, baseKey)
-					time.Sleep(5 * time.Second)
-					continue
-				} else {
-					log.Errorf("consul watch failed for key %q. Error: %s. stopping watch..", baseKey, err)
-					stop <- true
-					return err
-				}
-			}
-			// Consul returns success and a nil kv when a key is not found.
-			// This shall translate into appropriate 'Delete' events or
-			// no events (depending on whether some keys were seen before)
-			// XXX: shall we stop the watch in this case?
-			if kvs == nil {
-				kvs = api.KVPairs{}
-			}
+	return nil
+}
+
+// ListChildren lists the names of the keys and directories right below
+// baseKey, without reading the values under them
+func (d *ConsulStateDriver) ListChildren(baseKey string) ([]string, error) {
+	prefix := processKey(baseKey)
+	keys, _, err := d.Client.KV().Keys(prefix, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, key := range keys {
+		if name := strings.TrimSuffix(strings.TrimPrefix(key, prefix), "/"); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
 
-			waitIndex = qm.LastIndex
-			consulRsps <- kvs
+// KeyPrefixExists returns true if there are keys under a base key. Only the
+// keys right below it are listed, without their values.
+func (d *ConsulStateDriver) KeyPrefixExists(baseKey string) (bool, error) {
+	prefix := processKey(baseKey)
+	keys, _, err := d.Client.KV().Keys(prefix, "/", nil)
+	if err != nil {
+		return false, err
+	}
+
+	for _, key := range keys {
+		// skip the folder key of the base key
+		if key != prefix {
+			return true, nil
 		}
 	}
+
+	return false, nil
 }
 
 // ClearState removes key from etcd.
@@ -258,6 +457,12 @@ func (d *ConsulStateDriver) ClearState(key string) error {
 	return err
 }
 
+// ClearAll removes the keys under baseKey
+func (d *ConsulStateDriver) ClearAll(baseKey string) error {
+	_, err := d.Client.KV().DeleteTree(processKey(baseKey), nil)
+	return err
+}
+
 // ReadState reads key into a core.State with the unmarshaling function.
 func (d *ConsulStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
@@ -267,7 +472,7 @@ func (d *ConsulStateDriver) ReadState(key string, value core.State,
 		return err
 	}
 
-	return unmarshal(encodedState, value)
+	return unmarshalState(key, encodedState, value, unmarshal)
 }
 
 // ReadAllState Reads all the state from baseKey and returns a list of core.State.
@@ -280,27 +485,29 @@ func (d *ConsulStateDriver) ReadAllState(baseKey string, sType core.State,
 // WatchAllState watches all state from the baseKey.
 func (d *ConsulStateDriver) WatchAllState(baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
-	baseKey = processKey(baseKey)
 	byteRsps := make(chan [2][]byte, 1)
 	recvErr := make(chan error, 1)
 
-	go channelStateEvents(d, sType, unmarshal, byteRsps, rsps, recvErr)
-
 	err := d.WatchAll(baseKey, byteRsps)
 	if err != nil {
+		log.Errorf("WatchAll returned %v", err)
 		return err
 	}
 
-	err = <-recvErr
-	return err
+	for {
+		go channelStateEvents(d, baseKey, sType, unmarshal, byteRsps, rsps, recvErr)
 
+		err = <-recvErr
+		log.Errorf("Err from channelStateEvents %v", err)
+		time.Sleep(time.Second)
+	}
 }
 
 // WriteState writes a value of core.State into a key with a given marshaling function.
 func (d *ConsulStateDriver) WriteState(key string, value core.State,
 	marshal func(interface{}) ([]byte, error)) error {
 	key = processKey(key)
-	encodedState, err := marshal(value)
+	encodedState, err := marshalState(key, value, marshal)
 	if err != nil {
 		return err
 	}
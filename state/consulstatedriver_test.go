@@ -16,9 +16,11 @@ limitations under the License.
 package state
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/contiv/netplugin/core"
+	"github.com/hashicorp/consul/api"
 )
 
 func setupConsulDriver(t *testing.T) *ConsulStateDriver {
@@ -54,6 +56,11 @@ func TestConsulStateDriverRead(t *testing.T) {
 	commonTestStateDriverRead(t, driver)
 }
 
+func TestConsulStateDriverKeyPrefixExists(t *testing.T) {
+	driver := setupConsulDriver(t)
+	commonTestStateDriverKeyPrefixExists(t, driver)
+}
+
 func TestConsulStateDriverWriteState(t *testing.T) {
 	driver := setupConsulDriver(t)
 	commonTestStateDriverWriteState(t, driver)
@@ -98,3 +105,38 @@ func TestConsulStateDriverWatchAllStateDelete(t *testing.T) {
 	driver := setupConsulDriver(t)
 	commonTestStateDriverWatchAllStateDelete(t, driver)
 }
+
+func TestConsulEvents(t *testing.T) {
+	kvCache := map[string]*api.KVPair{
+		"a/1": {Key: "a/1", Value: []byte("1"), ModifyIndex: 1},
+		"a/2": {Key: "a/2", Value: []byte("2"), ModifyIndex: 2},
+	}
+	kvs := api.KVPairs{
+		{Key: "a/1", Value: []byte("1"), ModifyIndex: 1},
+		{Key: "a/2", Value: []byte("2b"), ModifyIndex: 4},
+		{Key: "a/3", Value: []byte("3"), ModifyIndex: 3},
+	}
+
+	rsps := make(chan [2][]byte, 10)
+	consulEvents(kvCache, kvs, rsps)
+	expRsps := [][2][]byte{
+		{[]byte("2b"), []byte("2")},
+		{[]byte("3"), nil},
+	}
+	for _, expRsp := range expRsps {
+		if rsp := <-rsps; !reflect.DeepEqual(rsp, expRsp) {
+			t.Fatalf("Expected event %q, got %q", expRsp, rsp)
+		}
+	}
+
+	// a nil result deletes the keys seen
+	consulEvents(kvCache, nil, rsps)
+	if len(rsps) != 3 || len(kvCache) != 0 {
+		t.Fatalf("Expected 3 delete events, got %d, keys left %v", len(rsps), kvCache)
+	}
+	for len(rsps) > 0 {
+		if rsp := <-rsps; rsp[0] != nil || rsp[1] == nil {
+			t.Fatalf("Expected a delete event, got %q", rsp)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// NewTLSConfig returns the TLS config of a state store connection, with the
+// client certificate and the CA bundle of sec
+func NewTLSConfig(sec core.DbSecurity) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if sec.CertFile != "" || sec.KeyFile != "" {
+		if sec.CertFile == "" || sec.KeyFile == "" {
+			return nil, errors.New("state store client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(sec.CertFile, sec.KeyFile)
+		if err != nil {
+			return nil, core.Errorf("Error loading state store client certificate. Err: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if sec.CAFile != "" {
+		pem, err := ioutil.ReadFile(sec.CAFile)
+		if err != nil {
+			return nil, core.Errorf("Error reading state store CA bundle. Err: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, core.Errorf("No certificates in state store CA bundle %s", sec.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// NewTLSTransport returns an http transport to the state store using TLS,
+// with the timeouts of the etcd client default transport
+func NewTLSTransport(sec core.DbSecurity) (*http.Transport, error) {
+	tlsConfig, err := NewTLSConfig(sec)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:     tlsConfig,
+	}, nil
+}
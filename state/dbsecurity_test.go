@@ -0,0 +1,55 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestNewTLSConfig(t *testing.T) {
+	if _, err := NewTLSConfig(core.DbSecurity{KeyFile: "key.pem"}); err == nil {
+		t.Fatalf("Key without a certificate was accepted")
+	}
+	if _, err := NewTLSConfig(core.DbSecurity{CAFile: "/nonexistent/ca.pem"}); err == nil {
+		t.Fatalf("Missing CA bundle was accepted")
+	}
+
+	caFile, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatalf("Error creating CA bundle. Err: %v", err)
+	}
+	defer os.Remove(caFile.Name())
+	caFile.WriteString("not a certificate")
+	caFile.Close()
+	if _, err := NewTLSConfig(core.DbSecurity{CAFile: caFile.Name()}); err == nil {
+		t.Fatalf("CA bundle without certificates was accepted")
+	}
+
+	// credentials alone do not use TLS
+	sec := core.DbSecurity{Username: "netplugin", Password: "secret"}
+	if sec.UseTLS() {
+		t.Fatalf("TLS used without a certificate or CA bundle")
+	}
+	instInfo := core.InstanceInfo{DbURL: "consul://127.0.0.1:8500", DbSecurity: sec}
+	driver := &ConsulStateDriver{}
+	if err := driver.Init(&instInfo); err != nil {
+		t.Fatalf("driver init failed. Error: %s", err)
+	}
+}
@@ -0,0 +1,374 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// encryptedMarker is the field of the JSON objects replacing the encrypted
+// values of sensitive fields. Its value is the envelope version.
+const encryptedMarker = "contivEncrypted"
+
+// maxDataKeys is the number of unwrapped data keys kept
+const maxDataKeys = 64
+
+// KeyWrapper encrypts the data keys of the state encryption with a key
+// encryption key, eg. a local key or a KMS
+type KeyWrapper interface {
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// encryptedValue is the envelope of an encrypted field value. Key is the
+// wrapped data key, Data the nonce and the sealed JSON value.
+type encryptedValue struct {
+	Version int    `json:"contivEncrypted"`
+	Key     []byte `json:"key"`
+	Data    []byte `json:"data"`
+}
+
+// stateEncryption is the envelope encryption of the sensitive fields
+type stateEncryption struct {
+	wrapper    KeyWrapper
+	dataKey    []byte
+	wrappedKey []byte
+
+	mutex    sync.Mutex
+	dataKeys map[string][]byte // unwrapped data keys by wrapped key
+}
+
+var (
+	encryptionMutex sync.RWMutex
+	encryption      *stateEncryption
+)
+
+// SetStateEncryption encrypts the values of the sensitive fields of the
+// states written, see MarkSensitiveField, with data keys wrapped by w. The
+// states read are decrypted with it. A nil w stops encrypting, and encrypted
+// states can not be read.
+//
+// The sensitive fields of the contiv state are the docknet driver and IPAM
+// options and annotations marked sensitive, in the docknet oper states and
+// the network config states. The BGP and service configs are objdb objects,
+// which are not encrypted; their models hold no credentials.
+func SetStateEncryption(w KeyWrapper) error {
+	var enc *stateEncryption
+	if w != nil {
+		dataKey := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+			return err
+		}
+		wrappedKey, err := w.WrapKey(dataKey)
+		if err != nil {
+			return err
+		}
+		enc = &stateEncryption{
+			wrapper:    w,
+			dataKey:    dataKey,
+			wrappedKey: wrappedKey,
+			dataKeys:   map[string][]byte{string(wrappedKey): dataKey},
+		}
+	}
+
+	encryptionMutex.Lock()
+	defer encryptionMutex.Unlock()
+	encryption = enc
+
+	return nil
+}
+
+// getEncryption returns the state encryption, nil if it is not set
+func getEncryption() *stateEncryption {
+	encryptionMutex.RLock()
+	defer encryptionMutex.RUnlock()
+	return encryption
+}
+
+// unwrapKey returns the data key of a wrapped key
+func (e *stateEncryption) unwrapKey(wrapped []byte) ([]byte, error) {
+	e.mutex.Lock()
+	dataKey, ok := e.dataKeys[string(wrapped)]
+	e.mutex.Unlock()
+	if ok {
+		return dataKey, nil
+	}
+
+	dataKey, err := e.wrapper.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if len(e.dataKeys) >= maxDataKeys {
+		e.dataKeys = map[string][]byte{string(e.wrappedKey): e.dataKey}
+	}
+	e.dataKeys[string(wrapped)] = dataKey
+
+	return dataKey, nil
+}
+
+// newGCM returns the AES-GCM cipher of a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with AES-GCM, prefixing the nonce
+func seal(key, plain, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plain, data), nil
+}
+
+// open decrypts what seal encrypted
+func open(key, sealed, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, core.Errorf("encrypted value is too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], data)
+}
+
+// encryptFields replaces the values of the sensitive fields of a decoded JSON
+// value with their envelopes. The field name is authenticated with the value.
+func (e *stateEncryption) encryptFields(value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if !sensitiveField(key) {
+				if err := e.encryptFields(field); err != nil {
+					return err
+				}
+				continue
+			}
+			if field == nil || field == "" {
+				continue
+			}
+
+			plain, err := json.Marshal(field)
+			if err != nil {
+				return err
+			}
+			sealed, err := seal(e.dataKey, plain, []byte(key))
+			if err != nil {
+				return err
+			}
+			v[key] = encryptedValue{Version: 1, Key: e.wrappedKey, Data: sealed}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := e.encryptFields(elem); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decryptFields replaces the envelopes in a decoded JSON value with the
+// values they encrypt
+func (e *stateEncryption) decryptFields(value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			obj, ok := field.(map[string]interface{})
+			if !ok || obj[encryptedMarker] == nil {
+				if err := e.decryptFields(field); err != nil {
+					return err
+				}
+				continue
+			}
+
+			encoded, _ := json.Marshal(obj)
+			envelope := encryptedValue{}
+			if err := json.Unmarshal(encoded, &envelope); err != nil {
+				return err
+			}
+			if envelope.Version != 1 {
+				return core.Errorf("unsupported encrypted value version %d", envelope.Version)
+			}
+			dataKey, err := e.unwrapKey(envelope.Key)
+			if err != nil {
+				return err
+			}
+			plain, err := open(dataKey, envelope.Data, []byte(key))
+			if err != nil {
+				return core.Errorf("Error decrypting %s. Err: %v", key, err)
+			}
+			if v[key], err = decodeJSON(plain); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := e.decryptFields(elem); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeJSON decodes a JSON value with the numbers as json.Number
+func decodeJSON(encoded []byte) (interface{}, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// encryptState encrypts the sensitive fields of an encoded JSON state if the
+// state encryption is set. Other values are returned as is.
+func encryptState(encodedState []byte) ([]byte, error) {
+	e := getEncryption()
+	if e == nil {
+		return encodedState, nil
+	}
+
+	obj, err := decodeJSON(encodedState)
+	if _, ok := obj.(map[string]interface{}); err != nil || !ok {
+		return encodedState, nil
+	}
+	if err := e.encryptFields(obj); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(obj)
+}
+
+// decryptState decrypts the sensitive fields of an encoded JSON state. States
+// without encrypted fields are returned as is.
+func decryptState(encodedState []byte) ([]byte, error) {
+	if !bytes.Contains(encodedState, []byte(`"`+encryptedMarker+`"`)) {
+		return encodedState, nil
+	}
+
+	obj, err := decodeJSON(encodedState)
+	if err != nil {
+		return encodedState, nil
+	}
+	e := getEncryption()
+	if e == nil {
+		return nil, core.Errorf("state is encrypted and no state encryption key is set")
+	}
+	if err := e.decryptFields(obj); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(obj)
+}
+
+// fileKeyWrapper wraps data keys with an AES-256 key
+type fileKeyWrapper struct {
+	key []byte
+}
+
+// NewFileKeyWrapper returns a key wrapper using the AES-256 key in a file,
+// either 32 raw bytes or their base64 encoding
+func NewFileKeyWrapper(path string) (KeyWrapper, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := content
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content))); err == nil {
+		key = decoded
+	}
+	if len(key) != 32 {
+		return nil, core.Errorf("state encryption key in %s is not 32 bytes", path)
+	}
+
+	return &fileKeyWrapper{key: key}, nil
+}
+
+func (w *fileKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(w.key, dataKey, nil)
+}
+
+func (w *fileKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(w.key, wrapped, nil)
+}
+
+// pluginKeyWrapper wraps data keys with a KMS plugin
+type pluginKeyWrapper struct {
+	path string
+}
+
+// NewPluginKeyWrapper returns a key wrapper running a KMS plugin. The plugin
+// is run with "wrap" or "unwrap" as argument, reads the base64 encoded key on
+// stdin and writes the base64 encoded result on stdout.
+func NewPluginKeyWrapper(path string) KeyWrapper {
+	return &pluginKeyWrapper{path: path}
+}
+
+// run runs the plugin with a base64 encoded key
+func (w *pluginKeyWrapper) run(op string, key []byte) ([]byte, error) {
+	cmd := exec.Command(w.path, op)
+	cmd.Stdin = strings.NewReader(base64.StdEncoding.EncodeToString(key))
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, core.Errorf("KMS plugin %s %s failed. Err: %v %s", w.path, op, err, stderr.String())
+	}
+
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+}
+
+func (w *pluginKeyWrapper) WrapKey(dataKey []byte) ([]byte, error) {
+	return w.run("wrap", dataKey)
+}
+
+func (w *pluginKeyWrapper) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return w.run("unwrap", wrapped)
+}
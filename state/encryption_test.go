@@ -0,0 +1,102 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+type secretTestState struct {
+	core.CommonState
+	Name     string            `json:"name"`
+	Password string            `json:"password"`
+	Options  map[string]string `json:"options"`
+}
+
+func (s *secretTestState) Write() error                   { return nil }
+func (s *secretTestState) Read(id string) error           { return nil }
+func (s *secretTestState) ReadAll() ([]core.State, error) { return nil, nil }
+func (s *secretTestState) Clear() error                   { return nil }
+
+func TestStateEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatalf("Error creating temp dir. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	defer SetStateEncryption(nil)
+
+	keyFile := filepath.Join(dir, "key")
+	ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0600)
+	wrapper, err := NewFileKeyWrapper(keyFile)
+	if err != nil {
+		t.Fatalf("Error reading key file. Err: %v", err)
+	}
+	plugin := filepath.Join(dir, "kms")
+	ioutil.WriteFile(plugin, []byte("#!/bin/sh\ncat\n"), 0700)
+
+	MarkSensitiveField("md5-key")
+	for _, w := range []KeyWrapper{wrapper, NewPluginKeyWrapper(plugin)} {
+		if err := SetStateEncryption(w); err != nil {
+			t.Fatalf("Error setting state encryption. Err: %v", err)
+		}
+
+		d := &FakeStateDriver{}
+		d.Init(&core.InstanceInfo{})
+		key := "/contiv.io/state/secrets/s1"
+		written := &secretTestState{Name: "s1", Password: "p4ss", Options: map[string]string{"md5-key": "k3y", "mtu": "1500"}}
+		written.ID = "s1"
+		if err := d.WriteState(key, written, json.Marshal); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+
+		raw, _ := d.Read(key)
+		if bytes.Contains(raw, []byte("p4ss")) || bytes.Contains(raw, []byte("k3y")) || !bytes.Contains(raw, []byte("1500")) {
+			t.Fatalf("Sensitive fields were not encrypted: %s", raw)
+		}
+
+		read := &secretTestState{}
+		if err := d.ReadState(key, read, json.Unmarshal); err != nil {
+			t.Fatalf("Error reading state. Err: %v", err)
+		}
+		if read.Password != "p4ss" || read.Options["md5-key"] != "k3y" || read.Name != "s1" {
+			t.Fatalf("Unexpected state read %+v", read)
+		}
+
+		// a value moved to another field does not decrypt
+		moved := bytes.Replace(raw, []byte(`"password"`), []byte(`"secret"`), 1)
+		d.Write(key, moved)
+		if err := d.ReadState(key, read, json.Unmarshal); err == nil {
+			t.Fatalf("Read a value moved to another field")
+		}
+	}
+
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.WriteState("/contiv.io/state/secrets/s1", &secretTestState{Password: "p4ss"}, json.Marshal)
+	SetStateEncryption(nil)
+	if err := d.ReadState("/contiv.io/state/secrets/s1", &secretTestState{}, json.Unmarshal); err == nil {
+		t.Fatalf("Read an encrypted state without the key")
+	}
+}
@@ -17,7 +17,9 @@ package state
 
 import (
 	"errors"
+	"path"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -60,6 +62,15 @@ func (d *EtcdStateDriver) Init(instInfo *core.InstanceInfo) error {
 	etcdURL := strings.Replace(instInfo.DbURL, "etcd://", "http://", 1)
 	etcdConfig := client.Config{
 		Endpoints: []string{etcdURL},
+		Username:  instInfo.DbSecurity.Username,
+		Password:  instInfo.DbSecurity.Password,
+	}
+	if instInfo.DbSecurity.UseTLS() {
+		etcdConfig.Endpoints = []string{strings.Replace(instInfo.DbURL, "etcd://", "https://", 1)}
+		etcdConfig.Transport, err = NewTLSTransport(instInfo.DbSecurity)
+		if err != nil {
+			return err
+		}
 	}
 
 	d.Client, err = client.New(etcdConfig)
@@ -144,55 +155,270 @@ func (d *EtcdStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 	return values, nil
 }
 
-func (d *EtcdStateDriver) channelEtcdEvents(watcher client.Watcher, rsps chan [2][]byte) {
+// etcdWatchRetry is the delay before a failed watch is resumed
+var etcdWatchRetry = time.Second
+
+// getEtcdKeys reads the values under baseKey by key, including the keys of
+// nested directories, and the etcd index they were read at
+func (d *EtcdStateDriver) getEtcdKeys(baseKey string) (map[string][]byte, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	resp, err := d.KeysAPI.Get(ctx, baseKey, &client.GetOptions{Recursive: true, Quorum: true})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	values := make(map[string][]byte)
+	nodes := client.Nodes{resp.Node}
+	for len(nodes) > 0 {
+		node := nodes[0]
+		nodes = append(nodes[1:], node.Nodes...)
+		if !node.Dir {
+			values[node.Key] = []byte(node.Value)
+		}
+	}
+
+	return values, resp.Index, nil
+}
+
+// ReadAllKeys reads the values under baseKey by key, including the keys of
+// nested directories.
+func (d *EtcdStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	values, _, err := d.getEtcdKeys(baseKey)
+	return values, err
+}
+
+// syncEtcdKeys reads the values under baseKey and the index to watch after.
+// A missing baseKey has no values.
+func (d *EtcdStateDriver) syncEtcdKeys(baseKey string) (map[string][]byte, uint64, error) {
+	values, index, err := d.getEtcdKeys(baseKey)
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeKeyNotFound {
+		return make(map[string][]byte), etcdErr.Index, nil
+	}
+
+	return values, index, err
+}
+
+// channelEtcdEvents sends the events of an etcd watch response for the keys
+// matching match, and applies them to kvCache. A nil match matches all keys.
+func channelEtcdEvents(etcdRsp *client.Response, match func(string) bool, kvCache map[string][]byte,
+	rsps chan [2][]byte) {
+	// a deleted directory deletes the keys under it
+	if etcdRsp.Node.Dir && etcdRsp.Node.Value == "" && etcdRsp.Action != "set" && etcdRsp.Action != "create" {
+		for key, prev := range kvCache {
+			if strings.HasPrefix(key, etcdRsp.Node.Key+"/") {
+				delete(kvCache, key)
+				log.Debugf("Received \"delete\" for key: %s", key)
+				rsps <- [2][]byte{nil, prev}
+			}
+		}
+		return
+	}
+	if match != nil && !match(etcdRsp.Node.Key) {
+		return
+	}
+
+	// XXX: The logic below assumes that the node returned is always a node
+	// of interest. Eg: If we set a watch on /a/b/c, then we are mostly
+	// interested in changes in that directory i.e. changes to /a/b/c/d1..d2
+	// This works for now as the constructs like network and endpoints that
+	// need to be watched are organized as above. Need to revisit when
+	// this assumption changes.
+	rsp := [2][]byte{nil, nil}
+	eventStr := "create"
+	if etcdRsp.Node.Value != "" {
+		rsp[0] = []byte(etcdRsp.Node.Value)
+		kvCache[etcdRsp.Node.Key] = rsp[0]
+	} else {
+		delete(kvCache, etcdRsp.Node.Key)
+	}
+	if etcdRsp.PrevNode != nil && etcdRsp.PrevNode.Value != "" {
+		rsp[1] = []byte(etcdRsp.PrevNode.Value)
+		if etcdRsp.Node.Value != "" {
+			eventStr = "modify"
+		} else {
+			eventStr = "delete"
+		}
+	}
+
+	log.Debugf("Received %q for key: %s", eventStr, etcdRsp.Node.Key)
+	//channel the translated response
+	rsps <- rsp
+}
+
+// watchEtcdKeys watches the keys under baseKey matching match from the index
+// after. A failed watch resumes after the last event seen. If etcd cleared
+// the events since, they were missed, and the values under baseKey are read
+// again and their changes sent.
+func (d *EtcdStateDriver) watchEtcdKeys(baseKey string, match func(string) bool, after uint64,
+	kvCache map[string][]byte, rsps chan [2][]byte) {
+	watcher := d.KeysAPI.Watcher(baseKey, &client.WatcherOptions{AfterIndex: after, Recursive: true})
 	for {
 		// block on change notifications
 		etcdRsp, err := watcher.Next(context.Background())
-		if err != nil {
-			log.Errorf("Error %v during watch", err)
-			time.Sleep(time.Second)
+		if err == nil {
+			after = etcdRsp.Node.ModifiedIndex
+			channelEtcdEvents(etcdRsp, match, kvCache, rsps)
 			continue
 		}
 
-		// XXX: The logic below assumes that the node returned is always a node
-		// of interest. Eg: If we set a watch on /a/b/c, then we are mostly
-		// interested in changes in that directory i.e. changes to /a/b/c/d1..d2
-		// This works for now as the constructs like network and endpoints that
-		// need to be watched are organized as above. Need to revisit when
-		// this assumption changes.
-		rsp := [2][]byte{nil, nil}
-		eventStr := "create"
-		if etcdRsp.Node.Value != "" {
-			rsp[0] = []byte(etcdRsp.Node.Value)
-		}
-		if etcdRsp.PrevNode != nil && etcdRsp.PrevNode.Value != "" {
-			rsp[1] = []byte(etcdRsp.PrevNode.Value)
-			if etcdRsp.Node.Value != "" {
-				eventStr = "modify"
-			} else {
-				eventStr = "delete"
+		if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeEventIndexCleared {
+			log.Warnf("Missed events of %s after index %d, resyncing", baseKey, after)
+			kvs, index, err := d.syncEtcdKeys(baseKey)
+			if err != nil {
+				log.Errorf("Error %v during watch resync", err)
+				time.Sleep(etcdWatchRetry)
+				continue
+			}
+			for _, rsp := range kvEvents(kvCache, filterKeys(kvs, match)) {
+				rsps <- rsp
 			}
+			after = index
+		} else {
+			log.Errorf("Error %v during watch", err)
+			time.Sleep(etcdWatchRetry)
 		}
 
-		log.Debugf("Received %q for key: %s", eventStr, etcdRsp.Node.Key)
-		//channel the translated response
-		rsps <- rsp
+		watcher = d.KeysAPI.Watcher(baseKey, &client.WatcherOptions{AfterIndex: after, Recursive: true})
 	}
 }
 
-// WatchAll state transitions from baseKey
+// WatchAll state transitions from baseKey. The watch is resumed when it
+// fails, and resynced when events were missed.
 func (d *EtcdStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
-	watcher := d.KeysAPI.Watcher(baseKey, &client.WatcherOptions{Recursive: true})
-	if watcher == nil {
-		log.Errorf("etcd watch failed.")
-		return errors.New("Etcd watch failed")
+	return d.WatchAllFiltered(baseKey, nil, rsps)
+}
+
+// WatchAllFiltered watches the state transitions of the keys under baseKey
+// matching match, like WatchAll. The watch starts at the current etcd index,
+// and the values under baseKey are only read to resync it. The changes missed
+// are sent against the values the watch has seen, so a value it has not seen
+// is sent as created.
+func (d *EtcdStateDriver) WatchAllFiltered(baseKey string, match func(string) bool, rsps chan [2][]byte) error {
+	index, err := d.getEtcdIndex(baseKey)
+	if err != nil {
+		// watch from now
+		log.Warnf("Error reading the etcd index to watch %s. Err: %v", baseKey, err)
+		index = 0
 	}
 
-	go d.channelEtcdEvents(watcher, rsps)
+	go d.watchEtcdKeys(baseKey, match, index, make(map[string][]byte), rsps)
 
 	return nil
 }
 
+// getEtcdIndex returns the current etcd index, reading baseKey without
+// recursing into it. A missing baseKey still has the index.
+func (d *EtcdStateDriver) getEtcdIndex(baseKey string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	resp, err := d.KeysAPI.Get(ctx, baseKey, &client.GetOptions{Quorum: true})
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeKeyNotFound {
+		return etcdErr.Index, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return resp.Index, nil
+}
+
+// ListChildren lists the names of the keys and directories right below
+// baseKey, without reading the values under them
+func (d *EtcdStateDriver) ListChildren(baseKey string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	resp, err := d.KeysAPI.Get(ctx, strings.TrimSuffix(baseKey, "/"), &client.GetOptions{Quorum: true})
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, node := range resp.Node.Nodes {
+		names = append(names, path.Base(node.Key))
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// KeyPrefixExists returns true if there are keys under a base key. The base
+// key is read without recursion, then its subdirectories until a key is
+// found, as etcd keeps the directories of deleted keys.
+func (d *EtcdStateDriver) KeyPrefixExists(baseKey string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	resp, err := d.KeysAPI.Get(ctx, strings.TrimSuffix(baseKey, "/"), &client.GetOptions{Quorum: true})
+	if etcdErr, ok := err.(client.Error); ok && etcdErr.Code == client.ErrorCodeKeyNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !resp.Node.Dir {
+		return true, nil
+	}
+
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			return true, nil
+		}
+	}
+	for _, node := range resp.Node.Nodes {
+		if exists, err := d.KeyPrefixExists(node.Key); err != nil || exists {
+			return exists, err
+		}
+	}
+
+	return false, nil
+}
+
+// WriteTTL writes a key that etcd removes after ttl, unless written again
+func (d *EtcdStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	_, err := d.KeysAPI.Set(ctx, key, string(value[:]), &client.SetOptions{TTL: ttl})
+	return err
+}
+
+// ReadVersion reads a key and its modified index
+func (d *EtcdStateDriver) ReadVersion(key string) ([]byte, uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	resp, err := d.KeysAPI.Get(ctx, key, &client.GetOptions{Quorum: true})
+	if err != nil {
+		return []byte{}, 0, err
+	}
+
+	return []byte(resp.Node.Value), resp.Node.ModifiedIndex, nil
+}
+
+// WriteVersion writes a key if it is still at the modified index version, or
+// if it does not exist when version is 0
+func (d *EtcdStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	opts := &client.SetOptions{PrevIndex: version}
+	if version == 0 {
+		opts = &client.SetOptions{PrevExist: client.PrevNoExist}
+	}
+
+	_, err := d.KeysAPI.Set(ctx, key, string(value[:]), opts)
+	if etcdErr, ok := err.(client.Error); ok {
+		switch etcdErr.Code {
+		case client.ErrorCodeTestFailed, client.ErrorCodeNodeExist, client.ErrorCodeKeyNotFound:
+			return ErrStateConflict
+		}
+	}
+
+	return err
+}
+
 // ClearState removes key from etcd
 func (d *EtcdStateDriver) ClearState(key string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
@@ -202,6 +428,15 @@ func (d *EtcdStateDriver) ClearState(key string) error {
 	return err
 }
 
+// ClearAll removes baseKey and the keys under it from etcd
+func (d *EtcdStateDriver) ClearAll(baseKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeout)
+	defer cancel()
+
+	_, err := d.KeysAPI.Delete(ctx, strings.TrimSuffix(baseKey, "/"), &client.DeleteOptions{Recursive: true})
+	return core.ErrIfKeyExists(err)
+}
+
 // ReadState reads key into a core.State with the unmarshaling function.
 func (d *EtcdStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
@@ -210,7 +445,7 @@ func (d *EtcdStateDriver) ReadState(key string, value core.State,
 		return err
 	}
 
-	return unmarshal(encodedState, value)
+	return unmarshalState(key, encodedState, value, unmarshal)
 }
 
 // readAllStateCommon reads and unmarshals (given a function) all state into a
@@ -218,17 +453,25 @@ func (d *EtcdStateDriver) ReadState(key string, value core.State,
 // XXX: move this to some common file
 func readAllStateCommon(d core.StateDriver, baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
-	stateType := reflect.TypeOf(sType)
-	sliceType := reflect.SliceOf(stateType)
-	values := reflect.MakeSlice(sliceType, 0, 1)
-
 	byteValues, err := d.ReadAll(baseKey)
 	if err != nil {
 		return nil, err
 	}
+
+	return decodeAllState(d, baseKey, byteValues, sType, unmarshal)
+}
+
+// decodeAllState unmarshals the values read from baseKey into a list of
+// core.State objects
+func decodeAllState(d core.StateDriver, baseKey string, byteValues [][]byte, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	stateType := reflect.TypeOf(sType)
+	sliceType := reflect.SliceOf(stateType)
+	values := reflect.MakeSlice(sliceType, 0, 1)
+
 	for _, byteValue := range byteValues {
 		value := reflect.New(stateType)
-		err = unmarshal(byteValue, value.Interface())
+		err := unmarshalState(baseKey, byteValue, value.Interface(), unmarshal)
 		if err != nil {
 			return nil, err
 		}
@@ -260,7 +503,7 @@ func (d *EtcdStateDriver) ReadAllState(baseKey string, sType core.State,
 // specified type and unmarshals (given a function) all changes and puts then on
 // channel of core.WatchState objects.
 // XXX: move this to some common file
-func channelStateEvents(d core.StateDriver, sType core.State,
+func channelStateEvents(d core.StateDriver, baseKey string, sType core.State,
 	unmarshal func([]byte, interface{}) error,
 	byteRsps chan [2][]byte, rsps chan core.WatchState, retErr chan error) {
 	for {
@@ -274,7 +517,7 @@ func channelStateEvents(d core.StateDriver, sType core.State,
 			}
 			stateType := reflect.TypeOf(sType)
 			value := reflect.New(stateType)
-			err := unmarshal(byteRsp[i], value.Interface())
+			err := unmarshalState(baseKey, byteRsp[i], value.Interface(), unmarshal)
 			if err != nil {
 				log.Errorf("unmarshal error: %v", err)
 				retErr <- err
@@ -313,7 +556,7 @@ func (d *EtcdStateDriver) WatchAllState(baseKey string, sType core.State,
 	}
 
 	for {
-		go channelStateEvents(d, sType, unmarshal, byteRsps, rsps, recvErr)
+		go channelStateEvents(d, baseKey, sType, unmarshal, byteRsps, rsps, recvErr)
 
 		err = <-recvErr
 		log.Errorf("Err from channelStateEvents %v", err)
@@ -324,7 +567,7 @@ func (d *EtcdStateDriver) WatchAllState(baseKey string, sType core.State,
 // WriteState writes a value of core.State into a key with a given marshaling function.
 func (d *EtcdStateDriver) WriteState(key string, value core.State,
 	marshal func(interface{}) ([]byte, error)) error {
-	encodedState, err := marshal(value)
+	encodedState, err := marshalState(key, value, marshal)
 	if err != nil {
 		return err
 	}
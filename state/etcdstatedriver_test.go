@@ -18,10 +18,17 @@ package state
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"golang.org/x/net/context"
+
 	"github.com/contiv/netplugin/core"
+	"github.com/coreos/etcd/client"
 )
 
 const (
@@ -139,6 +146,33 @@ func commonTestStateDriverWriteState(t *testing.T, d core.StateDriver) {
 	}
 }
 
+func commonTestStateDriverKeyPrefixExists(t *testing.T, d KeyPrefixChecker) {
+	writer := d.(core.StateDriver)
+	baseKey := "/TestKeyPrefixExists/"
+	key := baseKey + "dir/key"
+
+	if err := writer.Write(key, []byte("value")); err != nil {
+		t.Fatalf("failed to write bytes. Error: %s", err)
+	}
+	for prefix, want := range map[string]bool{baseKey: true, baseKey + "dir/": true, baseKey + "none/": false} {
+		if exists, err := d.KeyPrefixExists(prefix); err != nil || exists != want {
+			t.Fatalf("prefix %s exists %v, expected %v. Error: %v", prefix, exists, want, err)
+		}
+	}
+
+	if err := writer.ClearState(key); err != nil {
+		t.Fatalf("failed to clear state. Error: %s", err)
+	}
+	if exists, err := d.KeyPrefixExists(baseKey); err != nil || exists {
+		t.Fatalf("prefix %s exists after the clear. Error: %v", baseKey, err)
+	}
+}
+
+func TestEtcdStateDriverKeyPrefixExists(t *testing.T) {
+	driver := setupEtcdDriver(t)
+	commonTestStateDriverKeyPrefixExists(t, driver)
+}
+
 func TestEtcdStateDriverWriteState(t *testing.T) {
 	driver := setupEtcdDriver(t)
 	commonTestStateDriverWriteState(t, driver)
@@ -451,3 +485,137 @@ func TestEtcdStateDriverWatchAllStateDelete(t *testing.T) {
 	driver := setupEtcdDriver(t)
 	commonTestStateDriverWatchAllStateDelete(t, driver)
 }
+
+// fakeEtcdKeys is an etcd keys API reading values from a map, whose watchers
+// return the events sent by the test
+type fakeEtcdKeys struct {
+	client.KeysAPI
+	mutex  sync.Mutex
+	values map[string]string
+	index  uint64
+	afters []uint64 // index each watcher was created after
+	reads  int      // recursive reads
+	events chan *client.Response
+	errs   chan error
+}
+
+type fakeEtcdWatcher struct {
+	keys *fakeEtcdKeys
+}
+
+func (w *fakeEtcdWatcher) Next(ctx context.Context) (*client.Response, error) {
+	select {
+	case rsp := <-w.keys.events:
+		return rsp, nil
+	case err := <-w.keys.errs:
+		return nil, err
+	}
+}
+
+func (k *fakeEtcdKeys) Get(ctx context.Context, key string, opts *client.GetOptions) (*client.Response, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if opts != nil && opts.Recursive {
+		k.reads++
+	}
+
+	dir := &client.Node{Key: key, Dir: true}
+	for nodeKey, value := range k.values {
+		if strings.HasPrefix(nodeKey, key) {
+			dir.Nodes = append(dir.Nodes, &client.Node{Key: nodeKey, Value: value})
+		}
+	}
+	if len(dir.Nodes) == 0 {
+		return nil, client.Error{Code: client.ErrorCodeKeyNotFound, Index: k.index}
+	}
+
+	return &client.Response{Node: dir, Index: k.index}, nil
+}
+
+func (k *fakeEtcdKeys) Watcher(key string, opts *client.WatcherOptions) client.Watcher {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.afters = append(k.afters, opts.AfterIndex)
+	return &fakeEtcdWatcher{keys: k}
+}
+
+func (k *fakeEtcdKeys) readCount() int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.reads
+}
+
+func (k *fakeEtcdKeys) lastAfter() uint64 {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.afters[len(k.afters)-1]
+}
+
+func TestEtcdWatchResume(t *testing.T) {
+	defer func(retry time.Duration) { etcdWatchRetry = retry }(etcdWatchRetry)
+	etcdWatchRetry = time.Millisecond
+
+	keys := &fakeEtcdKeys{
+		values: map[string]string{"/test/a": "1"},
+		index:  10,
+		events: make(chan *client.Response),
+		errs:   make(chan error),
+	}
+	driver := &EtcdStateDriver{KeysAPI: keys}
+	rsps := make(chan [2][]byte)
+	if err := driver.WatchAll("/test/", rsps); err != nil {
+		t.Fatalf("Error watching. Err: %v", err)
+	}
+
+	receive := func() string {
+		select {
+		case rsp := <-rsps:
+			return string(rsp[0]) + "/" + string(rsp[1])
+		case <-time.After(waitTimeout):
+			t.Fatalf("timed out waiting for events")
+		}
+		return ""
+	}
+
+	keys.events <- &client.Response{Action: "set", Node: &client.Node{Key: "/test/b", Value: "2", ModifiedIndex: 11}}
+	if rsp := receive(); rsp != "2/" || keys.lastAfter() != 10 {
+		t.Fatalf("Expected create of 2 after index 10, got %q after %d", rsp, keys.lastAfter())
+	}
+	if keys.readCount() != 0 {
+		t.Fatalf("Values were read to start the watch")
+	}
+
+	// a dropped connection resumes after the last event
+	keys.errs <- errors.New("connection reset")
+	keys.events <- &client.Response{Action: "delete", Node: &client.Node{Key: "/test/b", ModifiedIndex: 12},
+		PrevNode: &client.Node{Key: "/test/b", Value: "2"}}
+	if rsp := receive(); rsp != "/2" || keys.lastAfter() != 11 {
+		t.Fatalf("Expected delete of 2 after index 11, got %q after %d", rsp, keys.lastAfter())
+	}
+
+	// cleared events are resynced, against the values the watch has seen
+	keys.mutex.Lock()
+	keys.values = map[string]string{"/test/a": "3", "/test/c": "4"}
+	keys.index = 20
+	keys.mutex.Unlock()
+	keys.errs <- client.Error{Code: client.ErrorCodeEventIndexCleared}
+	events := []string{receive(), receive()}
+	sort.Strings(events)
+	if events[0] != "3/" || events[1] != "4/" || keys.readCount() != 1 {
+		t.Fatalf("Expected creates of 3 and 4 after one read, got %v after %d reads", events, keys.readCount())
+	}
+	keys.mutex.Lock()
+	keys.values = map[string]string{"/test/a": "5", "/test/c": "4"}
+	keys.mutex.Unlock()
+	keys.errs <- client.Error{Code: client.ErrorCodeEventIndexCleared}
+	if rsp := receive(); rsp != "5/3" {
+		t.Fatalf("Expected modify of 3, got %q", rsp)
+	}
+
+	keys.events <- &client.Response{Action: "delete", Node: &client.Node{Key: "/test", Dir: true, ModifiedIndex: 21}}
+	events = []string{receive(), receive()}
+	sort.Strings(events)
+	if events[0] != "/4" || events[1] != "/5" || keys.lastAfter() != 20 {
+		t.Fatalf("Expected deletes of 4 and 5 after index 20, got %v after %d", events, keys.lastAfter())
+	}
+}
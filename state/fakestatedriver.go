@@ -2,6 +2,8 @@ package state
 
 import (
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 
@@ -9,7 +11,9 @@ import (
 )
 
 type valueData struct {
-	value []byte
+	value   []byte
+	version uint64
+	expires time.Time // zero if the key does not expire
 }
 
 // FakeStateDriverConfig represents the configuration of the fake statedriver,
@@ -20,6 +24,8 @@ type FakeStateDriverConfig struct{}
 // unit-tests
 type FakeStateDriver struct {
 	TestState map[string]valueData
+	mutex     sync.Mutex
+	version   uint64 // incremented on every write
 }
 
 // Init the driver
@@ -36,14 +42,40 @@ func (d *FakeStateDriver) Deinit() {
 
 // Write value to key
 func (d *FakeStateDriver) Write(key string, value []byte) error {
-	val := valueData{value: value}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.version++
+	val := valueData{value: value, version: d.version}
 	d.TestState[key] = val
 
 	return nil
 }
 
+// WriteTTL writes a key that is removed after ttl, unless written again
+func (d *FakeStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.version++
+	d.TestState[key] = valueData{value: value, version: d.version, expires: time.Now().Add(ttl)}
+
+	return nil
+}
+
+// expire removes the expired keys, the mutex must be held
+func (d *FakeStateDriver) expire() {
+	now := time.Now()
+	for key, val := range d.TestState {
+		if !val.expires.IsZero() && now.After(val.expires) {
+			delete(d.TestState, key)
+		}
+	}
+}
+
 // Read value from key
 func (d *FakeStateDriver) Read(key string) ([]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
 	if val, ok := d.TestState[key]; ok {
 		return val.value, nil
 	}
@@ -51,8 +83,61 @@ func (d *FakeStateDriver) Read(key string) ([]byte, error) {
 	return []byte{}, core.Errorf("Key not found! key: %v", key)
 }
 
+// ReadVersion reads the value and version of a key
+func (d *FakeStateDriver) ReadVersion(key string) ([]byte, uint64, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
+	if val, ok := d.TestState[key]; ok {
+		return val.value, val.version, nil
+	}
+
+	return []byte{}, 0, core.Errorf("Key not found! key: %v", key)
+}
+
+// WriteVersion writes a key if it is still at version, or if it does not
+// exist when version is 0
+func (d *FakeStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
+	if d.TestState[key].version != version {
+		return ErrStateConflict
+	}
+	d.version++
+	d.TestState[key] = valueData{value: value, version: d.version}
+
+	return nil
+}
+
+// Txn applies the ops atomically
+func (d *FakeStateDriver) Txn(ops []TxnOp) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
+	for _, op := range ops {
+		if op.Check && d.TestState[op.Key].version != op.Version {
+			return ErrStateConflict
+		}
+	}
+
+	for _, op := range ops {
+		if op.Delete {
+			delete(d.TestState, op.Key)
+			continue
+		}
+		d.version++
+		d.TestState[op.Key] = valueData{value: op.Value, version: d.version}
+	}
+
+	return nil
+}
+
 // ReadAll values from baseKey
 func (d *FakeStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
 	values := [][]byte{}
 
 	for key, val := range d.TestState {
@@ -63,6 +148,34 @@ func (d *FakeStateDriver) ReadAll(baseKey string) ([][]byte, error) {
 	return values, nil
 }
 
+// ReadAllKeys reads the values under baseKey by key
+func (d *FakeStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
+	values := make(map[string][]byte)
+
+	for key, val := range d.TestState {
+		if strings.HasPrefix(key, baseKey) {
+			values[key] = val.value
+		}
+	}
+	return values, nil
+}
+
+// ListChildren lists the names right below baseKey of the keys under it
+func (d *FakeStateDriver) ListChildren(baseKey string) ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.expire()
+
+	keys := []string{}
+	for key := range d.TestState {
+		keys = append(keys, key)
+	}
+	return childNames(baseKey, keys), nil
+}
+
 // WatchAll values from baseKey
 func (d *FakeStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
 	return core.Errorf("not supported")
@@ -70,12 +183,26 @@ func (d *FakeStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
 
 // ClearState clears key
 func (d *FakeStateDriver) ClearState(key string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
 	if _, ok := d.TestState[key]; ok {
 		delete(d.TestState, key)
 	}
 	return nil
 }
 
+// ClearAll clears the keys under baseKey
+func (d *FakeStateDriver) ClearAll(baseKey string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key := range d.TestState {
+		if strings.HasPrefix(key, baseKey) {
+			delete(d.TestState, key)
+		}
+	}
+	return nil
+}
+
 // ReadState unmarshals state into a core.State
 func (d *FakeStateDriver) ReadState(key string, value core.State,
 	unmarshal func([]byte, interface{}) error) error {
@@ -84,7 +211,7 @@ func (d *FakeStateDriver) ReadState(key string, value core.State,
 		return err
 	}
 
-	return unmarshal(encodedState, value)
+	return unmarshalState(key, encodedState, value, unmarshal)
 }
 
 // ReadAllState reads all state from baseKey of a given type
@@ -102,7 +229,7 @@ func (d *FakeStateDriver) WatchAllState(baseKey string, sType core.State,
 // WriteState writes a core.State to key.
 func (d *FakeStateDriver) WriteState(key string, value core.State,
 	marshal func(interface{}) ([]byte, error)) error {
-	encodedState, err := marshal(value)
+	encodedState, err := marshalState(key, value, marshal)
 	if err != nil {
 		return err
 	}
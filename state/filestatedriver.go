@@ -0,0 +1,437 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// fileWatchInterval is how often WatchAll polls the state directory
+var fileWatchInterval = time.Second
+
+// FileStateDriverConfig encapsulates the configuration parameters of the file
+// state driver
+type FileStateDriverConfig struct {
+	Dir string
+}
+
+// FileStateDriver implements the StateDriver interface with a file per key in
+// a local directory, for single node setups and tests without etcd or consul.
+// Keys are escaped into file names, and written with a rename, so that other
+// processes on the node never read a partial value. Watches poll the
+// directory. Keys written with a TTL have their expiry time in a file of the
+// same name in the ttl directory, and are removed when read after it.
+type FileStateDriver struct {
+	Dir string
+
+	mutex sync.Mutex
+	stop  chan struct{} // closed by Deinit to stop the watches
+}
+
+// Init the driver with a core.Config. The URL is file:// followed by the
+// state directory.
+func (d *FileStateDriver) Init(instInfo *core.InstanceInfo) error {
+	if instInfo == nil || !strings.HasPrefix(instInfo.DbURL, "file://") {
+		return errors.New("Invalid file config")
+	}
+
+	d.Dir = strings.TrimPrefix(instInfo.DbURL, "file://")
+	if d.Dir == "" {
+		return errors.New("Invalid file config, no state directory")
+	}
+
+	d.mutex.Lock()
+	d.stop = make(chan struct{})
+	d.mutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(d.Dir, "ttl"), 0700); err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Join(d.Dir, "tmp"), 0700)
+}
+
+// Deinit stops the watches.
+func (d *FileStateDriver) Deinit() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+}
+
+// keyPath returns the file of a key
+func (d *FileStateDriver) keyPath(key string) string {
+	return filepath.Join(d.Dir, url.QueryEscape(key))
+}
+
+// ttlPath returns the expiry time file of a key
+func (d *FileStateDriver) ttlPath(key string) string {
+	return filepath.Join(d.Dir, "ttl", url.QueryEscape(key))
+}
+
+// expired returns true if a key was written with a TTL that has passed, and
+// removes it
+func (d *FileStateDriver) expired(key string) bool {
+	content, err := ioutil.ReadFile(d.ttlPath(key))
+	if err != nil {
+		return false
+	}
+	expires, err := time.Parse(time.RFC3339Nano, string(content))
+	if err != nil || time.Now().Before(expires) {
+		return false
+	}
+
+	d.ClearState(key)
+	return true
+}
+
+// Write state to key
+func (d *FileStateDriver) Write(key string, value []byte) error {
+	if err := os.Remove(d.ttlPath(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return d.writeFile(d.keyPath(key), value)
+}
+
+// WriteTTL writes a key that is removed after ttl, unless written again
+func (d *FileStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	expires := time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)
+	if err := d.writeFile(d.ttlPath(key), []byte(expires)); err != nil {
+		return err
+	}
+
+	return d.writeFile(d.keyPath(key), value)
+}
+
+// writeFile writes a file with a rename
+func (d *FileStateDriver) writeFile(path string, value []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Join(d.Dir, "tmp"), "state")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(value)
+	if cerr := tmpFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+
+	return err
+}
+
+// Read value from key
+func (d *FileStateDriver) Read(key string) ([]byte, error) {
+	value, err := ioutil.ReadFile(d.keyPath(key))
+	if os.IsNotExist(err) || (err == nil && d.expired(key)) {
+		return []byte{}, core.Errorf("Key not found! key: %v", key)
+	}
+
+	return value, err
+}
+
+// readKeys returns the values of the keys under baseKey. The escaping keeps
+// prefixes, so the files of other keys are skipped by name, unread.
+func (d *FileStateDriver) readKeys(baseKey string) (map[string][]byte, []string, error) {
+	files, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filePrefix := url.QueryEscape(baseKey)
+	values := make(map[string][]byte)
+	keys := []string{}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasPrefix(file.Name(), filePrefix) {
+			continue
+		}
+		key, err := url.QueryUnescape(file.Name())
+		if err != nil {
+			continue
+		}
+		value, err := ioutil.ReadFile(filepath.Join(d.Dir, file.Name()))
+		if os.IsNotExist(err) || (err == nil && d.expired(key)) {
+			// cleared meanwhile, or expired
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		values[key] = value
+		keys = append(keys, key)
+	}
+
+	return values, keys, nil
+}
+
+// ReadAll values from baseKey
+func (d *FileStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	values, keys, err := d.readKeys(baseKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, core.Errorf("Key not found! key: %v", baseKey)
+	}
+
+	all := [][]byte{}
+	for _, key := range keys {
+		all = append(all, values[key])
+	}
+
+	return all, nil
+}
+
+// ReadAllKeys reads the values under baseKey by key
+func (d *FileStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	values, keys, err := d.readKeys(baseKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, core.Errorf("Key not found! key: %v", baseKey)
+	}
+
+	return values, nil
+}
+
+// kvEvents returns the events turning the values seen in kvCache into the
+// values in kvs, and updates kvCache
+func kvEvents(kvCache, kvs map[string][]byte) [][2][]byte {
+	events := [][2][]byte{}
+	for key, value := range kvs {
+		prev, ok := kvCache[key]
+		if ok && string(prev) == string(value) {
+			continue
+		}
+		events = append(events, [2][]byte{value, prev})
+		kvCache[key] = value
+	}
+	for key, prev := range kvCache {
+		if _, ok := kvs[key]; !ok {
+			events = append(events, [2][]byte{nil, prev})
+			delete(kvCache, key)
+		}
+	}
+
+	return events
+}
+
+// filterKeys returns the values of kvs whose keys match match. A nil match
+// matches all keys.
+func filterKeys(kvs map[string][]byte, match func(string) bool) map[string][]byte {
+	if match == nil {
+		return kvs
+	}
+
+	filtered := make(map[string][]byte)
+	for key, value := range kvs {
+		if match(key) {
+			filtered[key] = value
+		}
+	}
+
+	return filtered
+}
+
+// childNames returns the sorted names right below baseKey of the keys under
+// it
+func childNames(baseKey string, keys []string) []string {
+	seen := make(map[string]bool)
+	names := []string{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, baseKey) {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(key, baseKey), "/", 2)[0]
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// watchFileKeys polls the keys under baseKey matching match and sends their
+// changes, until stop is closed or the state directory is removed
+func (d *FileStateDriver) watchFileKeys(baseKey string, match func(string) bool, interval time.Duration,
+	kvCache map[string][]byte, stop chan struct{}, rsps chan [2][]byte) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+
+		kvs, _, err := d.readKeys(baseKey)
+		if os.IsNotExist(err) {
+			log.Warnf("State directory %s was removed, stopping the watch of %s", d.Dir, baseKey)
+			return
+		}
+		if err != nil {
+			log.Errorf("Error %v during watch", err)
+			continue
+		}
+		for _, rsp := range kvEvents(kvCache, filterKeys(kvs, match)) {
+			select {
+			case rsps <- rsp:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+// WatchAll state transitions from baseKey
+func (d *FileStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	return d.WatchAllFiltered(baseKey, nil, rsps)
+}
+
+// WatchAllFiltered watches the state transitions of the keys under baseKey
+// matching match
+func (d *FileStateDriver) WatchAllFiltered(baseKey string, match func(string) bool, rsps chan [2][]byte) error {
+	kvCache, _, err := d.readKeys(baseKey)
+	if err != nil {
+		log.Errorf("file watch failed for key %q. Error: %s", baseKey, err)
+		return err
+	}
+
+	d.mutex.Lock()
+	stop := d.stop
+	d.mutex.Unlock()
+	if stop == nil {
+		return errors.New("file state driver is not initialized")
+	}
+
+	go d.watchFileKeys(baseKey, match, fileWatchInterval, filterKeys(kvCache, match), stop, rsps)
+
+	return nil
+}
+
+// ListChildren lists the names right below baseKey of the keys under it
+func (d *FileStateDriver) ListChildren(baseKey string) ([]string, error) {
+	files, err := ioutil.ReadDir(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, file := range files {
+		if key, err := url.QueryUnescape(file.Name()); err == nil && !file.IsDir() {
+			keys = append(keys, key)
+		}
+	}
+
+	return childNames(baseKey, keys), nil
+}
+
+// ClearState removes key
+func (d *FileStateDriver) ClearState(key string) error {
+	err := os.Remove(d.keyPath(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err = os.Remove(d.ttlPath(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// ClearAll removes the keys under baseKey
+func (d *FileStateDriver) ClearAll(baseKey string) error {
+	_, keys, err := d.readKeys(baseKey)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := d.ClearState(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadState reads key into a core.State with the unmarshaling function.
+func (d *FileStateDriver) ReadState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) error {
+	encodedState, err := d.Read(key)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalState(key, encodedState, value, unmarshal)
+}
+
+// ReadAllState Reads all the state from baseKey and returns a list of core.State.
+func (d *FileStateDriver) ReadAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	return readAllStateCommon(d, baseKey, sType, unmarshal)
+}
+
+// WatchAllState watches all state from the baseKey.
+func (d *FileStateDriver) WatchAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	byteRsps := make(chan [2][]byte, 1)
+	recvErr := make(chan error, 1)
+
+	err := d.WatchAll(baseKey, byteRsps)
+	if err != nil {
+		log.Errorf("WatchAll returned %v", err)
+		return err
+	}
+
+	for {
+		go channelStateEvents(d, baseKey, sType, unmarshal, byteRsps, rsps, recvErr)
+
+		err = <-recvErr
+		log.Errorf("Err from channelStateEvents %v", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// WriteState writes a value of core.State into a key with a given marshaling function.
+func (d *FileStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	encodedState, err := marshalState(key, value, marshal)
+	if err != nil {
+		return err
+	}
+
+	return d.Write(key, encodedState)
+}
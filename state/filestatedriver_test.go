@@ -0,0 +1,145 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestFileStateDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating state directory. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &FileStateDriver{}
+	if err := d.Init(&core.InstanceInfo{DbURL: "etcd://127.0.0.1:2379"}); err == nil {
+		t.Fatalf("etcd URL was accepted")
+	}
+	if err := d.Init(&core.InstanceInfo{DbURL: "file://" + dir}); err != nil {
+		t.Fatalf("Error initializing the driver. Err: %v", err)
+	}
+
+	if _, err := d.Read("/test/a/1"); core.ErrIfKeyExists(err) != nil || err == nil {
+		t.Fatalf("Expected key not found, got: %v", err)
+	}
+	if _, err := d.ReadAll("/test/a/"); core.ErrIfKeyExists(err) != nil || err == nil {
+		t.Fatalf("Expected key not found, got: %v", err)
+	}
+
+	d.Write("/test/a/1", []byte("1"))
+	d.Write("/test/a/2", []byte("2"))
+	d.Write("/test/b/1", []byte("b"))
+	if value, err := d.Read("/test/a/1"); err != nil || string(value) != "1" {
+		t.Fatalf("Expected 1, got %q. Err: %v", value, err)
+	}
+	if values, err := d.ReadAll("/test/a/"); err != nil || len(values) != 2 {
+		t.Fatalf("Expected 2 values, got %q. Err: %v", values, err)
+	}
+
+	if err := d.ClearState("/test/a/1"); err != nil {
+		t.Fatalf("Error clearing key. Err: %v", err)
+	}
+	if err := d.ClearState("/test/a/1"); err != nil {
+		t.Fatalf("Error clearing a missing key. Err: %v", err)
+	}
+	if values, err := d.ReadAll("/test/a/"); err != nil || len(values) != 1 || string(values[0]) != "2" {
+		t.Fatalf("Expected value 2, got %q. Err: %v", values, err)
+	}
+}
+
+func TestFileStateDriverWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating state directory. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { fileWatchInterval = interval }(fileWatchInterval)
+	fileWatchInterval = 10 * time.Millisecond
+
+	d := &FileStateDriver{}
+	if err := d.Init(&core.InstanceInfo{DbURL: "file://" + dir}); err != nil {
+		t.Fatalf("Error initializing the driver. Err: %v", err)
+	}
+	d.Write("/test/a/1", []byte("old"))
+
+	rsps := make(chan [2][]byte)
+	if err := d.WatchAll("/test/a/", rsps); err != nil {
+		t.Fatalf("Error watching. Err: %v", err)
+	}
+
+	expect := func(curr, prev string) {
+		select {
+		case rsp := <-rsps:
+			if string(rsp[0]) != curr || string(rsp[1]) != prev {
+				t.Fatalf("Expected event %q/%q, got %q/%q", curr, prev, rsp[0], rsp[1])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("No event for %q/%q", curr, prev)
+		}
+	}
+
+	d.Write("/test/a/1", []byte("new"))
+	expect("new", "old")
+	d.Write("/test/b/1", []byte("other"))
+	d.ClearState("/test/a/1")
+	expect("", "new")
+
+	// Deinit stops the watch
+	d.Deinit()
+	d.Write("/test/a/2", []byte("stopped"))
+	select {
+	case rsp := <-rsps:
+		t.Fatalf("Event %q/%q after Deinit", rsp[0], rsp[1])
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := d.WatchAll("/test/a/", rsps); err == nil {
+		t.Fatalf("Watched after Deinit")
+	}
+}
+
+func TestFileStateDriverWatchRemovedDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating state directory. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &FileStateDriver{}
+	if err := d.Init(&core.InstanceInfo{DbURL: "file://" + dir}); err != nil {
+		t.Fatalf("Error initializing the driver. Err: %v", err)
+	}
+	defer d.Deinit()
+	os.RemoveAll(dir)
+
+	done := make(chan struct{})
+	go func() {
+		d.watchFileKeys("/test/", nil, time.Millisecond, map[string][]byte{}, d.stop, make(chan [2][]byte))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Watch of a removed state directory did not stop")
+	}
+}
@@ -0,0 +1,134 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// redactedValue replaces the values of sensitive fields in inspected state
+const redactedValue = "[redacted]"
+
+// sensitiveWords are the parts of field names whose values are always
+// redacted
+var sensitiveWords = []string{"password", "passphrase", "secret", "token", "credential", "privatekey"}
+
+var (
+	// sensitiveMutex protects sensitiveFields, the field names marked by
+	// MarkSensitiveField
+	sensitiveMutex  sync.Mutex
+	sensitiveFields = make(map[string]bool)
+)
+
+// InspectEntry is a key of the inspected state. The value is the decoded
+// JSON, or the raw value as a string if it is not JSON.
+type InspectEntry struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
+}
+
+type inspectEntries []InspectEntry
+
+func (e inspectEntries) Len() int           { return len(e) }
+func (e inspectEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e inspectEntries) Less(i, j int) bool { return e[i].Key < e[j].Key }
+
+// MarkSensitiveField marks a field or map key of the state, whose value is
+// redacted by InspectState
+func MarkSensitiveField(name string) {
+	sensitiveMutex.Lock()
+	defer sensitiveMutex.Unlock()
+	sensitiveFields[name] = true
+}
+
+// sensitiveField returns true if the value of a field is redacted
+func sensitiveField(name string) bool {
+	sensitiveMutex.Lock()
+	marked := sensitiveFields[name]
+	sensitiveMutex.Unlock()
+	if marked {
+		return true
+	}
+
+	lower := strings.ToLower(strings.Replace(strings.Replace(name, "-", "", -1), "_", "", -1))
+	for _, word := range sensitiveWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactFields replaces the values of the sensitive fields in a decoded JSON
+// value
+func redactFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if sensitiveField(key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactFields(field)
+			}
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = redactFields(elem)
+		}
+	}
+
+	return value
+}
+
+// InspectState returns the contiv state under a path, relative to the contiv
+// base key, eg. "oper/docknet/", ordered by key. Values are decoded and the
+// sensitive fields redacted.
+func InspectState(d core.StateDriver, path string) ([]InspectEntry, error) {
+	if strings.Contains(path, "..") {
+		return nil, core.Errorf("invalid state path %q", path)
+	}
+	baseKey := backupBasePath + strings.TrimPrefix(path, "/")
+
+	reader, ok := d.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+	values, err := reader.ReadAllKeys(baseKey)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := inspectEntries{}
+	for key, value := range values {
+		var decoded interface{}
+		decoder := json.NewDecoder(bytes.NewReader(value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&decoded); err != nil || decoder.More() {
+			decoded = string(value)
+		}
+		entries = append(entries, InspectEntry{Key: key, Value: redactFields(decoded)})
+	}
+	sort.Sort(entries)
+
+	return entries, nil
+}
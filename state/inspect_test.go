@@ -0,0 +1,57 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestInspectState(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.Write("/contiv.io/state/nets/net1", []byte(`{"id":"net1","pktTag":12345678901234567,`+
+		`"options":{"bgp-key":"k1","mtu":"1500"},"peers":[{"authToken":"t1"}]}`))
+	d.Write("/contiv.io/oper/docknet-uuid/abc", []byte("net1"))
+	d.Write("/contiv.io/oper/docknet/t1.net1.", []byte(`{"cluster_password":"p1"}`))
+	MarkSensitiveField("bgp-key")
+
+	entries, err := InspectState(d, "")
+	if err != nil || len(entries) != 3 || entries[0].Key != "/contiv.io/oper/docknet-uuid/abc" {
+		t.Fatalf("Expected all keys in order, got %+v. Err: %v", entries, err)
+	}
+	if entries[0].Value != "net1" {
+		t.Fatalf("Expected the raw value, got %v", entries[0].Value)
+	}
+
+	dump, _ := json.Marshal(entries)
+	expected := `[{"key":"/contiv.io/oper/docknet-uuid/abc","value":"net1"},` +
+		`{"key":"/contiv.io/oper/docknet/t1.net1.","value":{"cluster_password":"[redacted]"}},` +
+		`{"key":"/contiv.io/state/nets/net1","value":{"id":"net1",` +
+		`"options":{"bgp-key":"[redacted]","mtu":"1500"},"peers":[{"authToken":"[redacted]"}],"pktTag":12345678901234567}}]`
+	if string(dump) != expected {
+		t.Fatalf("Unexpected state dump %s", dump)
+	}
+
+	if entries, err := InspectState(d, "/state/"); err != nil || len(entries) != 1 {
+		t.Fatalf("Expected the config state only, got %+v. Err: %v", entries, err)
+	}
+	if _, err := InspectState(d, "state/../lock"); err == nil {
+		t.Fatalf("Inspected a path outside of the contiv state")
+	}
+}
@@ -0,0 +1,444 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// state store operations counted by MeteredStateDriver
+const (
+	opRead     = "read"
+	opReadAll  = "read_all"
+	opWrite    = "write"
+	opClear    = "clear"
+	opClearAll = "clear_all"
+	opWatch    = "watch"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// opMetrics are the metrics of an operation
+type opMetrics struct {
+	op      string
+	count   uint64
+	errors  uint64
+	buckets []uint64 // per latencyBuckets, and one for slower operations
+	sum     time.Duration
+}
+
+// opMetricsList is sorted by operation
+type opMetricsList []opMetrics
+
+func (l opMetricsList) Len() int           { return len(l) }
+func (l opMetricsList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l opMetricsList) Less(i, j int) bool { return l[i].op < l[j].op }
+
+// MeteredStateDriver is a state driver counting the operations of the
+// underlying driver, their errors and latency, and the watch events it
+// delivers. A missing key is not an error. ReadState, ReadAllState and
+// WriteState are counted as the reads and writes they make.
+type MeteredStateDriver struct {
+	driver core.StateDriver
+
+	mutex       sync.Mutex
+	ops         map[string]*opMetrics
+	watchEvents uint64
+}
+
+// NewMeteredStateDriver returns a state driver metering another
+func NewMeteredStateDriver(d core.StateDriver) *MeteredStateDriver {
+	return &MeteredStateDriver{
+		driver: d,
+		ops:    make(map[string]*opMetrics),
+	}
+}
+
+// observe records an operation started at start
+func (m *MeteredStateDriver) observe(op string, start time.Time, err error) {
+	elapsed := time.Since(start)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	metrics, ok := m.ops[op]
+	if !ok {
+		metrics = &opMetrics{op: op, buckets: make([]uint64, len(latencyBuckets)+1)}
+		m.ops[op] = metrics
+	}
+	metrics.count++
+	if core.ErrIfKeyExists(err) != nil {
+		metrics.errors++
+	}
+	metrics.sum += elapsed
+	bucket := sort.Search(len(latencyBuckets), func(i int) bool { return elapsed <= latencyBuckets[i] })
+	metrics.buckets[bucket]++
+}
+
+// watchEvent records a watch event
+func (m *MeteredStateDriver) watchEvent() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.watchEvents++
+}
+
+// snapshot returns a copy of the metrics, sorted by operation
+func (m *MeteredStateDriver) snapshot() (opMetricsList, uint64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	list := opMetricsList{}
+	for _, metrics := range m.ops {
+		metricsCopy := *metrics
+		metricsCopy.buckets = append([]uint64(nil), metrics.buckets...)
+		list = append(list, metricsCopy)
+	}
+	sort.Sort(list)
+
+	return list, m.watchEvents
+}
+
+// WriteMetrics writes the metrics in the prometheus text format
+func (m *MeteredStateDriver) WriteMetrics(w io.Writer) error {
+	list, watchEvents := m.snapshot()
+
+	lines := []string{
+		"# HELP contiv_state_store_ops_total State store operations.",
+		"# TYPE contiv_state_store_ops_total counter",
+	}
+	for _, metrics := range list {
+		lines = append(lines, fmt.Sprintf("contiv_state_store_ops_total{op=%q} %d", metrics.op, metrics.count))
+	}
+
+	lines = append(lines,
+		"# HELP contiv_state_store_errors_total Failed state store operations.",
+		"# TYPE contiv_state_store_errors_total counter")
+	for _, metrics := range list {
+		lines = append(lines, fmt.Sprintf("contiv_state_store_errors_total{op=%q} %d", metrics.op, metrics.errors))
+	}
+
+	lines = append(lines,
+		"# HELP contiv_state_store_op_duration_seconds Latency of the state store operations.",
+		"# TYPE contiv_state_store_op_duration_seconds histogram")
+	for _, metrics := range list {
+		cumulative := uint64(0)
+		for i, count := range metrics.buckets {
+			cumulative += count
+			le := "+Inf"
+			if i < len(latencyBuckets) {
+				le = fmt.Sprint(latencyBuckets[i].Seconds())
+			}
+			lines = append(lines, fmt.Sprintf("contiv_state_store_op_duration_seconds_bucket{op=%q,le=%q} %d",
+				metrics.op, le, cumulative))
+		}
+		lines = append(lines,
+			fmt.Sprintf("contiv_state_store_op_duration_seconds_sum{op=%q} %v", metrics.op, metrics.sum.Seconds()),
+			fmt.Sprintf("contiv_state_store_op_duration_seconds_count{op=%q} %d", metrics.op, metrics.count))
+	}
+
+	lines = append(lines,
+		"# HELP contiv_state_store_watch_events_total Events received from state store watches.",
+		"# TYPE contiv_state_store_watch_events_total counter",
+		fmt.Sprintf("contiv_state_store_watch_events_total %d", watchEvents))
+
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Init initializes the underlying driver
+func (m *MeteredStateDriver) Init(instInfo *core.InstanceInfo) error {
+	return m.driver.Init(instInfo)
+}
+
+// Deinit deinitializes the underlying driver
+func (m *MeteredStateDriver) Deinit() {
+	m.driver.Deinit()
+}
+
+// Write writes to the underlying driver
+func (m *MeteredStateDriver) Write(key string, value []byte) error {
+	start := time.Now()
+	err := m.driver.Write(key, value)
+	m.observe(opWrite, start, err)
+	return err
+}
+
+// Read reads from the underlying driver
+func (m *MeteredStateDriver) Read(key string) ([]byte, error) {
+	start := time.Now()
+	value, err := m.driver.Read(key)
+	m.observe(opRead, start, err)
+	return value, err
+}
+
+// ReadAll reads the values under a base key from the underlying driver
+func (m *MeteredStateDriver) ReadAll(baseKey string) ([][]byte, error) {
+	start := time.Now()
+	values, err := m.driver.ReadAll(baseKey)
+	m.observe(opReadAll, start, err)
+	return values, err
+}
+
+// ReadAllKeys reads the values under a base key by key from the underlying
+// driver
+func (m *MeteredStateDriver) ReadAllKeys(baseKey string) (map[string][]byte, error) {
+	reader, ok := m.driver.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+
+	start := time.Now()
+	values, err := reader.ReadAllKeys(baseKey)
+	m.observe(opReadAll, start, err)
+	return values, err
+}
+
+// KeyPrefixExists checks whether there are keys under a base key in the
+// underlying driver
+func (m *MeteredStateDriver) KeyPrefixExists(baseKey string) (bool, error) {
+	checker, ok := m.driver.(KeyPrefixChecker)
+	if !ok {
+		return false, core.Errorf("state driver does not check key prefixes")
+	}
+
+	start := time.Now()
+	exists, err := checker.KeyPrefixExists(baseKey)
+	m.observe(opRead, start, err)
+	return exists, err
+}
+
+// ListChildren lists the names right below a base key in the underlying
+// driver
+func (m *MeteredStateDriver) ListChildren(baseKey string) ([]string, error) {
+	lister, ok := m.driver.(ChildLister)
+	if !ok {
+		return nil, core.Errorf("state driver does not list keys")
+	}
+
+	start := time.Now()
+	names, err := lister.ListChildren(baseKey)
+	m.observe(opReadAll, start, err)
+	return names, err
+}
+
+// WriteTTL writes a key with a TTL to the underlying driver
+func (m *MeteredStateDriver) WriteTTL(key string, value []byte, ttl time.Duration) error {
+	writer, ok := m.driver.(TTLWriter)
+	if !ok {
+		return core.Errorf("state driver does not expire keys")
+	}
+
+	start := time.Now()
+	err := writer.WriteTTL(key, value, ttl)
+	m.observe(opWrite, start, err)
+	return err
+}
+
+// ReadVersion reads a key and its version from the underlying driver. If
+// it does not version keys, the version is 0.
+func (m *MeteredStateDriver) ReadVersion(key string) ([]byte, uint64, error) {
+	versioned, ok := m.driver.(VersionedDriver)
+	if !ok {
+		value, err := m.Read(key)
+		return value, 0, err
+	}
+
+	start := time.Now()
+	value, version, err := versioned.ReadVersion(key)
+	m.observe(opRead, start, err)
+	return value, version, err
+}
+
+// WriteVersion writes a key at a version to the underlying driver. If it
+// does not version keys, the key is written unconditionally. Conflicts are
+// not errors.
+func (m *MeteredStateDriver) WriteVersion(key string, value []byte, version uint64) error {
+	versioned, ok := m.driver.(VersionedDriver)
+	if !ok {
+		return m.Write(key, value)
+	}
+
+	start := time.Now()
+	err := versioned.WriteVersion(key, value, version)
+	if err == ErrStateConflict {
+		m.observe(opWrite, start, nil)
+	} else {
+		m.observe(opWrite, start, err)
+	}
+	return err
+}
+
+// Txn applies the ops in a transaction of the underlying driver. Conflicts
+// are not errors.
+func (m *MeteredStateDriver) Txn(ops []TxnOp) error {
+	txn, ok := m.driver.(TxnDriver)
+	if !ok {
+		return ErrTxnUnsupported
+	}
+
+	start := time.Now()
+	err := txn.Txn(ops)
+	switch err {
+	case ErrTxnUnsupported:
+	case ErrStateConflict:
+		m.observe(opWrite, start, nil)
+	default:
+		m.observe(opWrite, start, err)
+	}
+	return err
+}
+
+// WatchAll watches the underlying driver, counting the events
+func (m *MeteredStateDriver) WatchAll(baseKey string, rsps chan [2][]byte) error {
+	events := make(chan [2][]byte)
+	start := time.Now()
+	err := m.driver.WatchAll(baseKey, events)
+	m.observe(opWatch, start, err)
+	if err != nil {
+		return err
+	}
+
+	go m.countWatchEvents(events, rsps)
+
+	return nil
+}
+
+// WatchAllFiltered watches the keys matching a filter in the underlying
+// driver, counting the events
+func (m *MeteredStateDriver) WatchAllFiltered(baseKey string, match func(string) bool, rsps chan [2][]byte) error {
+	watcher, ok := m.driver.(FilteredWatcher)
+	if !ok {
+		return core.Errorf("state driver does not filter watches")
+	}
+
+	events := make(chan [2][]byte)
+	start := time.Now()
+	err := watcher.WatchAllFiltered(baseKey, match, events)
+	m.observe(opWatch, start, err)
+	if err != nil {
+		return err
+	}
+
+	go m.countWatchEvents(events, rsps)
+
+	return nil
+}
+
+// countWatchEvents counts and forwards the events of a watch
+func (m *MeteredStateDriver) countWatchEvents(events, rsps chan [2][]byte) {
+	// the drivers never stop watches, so neither does it
+	for event := range events {
+		m.watchEvent()
+		rsps <- event
+	}
+}
+
+// ClearState clears the key in the underlying driver
+func (m *MeteredStateDriver) ClearState(key string) error {
+	start := time.Now()
+	err := m.driver.ClearState(key)
+	m.observe(opClear, start, err)
+	return err
+}
+
+// ClearAll clears the keys under a base key in the underlying driver
+func (m *MeteredStateDriver) ClearAll(baseKey string) error {
+	clearer, ok := m.driver.(RecursiveClearer)
+	if !ok {
+		return core.Errorf("state driver does not clear base keys")
+	}
+
+	start := time.Now()
+	err := clearer.ClearAll(baseKey)
+	m.observe(opClearAll, start, err)
+	return err
+}
+
+// ReadState reads a state from the underlying driver
+func (m *MeteredStateDriver) ReadState(key string, value core.State,
+	unmarshal func([]byte, interface{}) error) error {
+	encodedState, err := m.Read(key)
+	if err != nil {
+		return err
+	}
+
+	return unmarshalState(key, encodedState, value, unmarshal)
+}
+
+// ReadAllState reads all states under a base key from the underlying driver
+func (m *MeteredStateDriver) ReadAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	return readAllStateCommon(m, baseKey, sType, unmarshal)
+}
+
+// WatchAllState watches the states under a base key in the underlying
+// driver, counting the events
+func (m *MeteredStateDriver) WatchAllState(baseKey string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	byteRsps := make(chan [2][]byte, 1)
+	recvErr := make(chan error, 1)
+
+	err := m.WatchAll(baseKey, byteRsps)
+	if err != nil {
+		log.Errorf("WatchAll returned %v", err)
+		return err
+	}
+
+	for {
+		go channelStateEvents(m, baseKey, sType, unmarshal, byteRsps, rsps, recvErr)
+
+		err = <-recvErr
+		log.Errorf("Err from channelStateEvents %v", err)
+		time.Sleep(time.Second)
+	}
+}
+
+// WriteState writes a state to the underlying driver
+func (m *MeteredStateDriver) WriteState(key string, value core.State,
+	marshal func(interface{}) ([]byte, error)) error {
+	encodedState, err := marshalState(key, value, marshal)
+	if err != nil {
+		return err
+	}
+
+	return m.Write(key, encodedState)
+}
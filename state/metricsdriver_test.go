@@ -0,0 +1,79 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// failingFakeDriver is a fake driver failing the writes
+type failingFakeDriver struct {
+	watchedFakeDriver
+}
+
+func (d *failingFakeDriver) Write(key string, value []byte) error {
+	return core.Errorf("write failed")
+}
+
+func TestMeteredStateDriver(t *testing.T) {
+	d := &failingFakeDriver{watchedFakeDriver{watches: make(map[string]chan [2][]byte)}}
+	if err := d.Init(&core.InstanceInfo{}); err != nil {
+		t.Fatalf("Error initializing the fake driver. Err: %v", err)
+	}
+	d.FakeStateDriver.Write("/test/a/1", []byte("1"))
+	m := NewMeteredStateDriver(d)
+
+	m.Read("/test/a/1")
+	m.Read("/test/a/2")
+	m.ReadAll("/test/a/")
+	if err := m.Write("/test/a/1", []byte("2")); err == nil {
+		t.Fatalf("Write did not fail")
+	}
+
+	rsps := make(chan [2][]byte)
+	if err := m.WatchAll("/test/a/", rsps); err != nil {
+		t.Fatalf("Error watching. Err: %v", err)
+	}
+	d.watches["/test/a/"] <- [2][]byte{[]byte("3"), nil}
+	select {
+	case <-rsps:
+	case <-time.After(time.Second):
+		t.Fatalf("Watch event was not forwarded")
+	}
+
+	out := &bytes.Buffer{}
+	if err := m.WriteMetrics(out); err != nil {
+		t.Fatalf("Error writing metrics. Err: %v", err)
+	}
+	for _, line := range []string{
+		`contiv_state_store_ops_total{op="read"} 2`,
+		`contiv_state_store_ops_total{op="read_all"} 1`,
+		`contiv_state_store_errors_total{op="read"} 0`,
+		`contiv_state_store_errors_total{op="write"} 1`,
+		`contiv_state_store_op_duration_seconds_bucket{op="read",le="+Inf"} 2`,
+		`contiv_state_store_op_duration_seconds_count{op="write"} 1`,
+		`contiv_state_store_watch_events_total 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("Expected %s in the metrics, got:\n%s", line, out)
+		}
+	}
+}
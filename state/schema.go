@@ -0,0 +1,227 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// schemaVersionField is the field of the JSON states with their schema
+// version. States written before versioning have none, and are version 0.
+// States may set it themselves, like the docknet oper state, and then
+// migrations of their base key must be of a later version.
+const schemaVersionField = "schemaVersion"
+
+// Migration upgrades the states under a base key to Version, from the
+// previous version. Migrate changes the JSON object of a state in place, with
+// the numbers decoded as json.Number. The sensitive fields may be encrypted,
+// see SetStateEncryption, so migrations must not change them.
+type Migration struct {
+	BaseKey string
+	Version int
+	Migrate func(state map[string]interface{}) error
+}
+
+var (
+	migrationMutex sync.RWMutex
+	migrations     []Migration // ordered by version
+)
+
+type migrationList []Migration
+
+func (m migrationList) Len() int           { return len(m) }
+func (m migrationList) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m migrationList) Less(i, j int) bool { return m[i].Version < m[j].Version }
+
+// RegisterMigration adds a state migration. The schema version of the states
+// written under a base key is the highest version of its migrations, so a
+// change to a state that needs a migration registers it with the next
+// version.
+func RegisterMigration(m Migration) {
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	// copy the migrations so that the lists being run are not changed
+	list := make(migrationList, len(migrations), len(migrations)+1)
+	copy(list, migrations)
+	list = append(list, m)
+	sort.Stable(list)
+	migrations = list
+}
+
+// SchemaVersion returns the schema version of the states written to key, 1
+// if it has no migrations
+func SchemaVersion(key string) int {
+	version := 1
+	for _, m := range keyMigrations(key) {
+		if m.Version > version {
+			version = m.Version
+		}
+	}
+
+	return version
+}
+
+// keyMigrations returns the migrations of the states under key
+func keyMigrations(key string) []Migration {
+	migrationMutex.RLock()
+	defer migrationMutex.RUnlock()
+
+	var keyList []Migration
+	for _, m := range migrations {
+		if strings.HasPrefix(key, m.BaseKey) {
+			keyList = append(keyList, m)
+		}
+	}
+
+	return keyList
+}
+
+// stampState sets the schema version of key in an encoded JSON state, unless
+// the state has set its own. Other values are returned as is.
+func stampState(key string, encodedState []byte) []byte {
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(encodedState, &obj); err != nil {
+		return encodedState
+	}
+	if version, ok := obj[schemaVersionField]; ok && string(version) != "0" {
+		return encodedState
+	}
+
+	obj[schemaVersionField], _ = json.Marshal(SchemaVersion(key))
+	stamped, err := json.Marshal(obj)
+	if err != nil {
+		return encodedState
+	}
+
+	return stamped
+}
+
+// marshalState encodes a state stamped with the schema version of key, with
+// the sensitive fields encrypted if the state encryption is set
+func marshalState(key string, value core.State, marshal func(interface{}) ([]byte, error)) ([]byte, error) {
+	encodedState, err := marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	encodedState, err = encryptState(encodedState)
+	if err != nil {
+		return nil, err
+	}
+
+	return stampState(key, encodedState), nil
+}
+
+// migrateState runs the migrations of key the encoded state is older than.
+// For a base key, only the migrations of the base key and its parents apply.
+// The state is returned as is if no migration applies.
+func migrateState(key string, encodedState []byte) ([]byte, error) {
+	keyList := keyMigrations(key)
+	if len(keyList) == 0 {
+		return encodedState, nil
+	}
+
+	obj := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(encodedState))
+	decoder.UseNumber()
+	if err := decoder.Decode(&obj); err != nil {
+		// not a JSON state
+		return encodedState, nil
+	}
+
+	version := int64(0)
+	if num, ok := obj[schemaVersionField].(json.Number); ok {
+		version, _ = num.Int64()
+	}
+
+	migrated := false
+	for _, m := range keyList {
+		if int64(m.Version) <= version {
+			continue
+		}
+		if err := m.Migrate(obj); err != nil {
+			log.Errorf("Error migrating %s to schema version %d. Err: %v", key, m.Version, err)
+			return nil, err
+		}
+		obj[schemaVersionField] = m.Version
+		migrated = true
+	}
+	if !migrated {
+		return encodedState, nil
+	}
+
+	return json.Marshal(obj)
+}
+
+// unmarshalState decodes a state after decrypting and migrating it
+func unmarshalState(key string, encodedState []byte, value interface{},
+	unmarshal func([]byte, interface{}) error) error {
+	encodedState, err := decryptState(encodedState)
+	if err != nil {
+		log.Errorf("Error decrypting %s. Err: %v", key, err)
+		return err
+	}
+	encodedState, err = migrateState(key, encodedState)
+	if err != nil {
+		return err
+	}
+
+	return unmarshal(encodedState, value)
+}
+
+// MigrateAllState writes back the contiv states that are older than their
+// migrations, so that readers listing a parent of the base key of a migration
+// see them migrated. It returns the number of states migrated. It runs when
+// netmaster becomes the leader.
+func MigrateAllState(d core.StateDriver) (int, error) {
+	reader, ok := d.(KeyReader)
+	if !ok {
+		return 0, core.Errorf("state driver does not read keys")
+	}
+
+	values, err := reader.ReadAllKeys(backupBasePath)
+	if err != nil {
+		return 0, core.ErrIfKeyExists(err)
+	}
+
+	count := 0
+	for key, value := range values {
+		if backupSkips(key) {
+			continue
+		}
+		migrated, err := migrateState(key, value)
+		if err != nil {
+			return count, err
+		}
+		if bytes.Equal(migrated, value) {
+			continue
+		}
+		if err := d.Write(key, migrated); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
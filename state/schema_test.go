@@ -0,0 +1,111 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+type schemaTestState struct {
+	core.CommonState
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+func (s *schemaTestState) Write() error                   { return nil }
+func (s *schemaTestState) Read(id string) error           { return nil }
+func (s *schemaTestState) ReadAll() ([]core.State, error) { return nil, nil }
+func (s *schemaTestState) Clear() error                   { return nil }
+
+func TestStateMigration(t *testing.T) {
+	defer func(saved []Migration) { migrations = saved }(migrations)
+
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+
+	// states written before versioning
+	d.Write("/contiv.io/state/schema-test/a", []byte(`{"id":"a","title":"old","size":9007199254740993}`))
+	d.Write("/contiv.io/state/other/b", []byte(`{"id":"b","title":"other"}`))
+
+	RegisterMigration(Migration{
+		BaseKey: "/contiv.io/state/schema-test/",
+		Version: 2,
+		Migrate: func(state map[string]interface{}) error {
+			state["name"] = state["title"]
+			delete(state, "title")
+			return nil
+		},
+	})
+	if SchemaVersion("/contiv.io/state/schema-test/a") != 2 || SchemaVersion("/contiv.io/state/other/b") != 1 {
+		t.Fatalf("Expected schema version 2 of the migrated states only")
+	}
+
+	// migrated on read
+	s := &schemaTestState{}
+	if err := d.ReadState("/contiv.io/state/schema-test/a", s, json.Unmarshal); err != nil || s.Name != "old" {
+		t.Fatalf("State was not migrated, got %+v. Err: %v", s, err)
+	}
+	states, err := d.ReadAllState("/contiv.io/state/schema-test/", s, json.Unmarshal)
+	if err != nil || len(states) != 1 || states[0].(*schemaTestState).Name != "old" {
+		t.Fatalf("States were not migrated, got %+v. Err: %v", states, err)
+	}
+
+	// migrated in the store
+	if count, err := MigrateAllState(d); err != nil || count != 1 {
+		t.Fatalf("Expected 1 state migrated, got %d. Err: %v", count, err)
+	}
+	value, _ := d.Read("/contiv.io/state/schema-test/a")
+	obj := map[string]interface{}{}
+	json.Unmarshal(value, &obj)
+	if obj["name"] != "old" || obj[schemaVersionField] != float64(2) || obj["title"] != nil {
+		t.Fatalf("State was not migrated in the store, got %s", value)
+	}
+	if !containsNumber(value, "9007199254740993") {
+		t.Fatalf("Numbers were changed by the migration, got %s", value)
+	}
+	if count, _ := MigrateAllState(d); count != 0 {
+		t.Fatalf("Migrated states were migrated again")
+	}
+
+	// written states are stamped
+	s.Name = "new"
+	if err := d.WriteState("/contiv.io/state/schema-test/c", s, json.Marshal); err != nil {
+		t.Fatalf("Error writing state. Err: %v", err)
+	}
+	value, _ = d.Read("/contiv.io/state/schema-test/c")
+	obj = map[string]interface{}{}
+	json.Unmarshal(value, &obj)
+	if obj[schemaVersionField] != float64(2) {
+		t.Fatalf("Written state was not stamped, got %s", value)
+	}
+
+	// states with their own schema version keep it
+	d.WriteState("/contiv.io/state/other/c", s, func(v interface{}) ([]byte, error) {
+		return []byte(`{"id":"c","schemaVersion":10}`), nil
+	})
+	if value, _ = d.Read("/contiv.io/state/other/c"); string(value) != `{"id":"c","schemaVersion":10}` {
+		t.Fatalf("Schema version of the state was changed, got %s", value)
+	}
+}
+
+func containsNumber(value []byte, num string) bool {
+	obj := map[string]json.RawMessage{}
+	json.Unmarshal(value, &obj)
+	return string(obj["size"]) == num
+}
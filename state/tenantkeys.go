@@ -0,0 +1,203 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TenantsPath is the base key of the tenant scoped state. The state of a
+// tenant is under TenantPath, so that it is listed without reading the state
+// of other tenants, and removed with a single recursive delete. Only the
+// docknet oper state is tenant scoped so far, under "docknet/". The network,
+// endpoint group and endpoint states are still under their global paths.
+const TenantsPath = "/contiv.io/tenants/"
+
+// TenantPath returns the base key of the state of a tenant
+func TenantPath(tenantName string) string {
+	return TenantsPath + tenantName + "/"
+}
+
+// RecursiveClearer is implemented by the state drivers that remove the keys
+// under a base key at once
+type RecursiveClearer interface {
+	ClearAll(baseKey string) error
+}
+
+// ChildLister is implemented by the state drivers that list the names right
+// below a base key without reading the values under it
+type ChildLister interface {
+	ListChildren(baseKey string) ([]string, error)
+}
+
+// KeyPrefixChecker is implemented by the state drivers that check whether
+// there are keys under a base key without reading all of them
+type KeyPrefixChecker interface {
+	KeyPrefixExists(baseKey string) (bool, error)
+}
+
+// FilteredWatcher is implemented by the state drivers that watch only the
+// keys under a base key that match a filter
+type FilteredWatcher interface {
+	WatchAllFiltered(baseKey string, match func(key string) bool, rsps chan [2][]byte) error
+}
+
+// tenantPathMatcher returns a filter matching the keys under path in the
+// state of any tenant
+func tenantPathMatcher(path string) func(string) bool {
+	return func(key string) bool {
+		if !strings.HasPrefix(key, TenantsPath) {
+			return false
+		}
+		tenantKey := strings.SplitN(strings.TrimPrefix(key, TenantsPath), "/", 2)
+		return len(tenantKey) == 2 && strings.HasPrefix(tenantKey[1], path)
+	}
+}
+
+// ClearTenantState removes the state of a tenant
+func ClearTenantState(d core.StateDriver, tenantName string) error {
+	if tenantName == "" {
+		return core.Errorf("invalid tenant name")
+	}
+
+	if clearer, ok := d.(RecursiveClearer); ok {
+		return clearer.ClearAll(TenantPath(tenantName))
+	}
+
+	reader, ok := d.(KeyReader)
+	if !ok {
+		return core.Errorf("state driver does not read keys")
+	}
+	values, err := reader.ReadAllKeys(TenantPath(tenantName))
+	if err != nil {
+		return core.ErrIfKeyExists(err)
+	}
+	for key := range values {
+		if err := d.ClearState(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadAllTenants reads the values under path in the state of every tenant, eg.
+// "docknet/" for the docknets of all tenants, ordered by key. When the driver
+// lists the tenants, only the keys under path are read, otherwise the tenant
+// scoped state is read once and filtered.
+func ReadAllTenants(d core.StateDriver, path string) ([][]byte, error) {
+	reader, ok := d.(KeyReader)
+	if !ok {
+		return nil, core.Errorf("state driver does not read keys")
+	}
+
+	values, err := readTenantKeys(d, reader, path)
+	if err != nil {
+		return nil, err
+	}
+
+	match := tenantPathMatcher(path)
+	keys := []string{}
+	for key := range values {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	all := [][]byte{}
+	for _, key := range keys {
+		all = append(all, values[key])
+	}
+
+	return all, nil
+}
+
+// readTenantKeys reads the values under path of every tenant, with the keys
+// of the tenants listed if the driver lists them
+func readTenantKeys(d core.StateDriver, reader KeyReader, path string) (map[string][]byte, error) {
+	lister, ok := d.(ChildLister)
+	if !ok || path == "" {
+		return reader.ReadAllKeys(TenantsPath)
+	}
+
+	tenants, err := lister.ListChildren(TenantsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string][]byte)
+	for _, tenantName := range tenants {
+		tenantValues, err := reader.ReadAllKeys(TenantPath(tenantName) + path)
+		if err != nil {
+			if core.ErrIfKeyExists(err) == nil {
+				continue
+			}
+			return nil, err
+		}
+		for key, value := range tenantValues {
+			values[key] = value
+		}
+	}
+
+	return values, nil
+}
+
+// ReadAllTenantsState reads the states under path in the state of every
+// tenant
+func ReadAllTenantsState(d core.StateDriver, path string, sType core.State,
+	unmarshal func([]byte, interface{}) error) ([]core.State, error) {
+	byteValues, err := ReadAllTenants(d, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAllState(d, TenantsPath, byteValues, sType, unmarshal)
+}
+
+// WatchAllTenantsState watches the states under path in the state of every
+// tenant. The keys of other paths are not decoded. It's a blocking call, like
+// WatchAllState.
+func WatchAllTenantsState(d core.StateDriver, path string, sType core.State,
+	unmarshal func([]byte, interface{}) error, rsps chan core.WatchState) error {
+	watcher, ok := d.(FilteredWatcher)
+	if !ok {
+		return core.Errorf("state driver does not filter watches")
+	}
+
+	byteRsps := make(chan [2][]byte, 1)
+	recvErr := make(chan error, 1)
+
+	err := watcher.WatchAllFiltered(TenantsPath, tenantPathMatcher(path), byteRsps)
+	if err != nil {
+		log.Errorf("WatchAllFiltered returned %v", err)
+		return err
+	}
+
+	for {
+		go channelStateEvents(d, TenantsPath, sType, unmarshal, byteRsps, rsps, recvErr)
+
+		err = <-recvErr
+		log.Errorf("Err from channelStateEvents %v", err)
+		time.Sleep(time.Second)
+	}
+}
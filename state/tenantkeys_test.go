@@ -0,0 +1,106 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestTenantState(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.Write(TenantPath("t2")+"docknet/t2.net1.", []byte("t2.net1"))
+	d.Write(TenantPath("t1")+"docknet/t1.net2.", []byte("t1.net2"))
+	d.Write(TenantPath("t1")+"docknet/t1.net1.", []byte("t1.net1"))
+	d.Write(TenantPath("t1")+"other/t1.net1.", []byte("other"))
+	d.Write("/contiv.io/oper/docknet/t1.net3.", []byte("legacy"))
+
+	values, err := ReadAllTenants(d, "docknet/")
+	if err != nil {
+		t.Fatalf("Error reading tenants. Err: %v", err)
+	}
+	if len(values) != 3 || string(values[0]) != "t1.net1" || string(values[2]) != "t2.net1" {
+		t.Fatalf("Expected the docknets of all tenants in order, got %q", values)
+	}
+
+	if err := ClearTenantState(d, "t1"); err != nil {
+		t.Fatalf("Error clearing tenant state. Err: %v", err)
+	}
+	if values, _ := ReadAllTenants(d, ""); len(values) != 1 || string(values[0]) != "t2.net1" {
+		t.Fatalf("Expected only the state of t2, got %q", values)
+	}
+	if _, err := d.Read("/contiv.io/oper/docknet/t1.net3."); err != nil {
+		t.Fatalf("State outside of the tenant was cleared. Err: %v", err)
+	}
+	if err := ClearTenantState(d, ""); err == nil {
+		t.Fatalf("Cleared the state of all tenants")
+	}
+}
+
+func TestListChildren(t *testing.T) {
+	d := &FakeStateDriver{}
+	d.Init(&core.InstanceInfo{})
+	d.Write(TenantPath("t2")+"docknet/t2.net1.", []byte("t2.net1"))
+	d.Write(TenantPath("t1")+"docknet/t1.net1.", []byte("t1.net1"))
+	d.Write(TenantPath("t1")+"other/t1.net1.", []byte("other"))
+
+	names, err := d.ListChildren(TenantsPath)
+	if err != nil || len(names) != 2 || names[0] != "t1" || names[1] != "t2" {
+		t.Fatalf("Expected tenants t1 and t2, got %q. Err: %v", names, err)
+	}
+	if names, _ := d.ListChildren(TenantPath("t1")); len(names) != 2 || names[0] != "docknet" {
+		t.Fatalf("Expected the paths of t1, got %q", names)
+	}
+}
+
+func TestWatchAllTenantsState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating state directory. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(interval time.Duration) { fileWatchInterval = interval }(fileWatchInterval)
+	fileWatchInterval = 10 * time.Millisecond
+
+	d := &FileStateDriver{}
+	if err := d.Init(&core.InstanceInfo{DbURL: "file://" + dir}); err != nil {
+		t.Fatalf("Error initializing the driver. Err: %v", err)
+	}
+
+	rsps := make(chan core.WatchState)
+	go WatchAllTenantsState(d, "docknet/", &testState{}, json.Unmarshal, rsps)
+	time.Sleep(50 * time.Millisecond)
+
+	// keys of other paths would not decode as a testState
+	d.Write(TenantPath("t1")+"other/t1.net1.", []byte("not json"))
+	d.Write(TenantPath("t1")+"docknet/t1.net1.", []byte(`{"strField":"net1"}`))
+
+	select {
+	case rsp := <-rsps:
+		if s, ok := rsp.Curr.(*testState); !ok || s.StrField != "net1" || rsp.Prev != nil {
+			t.Fatalf("Expected the docknet event, got %+v", rsp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No docknet event")
+	}
+}
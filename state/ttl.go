@@ -0,0 +1,51 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// TTLWriter is implemented by the state drivers that remove keys after a
+// TTL, for transient records such as heartbeats or operations in progress
+// that must not accumulate when their writer goes away
+type TTLWriter interface {
+	// WriteTTL writes a key that is removed after ttl, unless written
+	// again. A key written again without a TTL no longer expires.
+	WriteTTL(key string, value []byte, ttl time.Duration) error
+}
+
+// WriteStateTTL writes a state that is removed after ttl, unless written
+// again
+func WriteStateTTL(d core.StateDriver, key string, value core.State,
+	marshal func(interface{}) ([]byte, error), ttl time.Duration) error {
+	writer, ok := d.(TTLWriter)
+	if !ok {
+		return core.Errorf("state driver does not expire keys")
+	}
+	if ttl <= 0 {
+		return core.Errorf("invalid TTL %s", ttl)
+	}
+
+	encodedState, err := marshalState(key, value, marshal)
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteTTL(key, encodedState, ttl)
+}
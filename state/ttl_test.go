@@ -0,0 +1,74 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestWriteStateTTL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatalf("Error creating state directory. Err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileDriver := &FileStateDriver{}
+	if err := fileDriver.Init(&core.InstanceInfo{DbURL: "file://" + dir}); err != nil {
+		t.Fatalf("Error initializing the driver. Err: %v", err)
+	}
+	fakeDriver := &FakeStateDriver{}
+	fakeDriver.Init(&core.InstanceInfo{})
+
+	for _, d := range []core.StateDriver{fileDriver, NewMeteredStateDriver(fakeDriver)} {
+		heartbeat := &testState{IntField: 1}
+		heartbeat.ID = "host1"
+		if err := WriteStateTTL(d, "/contiv.io/oper/heartbeat/host1", heartbeat, json.Marshal, 0); err == nil {
+			t.Fatalf("Wrote a state with no TTL")
+		}
+		if err := WriteStateTTL(d, "/contiv.io/oper/heartbeat/host1", heartbeat, json.Marshal, 50*time.Millisecond); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+		if err := WriteStateTTL(d, "/contiv.io/oper/heartbeat/host2", heartbeat, json.Marshal, 50*time.Millisecond); err != nil {
+			t.Fatalf("Error writing state. Err: %v", err)
+		}
+		// rewritten without a TTL, host2 no longer expires
+		d.WriteState("/contiv.io/oper/heartbeat/host2", heartbeat, json.Marshal)
+
+		read := &testState{}
+		if err := d.ReadState("/contiv.io/oper/heartbeat/host1", read, json.Unmarshal); err != nil || read.IntField != 1 {
+			t.Fatalf("Error reading state before the TTL. Err: %v", err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if _, err := d.Read("/contiv.io/oper/heartbeat/host1"); core.ErrIfKeyExists(err) != nil || err == nil {
+			t.Fatalf("Expected the state to expire, got: %v", err)
+		}
+		if values, err := d.ReadAll("/contiv.io/oper/heartbeat/"); err != nil || len(values) != 1 {
+			t.Fatalf("Expected only the state without a TTL, got %q. Err: %v", values, err)
+		}
+	}
+
+	if err := WriteStateTTL(NewBatch(fakeDriver), "/contiv.io/oper/heartbeat/host1", &testState{}, json.Marshal, time.Second); err == nil {
+		t.Fatalf("Wrote a TTL to a driver not expiring keys")
+	}
+}
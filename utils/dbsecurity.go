@@ -0,0 +1,77 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/contiv/netplugin/core"
+)
+
+// DbPasswordEnv is the environment variable with the cluster store password,
+// used when neither a password nor a password file is set
+const DbPasswordEnv = "CONTIV_CLUSTER_STORE_PASSWORD"
+
+// AddDbSecurityFlags adds the flags setting the client certificate, CA bundle
+// and credentials of the state store to a flag set
+func AddDbSecurityFlags(flagSet *flag.FlagSet, sec *core.DbSecurity) {
+	flagSet.StringVar(&sec.CertFile,
+		"cluster-store-cert",
+		"",
+		"Client certificate file to connect to the cluster store with TLS")
+	flagSet.StringVar(&sec.KeyFile,
+		"cluster-store-key",
+		"",
+		"Client key file of the cluster store certificate")
+	flagSet.StringVar(&sec.CAFile,
+		"cluster-store-ca",
+		"",
+		"CA bundle to verify the cluster store with TLS")
+	flagSet.StringVar(&sec.Username,
+		"cluster-store-username",
+		"",
+		"Username to authenticate to the cluster store")
+	flagSet.StringVar(&sec.Password,
+		"cluster-store-password",
+		"",
+		"Password to authenticate to the cluster store, visible in the process list; prefer the password file or $"+DbPasswordEnv)
+	flagSet.StringVar(&sec.PasswordFile,
+		"cluster-store-password-file",
+		"",
+		"File with the password to authenticate to the cluster store")
+}
+
+// LoadDbPassword sets the cluster store password of sec from its password
+// file, or from the environment if no password is set
+func LoadDbPassword(sec *core.DbSecurity) error {
+	switch {
+	case sec.Password != "" && sec.PasswordFile != "":
+		return core.Errorf("only one of a cluster store password or password file can be set")
+	case sec.PasswordFile != "":
+		password, err := ioutil.ReadFile(sec.PasswordFile)
+		if err != nil {
+			return core.Errorf("Error reading cluster store password file. Err: %v", err)
+		}
+		sec.Password = strings.TrimRight(string(password), "\r\n")
+	case sec.Password == "":
+		sec.Password = os.Getenv(DbPasswordEnv)
+	}
+
+	return nil
+}
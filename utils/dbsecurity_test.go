@@ -0,0 +1,62 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+)
+
+func TestLoadDbPassword(t *testing.T) {
+	passwordFile, err := ioutil.TempFile("", "password")
+	if err != nil {
+		t.Fatalf("Error creating password file. Err: %v", err)
+	}
+	defer os.Remove(passwordFile.Name())
+	passwordFile.WriteString("from-file\n")
+	passwordFile.Close()
+
+	os.Setenv(DbPasswordEnv, "from-env")
+	defer os.Unsetenv(DbPasswordEnv)
+
+	sec := core.DbSecurity{PasswordFile: passwordFile.Name()}
+	if err := LoadDbPassword(&sec); err != nil || sec.Password != "from-file" {
+		t.Fatalf("Expected the password of the file, got %q. Err: %v", sec.Password, err)
+	}
+
+	sec = core.DbSecurity{}
+	if err := LoadDbPassword(&sec); err != nil || sec.Password != "from-env" {
+		t.Fatalf("Expected the password of the environment, got %q. Err: %v", sec.Password, err)
+	}
+
+	sec = core.DbSecurity{Password: "from-flag"}
+	if err := LoadDbPassword(&sec); err != nil || sec.Password != "from-flag" {
+		t.Fatalf("Expected the password of the flag, got %q. Err: %v", sec.Password, err)
+	}
+
+	sec = core.DbSecurity{Password: "from-flag", PasswordFile: passwordFile.Name()}
+	if err := LoadDbPassword(&sec); err == nil {
+		t.Fatalf("Password and password file were both accepted")
+	}
+
+	sec = core.DbSecurity{PasswordFile: "/nonexistent/password"}
+	if err := LoadDbPassword(&sec); err == nil {
+		t.Fatalf("Missing password file was accepted")
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"reflect"
+	"time"
 
 	"github.com/contiv/netplugin/core"
 	"github.com/contiv/netplugin/drivers"
@@ -37,6 +38,10 @@ var stateDriverRegistry = map[string]driverConfigTypes{
 		DriverType: reflect.TypeOf(state.ConsulStateDriver{}),
 		ConfigType: reflect.TypeOf(state.ConsulStateDriverConfig{}),
 	},
+	FileNameStr: {
+		DriverType: reflect.TypeOf(state.FileStateDriver{}),
+		ConfigType: reflect.TypeOf(state.FileStateDriverConfig{}),
+	},
 	// fakestate-driver is used for tests, so not exposing a public name for it.
 	"fakedriver": {
 		DriverType: reflect.TypeOf(state.FakeStateDriver{}),
@@ -49,6 +54,8 @@ const (
 	EtcdNameStr = "etcd"
 	// ConsulNameStr is a string constant for consul state-store
 	ConsulNameStr = "consul"
+	// FileNameStr is a string constant for the local file state-store
+	FileNameStr = "file"
 	// OvsNameStr is a string constant for ovs driver
 	OvsNameStr = "ovs"
 )
@@ -104,6 +111,53 @@ func GetStateDriver() (core.StateDriver, error) {
 	return gStateDriver, nil
 }
 
+// CacheStateDriver puts a read cache, invalidated by watches, in front of the
+// singleton instance of the state-driver. Cached reads are reloaded after
+// maxAge, unless it is 0.
+func CacheStateDriver(maxAge time.Duration) (core.StateDriver, error) {
+	if gStateDriver == nil {
+		return nil, core.Errorf("statedriver has not been not created.")
+	}
+
+	if _, ok := gStateDriver.(*state.CachedStateDriver); !ok {
+		gStateDriver = state.NewCachedStateDriver(gStateDriver, maxAge)
+	}
+
+	return gStateDriver, nil
+}
+
+// MeterStateDriver counts the operations of the singleton instance of the
+// state-driver, see state.MeteredStateDriver
+func MeterStateDriver() (*state.MeteredStateDriver, error) {
+	if gStateDriver == nil {
+		return nil, core.Errorf("statedriver has not been not created.")
+	}
+
+	metered, ok := gStateDriver.(*state.MeteredStateDriver)
+	if !ok {
+		metered = state.NewMeteredStateDriver(gStateDriver)
+		gStateDriver = metered
+	}
+
+	return metered, nil
+}
+
+// AuditStateDriver records the changes made through the singleton instance of
+// the state-driver to the keys under baseKeys, see state.AuditedStateDriver
+func AuditStateDriver(actor string, baseKeys ...string) (*state.AuditedStateDriver, error) {
+	if gStateDriver == nil {
+		return nil, core.Errorf("statedriver has not been not created.")
+	}
+
+	audited, ok := gStateDriver.(*state.AuditedStateDriver)
+	if !ok {
+		audited = state.NewAuditedStateDriver(gStateDriver, actor, baseKeys...)
+		gStateDriver = audited
+	}
+
+	return audited, nil
+}
+
 // ReleaseStateDriver releases the singleton instance of the state-driver
 func ReleaseStateDriver() {
 	if gStateDriver != nil {
@@ -0,0 +1,251 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
+	"github.com/contiv/objdb"
+	"github.com/coreos/etcd/client"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// objdbMutex protects the objdb plugins registered by SecureObjdb
+	objdbMutex sync.Mutex
+
+	// etcdTransportMutex serializes the etcd objdb clients being created
+	// with a swapped etcd default transport
+	etcdTransportMutex sync.Mutex
+)
+
+// SecureObjdb makes the objdb clients created afterwards, including the
+// modeldb one, connect to the cluster store with the TLS config and
+// credentials of sec. objdb only takes the store URL, so its etcd and consul
+// plugins are replaced by wrappers:
+//   - etcd clients get https endpoints and a transport with the TLS config
+//     and credentials
+//   - consul clients go through a proxy listening on a unix socket in a
+//     private directory, which connects to consul with the TLS config and
+//     credentials
+//
+// Without TLS and credentials, the objdb plugins are restored.
+func SecureObjdb(sec core.DbSecurity) error {
+	objdbMutex.Lock()
+	defer objdbMutex.Unlock()
+
+	etcdPlugin := objdb.GetPlugin("etcd")
+	if p, ok := etcdPlugin.(*secureEtcdPlugin); ok {
+		etcdPlugin = p.plugin
+	}
+	consulPlugin := objdb.GetPlugin("consul")
+	if p, ok := consulPlugin.(*secureConsulPlugin); ok {
+		consulPlugin = p.plugin
+	}
+
+	if !sec.UseTLS() && sec.Username == "" {
+		objdb.RegisterPlugin("etcd", etcdPlugin)
+		objdb.RegisterPlugin("consul", consulPlugin)
+		return nil
+	}
+
+	// without TLS, the transports only add the credentials
+	transport, ok := client.DefaultTransport.(*http.Transport)
+	if !ok {
+		transport = &http.Transport{Proxy: http.ProxyFromEnvironment}
+	}
+	if sec.UseTLS() {
+		var err error
+		transport, err = state.NewTLSTransport(sec)
+		if err != nil {
+			return err
+		}
+	}
+
+	objdb.RegisterPlugin("etcd", &secureEtcdPlugin{
+		plugin:    etcdPlugin,
+		useTLS:    sec.UseTLS(),
+		transport: newAuthTransport(transport, sec),
+	})
+	objdb.RegisterPlugin("consul", &secureConsulPlugin{
+		plugin:    consulPlugin,
+		useTLS:    sec.UseTLS(),
+		transport: newAuthTransport(transport, sec),
+	})
+
+	return nil
+}
+
+// NewObjdbClient creates an objdb client to the cluster store, connecting
+// with the same TLS config and credentials as the state driver
+func NewObjdbClient(dbURL string, sec core.DbSecurity) (objdb.API, error) {
+	if err := SecureObjdb(sec); err != nil {
+		return nil, err
+	}
+
+	return objdb.NewClient(dbURL)
+}
+
+// authTransport adds the cluster store credentials to the requests it sends
+type authTransport struct {
+	*http.Transport
+	username string
+	password string
+
+	// authReqs maps the requests in flight to the requests sent, so that
+	// they can be canceled
+	mutex    sync.Mutex
+	authReqs map[*http.Request]*http.Request
+}
+
+func newAuthTransport(transport *http.Transport, sec core.DbSecurity) *authTransport {
+	return &authTransport{
+		Transport: transport,
+		username:  sec.Username,
+		password:  sec.Password,
+		authReqs:  make(map[*http.Request]*http.Request),
+	}
+}
+
+// RoundTrip sends a copy of req with the credentials
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.username == "" {
+		return t.Transport.RoundTrip(req)
+	}
+
+	authReq := req.Clone(req.Context())
+	authReq.SetBasicAuth(t.username, t.password)
+
+	t.mutex.Lock()
+	t.authReqs[req] = authReq
+	t.mutex.Unlock()
+	defer func() {
+		t.mutex.Lock()
+		delete(t.authReqs, req)
+		t.mutex.Unlock()
+	}()
+
+	return t.Transport.RoundTrip(authReq)
+}
+
+// CancelRequest cancels the copy of req sent
+func (t *authTransport) CancelRequest(req *http.Request) {
+	t.mutex.Lock()
+	authReq, ok := t.authReqs[req]
+	t.mutex.Unlock()
+	if !ok {
+		authReq = req
+	}
+
+	t.Transport.CancelRequest(authReq)
+}
+
+// secureEtcdPlugin creates the objdb etcd clients with https endpoints and
+// the transport
+type secureEtcdPlugin struct {
+	plugin    objdb.Plugin
+	useTLS    bool
+	transport *authTransport
+}
+
+// NewClient creates an objdb etcd client. The etcd client picks the default
+// transport when it is created, so it is swapped meanwhile.
+func (p *secureEtcdPlugin) NewClient(endpoints []string) (objdb.API, error) {
+	if p.useTLS {
+		secureEndpoints := []string{}
+		for _, endpoint := range endpoints {
+			secureEndpoints = append(secureEndpoints, "https://"+strings.TrimPrefix(endpoint, "http://"))
+		}
+		endpoints = secureEndpoints
+	}
+
+	etcdTransportMutex.Lock()
+	defer etcdTransportMutex.Unlock()
+	defaultTransport := client.DefaultTransport
+	client.DefaultTransport = p.transport
+	defer func() { client.DefaultTransport = defaultTransport }()
+
+	return p.plugin.NewClient(endpoints)
+}
+
+// secureConsulPlugin creates the objdb consul clients connected through a
+// proxy using the transport
+type secureConsulPlugin struct {
+	plugin    objdb.Plugin
+	useTLS    bool
+	transport *authTransport
+}
+
+// NewClient starts a proxy to consul and creates an objdb consul client to
+// it. The proxy runs until the process exits.
+func (p *secureConsulPlugin) NewClient(endpoints []string) (objdb.API, error) {
+	address := "127.0.0.1:8500"
+	if len(endpoints) > 0 {
+		address = strings.TrimPrefix(endpoints[0], "http://")
+	}
+
+	socket, err := p.startProxy(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.plugin.NewClient([]string{"unix://" + socket})
+}
+
+// startProxy serves a proxy to consul at address on a unix socket only the
+// user can connect to, and returns the socket path
+func (p *secureConsulPlugin) startProxy(address string) (string, error) {
+	dir, err := ioutil.TempDir("", "contiv-objdb")
+	if err != nil {
+		return "", err
+	}
+	socket := filepath.Join(dir, "consul.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	scheme := "http"
+	if p.useTLS {
+		scheme = "https"
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = address
+			req.Host = address
+		},
+		Transport: p.transport,
+	}
+
+	go func() {
+		err := http.Serve(listener, proxy)
+		log.Errorf("consul proxy at %s stopped. Err: %v", socket, err)
+	}()
+
+	return socket, nil
+}
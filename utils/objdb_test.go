@@ -0,0 +1,83 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/objdb"
+)
+
+func TestSecureObjdb(t *testing.T) {
+	// a cluster store answering the etcd and consul connection checks, and
+	// recording if each API was called with the credentials
+	var mutex sync.Mutex
+	authed := make(map[string]bool)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		mutex.Lock()
+		authed[strings.Split(r.URL.Path, "/")[1]] = ok && user == "contiv" && password == "secret"
+		mutex.Unlock()
+
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			w.Header().Set("X-Consul-Index", "1")
+			w.Header().Set("X-Consul-LastContact", "0")
+			w.Write([]byte("[]"))
+			return
+		}
+		w.Write([]byte(`{"action":"get","node":{"key":"/","dir":true}}`))
+	}))
+	defer server.Close()
+
+	caFile, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatalf("Error creating CA file. Err: %v", err)
+	}
+	defer os.Remove(caFile.Name())
+	pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	caFile.Close()
+
+	sec := core.DbSecurity{CAFile: caFile.Name(), Username: "contiv", Password: "secret"}
+	defer SecureObjdb(core.DbSecurity{})
+	address := strings.TrimPrefix(server.URL, "https://")
+	for _, store := range []string{"etcd", "consul"} {
+		if _, err := NewObjdbClient(store+"://"+address, sec); err != nil {
+			t.Fatalf("Error creating %s client. Err: %v", store, err)
+		}
+	}
+	if !authed["v2"] || !authed["v1"] {
+		t.Fatalf("Cluster store was not called with the credentials: %v", authed)
+	}
+
+	// without security the objdb plugins are restored
+	if err := SecureObjdb(core.DbSecurity{}); err != nil {
+		t.Fatalf("Error restoring the objdb plugins. Err: %v", err)
+	}
+	if _, ok := objdb.GetPlugin("etcd").(*secureEtcdPlugin); ok {
+		t.Fatalf("etcd objdb plugin was not restored")
+	}
+	if _, ok := objdb.GetPlugin("consul").(*secureConsulPlugin); ok {
+		t.Fatalf("consul objdb plugin was not restored")
+	}
+}
@@ -0,0 +1,66 @@
+/***
+Copyright 2014 Cisco Systems Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"flag"
+
+	"github.com/contiv/netplugin/core"
+	"github.com/contiv/netplugin/state"
+)
+
+// StateEncryption has the key encrypting the sensitive fields of the states,
+// from a key file or a KMS plugin
+type StateEncryption struct {
+	KeyFile   string
+	KMSPlugin string
+}
+
+// AddStateEncryptionFlags adds the flags setting the state encryption key to
+// a flag set
+func AddStateEncryptionFlags(flagSet *flag.FlagSet, enc *StateEncryption) {
+	flagSet.StringVar(&enc.KeyFile,
+		"cluster-store-encryption-key",
+		"",
+		"File with the AES-256 key encrypting the secrets in the cluster store, raw or base64")
+	flagSet.StringVar(&enc.KMSPlugin,
+		"cluster-store-kms-plugin",
+		"",
+		"KMS plugin encrypting the keys of the secrets in the cluster store")
+}
+
+// InitStateEncryption sets the state encryption, if a key file or a KMS
+// plugin is configured
+func InitStateEncryption(enc StateEncryption) error {
+	var wrapper state.KeyWrapper
+	var err error
+
+	switch {
+	case enc.KeyFile != "" && enc.KMSPlugin != "":
+		return core.Errorf("only one of a state encryption key or a KMS plugin can be set")
+	case enc.KeyFile != "":
+		wrapper, err = state.NewFileKeyWrapper(enc.KeyFile)
+		if err != nil {
+			return err
+		}
+	case enc.KMSPlugin != "":
+		wrapper = state.NewPluginKeyWrapper(enc.KMSPlugin)
+	default:
+		return nil
+	}
+
+	return state.SetStateEncryption(wrapper)
+}